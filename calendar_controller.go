@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The CalDAV server (RFC 4791) has a single calendar of notes as VTODOs. Its
+// root is both the principal and the calendar home, as there are no users;
+// for the same reason, calendars and feeds are not per user.
+const (
+	CALDAV_ROOT_PATH     = "/caldav/"
+	CALDAV_CALENDAR_PATH = CALDAV_ROOT_PATH + "notes/"
+	CALDAV_OBJECT_SUFFIX = ".ics"
+	CALDAV_DISPLAY_NAME  = "Notes"
+
+	DAV_NAMESPACE            = "DAV:"
+	CALDAV_NAMESPACE         = "urn:ietf:params:xml:ns:caldav"
+	CALENDARSERVER_NAMESPACE = "http://calendarserver.org/ns/"
+
+	MAX_CALENDAR_OBJECT_SIZE = 1 << 20
+	MAX_CALENDAR_NAME_LENGTH = 255
+)
+
+var davPrefixes = map[string]string{DAV_NAMESPACE: "d", CALDAV_NAMESPACE: "c", CALENDARSERVER_NAMESPACE: "cs"}
+
+var (
+	davResourceType     = xml.Name{Space: DAV_NAMESPACE, Local: "resourcetype"}
+	davDisplayName      = xml.Name{Space: DAV_NAMESPACE, Local: "displayname"}
+	davGetETag          = xml.Name{Space: DAV_NAMESPACE, Local: "getetag"}
+	davGetContentType   = xml.Name{Space: DAV_NAMESPACE, Local: "getcontenttype"}
+	davUserPrincipal    = xml.Name{Space: DAV_NAMESPACE, Local: "current-user-principal"}
+	davPrincipalURL     = xml.Name{Space: DAV_NAMESPACE, Local: "principal-URL"}
+	davPrivilegeSet     = xml.Name{Space: DAV_NAMESPACE, Local: "current-user-privilege-set"}
+	davReportSet        = xml.Name{Space: DAV_NAMESPACE, Local: "supported-report-set"}
+	caldavHomeSet       = xml.Name{Space: CALDAV_NAMESPACE, Local: "calendar-home-set"}
+	caldavComponentSet  = xml.Name{Space: CALDAV_NAMESPACE, Local: "supported-calendar-component-set"}
+	caldavCalendarData  = xml.Name{Space: CALDAV_NAMESPACE, Local: "calendar-data"}
+	calendarServerCTag  = xml.Name{Space: CALENDARSERVER_NAMESPACE, Local: "getctag"}
+	caldavQuery         = xml.Name{Space: CALDAV_NAMESPACE, Local: "calendar-query"}
+	caldavMultiget      = xml.Name{Space: CALDAV_NAMESPACE, Local: "calendar-multiget"}
+	davSupportedReport  = xml.Name{Space: DAV_NAMESPACE, Local: "supported-report"}
+	caldavValidData     = xml.Name{Space: CALDAV_NAMESPACE, Local: "valid-calendar-data"}
+	caldavValidObject   = xml.Name{Space: CALDAV_NAMESPACE, Local: "valid-calendar-object-resource"}
+	caldavComponent     = xml.Name{Space: CALDAV_NAMESPACE, Local: "supported-calendar-component"}
+	caldavMaxObjectSize = xml.Name{Space: CALDAV_NAMESPACE, Local: "max-resource-size"}
+	davQuotaNotExceeded = xml.Name{Space: DAV_NAMESPACE, Local: "quota-not-exceeded"}
+)
+
+type ICalendarController interface {
+	Feed(c *gin.Context)
+	WellKnown(c *gin.Context)
+	Options(c *gin.Context)
+	Propfind(c *gin.Context)
+	Report(c *gin.Context)
+	GetObject(c *gin.Context)
+	PutObject(c *gin.Context)
+	DeleteObject(c *gin.Context)
+}
+
+type CalendarController struct {
+	noteService INoteService
+}
+
+// davRequest is the body of PROPFIND and REPORT requests.
+type davRequest struct {
+	XMLName xml.Name
+	AllProp *struct{} `xml:"DAV: allprop"`
+	Prop    *struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+	Hrefs  []string `xml:"DAV: href"`
+	Filter struct {
+		CompFilter compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+type compFilter struct {
+	Name        string       `xml:"name,attr"`
+	CompFilters []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	TimeRange   *timeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+}
+
+type timeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// includes tells whether a to-do due at the time overlaps the range (RFC
+// 4791 9.9). Undated to-dos overlap any range, as does a nil range.
+func (tr *timeRange) includes(due *time.Time) bool {
+	if tr == nil || due == nil {
+		return true
+	}
+	if start, err := time.Parse(CALENDAR_TIME_FORMAT, tr.Start); err == nil && due.Before(start) {
+		return false
+	}
+	if end, err := time.Parse(CALENDAR_TIME_FORMAT, tr.End); err == nil && !due.Before(end) {
+		return false
+	}
+	return true
+}
+
+// propNames returns the requested properties, or nil for all of them.
+func (r *davRequest) propNames() []xml.Name {
+	if r.AllProp != nil || r.Prop == nil {
+		return nil
+	}
+	names := []xml.Name{}
+	for _, prop := range r.Prop.Names {
+		names = append(names, prop.XMLName)
+	}
+	return names
+}
+
+// vtodoTimeRange tells whether a calendar-query filter matches to-dos, and
+// returns the time range they must be due in, if any.
+func (r *davRequest) vtodoTimeRange() (*timeRange, bool) {
+	var timeRange *timeRange
+	for _, filter := range r.Filter.CompFilter.CompFilters {
+		if filter.Name != "VTODO" {
+			return nil, false
+		}
+		timeRange = filter.TimeRange
+	}
+	return timeRange, true
+}
+
+// davResource is a response in a multistatus, with its properties as XML.
+type davResource struct {
+	href   string
+	status int
+	props  map[xml.Name]string
+}
+
+func davElement(name xml.Name, inner string) string {
+	prefix, found := davPrefixes[name.Space]
+	if !found {
+		return fmt.Sprintf(`<x:%s xmlns:x="%s">%s</x:%s>`, name.Local, davText(name.Space), inner, name.Local)
+	}
+	return fmt.Sprintf("<%s:%s>%s</%s:%s>", prefix, name.Local, inner, prefix, name.Local)
+}
+
+func davText(text string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+func davHref(href string) string {
+	return davElement(xml.Name{Space: DAV_NAMESPACE, Local: "href"}, davText(href))
+}
+
+func davStatus(status int) string {
+	return davElement(xml.Name{Space: DAV_NAMESPACE, Local: "status"},
+		fmt.Sprintf("HTTP/1.1 %d %s", status, http.StatusText(status)))
+}
+
+func davNamespaces() string {
+	return fmt.Sprintf(`xmlns:d="%s" xmlns:c="%s" xmlns:cs="%s"`, DAV_NAMESPACE, CALDAV_NAMESPACE, CALENDARSERVER_NAMESPACE)
+}
+
+// respondMultistatus responds with the requested properties of the
+// resources, or all of them if names is nil.
+func respondMultistatus(c *gin.Context, resources []davResource, names []xml.Name) {
+	var body strings.Builder
+	body.WriteString(xml.Header)
+	body.WriteString("<d:multistatus " + davNamespaces() + ">")
+	for _, resource := range resources {
+		body.WriteString("<d:response>" + davHref(resource.href))
+		if resource.status != 0 {
+			body.WriteString(davStatus(resource.status) + "</d:response>")
+			continue
+		}
+		var found, missing strings.Builder
+		requested := names
+		if requested == nil {
+			for name := range resource.props {
+				if name != caldavCalendarData {
+					requested = append(requested, name)
+				}
+			}
+			sort.Slice(requested, func(i, j int) bool {
+				return requested[i].Space+" "+requested[i].Local < requested[j].Space+" "+requested[j].Local
+			})
+		}
+		for _, name := range requested {
+			if value, ok := resource.props[name]; ok {
+				found.WriteString(davElement(name, value))
+			} else {
+				missing.WriteString(davElement(name, ""))
+			}
+		}
+		if found.Len() > 0 {
+			body.WriteString("<d:propstat><d:prop>" + found.String() + "</d:prop>" + davStatus(http.StatusOK) + "</d:propstat>")
+		}
+		if missing.Len() > 0 {
+			body.WriteString("<d:propstat><d:prop>" + missing.String() + "</d:prop>" + davStatus(http.StatusNotFound) + "</d:propstat>")
+		}
+		body.WriteString("</d:response>")
+	}
+	body.WriteString("</d:multistatus>")
+	c.Data(http.StatusMultiStatus, "application/xml; charset=utf-8", []byte(body.String()))
+}
+
+// respondPrecondition responds with the failed precondition as a DAV:error.
+func respondPrecondition(c *gin.Context, status int, precondition xml.Name) {
+	body := xml.Header + "<d:error " + davNamespaces() + ">" + davElement(precondition, "") + "</d:error>"
+	c.Data(status, "application/xml; charset=utf-8", []byte(body))
+}
+
+func noteETag(note Note) string {
+	return `"` + strconv.FormatUint(note.Sequence, 10) + `"`
+}
+
+// noteHref is the name the client that created the note gave it, or else
+// "<id>.ics".
+func noteHref(note Note) string {
+	if note.CalendarName != "" {
+		return CALDAV_CALENDAR_PATH + url.PathEscape(note.CalendarName)
+	}
+	return CALDAV_CALENDAR_PATH + strconv.FormatUint(note.ID, 10) + CALDAV_OBJECT_SUFFIX
+}
+
+// noteIdFromName returns the ID of the note at "<id>.ics".
+func noteIdFromName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, CALDAV_OBJECT_SUFFIX) {
+		return 0, false
+	}
+	id, err := getIdFromParamString(strings.TrimSuffix(name, CALDAV_OBJECT_SUFFIX))
+	return id, err == nil
+}
+
+func noteCalendarData(note Note, stamp time.Time) string {
+	var data bytes.Buffer
+	writer := newCalendarWriter(&data, stamp)
+	writer.Write(note)
+	writer.Close()
+	return data.String()
+}
+
+// service returns the note service bound to the context of the request.
+func (cc *CalendarController) service(c *gin.Context) INoteService {
+	return cc.noteService.WithContext(c.Request.Context())
+}
+
+// loadNotes returns all notes and a tag that changes whenever any of them is
+// created, updated or deleted.
+func (cc *CalendarController) loadNotes(c *gin.Context) ([]Note, string, error) {
+	notes := []Note{}
+	hash := fnv.New64a()
+	err := cc.service(c).GetAllInBatches(EXPORT_BATCH_SIZE, func(batch []Note) error {
+		for _, note := range batch {
+			fmt.Fprintf(hash, "%d:%d;", note.ID, note.Sequence)
+		}
+		notes = append(notes, batch...)
+		return nil
+	})
+	return notes, fmt.Sprintf(`"%x"`, hash.Sum64()), err
+}
+
+// Feed responds with the notes with due dates as an iCalendar object to
+// subscribe to. It is built in memory so that a failure is never served as a
+// shorter feed.
+func (cc *CalendarController) Feed(c *gin.Context) {
+	notes, tag, err := cc.loadNotes(c)
+	if err != nil {
+		RespondProblem(c, InternalErrorProblem())
+		return
+	}
+	c.Header("ETag", tag)
+	if c.GetHeader("If-None-Match") == tag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	var feed bytes.Buffer
+	writer := newCalendarWriter(&feed, time.Now())
+	for _, note := range notes {
+		if note.Due != nil {
+			writer.Write(note)
+		}
+	}
+	writer.Close()
+	c.Data(http.StatusOK, CALENDAR_CONTENT_TYPE, feed.Bytes())
+}
+
+// WellKnown redirects clients discovering the server (RFC 6764).
+func (cc *CalendarController) WellKnown(c *gin.Context) {
+	c.Redirect(http.StatusMovedPermanently, CALDAV_ROOT_PATH)
+}
+
+func (cc *CalendarController) Options(c *gin.Context) {
+	c.Header("DAV", "1, 3, calendar-access")
+	c.Header("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+	c.Status(http.StatusOK)
+}
+
+// bindDAVRequest parses the XML body, which is empty for PROPFIND of all
+// properties.
+func bindDAVRequest(c *gin.Context, request *davRequest) bool {
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, MAX_CALENDAR_OBJECT_SIZE))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return false
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return true
+	}
+	if err := xml.Unmarshal(body, request); err != nil {
+		c.Status(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (cc *CalendarController) rootResource() davResource {
+	home := davHref(CALDAV_ROOT_PATH)
+	return davResource{href: CALDAV_ROOT_PATH, props: map[xml.Name]string{
+		davResourceType:  "<d:collection/><d:principal/>",
+		davDisplayName:   davText(API_TITLE),
+		davUserPrincipal: home,
+		davPrincipalURL:  home,
+		caldavHomeSet:    home,
+	}}
+}
+
+func (cc *CalendarController) calendarResource(tag string) davResource {
+	return davResource{href: CALDAV_CALENDAR_PATH, props: map[xml.Name]string{
+		davResourceType:  "<d:collection/><c:calendar/>",
+		davDisplayName:   CALDAV_DISPLAY_NAME,
+		davUserPrincipal: davHref(CALDAV_ROOT_PATH),
+		davPrivilegeSet:  "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>",
+		davReportSet: "<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>",
+		caldavComponentSet: `<c:comp name="VTODO"/>`,
+		calendarServerCTag: davText(tag),
+		davGetETag:         davText(tag),
+	}}
+}
+
+func noteResource(note Note, stamp time.Time) davResource {
+	return davResource{href: noteHref(note), props: map[xml.Name]string{
+		davResourceType:    "",
+		davGetETag:         davText(noteETag(note)),
+		davGetContentType:  CALENDAR_CONTENT_TYPE + "; component=VTODO",
+		caldavCalendarData: davText(noteCalendarData(note, stamp)),
+	}}
+}
+
+// Propfind responds with the properties of the resource and, with Depth 1,
+// its members. Infinite depth is served as 1, as the tree is that shallow.
+func (cc *CalendarController) Propfind(c *gin.Context) {
+	var request davRequest
+	if !bindDAVRequest(c, &request) {
+		return
+	}
+	depth := c.GetHeader("Depth") != "0"
+	path := c.Request.URL.Path
+
+	var resources []davResource
+	switch path {
+	case CALDAV_ROOT_PATH:
+		resources = append(resources, cc.rootResource())
+		if depth {
+			_, tag, err := cc.loadNotes(c)
+			if err != nil {
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			resources = append(resources, cc.calendarResource(tag))
+		}
+	case CALDAV_CALENDAR_PATH:
+		notes, tag, err := cc.loadNotes(c)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		resources = append(resources, cc.calendarResource(tag))
+		if depth {
+			now := time.Now()
+			for _, note := range notes {
+				resources = append(resources, noteResource(note, now))
+			}
+		}
+	default:
+		note, found := cc.noteByName(c, c.Param("name"))
+		if !found {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		resources = append(resources, noteResource(note, time.Now()))
+	}
+	respondMultistatus(c, resources, request.propNames())
+}
+
+// Report serves calendar-query, which matches the notes due in its time
+// range and the undated ones, and calendar-multiget.
+func (cc *CalendarController) Report(c *gin.Context) {
+	var request davRequest
+	if !bindDAVRequest(c, &request) {
+		return
+	}
+	names := request.propNames()
+	now := time.Now()
+
+	var resources []davResource
+	switch request.XMLName {
+	case caldavQuery:
+		timeRange, ok := request.vtodoTimeRange()
+		if !ok {
+			break
+		}
+		notes, _, err := cc.loadNotes(c)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		for _, note := range notes {
+			if timeRange.includes(note.Due) {
+				resources = append(resources, noteResource(note, now))
+			}
+		}
+	case caldavMultiget:
+		for _, href := range request.Hrefs {
+			name, err := url.PathUnescape(strings.TrimPrefix(href, CALDAV_CALENDAR_PATH))
+			if err != nil || !strings.HasPrefix(href, CALDAV_CALENDAR_PATH) {
+				resources = append(resources, davResource{href: href, status: http.StatusNotFound})
+				continue
+			}
+			note, found := cc.noteByName(c, name)
+			if !found {
+				resources = append(resources, davResource{href: href, status: http.StatusNotFound})
+				continue
+			}
+			resources = append(resources, noteResource(note, now))
+		}
+	default:
+		respondPrecondition(c, http.StatusForbidden, davSupportedReport)
+		return
+	}
+	respondMultistatus(c, resources, names)
+}
+
+// noteByName returns the note a client created under the name, or else the
+// note at "<id>.ics" unless a client created it under another name.
+func (cc *CalendarController) noteByName(c *gin.Context, name string) (Note, bool) {
+	if note, found := cc.service(c).GetByCalendarName(name); found {
+		return note, true
+	}
+	id, ok := noteIdFromName(name)
+	if !ok {
+		return Note{}, false
+	}
+	note, found := cc.service(c).GetById(id)
+	if !found || note.CalendarName != "" {
+		return Note{}, false
+	}
+	return note, true
+}
+
+func (cc *CalendarController) GetObject(c *gin.Context) {
+	note, found := cc.noteByName(c, c.Param("name"))
+	if !found {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Header("ETag", noteETag(note))
+	c.Data(http.StatusOK, CALENDAR_CONTENT_TYPE, []byte(noteCalendarData(note, time.Now())))
+}
+
+// preconditionsHold checks If-Match and If-None-Match against the note at
+// the path, if any.
+func preconditionsHold(c *gin.Context, note Note, found bool) bool {
+	if match := c.GetHeader("If-Match"); match != "" && (!found || (match != "*" && match != noteETag(note))) {
+		return false
+	}
+	if noneMatch := c.GetHeader("If-None-Match"); noneMatch != "" && found && (noneMatch == "*" || noneMatch == noteETag(note)) {
+		return false
+	}
+	return true
+}
+
+// PutObject updates the note at the path with the VTODO, or creates a note
+// if there is none. A created note keeps the name and the UID given by the
+// client. An update made with If-Match is made only if the note is still at
+// the sequence of the ETag, so that a change made after the check is not
+// overwritten.
+func (cc *CalendarController) PutObject(c *gin.Context) {
+	name := c.Param("name")
+	note, found := cc.noteByName(c, name)
+	if !preconditionsHold(c, note, found) {
+		c.Status(http.StatusPreconditionFailed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, MAX_CALENDAR_OBJECT_SIZE+1))
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if len(body) > MAX_CALENDAR_OBJECT_SIZE {
+		respondPrecondition(c, http.StatusForbidden, caldavMaxObjectSize)
+		return
+	}
+	request, uid, err := parseVTODO(body)
+	switch {
+	case err == errNoVTODO:
+		respondPrecondition(c, http.StatusForbidden, caldavComponent)
+		return
+	case err != nil:
+		respondPrecondition(c, http.StatusForbidden, caldavValidData)
+		return
+	}
+	if err := Validate(&request); err != nil || len(name) > MAX_CALENDAR_NAME_LENGTH || len(uid) > MAX_CALENDAR_NAME_LENGTH {
+		respondPrecondition(c, http.StatusForbidden, caldavValidObject)
+		return
+	}
+
+	if !found {
+		created := request.Note()
+		created.CalendarName, created.CalendarUID = name, uid
+		id, err := cc.service(c).Create(created)
+		if quotaExceeded(err) {
+			respondPrecondition(c, http.StatusInsufficientStorage, davQuotaNotExceeded)
+			return
+		}
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		created.ID = id
+		c.Header("Location", noteHref(created))
+		c.Status(http.StatusCreated)
+		return
+	}
+	if match := c.GetHeader("If-Match"); match != "" && match != "*" {
+		// The ETag matched, so the note was at its sequence when read.
+		sequence, err := cc.service(c).UpdateIfUnchanged(note.ID, note.Sequence, request.Note())
+		var conflict *NoteConflictError
+		if errors.As(err, &conflict) {
+			c.Status(http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			cc.respondUpdateError(c, err)
+			return
+		}
+		c.Header("ETag", noteETag(Note{Sequence: sequence}))
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if _, err := cc.service(c).Update(note.ID, request.Note()); err != nil {
+		cc.respondUpdateError(c, err)
+		return
+	}
+	if updated, found := cc.service(c).GetById(note.ID); found {
+		c.Header("ETag", noteETag(updated))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (cc *CalendarController) respondUpdateError(c *gin.Context, err error) {
+	if quotaExceeded(err) {
+		respondPrecondition(c, http.StatusInsufficientStorage, davQuotaNotExceeded)
+		return
+	}
+	c.Status(http.StatusInternalServerError)
+}
+
+func (cc *CalendarController) DeleteObject(c *gin.Context) {
+	note, found := cc.noteByName(c, c.Param("name"))
+	if !found {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if !preconditionsHold(c, note, found) {
+		c.Status(http.StatusPreconditionFailed)
+		return
+	}
+	if deleted := cc.service(c).Delete(note.ID); !deleted {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const vtodo = "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nUID:client-uid\r\nSUMMARY:Milk\r\nDESCRIPTION:2 bottles\r\n" +
+	"END:VTODO\r\nEND:VCALENDAR\r\n"
+
+func serveCalendar(mockService *MockService, method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	router := NewRouter(&Controllers{calendarController: CalendarController{mockService}})
+	response := httptest.NewRecorder()
+	request, _ := http.NewRequest(method, path, strings.NewReader(body))
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	router.ServeHTTP(response, request)
+	return response
+}
+
+func TestCalendarController_Feed(t *testing.T) {
+	due := time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+	mockService := &MockService{}
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{{{ID: 1, Title: "Milk", Sequence: 3, Due: &due}, {ID: 2, Title: "Undated"}}}, nil)
+
+	response := serveCalendar(mockService, "GET", "/v1/calendar.ics", "", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, CALENDAR_CONTENT_TYPE, response.Header().Get("Content-Type"))
+	assert.Contains(t, response.Body.String(), "BEGIN:VTODO\r\nUID:note-1@todo-go-api\r\n")
+	assert.Contains(t, response.Body.String(), "SUMMARY:Milk\r\n")
+	assert.Contains(t, response.Body.String(), "DUE:20240105T090000Z\r\n")
+	assert.NotContains(t, response.Body.String(), "Undated")
+
+	etag := response.Header().Get("ETag")
+	response = serveCalendar(mockService, "GET", "/v1/calendar.ics", "", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, response.Code)
+	assert.Empty(t, response.Body.String())
+
+	mockService = &MockService{}
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{}, errors.New("connection refused"))
+	response = serveCalendar(mockService, "GET", "/v1/calendar.ics", "", nil)
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+}
+
+func TestCalendarController_discovery(t *testing.T) {
+	response := serveCalendar(&MockService{}, "PROPFIND", "/.well-known/caldav", "", nil)
+	assert.Equal(t, http.StatusMovedPermanently, response.Code)
+	assert.Equal(t, CALDAV_ROOT_PATH, response.Header().Get("Location"))
+
+	response = serveCalendar(&MockService{}, "OPTIONS", CALDAV_CALENDAR_PATH, "", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Header().Get("DAV"), "calendar-access")
+
+	response = serveCalendar(&MockService{}, "PROPFIND", CALDAV_ROOT_PATH,
+		`<propfind xmlns="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><prop><current-user-principal/>`+
+			`<C:calendar-home-set/><getlastmodified/></prop></propfind>`, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, response.Code)
+	assert.Equal(t, xml.Header+`<d:multistatus `+davNamespaces()+`><d:response><d:href>/caldav/</d:href>`+
+		`<d:propstat><d:prop><d:current-user-principal><d:href>/caldav/</d:href></d:current-user-principal>`+
+		`<c:calendar-home-set><d:href>/caldav/</d:href></c:calendar-home-set></d:prop>`+
+		`<d:status>HTTP/1.1 200 OK</d:status></d:propstat>`+
+		`<d:propstat><d:prop><d:getlastmodified></d:getlastmodified></d:prop>`+
+		`<d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response></d:multistatus>`, response.Body.String())
+}
+
+func TestCalendarController_Propfind(t *testing.T) {
+	mockService := &MockService{}
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{{{ID: 1, Title: "Milk", Sequence: 3},
+		{ID: 2, Title: "Eggs", CalendarName: "client uid.ics"}}}, nil)
+	mockService.On("GetByCalendarName", mock.Anything).Return(Note{}, false)
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "Milk", Sequence: 3}, true)
+	mockService.On("GetById", uint64(2)).Return(Note{}, false)
+
+	response := serveCalendar(mockService, "PROPFIND", CALDAV_CALENDAR_PATH, "", map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, response.Code)
+	body := response.Body.String()
+	assert.Contains(t, body, "<d:resourcetype><d:collection/><c:calendar/></d:resourcetype>")
+	assert.Contains(t, body, `<c:supported-calendar-component-set><c:comp name="VTODO"/></c:supported-calendar-component-set>`)
+	assert.Contains(t, body, "<d:href>/caldav/notes/1.ics</d:href>")
+	assert.Contains(t, body, "<d:href>/caldav/notes/client%20uid.ics</d:href>")
+	assert.Contains(t, body, "<d:getetag>&#34;3&#34;</d:getetag>")
+	assert.NotContains(t, body, "calendar-data")
+
+	response = serveCalendar(mockService, "PROPFIND", CALDAV_CALENDAR_PATH+"1.ics", "", map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, response.Code)
+	response = serveCalendar(mockService, "PROPFIND", CALDAV_CALENDAR_PATH+"2.ics", "", nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	response = serveCalendar(mockService, "PROPFIND", CALDAV_CALENDAR_PATH, "<propfind", nil)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+}
+
+func TestCalendarController_Report(t *testing.T) {
+	due := time.Date(2023, 12, 31, 9, 0, 0, 0, time.UTC)
+	mockService := &MockService{}
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{{{ID: 1, Title: "Milk", Sequence: 3}, {ID: 3, Title: "Past", Due: &due}}}, nil)
+	mockService.On("GetByCalendarName", "client uid.ics").Return(Note{ID: 4, Title: "Eggs", CalendarName: "client uid.ics"}, true)
+	mockService.On("GetByCalendarName", mock.Anything).Return(Note{}, false)
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "Milk", Sequence: 3}, true)
+	mockService.On("GetById", uint64(2)).Return(Note{}, false)
+
+	for _, td := range []struct {
+		title    string
+		body     string
+		expected []string
+		excluded []string
+		status   int
+	}{
+		{
+			title: "Queries to-dos",
+			body: `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/>` +
+				`<C:calendar-data/></D:prop><C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VTODO">` +
+				`<C:time-range start="20240101T000000Z"/></C:comp-filter></C:comp-filter></C:filter></C:calendar-query>`,
+			expected: []string{"<d:href>/caldav/notes/1.ics</d:href>", "<d:getetag>&#34;3&#34;</d:getetag>",
+				"<c:calendar-data>BEGIN:VCALENDAR&#xD;&#xA;"},
+			excluded: []string{"<d:href>/caldav/notes/3.ics</d:href>"},
+			status:   http.StatusMultiStatus,
+		},
+		{
+			title: "Queries events",
+			body: `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/>` +
+				`</D:prop><C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"/></C:comp-filter>` +
+				`</C:filter></C:calendar-query>`,
+			expected: []string{"<d:multistatus " + davNamespaces() + "></d:multistatus>"},
+			status:   http.StatusMultiStatus,
+		},
+		{
+			title: "Gets to-dos by href",
+			body: `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop>` +
+				`<C:calendar-data/></D:prop><D:href>/caldav/notes/1.ics</D:href><D:href>/caldav/notes/2.ics</D:href>` +
+				`<D:href>/caldav/notes/client%20uid.ics</D:href></C:calendar-multiget>`,
+			expected: []string{"SUMMARY:Milk", "SUMMARY:Eggs", "<d:response><d:href>/caldav/notes/2.ics</d:href>" +
+				"<d:status>HTTP/1.1 404 Not Found</d:status></d:response>"},
+			status: http.StatusMultiStatus,
+		},
+		{
+			title:    "Rejects other reports",
+			body:     `<D:sync-collection xmlns:D="DAV:"><D:sync-token/></D:sync-collection>`,
+			expected: []string{"<d:supported-report></d:supported-report>"},
+			status:   http.StatusForbidden,
+		},
+	} {
+		t.Run("Report: "+td.title, func(t *testing.T) {
+			response := serveCalendar(mockService, "REPORT", CALDAV_CALENDAR_PATH, td.body, map[string]string{"Depth": "1"})
+			assert.Equal(t, td.status, response.Code)
+			for _, expected := range td.expected {
+				assert.Contains(t, response.Body.String(), expected)
+			}
+			for _, excluded := range td.excluded {
+				assert.NotContains(t, response.Body.String(), excluded)
+			}
+		})
+	}
+}
+
+func TestCalendarController_GetObject(t *testing.T) {
+	mockService := &MockService{}
+	mockService.On("GetByCalendarName", "client-uid.ics").Return(Note{ID: 2, Title: "Eggs", CalendarName: "client-uid.ics"}, true)
+	mockService.On("GetByCalendarName", mock.Anything).Return(Note{}, false)
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "Milk", Sequence: 3}, true)
+	mockService.On("GetById", uint64(2)).Return(Note{ID: 2, Title: "Eggs", CalendarName: "client-uid.ics"}, true)
+
+	response := serveCalendar(mockService, "GET", CALDAV_CALENDAR_PATH+"1.ics", "", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `"3"`, response.Header().Get("ETag"))
+	assert.Contains(t, response.Body.String(), "SUMMARY:Milk\r\n")
+
+	response = serveCalendar(mockService, "GET", CALDAV_CALENDAR_PATH+"client-uid.ics", "", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), "SUMMARY:Eggs\r\n")
+
+	response = serveCalendar(mockService, "GET", CALDAV_CALENDAR_PATH+"2.ics", "", nil)
+	assert.Equal(t, http.StatusNotFound, response.Code, "a note created under a name is not at its ID")
+	response = serveCalendar(mockService, "GET", CALDAV_CALENDAR_PATH+"milk.ics", "", nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestCalendarController_PutObject(t *testing.T) {
+	for _, td := range []struct {
+		title            string
+		name             string
+		body             string
+		headers          map[string]string
+		expectedStatus   int
+		expectedLocation string
+		expectedETag     string
+		expectedBody     string
+	}{
+		{
+			title:            "Creates a note",
+			name:             "client-uid.ics",
+			body:             vtodo,
+			expectedStatus:   http.StatusCreated,
+			expectedLocation: "/caldav/notes/client-uid.ics",
+		},
+		{
+			title:          "Updates a note",
+			name:           "1.ics",
+			body:           vtodo,
+			headers:        map[string]string{"If-Match": `"3"`},
+			expectedStatus: http.StatusNoContent,
+			expectedETag:   `"4"`,
+		},
+		{
+			title:          "Clears the content of a note without a description",
+			name:           "1.ics",
+			body:           strings.Replace(vtodo, "DESCRIPTION:2 bottles\r\n", "", 1),
+			expectedStatus: http.StatusNoContent,
+			expectedETag:   `"4"`,
+		},
+		{
+			title:          "Does not update a note changed since",
+			name:           "1.ics",
+			body:           vtodo,
+			headers:        map[string]string{"If-Match": `"2"`},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			title:          "Does not update a note changed after the check",
+			name:           "1.ics",
+			body:           strings.Replace(vtodo, "SUMMARY:Milk", "SUMMARY:Eggs", 1),
+			headers:        map[string]string{"If-Match": `"3"`},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			title:          "Does not overwrite a note with If-None-Match",
+			name:           "1.ics",
+			body:           vtodo,
+			headers:        map[string]string{"If-None-Match": "*"},
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			title:          "Rejects events",
+			name:           "event.ics",
+			body:           "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:Meeting\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "<c:supported-calendar-component></c:supported-calendar-component>",
+		},
+		{
+			title:          "Rejects invalid calendar data",
+			name:           "x.ics",
+			body:           "Milk",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "<c:valid-calendar-data></c:valid-calendar-data>",
+		},
+		{
+			title:          "Rejects invalid notes",
+			name:           "x.ics",
+			body:           strings.Replace(vtodo, "SUMMARY:Milk", "SUMMARY:"+strings.Repeat("a", MAX_TITLE_LENGTH+1), 1),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "<c:valid-calendar-object-resource></c:valid-calendar-object-resource>",
+		},
+		{
+			title:          "Rejects long names",
+			name:           strings.Repeat("a", MAX_CALENDAR_NAME_LENGTH+1),
+			body:           vtodo,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "<c:valid-calendar-object-resource></c:valid-calendar-object-resource>",
+		},
+	} {
+		t.Run("PutObject: "+td.title, func(t *testing.T) {
+			mockService := &MockService{}
+			mockService.On("GetByCalendarName", mock.Anything).Return(Note{}, false)
+			mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "Old", Sequence: 3}, true).Once()
+			mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "Milk", Content: "2 bottles", Sequence: 4}, true)
+			mockService.On("Update", uint64(1), Note{Title: "Milk"}).Return(uint64(1), nil)
+			mockService.On("UpdateIfUnchanged", uint64(1), uint64(3), Note{Title: "Milk", Content: "2 bottles"}).Return(uint64(4), nil)
+			mockService.On("UpdateIfUnchanged", uint64(1), uint64(3), Note{Title: "Eggs", Content: "2 bottles"}).
+				Return(uint64(0), &NoteConflictError{})
+			mockService.On("Create", Note{Title: "Milk", Content: "2 bottles", CalendarName: "client-uid.ics", CalendarUID: "client-uid"}).
+				Return(uint64(2), nil)
+
+			response := serveCalendar(mockService, "PUT", CALDAV_CALENDAR_PATH+td.name, td.body, td.headers)
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			assert.Equal(t, td.expectedLocation, response.Header().Get("Location"))
+			assert.Equal(t, td.expectedETag, response.Header().Get("ETag"))
+			assert.Contains(t, response.Body.String(), td.expectedBody)
+			if td.expectedStatus >= http.StatusBadRequest {
+				mockService.AssertNotCalled(t, "Create")
+				mockService.AssertNotCalled(t, "Update")
+			}
+		})
+	}
+}
+
+func TestCalendarController_DeleteObject(t *testing.T) {
+	mockService := &MockService{}
+	mockService.On("GetByCalendarName", mock.Anything).Return(Note{}, false)
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "Milk", Sequence: 3}, true)
+	mockService.On("GetById", uint64(2)).Return(Note{}, false)
+	mockService.On("Delete", uint64(1)).Return(true)
+
+	response := serveCalendar(mockService, "DELETE", CALDAV_CALENDAR_PATH+"1.ics", "", map[string]string{"If-Match": `"2"`})
+	assert.Equal(t, http.StatusPreconditionFailed, response.Code)
+	mockService.AssertNotCalled(t, "Delete", uint64(1))
+
+	response = serveCalendar(mockService, "DELETE", CALDAV_CALENDAR_PATH+"1.ics", "", map[string]string{"If-Match": `"3"`})
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	mockService.AssertCalled(t, "Delete", uint64(1))
+
+	response = serveCalendar(mockService, "DELETE", CALDAV_CALENDAR_PATH+"2.ics", "", nil)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
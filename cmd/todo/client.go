@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Note struct {
+	ID        uint64     `json:"id" yaml:"id"`
+	Title     string     `json:"title" yaml:"title"`
+	Content   string     `json:"content" yaml:"content"`
+	Tags      []string   `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Completed bool       `json:"completed,omitempty" yaml:"completed,omitempty"`
+	Due       *time.Time `json:"due,omitempty" yaml:"due,omitempty"`
+}
+
+type NoteRequest struct {
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	Tags      []string   `json:"tags"`
+	Completed bool       `json:"completed"`
+	Due       *time.Time `json:"due,omitempty"`
+}
+
+// Request is the note as sent back by a full update.
+func (n *Note) Request() NoteRequest {
+	return NoteRequest{Title: n.Title, Content: n.Content, Tags: n.Tags, Completed: n.Completed, Due: n.Due}
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is an error response of the API in the format of RFC 7807.
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail"`
+	Errors []FieldError `json:"errors"`
+}
+
+func (p *Problem) Error() string {
+	message := p.Title
+	if p.Detail != "" {
+		message += ": " + p.Detail
+	}
+	for _, fieldError := range p.Errors {
+		message += fmt.Sprintf("\n  %s %s", fieldError.Field, fieldError.Message)
+	}
+	return message
+}
+
+// Client calls the REST API of the server.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewClient returns a client giving up on requests after the timeout, so
+// that a server not responding does not hang the CLI.
+func NewClient(server string, apiKey string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(server, "/") + "/v1",
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *Client) do(method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		var problem Problem
+		if err := json.NewDecoder(response.Body).Decode(&problem); err != nil || problem.Title == "" {
+			return fmt.Errorf("server responded with %s", response.Status)
+		}
+		return &problem
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func notePath(id uint64) string {
+	return "/notes/" + strconv.FormatUint(id, 10)
+}
+
+func (c *Client) List() ([]Note, error) {
+	var notes []Note
+	err := c.do(http.MethodGet, "/notes", nil, &notes)
+	return notes, err
+}
+
+// Search returns the notes whose title or content contains the query,
+// ignoring case, and that have the tag, if given.
+func (c *Client) Search(query string, tag string) ([]Note, error) {
+	values := url.Values{}
+	if query != "" {
+		values.Set("q", query)
+	}
+	if tag != "" {
+		values.Set("tag", tag)
+	}
+	var notes []Note
+	err := c.do(http.MethodGet, "/notes?"+values.Encode(), nil, &notes)
+	return notes, err
+}
+
+func (c *Client) Get(id uint64) (Note, error) {
+	var note Note
+	err := c.do(http.MethodGet, notePath(id), nil, &note)
+	return note, err
+}
+
+func (c *Client) Create(request NoteRequest) error {
+	return c.do(http.MethodPost, "/notes", request, nil)
+}
+
+func (c *Client) Update(id uint64, request NoteRequest) error {
+	return c.do(http.MethodPut, notePath(id), request, nil)
+}
+
+func (c *Client) Delete(id uint64) error {
+	return c.do(http.MethodDelete, notePath(id), nil, nil)
+}
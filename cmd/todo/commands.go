@@ -0,0 +1,480 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// cli holds the global flags and the streams the commands write to.
+type cli struct {
+	configPath string
+	profile    string
+	server     string
+	output     string
+	timeout    time.Duration
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *cli) loadConfig() (*Config, error) {
+	return LoadConfig(c.configPath)
+}
+
+// client talks to the server of the profile unless --server is given.
+func (c *cli) client() (*Client, error) {
+	config, err := c.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	profile := config.Profile(c.profile)
+	if c.server != "" {
+		profile.Server = c.server
+	}
+	return NewClient(profile.Server, profile.APIKey, c.timeout), nil
+}
+
+func parseId(arg string) (uint64, error) {
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid note ID %q", arg)
+	}
+	return id, nil
+}
+
+func newRootCommand(stdin io.Reader, stdout io.Writer, stderr io.Writer) *cobra.Command {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	root := &cobra.Command{
+		Use:           "todo",
+		Short:         "Command-line client for the notes API",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if !validOutputFormat(c.output) {
+				return fmt.Errorf("output must be one of %s", strings.Join(outputFormats, ", "))
+			}
+			return nil
+		},
+	}
+	root.SetIn(stdin)
+	root.SetOut(stdout)
+	root.SetErr(stderr)
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&c.configPath, "config", defaultConfigPath(), "configuration file")
+	flags.StringVarP(&c.profile, "profile", "p", "", "profile to use instead of the current one")
+	flags.StringVar(&c.server, "server", "", "server URL, overriding the profile")
+	flags.DurationVar(&c.timeout, "timeout", DEFAULT_TIMEOUT, "timeout of each request to the server")
+	flags.StringVarP(&c.output, "output", "o", OUTPUT_TABLE, "output format: "+strings.Join(outputFormats, ", "))
+	root.RegisterFlagCompletionFunc("output", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return outputFormats, cobra.ShellCompDirectiveNoFileComp
+	})
+	root.RegisterFlagCompletionFunc("profile", c.completeProfiles)
+
+	root.AddCommand(
+		c.listCommand(),
+		c.showCommand(),
+		c.addCommand(),
+		c.editCommand(),
+		c.rmCommand(),
+		c.searchCommand(),
+		c.tagCommand(),
+		c.completeCommand(),
+		c.profileCommand(),
+	)
+	return root
+}
+
+// completeNoteIds completes note IDs with their titles as descriptions.
+func (c *cli) completeNoteIds(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	client, err := c.client()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	notes, err := client.List()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	completions := make([]string, 0, len(notes))
+	for _, note := range notes {
+		id := strconv.FormatUint(note.ID, 10)
+		if strings.HasPrefix(id, toComplete) {
+			completions = append(completions, id+"\t"+note.Title)
+		}
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+func (c *cli) completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config, err := c.loadConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func (c *cli) listCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List notes",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			notes, err := client.List()
+			if err != nil {
+				return err
+			}
+			return printNotes(c.stdout, c.output, notes)
+		},
+	}
+}
+
+func (c *cli) showCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:               "show <id>",
+		Short:             "Show a note",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: c.completeNoteIds,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseId(args[0])
+			if err != nil {
+				return err
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			note, err := client.Get(id)
+			if err != nil {
+				return err
+			}
+			return printNote(c.stdout, c.output, note)
+		},
+	}
+}
+
+func (c *cli) addCommand() *cobra.Command {
+	var content string
+	cmd := &cobra.Command{
+		Use:   "add <title>",
+		Short: "Add a note",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			request := NoteRequest{Title: strings.Join(args, " "), Content: content}
+			if err := client.Create(request); err != nil {
+				return err
+			}
+			fmt.Fprintln(c.stdout, "Note added")
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&content, "content", "c", "", "content of the note")
+	return cmd
+}
+
+func (c *cli) editCommand() *cobra.Command {
+	var title, content string
+	cmd := &cobra.Command{
+		Use:   "edit <id>",
+		Short: "Edit a note",
+		Long: "Edit a note in $VISUAL or $EDITOR, with the title on the first line and the content after a blank line.\n" +
+			"With --title or --content, the note is changed without opening the editor.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: c.completeNoteIds,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseId(args[0])
+			if err != nil {
+				return err
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			note, err := client.Get(id)
+			if err != nil {
+				return err
+			}
+
+			request := note.Request()
+			flags := cmd.Flags()
+			if flags.Changed("title") || flags.Changed("content") {
+				if flags.Changed("title") {
+					request.Title = title
+				}
+				if flags.Changed("content") {
+					request.Content = content
+				}
+			} else if request, err = editNote(note, c.stdin, c.stdout, c.stderr); err != nil {
+				return err
+			}
+
+			if request.Title == note.Title && request.Content == note.Content {
+				fmt.Fprintln(c.stdout, "Note unchanged")
+				return nil
+			}
+			if err := client.Update(id, request); err != nil {
+				return err
+			}
+			fmt.Fprintf(c.stdout, "Note %d updated\n", id)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&title, "title", "t", "", "new title of the note")
+	cmd.Flags().StringVarP(&content, "content", "c", "", "new content of the note")
+	return cmd
+}
+
+func (c *cli) rmCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:               "rm <id>...",
+		Short:             "Delete notes",
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: c.completeNoteIds,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids := make([]uint64, 0, len(args))
+			for _, arg := range args {
+				id, err := parseId(arg)
+				if err != nil {
+					return err
+				}
+				ids = append(ids, id)
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				if err := client.Delete(id); err != nil {
+					return fmt.Errorf("note %d: %w", id, err)
+				}
+				fmt.Fprintf(c.stdout, "Note %d deleted\n", id)
+			}
+			return nil
+		},
+	}
+}
+
+func (c *cli) searchCommand() *cobra.Command {
+	var tag string
+	cmd := &cobra.Command{
+		Use:   "search [query]",
+		Short: "Search notes by title and content, ignoring case, or by tag",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := strings.Join(args, " ")
+			if query == "" && tag == "" {
+				return errors.New("a query or --tag is required")
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			notes, err := client.Search(query, tag)
+			if err != nil {
+				return err
+			}
+			return printNotes(c.stdout, c.output, notes)
+		},
+	}
+	cmd.Flags().StringVarP(&tag, "tag", "t", "", "tag the notes have")
+	return cmd
+}
+
+// updateNotes changes each note with fn and saves it, unless fn leaves it
+// unchanged.
+func (c *cli) updateNotes(args []string, fn func(request *NoteRequest)) error {
+	ids := make([]uint64, 0, len(args))
+	for _, arg := range args {
+		id, err := parseId(arg)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	client, err := c.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		note, err := client.Get(id)
+		if err != nil {
+			return fmt.Errorf("note %d: %w", id, err)
+		}
+		request := note.Request()
+		fn(&request)
+		if reflect.DeepEqual(request, note.Request()) {
+			fmt.Fprintf(c.stdout, "Note %d unchanged\n", id)
+			continue
+		}
+		if err := client.Update(id, request); err != nil {
+			return fmt.Errorf("note %d: %w", id, err)
+		}
+		fmt.Fprintf(c.stdout, "Note %d updated\n", id)
+	}
+	return nil
+}
+
+func (c *cli) tagCommand() *cobra.Command {
+	var remove bool
+	cmd := &cobra.Command{
+		Use:   "tag <id> <tag>...",
+		Short: "Add tags to a note, or remove them with --remove",
+		Args:  cobra.MinimumNArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return c.completeNoteIds(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.updateNotes(args[:1], func(request *NoteRequest) {
+				tags := []string{}
+				for _, tag := range request.Tags {
+					if !remove || !contains(args[1:], tag) {
+						tags = append(tags, tag)
+					}
+				}
+				for _, tag := range args[1:] {
+					if !remove && !contains(tags, tag) {
+						tags = append(tags, tag)
+					}
+				}
+				if len(tags) != len(request.Tags) {
+					request.Tags = tags
+				}
+			})
+		},
+	}
+	cmd.Flags().BoolVarP(&remove, "remove", "r", false, "remove the tags instead")
+	return cmd
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cli) completeCommand() *cobra.Command {
+	var undo bool
+	cmd := &cobra.Command{
+		Use:               "complete <id>...",
+		Short:             "Mark notes as completed, or as not completed with --undo",
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: c.completeNoteIds,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.updateNotes(args, func(request *NoteRequest) {
+				request.Completed = !undo
+			})
+		},
+	}
+	cmd.Flags().BoolVarP(&undo, "undo", "u", false, "mark the notes as not completed")
+	return cmd
+}
+
+func (c *cli) profileCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage profiles of servers and API keys",
+	}
+
+	var server, apiKey string
+	set := &cobra.Command{
+		Use:   "set <name>",
+		Short: "Create or change a profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := c.loadConfig()
+			if err != nil {
+				return err
+			}
+			profile := config.Profiles[args[0]]
+			if cmd.Flags().Changed("server") {
+				profile.Server = server
+			}
+			if cmd.Flags().Changed("api-key") {
+				profile.APIKey = apiKey
+			}
+			config.Profiles[args[0]] = profile
+			if config.Current == "" {
+				config.Current = args[0]
+			}
+			return config.Save(c.configPath)
+		},
+	}
+	set.Flags().StringVar(&server, "server", "", "server URL")
+	set.Flags().StringVar(&apiKey, "api-key", "", "API key sent as a bearer token")
+
+	use := &cobra.Command{
+		Use:               "use <name>",
+		Short:             "Make a profile the current one",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: c.completeProfiles,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := c.loadConfig()
+			if err != nil {
+				return err
+			}
+			if _, found := config.Profiles[args[0]]; !found {
+				return fmt.Errorf("profile %q does not exist", args[0])
+			}
+			config.Current = args[0]
+			return config.Save(c.configPath)
+		},
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := c.loadConfig()
+			if err != nil {
+				return err
+			}
+			if c.output != OUTPUT_TABLE {
+				return printValue(c.stdout, c.output, config.Redacted())
+			}
+			names, _ := c.completeProfiles(cmd, args, "")
+			current := config.ProfileName("")
+			table := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(table, "CURRENT\tNAME\tSERVER")
+			for _, name := range names {
+				marker := ""
+				if name == current {
+					marker = "*"
+				}
+				fmt.Fprintf(table, "%s\t%s\t%s\n", marker, name, config.Profiles[name].Server)
+			}
+			return table.Flush()
+		},
+	}
+
+	cmd.AddCommand(set, use, list)
+	return cmd
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAPI is an in-memory server of the notes API.
+type fakeAPI struct {
+	mu             sync.Mutex
+	notes          map[uint64]Note
+	nextId         uint64
+	authorizations []string
+}
+
+func newFakeAPI(notes ...Note) *fakeAPI {
+	api := &fakeAPI{notes: map[uint64]Note{}, nextId: 1}
+	for _, note := range notes {
+		api.notes[note.ID] = note
+		if note.ID >= api.nextId {
+			api.nextId = note.ID + 1
+		}
+	}
+	return api
+}
+
+func (api *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.authorizations = append(api.authorizations, r.Header.Get("Authorization"))
+
+	respond := func(status int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+	notFound := func() {
+		respond(http.StatusNotFound, Problem{Title: "Not found", Status: http.StatusNotFound, Detail: "Note does not exist"})
+	}
+	decode := func() (NoteRequest, bool) {
+		var request NoteRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Title == "" {
+			respond(http.StatusUnprocessableEntity, Problem{Title: "Validation failed", Status: http.StatusUnprocessableEntity,
+				Detail: "Request body has invalid fields", Errors: []FieldError{{"title", "is required"}}})
+			return request, false
+		}
+		return request, true
+	}
+	success := map[string]interface{}{"status": 200, "message": "Success"}
+
+	if r.URL.Path == "/v1/notes" {
+		switch r.Method {
+		case http.MethodGet:
+			query, tag := strings.ToLower(r.URL.Query().Get("q")), r.URL.Query().Get("tag")
+			notes := []Note{}
+			for _, note := range api.notes {
+				if (strings.Contains(strings.ToLower(note.Title), query) || strings.Contains(strings.ToLower(note.Content), query)) &&
+					(tag == "" || contains(note.Tags, tag)) {
+					notes = append(notes, note)
+				}
+			}
+			sort.Slice(notes, func(i, j int) bool { return notes[i].ID < notes[j].ID })
+			respond(http.StatusOK, notes)
+		case http.MethodPost:
+			if request, ok := decode(); ok {
+				api.notes[api.nextId] = Note{ID: api.nextId, Title: request.Title, Content: request.Content, Tags: request.Tags, Completed: request.Completed, Due: request.Due}
+				api.nextId++
+				respond(http.StatusOK, success)
+			}
+		}
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/v1/notes/"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if _, found := api.notes[id]; !found {
+		notFound()
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		respond(http.StatusOK, api.notes[id])
+	case http.MethodPut:
+		if request, ok := decode(); ok {
+			api.notes[id] = Note{ID: id, Title: request.Title, Content: request.Content, Tags: request.Tags, Completed: request.Completed, Due: request.Due}
+			respond(http.StatusOK, success)
+		}
+	case http.MethodDelete:
+		delete(api.notes, id)
+		respond(http.StatusOK, success)
+	}
+}
+
+// runTodo runs the CLI against the server with a configuration file in a
+// temporary directory.
+func runTodo(t *testing.T, server *httptest.Server, configPath string, args ...string) (string, error) {
+	t.Setenv("TODO_CONFIG", configPath)
+	var stdout, stderr bytes.Buffer
+	root := newRootCommand(strings.NewReader(""), &stdout, &stderr)
+	if server != nil {
+		args = append(args, "--server", server.URL)
+	}
+	root.SetArgs(args)
+	err := root.Execute()
+	return stdout.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	for _, td := range []struct {
+		title          string
+		args           []string
+		expectedOutput string
+		expectedError  string
+		expectedNotes  map[uint64]Note
+	}{
+		{
+			title:          "list prints a table",
+			args:           []string{"list"},
+			expectedOutput: "ID  DONE  TITLE     CONTENT\n1         shopping  milk\n2   x     work      first line\n",
+		},
+		{
+			title: "list prints JSON",
+			args:  []string{"list", "-o", "json"},
+			expectedOutput: "[\n  {\n    \"id\": 1,\n    \"title\": \"shopping\",\n    \"content\": \"milk\\neggs\",\n    \"tags\": [\n      \"home\"\n    ]\n  },\n" +
+				"  {\n    \"id\": 2,\n    \"title\": \"work\",\n    \"content\": \"first line\",\n    \"completed\": true\n  }\n]\n",
+		},
+		{
+			title:          "show prints YAML",
+			args:           []string{"show", "1", "-o", "yaml"},
+			expectedOutput: "id: 1\ntitle: shopping\ncontent: |-\n  milk\n  eggs\ntags:\n- home\n",
+		},
+		{
+			title:          "show prints a note",
+			args:           []string{"show", "1"},
+			expectedOutput: "ID:     1\nTitle:  shopping\nTags:   home\n\nmilk\neggs\n",
+		},
+		{
+			title:         "show reports problems",
+			args:          []string{"show", "9"},
+			expectedError: "Not found: Note does not exist",
+		},
+		{
+			title:         "show rejects invalid IDs",
+			args:          []string{"show", "x"},
+			expectedError: "invalid note ID \"x\"",
+		},
+		{
+			title:         "Invalid output formats are rejected",
+			args:          []string{"list", "-o", "xml"},
+			expectedError: "output must be one of table, json, yaml",
+		},
+		{
+			title:          "add creates a note",
+			args:           []string{"add", "call", "mom", "-c", "tonight"},
+			expectedOutput: "Note added\n",
+			expectedNotes:  map[uint64]Note{3: {ID: 3, Title: "call mom", Content: "tonight"}},
+		},
+		{
+			title:          "edit changes the title by flag",
+			args:           []string{"edit", "2", "--title", "job"},
+			expectedOutput: "Note 2 updated\n",
+			expectedNotes:  map[uint64]Note{2: {ID: 2, Title: "job", Content: "first line", Completed: true}},
+		},
+		{
+			title:         "edit reports field errors",
+			args:          []string{"edit", "2", "--title", ""},
+			expectedError: "Validation failed: Request body has invalid fields\n  title is required",
+		},
+		{
+			title:          "rm deletes notes",
+			args:           []string{"rm", "1", "2"},
+			expectedOutput: "Note 1 deleted\nNote 2 deleted\n",
+			expectedNotes:  map[uint64]Note{},
+		},
+		{
+			title:          "search matches content ignoring case",
+			args:           []string{"search", "EGGS"},
+			expectedOutput: "ID  DONE  TITLE     CONTENT\n1         shopping  milk\n",
+		},
+		{
+			title:          "search finds notes by tag",
+			args:           []string{"search", "--tag", "home"},
+			expectedOutput: "ID  DONE  TITLE     CONTENT\n1         shopping  milk\n",
+		},
+		{
+			title:         "search requires a query or tag",
+			args:          []string{"search"},
+			expectedError: "a query or --tag is required",
+		},
+		{
+			title:          "tag adds tags",
+			args:           []string{"tag", "1", "shop", "home"},
+			expectedOutput: "Note 1 updated\n",
+			expectedNotes:  map[uint64]Note{1: {ID: 1, Title: "shopping", Content: "milk\neggs", Tags: []string{"home", "shop"}}},
+		},
+		{
+			title:          "tag removes tags",
+			args:           []string{"tag", "1", "--remove", "home"},
+			expectedOutput: "Note 1 updated\n",
+			expectedNotes:  map[uint64]Note{1: {ID: 1, Title: "shopping", Content: "milk\neggs", Tags: []string{}}},
+		},
+		{
+			title:          "tag leaves notes with the tags unchanged",
+			args:           []string{"tag", "1", "home"},
+			expectedOutput: "Note 1 unchanged\n",
+		},
+		{
+			title:          "complete marks notes as completed",
+			args:           []string{"complete", "1", "2"},
+			expectedOutput: "Note 1 updated\nNote 2 unchanged\n",
+			expectedNotes:  map[uint64]Note{1: {ID: 1, Title: "shopping", Content: "milk\neggs", Tags: []string{"home"}, Completed: true}},
+		},
+		{
+			title:          "complete --undo marks notes as not completed",
+			args:           []string{"complete", "--undo", "2"},
+			expectedOutput: "Note 2 updated\n",
+			expectedNotes:  map[uint64]Note{2: {ID: 2, Title: "work", Content: "first line"}},
+		},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			api := newFakeAPI(Note{ID: 1, Title: "shopping", Content: "milk\neggs", Tags: []string{"home"}},
+				Note{ID: 2, Title: "work", Content: "first line", Completed: true})
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			output, err := runTodo(t, server, filepath.Join(t.TempDir(), "config.yaml"), td.args...)
+			if td.expectedError != "" {
+				assert.EqualError(t, err, td.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedOutput, output)
+			for id, note := range td.expectedNotes {
+				assert.Equal(t, note, api.notes[id])
+			}
+			if td.expectedNotes != nil && len(td.expectedNotes) == 0 {
+				assert.Empty(t, api.notes)
+			}
+		})
+	}
+}
+
+func TestEditCommand_editor(t *testing.T) {
+	due := time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+	api := newFakeAPI(Note{ID: 1, Title: "shopping", Content: "milk\neggs", Tags: []string{"home"}, Due: &due})
+	server := httptest.NewServer(api)
+	defer server.Close()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "sed -i s/eggs/bread/")
+	output, err := runTodo(t, server, configPath, "edit", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Note 1 updated\n", output)
+	assert.Equal(t, Note{ID: 1, Title: "shopping", Content: "milk\nbread", Tags: []string{"home"}, Due: &due}, api.notes[1])
+
+	t.Setenv("EDITOR", "true")
+	output, err = runTodo(t, server, configPath, "edit", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Note unchanged\n", output)
+}
+
+func TestParseNoteFile(t *testing.T) {
+	assert.Equal(t, NoteRequest{Title: "title", Content: "line\n\nline"}, parseNoteFile(formatNoteFile(Note{Title: "title", Content: "line\n\nline"})))
+	assert.Equal(t, NoteRequest{Title: "title"}, parseNoteFile(formatNoteFile(Note{Title: "title"})))
+	assert.Equal(t, NoteRequest{Title: "title"}, parseNoteFile(" title \n"))
+}
+
+func TestProfiles(t *testing.T) {
+	api := newFakeAPI(Note{ID: 1, Title: "shopping"})
+	server := httptest.NewServer(api)
+	defer server.Close()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	_, err := runTodo(t, nil, configPath, "profile", "set", "local", "--server", server.URL, "--api-key", "secret")
+	assert.NoError(t, err)
+	_, err = runTodo(t, nil, configPath, "profile", "set", "prod", "--server", "https://notes.example.com")
+	assert.NoError(t, err)
+
+	output, err := runTodo(t, nil, configPath, "profile", "list")
+	assert.NoError(t, err)
+	assert.Equal(t, "CURRENT  NAME   SERVER\n*        local  "+server.URL+"\n         prod   https://notes.example.com\n", output)
+
+	// Printed API keys are redacted.
+	output, err = runTodo(t, nil, configPath, "profile", "list", "-o", "yaml")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "api_key: '****'")
+	assert.NotContains(t, output, "secret")
+
+	// The current profile gives the server and the API key.
+	_, err = runTodo(t, nil, configPath, "list")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Bearer secret"}, api.authorizations)
+
+	_, err = runTodo(t, nil, configPath, "profile", "use", "missing")
+	assert.EqualError(t, err, "profile \"missing\" does not exist")
+	_, err = runTodo(t, nil, configPath, "profile", "use", "prod")
+	assert.NoError(t, err)
+
+	config, err := LoadConfig(configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, &Config{Current: "prod", Profiles: map[string]Profile{
+		"local": {server.URL, "secret"},
+		"prod":  {"https://notes.example.com", ""},
+	}}, config)
+	data, err := ioutil.ReadFile(configPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "api_key: secret")
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	config := &Config{Current: "prod", Profiles: map[string]Profile{
+		"prod":  {"https://notes.example.com", "0123456789abcdef"},
+		"short": {"https://notes.example.com", "secret"},
+		"none":  {"https://notes.example.com", ""},
+	}}
+
+	assert.Equal(t, &Config{Current: "prod", Profiles: map[string]Profile{
+		"prod":  {"https://notes.example.com", "****cdef"},
+		"short": {"https://notes.example.com", "****"},
+		"none":  {"https://notes.example.com", ""},
+	}}, config.Redacted())
+	assert.Equal(t, "0123456789abcdef", config.Profiles["prod"].APIKey)
+}
+
+func TestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	_, err := runTodo(t, nil, filepath.Join(t.TempDir(), "config.yaml"), "list", "--server", server.URL, "--timeout", "50ms")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Client.Timeout exceeded")
+}
+
+func TestCompletion(t *testing.T) {
+	api := newFakeAPI(Note{ID: 1, Title: "shopping"}, Note{ID: 12, Title: "work"}, Note{ID: 2, Title: "call"})
+	server := httptest.NewServer(api)
+	defer server.Close()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	output, err := runTodo(t, nil, configPath, "__complete", "--server", server.URL, "show", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1\tshopping\n12\twork\n:4\n", strings.SplitAfter(output, ":4\n")[0])
+
+	output, err = runTodo(t, nil, configPath, "completion", "bash")
+	assert.NoError(t, err)
+	assert.Contains(t, output, "__start_todo")
+}
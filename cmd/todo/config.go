@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DEFAULT_SERVER  = "http://localhost:8080"
+	DEFAULT_PROFILE = "default"
+	DEFAULT_TIMEOUT = 30 * time.Second
+)
+
+type Profile struct {
+	Server string `yaml:"server"`
+	APIKey string `yaml:"api_key,omitempty"`
+}
+
+// Config is the configuration file of the CLI. Each profile is a server and
+// the API key for it; Current is used unless --profile is given.
+type Config struct {
+	Current  string             `yaml:"current,omitempty"`
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+// defaultConfigPath is $TODO_CONFIG, or todo/config.yaml in the user
+// configuration directory.
+func defaultConfigPath() string {
+	if path := os.Getenv("TODO_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "todo.yaml"
+	}
+	return filepath.Join(dir, "todo", "config.yaml")
+}
+
+// LoadConfig reads the configuration file. A missing file is an empty
+// configuration.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{Profiles: map[string]Profile{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]Profile{}
+	}
+	return config, nil
+}
+
+// Save writes the configuration file readable only by the user, since it
+// holds API keys.
+func (c *Config) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Redacted returns the configuration with only the last 4 characters of the
+// API keys shown, for printing.
+func (c *Config) Redacted() *Config {
+	redacted := &Config{Current: c.Current, Profiles: map[string]Profile{}}
+	for name, profile := range c.Profiles {
+		profile.APIKey = redactAPIKey(profile.APIKey)
+		redacted.Profiles[name] = profile
+	}
+	return redacted
+}
+
+// redactAPIKey hides keys too short to show a part of entirely.
+func redactAPIKey(apiKey string) string {
+	switch {
+	case apiKey == "":
+		return ""
+	case len(apiKey) <= 8:
+		return "****"
+	}
+	return "****" + apiKey[len(apiKey)-4:]
+}
+
+func (c *Config) ProfileName(name string) string {
+	if name != "" {
+		return name
+	}
+	if c.Current != "" {
+		return c.Current
+	}
+	return DEFAULT_PROFILE
+}
+
+// Profile returns the named profile, or the current one if name is empty.
+// An unknown profile talks to the default server.
+func (c *Config) Profile(name string) Profile {
+	profile := c.Profiles[c.ProfileName(name)]
+	if profile.Server == "" {
+		profile.Server = DEFAULT_SERVER
+	}
+	return profile
+}
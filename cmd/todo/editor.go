@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// editorCommand is $VISUAL or $EDITOR, split on spaces so that editors can
+// be given with arguments.
+func editorCommand() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"vi"}
+}
+
+// formatNoteFile is the note as edited: the title on the first line, then a
+// blank line and the content.
+func formatNoteFile(note Note) string {
+	return note.Title + "\n\n" + note.Content + "\n"
+}
+
+func parseNoteFile(text string) NoteRequest {
+	parts := strings.SplitN(text, "\n", 2)
+	request := NoteRequest{Title: strings.TrimSpace(parts[0])}
+	if len(parts) == 2 {
+		content := strings.TrimPrefix(parts[1], "\n")
+		request.Content = strings.TrimSuffix(content, "\n")
+	}
+	return request
+}
+
+// editNote opens the note in the editor and returns it as saved, with the
+// tags, completion and due date of the note.
+func editNote(note Note, stdin io.Reader, stdout io.Writer, stderr io.Writer) (NoteRequest, error) {
+	file, err := ioutil.TempFile("", "todo-*.md")
+	if err != nil {
+		return NoteRequest{}, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(formatNoteFile(note))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return NoteRequest{}, err
+	}
+
+	editor := editorCommand()
+	cmd := exec.Command(editor[0], append(editor[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if err := cmd.Run(); err != nil {
+		return NoteRequest{}, errors.New("editor failed: " + err.Error())
+	}
+
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return NoteRequest{}, err
+	}
+	request := parseNoteFile(string(data))
+	request.Tags, request.Completed, request.Due = note.Tags, note.Completed, note.Due
+	return request, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	OUTPUT_TABLE = "table"
+	OUTPUT_JSON  = "json"
+	OUTPUT_YAML  = "yaml"
+
+	MAX_CONTENT_COLUMN_LENGTH = 50
+)
+
+var outputFormats = []string{OUTPUT_TABLE, OUTPUT_JSON, OUTPUT_YAML}
+
+func validOutputFormat(format string) bool {
+	for _, outputFormat := range outputFormats {
+		if format == outputFormat {
+			return true
+		}
+	}
+	return false
+}
+
+// printValue prints the value as JSON or YAML.
+func printValue(w io.Writer, format string, value interface{}) error {
+	if format == OUTPUT_YAML {
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		return encoder.Encode(value)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// summary is the first line of the content, shortened to fit in a table.
+func summary(content string) string {
+	line := strings.SplitN(content, "\n", 2)[0]
+	if runes := []rune(line); len(runes) > MAX_CONTENT_COLUMN_LENGTH {
+		return string(runes[:MAX_CONTENT_COLUMN_LENGTH-3]) + "..."
+	}
+	return line
+}
+
+func done(note Note) string {
+	if note.Completed {
+		return "x"
+	}
+	return ""
+}
+
+func printNotes(w io.Writer, format string, notes []Note) error {
+	if format != OUTPUT_TABLE {
+		if notes == nil {
+			notes = []Note{}
+		}
+		return printValue(w, format, notes)
+	}
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tDONE\tTITLE\tCONTENT")
+	for _, note := range notes {
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\n", note.ID, done(note), note.Title, summary(note.Content))
+	}
+	return table.Flush()
+}
+
+func printNote(w io.Writer, format string, note Note) error {
+	if format != OUTPUT_TABLE {
+		return printValue(w, format, note)
+	}
+	fmt.Fprintf(w, "ID:     %d\nTitle:  %s\n", note.ID, note.Title)
+	if len(note.Tags) > 0 {
+		fmt.Fprintf(w, "Tags:   %s\n", strings.Join(note.Tags, ", "))
+	}
+	if note.Due != nil {
+		fmt.Fprintf(w, "Due:    %s\n", note.Due.Local().Format("2006-01-02 15:04"))
+	}
+	if note.Completed {
+		fmt.Fprintln(w, "Done:   yes")
+	}
+	if note.Content != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimRight(note.Content, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CONFIG_FILE_ENV  = "CONFIG_FILE"
+	CONFIG_FILE_FLAG = "config"
+
+	CONFIG_FORMAT_YAML = "yaml"
+	CONFIG_FORMAT_TOML = "toml"
+
+	// SECRET_FILE_SUFFIX names the variable holding the path of a file with
+	// a secret, as Docker secrets are mounted.
+	SECRET_FILE_SUFFIX = "_FILE"
+	REDACTED           = "REDACTED"
+)
+
+// Duration is a time.Duration written as a string such as "30s" in
+// configuration files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil || duration < 0 {
+		return fmt.Errorf("%q is not a duration such as 30s", text)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// HTTPConfig configures the HTTP server. Read and write timeouts are off by
+// default, as they would also end event streams and long exports; a zero
+// timeout is no timeout.
+type HTTPConfig struct {
+	Addr              string   `yaml:"addr" toml:"addr"`
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	ReadTimeout       Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout      Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// ShutdownTimeout is how long in-flight requests and background work
+	// are given to finish on SIGINT or SIGTERM.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+type GRPCConfig struct {
+	Addr string `yaml:"addr" toml:"addr"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	Name     string `yaml:"name" toml:"name"`
+	SSLMode  string `yaml:"sslmode" toml:"sslmode"`
+}
+
+// DSN is the connection string of the database, with values quoted as
+// libpq requires.
+func (dc DatabaseConfig) DSN() string {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	dsn := fmt.Sprintf("host='%s' port=%d user='%s' password='%s' dbname='%s'",
+		quote.Replace(dc.Host), dc.Port, quote.Replace(dc.User), quote.Replace(dc.Password), quote.Replace(dc.Name))
+	if dc.SSLMode != "" {
+		dsn += fmt.Sprintf(" sslmode='%s'", quote.Replace(dc.SSLMode))
+	}
+	return dsn
+}
+
+type LogConfig struct {
+	Level string `yaml:"level" toml:"level"`
+}
+
+type OpenAPIConfig struct {
+	// Validation checks requests, and responses in test mode, against the
+	// OpenAPI document.
+	Validation bool `yaml:"validation" toml:"validation"`
+}
+
+// TracingConfig selects the exporter of spans. The OTLP exporter takes its
+// endpoint, headers and TLS settings from the standard OTEL_EXPORTER_OTLP_*
+// variables.
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	Protocol string `yaml:"protocol" toml:"protocol"`
+}
+
+// EventsConfig configures the log of note changes that event streams catch
+// up from.
+type EventsConfig struct {
+	Retention Duration `yaml:"retention" toml:"retention"`
+}
+
+type SMTPConfig struct {
+	Addr     string   `yaml:"addr" toml:"addr"`
+	From     string   `yaml:"from" toml:"from"`
+	To       []string `yaml:"to" toml:"to"`
+	Username string   `yaml:"username" toml:"username"`
+	Password string   `yaml:"password" toml:"password"`
+}
+
+type NotifierConfig struct {
+	Kind       string     `yaml:"kind" toml:"kind"`
+	WebhookURL string     `yaml:"webhook_url" toml:"webhook_url"`
+	SMTP       SMTPConfig `yaml:"smtp" toml:"smtp"`
+}
+
+// RateLimit allows Requests per Period on average, in bursts of up to Burst
+// requests (Requests if 0).
+type RateLimit struct {
+	Requests int      `yaml:"requests" toml:"requests"`
+	Period   Duration `yaml:"period" toml:"period"`
+	Burst    int      `yaml:"burst" toml:"burst"`
+}
+
+// RouteRateLimit limits the requests of each client to a route, given by its
+// method and its path under /v1 as routed, with parameters written :name
+// rather than {name} as in the OpenAPI document, such as POST /notes,
+// POST /notes:batch or PUT /notes/:id.
+type RouteRateLimit struct {
+	Method string    `yaml:"method" toml:"method"`
+	Path   string    `yaml:"path" toml:"path"`
+	Limit  RateLimit `yaml:"limit" toml:"limit"`
+}
+
+// RateLimitConfig limits the requests of each client to the API: to a route
+// by its limit in Routes, and to the other routes together by Default.
+// Clients with one of APIKeys are limited by their key, and the others by
+// their IP address.
+type RateLimitConfig struct {
+	Enabled bool             `yaml:"enabled" toml:"enabled"`
+	Store   string           `yaml:"store" toml:"store"`
+	Default RateLimit        `yaml:"default" toml:"default"`
+	Routes  []RouteRateLimit `yaml:"routes" toml:"routes"`
+	APIKeys []string         `yaml:"api_keys" toml:"api_keys"`
+}
+
+// QuotaConfig limits the notes of each workspace; a limit of 0 is no limit.
+type QuotaConfig struct {
+	MaxNotes int `yaml:"max_notes" toml:"max_notes"`
+	MaxBytes int `yaml:"max_bytes" toml:"max_bytes"`
+}
+
+// Config is the configuration of the server. It is loaded from defaults, a
+// YAML or TOML file, environment variables and flags, each overriding the
+// ones before.
+type Config struct {
+	HTTP      HTTPConfig      `yaml:"http" toml:"http"`
+	GRPC      GRPCConfig      `yaml:"grpc" toml:"grpc"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	OpenAPI   OpenAPIConfig   `yaml:"openapi" toml:"openapi"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Events    EventsConfig    `yaml:"events" toml:"events"`
+	Notifier  NotifierConfig  `yaml:"notifier" toml:"notifier"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Quota     QuotaConfig     `yaml:"quota" toml:"quota"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		HTTP: HTTPConfig{
+			Addr:              ":8080",
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		GRPC:     GRPCConfig{Addr: ":9090"},
+		Database: DatabaseConfig{Host: "localhost", Port: 5432, User: "postgres", Name: "todo"},
+		Log:      LogConfig{Level: "info"},
+		Tracing:  TracingConfig{Exporter: TRACES_EXPORTER_NONE, Protocol: OTLP_PROTOCOL_HTTP},
+		Events:   EventsConfig{Retention: Duration(7 * 24 * time.Hour)},
+		Notifier: NotifierConfig{Kind: NOTIFIER_LOG},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Store:   RATE_LIMIT_STORE_MEMORY,
+			Default: RateLimit{Requests: 100, Period: Duration(time.Minute)},
+		},
+	}
+}
+
+// setting binds a value of the configuration to an environment variable
+// and a flag. Secrets have no flags, as command lines are visible to other
+// processes, and can be read from files instead.
+type setting struct {
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	value  interface{}
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"HTTP_ADDR", "http-addr", "address to serve HTTP on", false, &c.HTTP.Addr},
+		{"HTTP_READ_HEADER_TIMEOUT", "http-read-header-timeout", "time to read request headers", false, &c.HTTP.ReadHeaderTimeout},
+		{"HTTP_READ_TIMEOUT", "http-read-timeout", "time to read requests (0 for none)", false, &c.HTTP.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", "http-write-timeout", "time to write responses (0 for none)", false, &c.HTTP.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", "http-idle-timeout", "time to keep idle connections open", false, &c.HTTP.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "time to finish requests and background work on shutdown", false, &c.HTTP.ShutdownTimeout},
+		{"GRPC_ADDR", "grpc-addr", "address to serve gRPC on", false, &c.GRPC.Addr},
+		{"POSTGRES_HOST", "db-host", "database host", false, &c.Database.Host},
+		{"POSTGRES_PORT", "db-port", "database port", false, &c.Database.Port},
+		{"POSTGRES_USER", "db-user", "database user", false, &c.Database.User},
+		{"POSTGRES_PASSWORD", "", "", true, &c.Database.Password},
+		{"POSTGRES_DB", "db-name", "database name", false, &c.Database.Name},
+		{"POSTGRES_SSLMODE", "db-sslmode", "database SSL mode", false, &c.Database.SSLMode},
+		{"LOG_LEVEL", "log-level", "log level: debug, info, warn or error", false, &c.Log.Level},
+		{"OPENAPI_VALIDATION", "openapi-validation", "validate requests against the OpenAPI document", false, &c.OpenAPI.Validation},
+		{"OTEL_TRACES_EXPORTER", "tracing-exporter", "span exporter: none or otlp", false, &c.Tracing.Exporter},
+		{"OTEL_EXPORTER_OTLP_PROTOCOL", "tracing-protocol", "OTLP protocol: http/protobuf or grpc", false, &c.Tracing.Protocol},
+		{"EVENT_RETENTION", "event-retention", "time to keep note events for streams to catch up from", false, &c.Events.Retention},
+		{"NOTIFIER", "notifier", "reminder notifier: log, webhook or smtp", false, &c.Notifier.Kind},
+		{"WEBHOOK_URL", "", "", true, &c.Notifier.WebhookURL},
+		{"SMTP_ADDR", "smtp-addr", "SMTP server address", false, &c.Notifier.SMTP.Addr},
+		{"SMTP_FROM", "smtp-from", "sender of reminder mails", false, &c.Notifier.SMTP.From},
+		{"SMTP_TO", "smtp-to", "comma-separated recipients of reminder mails", false, &c.Notifier.SMTP.To},
+		{"SMTP_USERNAME", "smtp-username", "SMTP user", false, &c.Notifier.SMTP.Username},
+		{"SMTP_PASSWORD", "", "", true, &c.Notifier.SMTP.Password},
+		{"RATE_LIMIT_ENABLED", "rate-limit-enabled", "limit the requests of each client", false, &c.RateLimit.Enabled},
+		{"RATE_LIMIT_STORE", "rate-limit-store", "store of rate limits: memory or postgres", false, &c.RateLimit.Store},
+		{"RATE_LIMIT_REQUESTS", "rate-limit-requests", "requests allowed per period by default", false, &c.RateLimit.Default.Requests},
+		{"RATE_LIMIT_PERIOD", "rate-limit-period", "period of the default rate limit", false, &c.RateLimit.Default.Period},
+		{"RATE_LIMIT_BURST", "rate-limit-burst", "requests allowed at once by default (0 for the requests per period)", false, &c.RateLimit.Default.Burst},
+		{"RATE_LIMIT_API_KEYS", "", "", true, &c.RateLimit.APIKeys},
+		{"QUOTA_MAX_NOTES", "quota-max-notes", "notes allowed per workspace (0 for no limit)", false, &c.Quota.MaxNotes},
+		{"QUOTA_MAX_BYTES", "quota-max-bytes", "bytes of titles and contents allowed per workspace (0 for no limit)", false, &c.Quota.MaxBytes},
+	}
+}
+
+func setSetting(value interface{}, text string) error {
+	switch value := value.(type) {
+	case *string:
+		*value = text
+	case *int:
+		parsed, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", text)
+		}
+		*value = parsed
+	case *bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", text)
+		}
+		*value = parsed
+	case *Duration:
+		return value.UnmarshalText([]byte(text))
+	case *[]string:
+		*value = nil
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*value = append(*value, item)
+			}
+		}
+	}
+	return nil
+}
+
+// RegisterConfigFlags adds the flags of the settings, and --config naming
+// the configuration file, to the flag set.
+func RegisterConfigFlags(flags *pflag.FlagSet) {
+	flags.String(CONFIG_FILE_FLAG, "", "YAML or TOML configuration file (or $"+CONFIG_FILE_ENV+")")
+	for _, setting := range DefaultConfig().settings() {
+		if setting.flag == "" {
+			continue
+		}
+		flags.String(setting.flag, "", setting.usage+" ($"+setting.env+")")
+		if _, ok := setting.value.(*bool); ok {
+			flags.Lookup(setting.flag).NoOptDefVal = "true"
+		}
+	}
+}
+
+// LoadConfig loads the configuration from the defaults, the file named by
+// --config or $CONFIG_FILE, the environment and the flags set in the flag
+// set, which may be nil. A secret can also be read from the file named by
+// its variable suffixed with _FILE.
+func LoadConfig(flags *pflag.FlagSet) (*Config, error) {
+	config := DefaultConfig()
+
+	path := os.Getenv(CONFIG_FILE_ENV)
+	if flags != nil && flags.Changed(CONFIG_FILE_FLAG) {
+		path, _ = flags.GetString(CONFIG_FILE_FLAG)
+	}
+	if path != "" {
+		if err := config.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, setting := range config.settings() {
+		text, source := os.Getenv(setting.env), setting.env
+		if setting.secret {
+			if file := os.Getenv(setting.env + SECRET_FILE_SUFFIX); file != "" {
+				if text != "" {
+					return nil, fmt.Errorf("%s and %s must not both be set", setting.env, setting.env+SECRET_FILE_SUFFIX)
+				}
+				data, err := ioutil.ReadFile(file)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", setting.env+SECRET_FILE_SUFFIX, err)
+				}
+				text, source = strings.TrimRight(string(data), "\r\n"), setting.env+SECRET_FILE_SUFFIX
+			}
+		}
+		if flags != nil && setting.flag != "" && flags.Changed(setting.flag) {
+			text, _ = flags.GetString(setting.flag)
+			source = "--" + setting.flag
+		} else if text == "" {
+			continue
+		}
+		if err := setSetting(setting.value, text); err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	return config, nil
+}
+
+// readFile reads the file as TOML if it is named *.toml, or as YAML. Keys
+// the configuration does not have are errors, so that typos are not
+// silently ignored.
+func (c *Config) readFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.NewDecoder(bytes.NewReader(data)).Strict(true).Decode(c)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(c); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports all the problems of the configuration at once, so that
+// the server does not start to fail on the first use of a value.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	oneOf := func(value string, values ...string) bool {
+		for _, v := range values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	check(c.HTTP.Addr != "", "http.addr is required")
+	check(c.GRPC.Addr != "", "grpc.addr is required")
+	for name, timeout := range map[string]Duration{
+		"http.read_header_timeout": c.HTTP.ReadHeaderTimeout,
+		"http.read_timeout":        c.HTTP.ReadTimeout,
+		"http.write_timeout":       c.HTTP.WriteTimeout,
+		"http.idle_timeout":        c.HTTP.IdleTimeout,
+	} {
+		check(timeout >= 0, "%s must not be negative", name)
+	}
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+	check(c.Events.Retention > 0, "events.retention must be positive")
+	check(c.Database.Host != "", "database.host is required")
+	check(c.Database.Port > 0 && c.Database.Port <= 65535, "database.port must be between 1 and 65535")
+	check(c.Database.User != "", "database.user is required")
+	check(c.Database.Name != "", "database.name is required")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "log.level must be debug, info, warn or error")
+	check(oneOf(c.Tracing.Exporter, TRACES_EXPORTER_NONE, TRACES_EXPORTER_OTLP), "tracing.exporter must be none or otlp")
+	check(oneOf(c.Tracing.Protocol, OTLP_PROTOCOL_HTTP, OTLP_PROTOCOL_GRPC), "tracing.protocol must be http/protobuf or grpc")
+	switch c.Notifier.Kind {
+	case NOTIFIER_LOG:
+	case NOTIFIER_WEBHOOK:
+		check(c.Notifier.WebhookURL != "", "notifier.webhook_url is required for the webhook notifier")
+	case NOTIFIER_SMTP:
+		smtp := c.Notifier.SMTP
+		check(smtp.Addr != "" && smtp.From != "" && len(smtp.To) > 0,
+			"notifier.smtp.addr, notifier.smtp.from and notifier.smtp.to are required for the smtp notifier")
+	default:
+		check(false, "notifier.kind must be log, webhook or smtp")
+	}
+
+	check(oneOf(c.RateLimit.Store, RATE_LIMIT_STORE_MEMORY, RATE_LIMIT_STORE_POSTGRES), "rate_limit.store must be memory or postgres")
+	checkLimit := func(name string, limit RateLimit) {
+		check(limit.Requests > 0 && limit.Period > 0 && limit.Burst >= 0,
+			"%s must allow a positive number of requests per positive period", name)
+	}
+	checkLimit("rate_limit.default", c.RateLimit.Default)
+	check(c.Quota.MaxNotes >= 0 && c.Quota.MaxBytes >= 0, "quota limits must not be negative")
+	for i, route := range c.RateLimit.Routes {
+		name := fmt.Sprintf("rate_limit.routes[%d]", i)
+		check(route.Method != "" && strings.HasPrefix(route.Path, "/"), "%s must have a method and a path starting with /", name)
+		checkLimit(name, route.Limit)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with the secrets that are
+// set replaced by REDACTED.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.Notifier.SMTP.To = append([]string(nil), c.Notifier.SMTP.To...)
+	redacted.RateLimit.Routes = append([]RouteRateLimit(nil), c.RateLimit.Routes...)
+	redacted.RateLimit.APIKeys = append([]string(nil), c.RateLimit.APIKeys...)
+	for _, setting := range redacted.settings() {
+		if !setting.secret {
+			continue
+		}
+		switch value := setting.value.(type) {
+		case *string:
+			if *value != "" {
+				*value = REDACTED
+			}
+		case *[]string:
+			for i := range *value {
+				(*value)[i] = REDACTED
+			}
+		}
+	}
+	return &redacted
+}
+
+// Print writes the configuration, with the secrets redacted, in the format
+// of a configuration file.
+func (c *Config) Print(w io.Writer, format string) error {
+	var data []byte
+	var err error
+	switch format {
+	case CONFIG_FORMAT_YAML:
+		data, err = yaml.Marshal(c.Redacted())
+	case CONFIG_FORMAT_TOML:
+		data, err = toml.Marshal(c.Redacted())
+	default:
+		return fmt.Errorf("format must be %s or %s", CONFIG_FORMAT_YAML, CONFIG_FORMAT_TOML)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func configFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterConfigFlags(flags)
+	assert.NoError(t, flags.Parse(args))
+	return flags
+}
+
+func TestLoadConfig_defaults(t *testing.T) {
+	config, err := LoadConfig(nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), config)
+	assert.NoError(t, config.Validate())
+}
+
+func TestLoadConfig_precedence(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+http:
+  addr: ":8000"
+  shutdown_timeout: 1m
+database:
+  host: file
+  port: 5433
+  user: file
+notifier:
+  kind: smtp
+  smtp:
+    addr: localhost:25
+    from: todo@example.com
+    to: [a@example.com]
+`)
+	t.Setenv(CONFIG_FILE_ENV, path)
+	t.Setenv("POSTGRES_HOST", "env")
+	t.Setenv("POSTGRES_USER", "env")
+	t.Setenv("SMTP_TO", "b@example.com, c@example.com")
+
+	config, err := LoadConfig(configFlags(t, "--db-user", "flag", "--openapi-validation"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, ":8000", config.HTTP.Addr)
+	assert.Equal(t, Duration(time.Minute), config.HTTP.ShutdownTimeout)
+	assert.Equal(t, Duration(2*time.Minute), config.HTTP.IdleTimeout)
+	assert.Equal(t, 5433, config.Database.Port)
+	assert.Equal(t, "env", config.Database.Host)
+	assert.Equal(t, "flag", config.Database.User)
+	assert.True(t, config.OpenAPI.Validation)
+	assert.Equal(t, []string{"b@example.com", "c@example.com"}, config.Notifier.SMTP.To)
+	assert.NoError(t, config.Validate())
+}
+
+func TestLoadConfig_toml(t *testing.T) {
+	path := writeConfigFile(t, "config.toml", `
+[grpc]
+addr = ":9999"
+
+[log]
+level = "debug"
+
+[[rate_limit.routes]]
+method = "POST"
+path = "/notes"
+limit = { requests = 10, period = "1m", burst = 5 }
+`)
+
+	config, err := LoadConfig(configFlags(t, "--config", path))
+
+	assert.NoError(t, err)
+	assert.Equal(t, ":9999", config.GRPC.Addr)
+	assert.Equal(t, "debug", config.Log.Level)
+	assert.Equal(t, []RouteRateLimit{
+		{Method: "POST", Path: "/notes", Limit: RateLimit{Requests: 10, Period: Duration(time.Minute), Burst: 5}},
+	}, config.RateLimit.Routes)
+	assert.NoError(t, config.Validate())
+}
+
+func TestLoadConfig_invalid(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "database:\n  hots: db\n")
+	_, err := LoadConfig(configFlags(t, "--config", path))
+	assert.Error(t, err)
+
+	_, err = LoadConfig(configFlags(t, "--http-idle-timeout", "soon"))
+	assert.EqualError(t, err, `--http-idle-timeout: "soon" is not a duration such as 30s`)
+
+	t.Setenv("POSTGRES_PORT", "postgres")
+	_, err = LoadConfig(nil)
+	assert.EqualError(t, err, `POSTGRES_PORT: "postgres" is not an integer`)
+}
+
+func TestLoadConfig_secretFiles(t *testing.T) {
+	path := writeConfigFile(t, "password", "s3cret\n")
+	t.Setenv("POSTGRES_PASSWORD_FILE", path)
+	t.Setenv("RATE_LIMIT_API_KEYS_FILE", writeConfigFile(t, "api-keys", "key1,key2\n"))
+
+	config, err := LoadConfig(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", config.Database.Password)
+	assert.Equal(t, []string{"key1", "key2"}, config.RateLimit.APIKeys)
+
+	t.Setenv("POSTGRES_PASSWORD", "other")
+	_, err = LoadConfig(nil)
+	assert.EqualError(t, err, "POSTGRES_PASSWORD and POSTGRES_PASSWORD_FILE must not both be set")
+
+	t.Setenv("POSTGRES_PASSWORD", "")
+	t.Setenv("POSTGRES_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = LoadConfig(nil)
+	assert.Error(t, err)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	config := DefaultConfig()
+	config.Database.Port = 0
+	config.Log.Level = "verbose"
+	config.Notifier.Kind = NOTIFIER_WEBHOOK
+	config.RateLimit.Routes = []RouteRateLimit{{Method: "POST", Path: "notes"}}
+
+	err := config.Validate()
+
+	assert.EqualError(t, err, "invalid configuration: database.port must be between 1 and 65535; "+
+		"log.level must be debug, info, warn or error; notifier.webhook_url is required for the webhook notifier; "+
+		"rate_limit.routes[0] must have a method and a path starting with /; "+
+		"rate_limit.routes[0] must allow a positive number of requests per positive period")
+}
+
+func TestConfig_Validate_quota(t *testing.T) {
+	t.Setenv("QUOTA_MAX_NOTES", "-1")
+	config, err := LoadConfig(nil)
+	assert.NoError(t, err)
+
+	assert.EqualError(t, config.Validate(), "invalid configuration: quota limits must not be negative")
+}
+
+func TestDatabaseConfig_DSN(t *testing.T) {
+	config := DatabaseConfig{Host: "db", Port: 5432, User: "todo", Password: `it's`, Name: "todo", SSLMode: "require"}
+
+	assert.Equal(t, `host='db' port=5432 user='todo' password='it\'s' dbname='todo' sslmode='require'`, config.DSN())
+}
+
+func TestConfig_Print(t *testing.T) {
+	config := DefaultConfig()
+	config.Database.Password = "s3cret"
+	config.Notifier.WebhookURL = "https://example.com/hook?token=s3cret"
+	config.RateLimit.APIKeys = []string{"s3cret-key"}
+
+	var yamlOut, tomlOut bytes.Buffer
+	assert.NoError(t, config.Print(&yamlOut, CONFIG_FORMAT_YAML))
+	assert.NoError(t, config.Print(&tomlOut, CONFIG_FORMAT_TOML))
+
+	for _, out := range []string{yamlOut.String(), tomlOut.String()} {
+		assert.NotContains(t, out, "s3cret")
+		assert.Contains(t, out, REDACTED)
+		assert.Contains(t, out, "30s")
+	}
+	assert.Equal(t, "s3cret", config.Database.Password)
+	assert.Equal(t, []string{"s3cret-key"}, config.RateLimit.APIKeys)
+	assert.EqualError(t, config.Print(&yamlOut, "json"), "format must be yaml or toml")
+}
+
+func TestServerCommand_configPrint(t *testing.T) {
+	t.Setenv("POSTGRES_PASSWORD", "s3cret")
+	var out bytes.Buffer
+	command := newServerCommand(&out)
+	command.SetArgs([]string{"config", "print", "--http-addr", ":8081"})
+
+	assert.NoError(t, command.Execute())
+	assert.Contains(t, out.String(), `addr: :8081`)
+	assert.Contains(t, out.String(), "password: "+REDACTED)
+	assert.NotContains(t, out.String(), "s3cret")
+
+	command = newServerCommand(&out)
+	command.SetArgs([]string{"config", "print", "--db-port", "0"})
+	assert.EqualError(t, command.Execute(), "invalid configuration: database.port must be between 1 and 65535")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// customMethods dispatches custom methods such as POST /notes:batch. gin
+// cannot escape ':' in paths, so the route is registered as "/notes:method"
+// and the parameter, which includes the colon, selects the handler.
+func customMethods(handlers map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		handler, found := handlers[c.Param("method")]
+		if !found {
+			problem := NotFoundProblem()
+			problem.Detail = "Method does not exist"
+			RespondProblem(c, problem)
+			return
+		}
+		handler(c)
+	}
+}
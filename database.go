@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DB_CONNECT_ATTEMPTS        = 10
+	DB_CONNECT_INITIAL_BACKOFF = 500 * time.Millisecond
+	DB_CONNECT_MAX_BACKOFF     = 10 * time.Second
+)
+
+// migratedModels are the models whose tables are migrated on start.
+var migratedModels = []interface{}{&Note{}, &NoteEvent{}, &IdempotencyKey{}, &Reminder{}, &RateLimitBucket{}, &QuotaUsage{}}
+
+// retryWithBackoff calls fn until it succeeds or the attempts are used up,
+// doubling the wait between attempts up to max. It returns the last error.
+func retryWithBackoff(attempts int, initial time.Duration, max time.Duration, sleep func(time.Duration), fn func() error) error {
+	backoff := initial
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(); err == nil || attempt == attempts {
+			break
+		}
+		slog.Warn("Retrying", "attempt", attempt, "backoff_ms", backoff.Milliseconds(), "error", err)
+		sleep(backoff)
+		if backoff *= 2; backoff > max {
+			backoff = max
+		}
+	}
+	return err
+}
+
+// OpenDatabase connects to the database, waiting for it to accept
+// connections while it starts along with the application.
+func OpenDatabase(dialector gorm.Dialector, config *gorm.Config) (*gorm.DB, error) {
+	var db *gorm.DB
+	err := retryWithBackoff(DB_CONNECT_ATTEMPTS, DB_CONNECT_INITIAL_BACKOFF, DB_CONNECT_MAX_BACKOFF, time.Sleep, func() error {
+		var err error
+		db, err = gorm.Open(dialector, config)
+		return err
+	})
+	return db, err
+}
+
+// Migrate creates the sequence of note changes, migrates the tables of
+// migratedModels and counts the usage of the notes there before.
+func Migrate(db *gorm.DB) error {
+	if err := db.Exec("CREATE SEQUENCE IF NOT EXISTS note_sequence").Error; err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(migratedModels...); err != nil {
+		return err
+	}
+	return InitUsage(db)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryWithBackoff(t *testing.T) {
+	for _, td := range []struct {
+		title          string
+		failures       int
+		expectedCalls  int
+		expectedSleeps []time.Duration
+		expectedErr    error
+	}{
+		{"Succeeds at once", 0, 1, nil, nil},
+		{"Backs off until it succeeds", 3, 4, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, nil},
+		{"Gives up after the attempts", 10, 5, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}, errors.New("refused 5")},
+	} {
+		t.Run("retryWithBackoff: "+td.title, func(t *testing.T) {
+			var sleeps []time.Duration
+			calls := 0
+			err := retryWithBackoff(5, time.Second, 3*time.Second, func(d time.Duration) { sleeps = append(sleeps, d) }, func() error {
+				calls++
+				if calls <= td.failures {
+					return fmt.Errorf("refused %d", calls)
+				}
+				return nil
+			})
+			assert.Equal(t, td.expectedErr, err)
+			assert.Equal(t, td.expectedCalls, calls)
+			assert.Equal(t, td.expectedSleeps, sleeps)
+		})
+	}
+}
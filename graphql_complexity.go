@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var errQuerySyntax = errors.New("syntax error in query")
+
+// The complexity of a query is estimated before executing it: every
+// selected field costs 1, multiplied by GRAPHQL_LIST_COMPLEXITY for each
+// list field it is selected under. Only the syntax needed for that is
+// parsed; graphql-go reports any other error in the query.
+
+type querySelection struct {
+	name     string // Field name, or fragment name of a spread.
+	spread   bool
+	children []querySelection
+}
+
+type queryParser struct {
+	tokens    []string
+	position  int
+	fragments map[string][]querySelection
+}
+
+func tokenizeQuery(query string) ([]string, error) {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r) || r == ',' || r == '\uFEFF':
+			i++
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' && runes[i] != '\r' {
+				i++
+			}
+		case r == '"':
+			// Strings only appear in arguments, so they are kept as one
+			// token without being decoded.
+			start := i
+			if strings.HasPrefix(string(runes[i:]), `"""`) {
+				end := strings.Index(string(runes[i+3:]), `"""`)
+				if end < 0 {
+					return nil, errQuerySyntax
+				}
+				i += 3 + len([]rune(string(runes[i+3:])[:end])) + 3
+			} else {
+				for i++; i < len(runes) && runes[i] != '"'; i++ {
+					if runes[i] == '\\' {
+						i++
+					}
+				}
+				if i >= len(runes) {
+					return nil, errQuerySyntax
+				}
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case r == '.':
+			if !strings.HasPrefix(string(runes[i:]), "...") {
+				return nil, errQuerySyntax
+			}
+			tokens = append(tokens, "...")
+			i += 3
+		case r == '-' || unicode.IsDigit(r):
+			start := i
+			for i++; i < len(runes) && strings.ContainsRune("0123456789.eE+-", runes[i]); i++ {
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func (qp *queryParser) peek() string {
+	if qp.position < len(qp.tokens) {
+		return qp.tokens[qp.position]
+	}
+	return ""
+}
+
+func (qp *queryParser) next() (string, error) {
+	if qp.position >= len(qp.tokens) {
+		return "", errQuerySyntax
+	}
+	qp.position++
+	return qp.tokens[qp.position-1], nil
+}
+
+func (qp *queryParser) expect(token string) error {
+	if next, err := qp.next(); err != nil || next != token {
+		return errQuerySyntax
+	}
+	return nil
+}
+
+// skipBalanced skips arguments or variable definitions in parentheses.
+func (qp *queryParser) skipBalanced() error {
+	if qp.peek() != "(" {
+		return nil
+	}
+	for depth := 0; ; {
+		token, err := qp.next()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func (qp *queryParser) skipDirectives() error {
+	for qp.peek() == "@" {
+		qp.position++
+		if _, err := qp.next(); err != nil {
+			return err
+		}
+		if err := qp.skipBalanced(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qp *queryParser) parseSelectionSet() ([]querySelection, error) {
+	if err := qp.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []querySelection
+	for qp.peek() != "}" {
+		token, err := qp.next()
+		if err != nil {
+			return nil, err
+		}
+		selection := querySelection{name: token}
+		if token == "..." {
+			if qp.peek() == "on" || qp.peek() == "@" || qp.peek() == "{" {
+				// Inline fragment: its fields are selected in place.
+				if qp.peek() == "on" {
+					qp.position += 2
+				}
+				if err := qp.skipDirectives(); err != nil {
+					return nil, err
+				}
+				children, err := qp.parseSelectionSet()
+				if err != nil {
+					return nil, err
+				}
+				selections = append(selections, children...)
+				continue
+			}
+			if selection.name, err = qp.next(); err != nil {
+				return nil, err
+			}
+			selection.spread = true
+		} else if qp.peek() == ":" {
+			qp.position++
+			if selection.name, err = qp.next(); err != nil {
+				return nil, err
+			}
+		}
+		if err := qp.skipBalanced(); err != nil {
+			return nil, err
+		}
+		if err := qp.skipDirectives(); err != nil {
+			return nil, err
+		}
+		if qp.peek() == "{" {
+			if selection.children, err = qp.parseSelectionSet(); err != nil {
+				return nil, err
+			}
+		}
+		selections = append(selections, selection)
+	}
+	qp.position++
+	return selections, nil
+}
+
+// parseDocument returns the selection sets of the operations, and collects
+// the fragments.
+func (qp *queryParser) parseDocument() ([][]querySelection, error) {
+	var operations [][]querySelection
+	for qp.position < len(qp.tokens) {
+		name := ""
+		if qp.peek() != "{" {
+			keyword, _ := qp.next()
+			if qp.peek() != "(" && qp.peek() != "@" && qp.peek() != "{" {
+				name, _ = qp.next()
+			}
+			if keyword == "fragment" {
+				if err := qp.expect("on"); err != nil {
+					return nil, err
+				}
+				if _, err := qp.next(); err != nil {
+					return nil, err
+				}
+			}
+			if err := qp.skipBalanced(); err != nil {
+				return nil, err
+			}
+			if err := qp.skipDirectives(); err != nil {
+				return nil, err
+			}
+			selections, err := qp.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			if keyword == "fragment" {
+				qp.fragments[name] = selections
+			} else {
+				operations = append(operations, selections)
+			}
+			continue
+		}
+		selections, err := qp.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, selections)
+	}
+	return operations, nil
+}
+
+func (qp *queryParser) complexity(selections []querySelection, multiplier int, listFields map[string]bool, visiting map[string]bool) int {
+	complexity := 0
+	for _, selection := range selections {
+		if selection.spread {
+			// Cycles are invalid; graphql-go reports them.
+			if !visiting[selection.name] {
+				visiting[selection.name] = true
+				complexity += qp.complexity(qp.fragments[selection.name], multiplier, listFields, visiting)
+				delete(visiting, selection.name)
+			}
+			continue
+		}
+		childMultiplier := multiplier
+		if listFields[selection.name] {
+			childMultiplier *= GRAPHQL_LIST_COMPLEXITY
+		}
+		complexity += multiplier + qp.complexity(selection.children, childMultiplier, listFields, visiting)
+	}
+	return complexity
+}
+
+// QueryComplexity returns the highest complexity of the operations in the
+// query. listFields are the names of the fields returning lists.
+func QueryComplexity(query string, listFields map[string]bool) (int, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return 0, err
+	}
+	parser := &queryParser{tokens: tokens, fragments: map[string][]querySelection{}}
+	operations, err := parser.parseDocument()
+	if err != nil {
+		return 0, err
+	}
+	highest := 0
+	for _, operation := range operations {
+		if complexity := parser.complexity(operation, 1, listFields, map[string]bool{}); complexity > highest {
+			highest = complexity
+		}
+	}
+	return highest, nil
+}
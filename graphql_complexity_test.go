@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryComplexity(t *testing.T) {
+	listFields := map[string]bool{"notes": true}
+	for _, td := range []struct {
+		title              string
+		query              string
+		expectedComplexity int
+		expectedError      error
+	}{
+		{"Fields", `{ note(id: 1) { id title } }`, 3, nil},
+		{"List fields", `query { notes { id title checklist { total } } }`, 1 + 10*4, nil},
+		{"Aliases", `{ a: notes { id } b: note(id: "2") { id } }`, 11 + 2, nil},
+		{"Arguments and directives", `mutation($t: String!) {
+			createNote(input: {title: $t, content: "{ notes { id } }"}) @include(if: true) { id }
+		}`, 2, nil},
+		{"Fragments", `query Q { notes { ...F } } fragment F on Note { id ... on Note { title } ... @skip(if: false) { content } }`, 1 + 30, nil},
+		{"Cyclic fragments", `{ notes { ...F } } fragment F on Note { id ...F }`, 1 + 10, nil},
+		{"Highest operation", `query A { note(id: 1) { id } } query B { notes { id } }`, 11, nil},
+		{"Comments and block strings", "# { notes }\n{ note(id: \"\"\"}\"\"\") { id } }", 2, nil},
+		{"Syntax error", `{ notes { id }`, 0, errQuerySyntax},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			complexity, err := QueryComplexity(td.query, listFields)
+			assert.Equal(t, td.expectedError, err)
+			assert.Equal(t, td.expectedComplexity, complexity)
+		})
+	}
+}
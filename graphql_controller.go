@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// Subscriptions are served over WebSocket with the graphql-transport-ws
+// protocol of graphql-ws.
+const (
+	GRAPHQL_WS_PROTOCOL    = "graphql-transport-ws"
+	GRAPHQL_WS_BUFFER_SIZE = 64
+
+	GRAPHQL_WS_CONNECTION_INIT = "connection_init"
+	GRAPHQL_WS_CONNECTION_ACK  = "connection_ack"
+	GRAPHQL_WS_PING            = "ping"
+	GRAPHQL_WS_PONG            = "pong"
+	GRAPHQL_WS_SUBSCRIBE       = "subscribe"
+	GRAPHQL_WS_NEXT            = "next"
+	GRAPHQL_WS_ERROR           = "error"
+	GRAPHQL_WS_COMPLETE        = "complete"
+)
+
+type IGraphQLController interface {
+	Query(c *gin.Context)
+}
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type GraphQLMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type GraphQLController struct {
+	schema      *graphql.Schema
+	noteService INoteService
+	listFields  map[string]bool
+	upgrader    websocket.Upgrader
+}
+
+func NewGraphQLController(noteService INoteService, noteEventSubscriber INoteEventSubscriber) GraphQLController {
+	schema := graphql.MustParseSchema(GRAPHQL_SCHEMA, &GraphQLResolver{noteService, noteEventSubscriber},
+		graphql.MaxDepth(GRAPHQL_MAX_DEPTH))
+	return GraphQLController{
+		schema:      schema,
+		noteService: noteService,
+		listFields:  listFieldsOf(schema),
+		upgrader:    websocket.Upgrader{Subprotocols: []string{GRAPHQL_WS_PROTOCOL}},
+	}
+}
+
+// listFieldsOf returns the names of the fields returning lists, except
+// those of the introspection types.
+func listFieldsOf(schema *graphql.Schema) map[string]bool {
+	listFields := map[string]bool{}
+	for _, t := range schema.Inspect().Types() {
+		fields := t.Fields(&struct{ IncludeDeprecated bool }{true})
+		if fields == nil || strings.HasPrefix(*t.Name(), "__") {
+			continue
+		}
+		for _, field := range *fields {
+			fieldType := field.Type()
+			if fieldType.Kind() == "NON_NULL" {
+				fieldType = fieldType.OfType()
+			}
+			if fieldType.Kind() == "LIST" {
+				listFields[field.Name()] = true
+			}
+		}
+	}
+	return listFields
+}
+
+// complexityErrors returns the errors to respond with if the query is too
+// complex.
+func (gc *GraphQLController) complexityErrors(query string) []*gqlerrors.QueryError {
+	complexity, err := QueryComplexity(query, gc.listFields)
+	if err != nil || complexity <= GRAPHQL_MAX_COMPLEXITY {
+		// Syntax errors are reported by graphql-go.
+		return nil
+	}
+	return []*gqlerrors.QueryError{{
+		Message: fmt.Sprintf("query has complexity %d, which exceeds the limit of %d", complexity, GRAPHQL_MAX_COMPLEXITY),
+	}}
+}
+
+// Query executes queries and mutations sent as JSON with POST or as query
+// parameters with GET, and serves subscriptions to WebSocket upgrades.
+func (gc *GraphQLController) Query(c *gin.Context) {
+	if c.IsWebsocket() {
+		gc.subscribe(c)
+		return
+	}
+
+	var request GraphQLRequest
+	if c.Request.Method == http.MethodGet {
+		request.Query = c.Query("query")
+		request.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				RespondProblem(c, InvalidParameterProblem("variables", "must be a JSON object"))
+				return
+			}
+		}
+	} else if err := c.ShouldBindJSON(&request); err != nil {
+		RespondProblem(c, InvalidRequestBodyProblem(err))
+		return
+	}
+
+	if errors := gc.complexityErrors(request.Query); errors != nil {
+		c.IndentedJSON(http.StatusOK, &graphql.Response{Errors: errors})
+		return
+	}
+	ctx := context.WithValue(c.Request.Context(), noteLoaderKey{}, NewNoteLoader(gc.noteService.WithContext(c.Request.Context())))
+	response := gc.schema.Exec(ctx, request.Query, request.OperationName, request.Variables)
+	c.IndentedJSON(http.StatusOK, response)
+}
+
+func graphQLMessage(id string, messageType string, payload interface{}) GraphQLMessage {
+	message := GraphQLMessage{ID: id, Type: messageType}
+	if payload != nil {
+		message.Payload, _ = json.Marshal(payload)
+	}
+	return message
+}
+
+func (gc *GraphQLController) subscribe(c *gin.Context) {
+	conn, err := gc.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied with an error.
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	messages := make(chan GraphQLMessage, GRAPHQL_WS_BUFFER_SIZE)
+	send := func(message GraphQLMessage) {
+		select {
+		case messages <- message:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		for {
+			select {
+			case message := <-messages:
+				if err := conn.WriteJSON(message); err != nil {
+					conn.Close()
+					return
+				}
+			case <-ctx.Done():
+				// Closing the connection also ends the read loop below.
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	var mutex sync.Mutex
+	subscriptions := map[string]context.CancelFunc{}
+	for {
+		var message GraphQLMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+		switch message.Type {
+		case GRAPHQL_WS_CONNECTION_INIT:
+			send(graphQLMessage("", GRAPHQL_WS_CONNECTION_ACK, nil))
+		case GRAPHQL_WS_PING:
+			send(graphQLMessage("", GRAPHQL_WS_PONG, nil))
+		case GRAPHQL_WS_SUBSCRIBE:
+			var request GraphQLRequest
+			if err := json.Unmarshal(message.Payload, &request); err != nil {
+				send(graphQLMessage(message.ID, GRAPHQL_WS_ERROR, []*gqlerrors.QueryError{{Message: "invalid payload"}}))
+				continue
+			}
+			if errors := gc.complexityErrors(request.Query); errors != nil {
+				send(graphQLMessage(message.ID, GRAPHQL_WS_ERROR, errors))
+				continue
+			}
+
+			mutex.Lock()
+			_, found := subscriptions[message.ID]
+			subscriptionCtx, cancelSubscription := context.WithCancel(ctx)
+			if !found {
+				subscriptions[message.ID] = cancelSubscription
+			}
+			mutex.Unlock()
+			if found {
+				cancelSubscription()
+				send(graphQLMessage(message.ID, GRAPHQL_WS_ERROR, []*gqlerrors.QueryError{{Message: "subscription ID is in use"}}))
+				continue
+			}
+
+			responses, err := gc.schema.Subscribe(subscriptionCtx, request.Query, request.OperationName, request.Variables)
+			if err != nil {
+				cancelSubscription()
+				send(graphQLMessage(message.ID, GRAPHQL_WS_ERROR, []*gqlerrors.QueryError{{Message: err.Error()}}))
+				continue
+			}
+			go func(id string) {
+				for response := range responses {
+					send(graphQLMessage(id, GRAPHQL_WS_NEXT, response))
+				}
+				// The subscription has been completed by the client or the
+				// connection closed otherwise.
+				mutex.Lock()
+				ended := subscriptionCtx.Err() == nil
+				if ended {
+					delete(subscriptions, id)
+				}
+				mutex.Unlock()
+				cancelSubscription()
+				if ended {
+					send(graphQLMessage(id, GRAPHQL_WS_COMPLETE, nil))
+				}
+			}(message.ID)
+		case GRAPHQL_WS_COMPLETE:
+			mutex.Lock()
+			if cancelSubscription, found := subscriptions[message.ID]; found {
+				cancelSubscription()
+				delete(subscriptions, message.ID)
+			}
+			mutex.Unlock()
+		}
+	}
+}
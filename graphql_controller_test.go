@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type graphQLTestResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func postGraphQL(t *testing.T, graphQLController GraphQLController, request GraphQLRequest) graphQLTestResponse {
+	body, _ := json.Marshal(request)
+	response := httptest.NewRecorder()
+	ginContext, _ := gin.CreateTestContext(response)
+	ginContext.Request, _ = http.NewRequest("POST", "/graphql", strings.NewReader(string(body)))
+
+	graphQLController.Query(ginContext)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	var actual graphQLTestResponse
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &actual))
+	return actual
+}
+
+func TestGraphQLController_Query(t *testing.T) {
+	mockService := &MockService{}
+	graphQLController := NewGraphQLController(mockService, &MockEventSubscriber{})
+	mockService.On("Get").Return([]Note{
+		{ID: 1, Title: "title", Content: "- [x] done\n- [ ] todo\n* [X] done too\n- not a task"},
+		{ID: 2, Title: "title2"},
+	})
+
+	actual := postGraphQL(t, graphQLController, GraphQLRequest{Query: `{ notes { id title checklist { completed total } } }`})
+
+	assert.Nil(t, actual.Errors)
+	assert.Equal(t, map[string]interface{}{"notes": []interface{}{
+		map[string]interface{}{"id": "1", "title": "title", "checklist": map[string]interface{}{"completed": 2.0, "total": 3.0}},
+		map[string]interface{}{"id": "2", "title": "title2", "checklist": map[string]interface{}{"completed": 0.0, "total": 0.0}},
+	}}, actual.Data)
+}
+
+func TestGraphQLController_Query_batchesNotes(t *testing.T) {
+	mockService := &MockService{}
+	graphQLController := NewGraphQLController(mockService, &MockEventSubscriber{})
+	mockService.On("GetByIds", mock.Anything).Return([]Note{{ID: 1, Title: "a"}, {ID: 2, Title: "b"}})
+
+	actual := postGraphQL(t, graphQLController, GraphQLRequest{
+		Query:     `query($id: ID!) { a: note(id: 1) { title } b: note(id: $id) { title } c: note(id: 3) { title } }`,
+		Variables: map[string]interface{}{"id": "2"},
+	})
+
+	assert.Nil(t, actual.Errors)
+	assert.Equal(t, map[string]interface{}{
+		"a": map[string]interface{}{"title": "a"},
+		"b": map[string]interface{}{"title": "b"},
+		"c": nil,
+	}, actual.Data)
+	mockService.AssertNumberOfCalls(t, "GetByIds", 1)
+	mockService.AssertNotCalled(t, "GetById", mock.Anything)
+	assert.ElementsMatch(t, []uint64{1, 2, 3}, mockService.Calls[0].Arguments.Get(0))
+}
+
+func TestGraphQLController_Query_get(t *testing.T) {
+	mockService := &MockService{}
+	graphQLController := NewGraphQLController(mockService, &MockEventSubscriber{})
+	mockService.On("GetByIds", []uint64{1}).Return([]Note{{ID: 1, Title: "a"}})
+
+	response := httptest.NewRecorder()
+	ginContext, _ := gin.CreateTestContext(response)
+	query := url.Values{"query": {`query($id: ID!) { note(id: $id) { title } }`}, "variables": {`{"id": "1"}`}}
+	ginContext.Request, _ = http.NewRequest("GET", "/graphql?"+query.Encode(), nil)
+
+	graphQLController.Query(ginContext)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.JSONEq(t, `{"data": {"note": {"title": "a"}}}`, response.Body.String())
+}
+
+var graphQLDue = time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+
+func TestGraphQLController_Query_mutations(t *testing.T) {
+	for _, td := range []struct {
+		title              string
+		query              string
+		setUp              func(mockService *MockService)
+		expectedData       map[string]interface{}
+		expectedMessage    string
+		expectedExtensions map[string]interface{}
+	}{
+		{
+			title: "Creates a note",
+			query: `mutation { createNote(input: {title: "title", content: "content"}) { id title content } }`,
+			setUp: func(mockService *MockService) {
+				mockService.On("Create", Note{Title: "title", Content: "content"}).Return(uint64(3), nil)
+			},
+			expectedData: map[string]interface{}{
+				"createNote": map[string]interface{}{"id": "3", "title": "title", "content": "content"},
+			},
+		},
+		{
+			title:           "Validates the input",
+			query:           `mutation { createNote(input: {title: " "}) { id } }`,
+			setUp:           func(mockService *MockService) {},
+			expectedMessage: "Request body has invalid fields",
+			expectedExtensions: map[string]interface{}{
+				"type": PROBLEM_VALIDATION_FAILED, "title": "Validation failed", "status": 422.0,
+				"errors": []interface{}{map[string]interface{}{"field": "title", "message": "is required"}},
+			},
+		},
+		{
+			title: "Updates a note",
+			query: `mutation { updateNote(id: 1, input: {title: "title", content: "content"}) { id title content } }`,
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "old", Content: "old"}, true)
+				mockService.On("Update", uint64(1), Note{Title: "title", Content: "content"}).Return(uint64(1), nil)
+			},
+			expectedData: map[string]interface{}{
+				"updateNote": map[string]interface{}{"id": "1", "title": "title", "content": "content"},
+			},
+		},
+		{
+			title: "Keeps the content, tags, completion and due date without them in the input",
+			query: `mutation { updateNote(id: 1, input: {title: "title", content: null}) { content tags completed due } }`,
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "old", Content: "old", Tags: Tags{"home"}, Completed: true, Due: &graphQLDue}, true)
+				mockService.On("Update", uint64(1), Note{Title: "title", Content: "old", Tags: Tags{"home"}, Completed: true, Due: &graphQLDue}).Return(uint64(1), nil)
+			},
+			expectedData: map[string]interface{}{
+				"updateNote": map[string]interface{}{"content": "old", "tags": []interface{}{"home"}, "completed": true, "due": "2024-01-05T09:00:00Z"},
+			},
+		},
+		{
+			title: "Replaces the content, tags, completion and due date with those in the input",
+			query: `mutation { updateNote(id: 1, input: {title: "title", content: "", tags: ["work", "work"], completed: false, noDue: true}) { content tags completed due } }`,
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "old", Content: "old", Tags: Tags{"home"}, Completed: true, Due: &graphQLDue}, true)
+				mockService.On("Update", uint64(1), Note{Title: "title", Tags: Tags{"work"}}).Return(uint64(1), nil)
+			},
+			expectedData: map[string]interface{}{
+				"updateNote": map[string]interface{}{"content": "", "tags": []interface{}{"work"}, "completed": false, "due": nil},
+			},
+		},
+		{
+			title: "Creates a note due at the time",
+			query: `mutation { createNote(input: {title: "title", due: "2024-01-05T09:00:00Z"}) { due } }`,
+			setUp: func(mockService *MockService) {
+				mockService.On("Create", Note{Title: "title", Due: &graphQLDue}).Return(uint64(3), nil)
+			},
+			expectedData: map[string]interface{}{
+				"createNote": map[string]interface{}{"due": "2024-01-05T09:00:00Z"},
+			},
+		},
+		{
+			title: "Does not update a missing note",
+			query: `mutation { updateNote(id: 1, input: {title: "title"}) { id } }`,
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{}, false)
+			},
+			expectedMessage:    "Note does not exist",
+			expectedExtensions: map[string]interface{}{"type": PROBLEM_NOT_FOUND, "title": "Not found", "status": 404.0},
+		},
+		{
+			title: "Deletes a note",
+			query: `mutation { deleteNote(id: "1") }`,
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{ID: 1}, true)
+				mockService.On("Delete", uint64(1)).Return(true)
+			},
+			expectedData: map[string]interface{}{"deleteNote": "1"},
+		},
+		{
+			title:           "Rejects an invalid ID",
+			query:           `mutation { deleteNote(id: "a") }`,
+			setUp:           func(mockService *MockService) {},
+			expectedMessage: "ID must be an unsigned integer",
+			expectedExtensions: map[string]interface{}{
+				"type": PROBLEM_INVALID_ID, "title": "Invalid ID", "status": 400.0,
+			},
+		},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			mockService := &MockService{}
+			graphQLController := NewGraphQLController(mockService, &MockEventSubscriber{})
+			td.setUp(mockService)
+
+			actual := postGraphQL(t, graphQLController, GraphQLRequest{Query: td.query})
+
+			mockService.AssertExpectations(t)
+			if td.expectedMessage == "" {
+				assert.Nil(t, actual.Errors)
+				assert.Equal(t, td.expectedData, actual.Data)
+				return
+			}
+			if assert.Len(t, actual.Errors, 1) {
+				assert.Equal(t, td.expectedMessage, actual.Errors[0].Message)
+				assert.Equal(t, td.expectedExtensions, actual.Errors[0].Extensions)
+			}
+		})
+	}
+}
+
+func TestGraphQLController_Query_limits(t *testing.T) {
+	graphQLController := NewGraphQLController(&MockService{}, &MockEventSubscriber{})
+
+	aliases := ""
+	for i := 0; i < 50; i++ {
+		aliases += " n" + string(rune('a'+i%26)) + string(rune('a'+i/26)) + ": notes { id }"
+	}
+	actual := postGraphQL(t, graphQLController, GraphQLRequest{Query: "{" + aliases + " }"})
+	if assert.Len(t, actual.Errors, 1) {
+		assert.Equal(t, "query has complexity 550, which exceeds the limit of 500", actual.Errors[0].Message)
+	}
+
+	deep := "{ __schema { types { fields { type { ofType { ofType { ofType { ofType { name } } } } } } } } }"
+	actual = postGraphQL(t, graphQLController, GraphQLRequest{Query: deep})
+	if assert.NotEmpty(t, actual.Errors) {
+		assert.Contains(t, actual.Errors[0].Message, "exceeds max depth")
+	}
+}
+
+func TestGraphQLController_Query_invalidBody(t *testing.T) {
+	graphQLController := NewGraphQLController(&MockService{}, &MockEventSubscriber{})
+	response := httptest.NewRecorder()
+	ginContext, _ := gin.CreateTestContext(response)
+	ginContext.Request, _ = http.NewRequest("POST", "/graphql", strings.NewReader("{"))
+
+	graphQLController.Query(ginContext)
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Equal(t, PROBLEM_CONTENT_TYPE, response.Header().Get("Content-Type"))
+}
+
+func readGraphQLMessage(t *testing.T, conn *websocket.Conn) GraphQLMessage {
+	var message GraphQLMessage
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestGraphQLController_Query_subscription(t *testing.T) {
+	mockSubscriber := &MockEventSubscriber{events: make(chan NoteEvent, 2)}
+	mockSubscriber.On("Subscribe").Return()
+	unsubscribed := make(chan struct{})
+	mockSubscriber.On("Unsubscribe", mock.Anything).Return().Run(func(args mock.Arguments) {
+		close(unsubscribed)
+	})
+	mockSubscriber.On("GetSince", uint64(1)).Return([]NoteEvent{{ID: 2, Type: NOTE_UPDATED, NoteID: 1, Title: "replayed"}})
+	mockSubscriber.events <- NoteEvent{ID: 2, Type: NOTE_UPDATED, NoteID: 1, Title: "replayed"}
+	mockSubscriber.events <- NoteEvent{ID: 3, Type: NOTE_DELETED, NoteID: 1}
+
+	graphQLController := NewGraphQLController(&MockService{}, mockSubscriber)
+	router := gin.New()
+	router.GET("/graphql", graphQLController.Query)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{GRAPHQL_WS_PROTOCOL}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/graphql", nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, GRAPHQL_WS_PROTOCOL, conn.Subprotocol())
+
+	conn.WriteJSON(GraphQLMessage{Type: GRAPHQL_WS_CONNECTION_INIT})
+	assert.Equal(t, GRAPHQL_WS_CONNECTION_ACK, readGraphQLMessage(t, conn).Type)
+	conn.WriteJSON(GraphQLMessage{Type: GRAPHQL_WS_PING})
+	assert.Equal(t, GRAPHQL_WS_PONG, readGraphQLMessage(t, conn).Type)
+
+	payload, _ := json.Marshal(GraphQLRequest{Query: `subscription { noteEvents(after: "1") { id type note { id title } } }`})
+	conn.WriteJSON(GraphQLMessage{ID: "s1", Type: GRAPHQL_WS_SUBSCRIBE, Payload: payload})
+	for _, expected := range []string{
+		`{"data": {"noteEvents": {"id": "2", "type": "updated", "note": {"id": "1", "title": "replayed"}}}}`,
+		`{"data": {"noteEvents": {"id": "3", "type": "deleted", "note": {"id": "1", "title": ""}}}}`,
+	} {
+		message := readGraphQLMessage(t, conn)
+		assert.Equal(t, "s1", message.ID)
+		assert.Equal(t, GRAPHQL_WS_NEXT, message.Type)
+		assert.JSONEq(t, expected, string(message.Payload))
+	}
+
+	conn.WriteJSON(GraphQLMessage{ID: "s1", Type: GRAPHQL_WS_COMPLETE})
+	<-unsubscribed
+	mockSubscriber.AssertExpectations(t)
+}
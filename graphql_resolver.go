@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const (
+	GRAPHQL_MAX_DEPTH       = 8
+	GRAPHQL_MAX_COMPLEXITY  = 500
+	GRAPHQL_LIST_COMPLEXITY = 10
+)
+
+const GRAPHQL_SCHEMA = `
+schema {
+	query: Query
+	mutation: Mutation
+	subscription: Subscription
+}
+
+# RFC 3339 date and time.
+scalar Time
+
+type Query {
+	notes: [Note!]!
+	note(id: ID!): Note
+}
+
+type Mutation {
+	createNote(input: NoteInput!): Note!
+	updateNote(id: ID!, input: NoteInput!): Note!
+	deleteNote(id: ID!): ID!
+}
+
+type Subscription {
+	# Events after the event with the ID are replayed first.
+	noteEvents(after: ID): NoteEvent!
+}
+
+type Note {
+	id: ID!
+	title: String!
+	content: String!
+	tags: [String!]!
+	completed: Boolean!
+	due: Time
+	checklist: Checklist!
+}
+
+# Progress of the Markdown task list items ("- [ ]" and "- [x]") in the content.
+type Checklist {
+	completed: Int!
+	total: Int!
+}
+
+type NoteEvent {
+	id: ID!
+	type: String!
+	note: Note!
+}
+
+input NoteInput {
+	title: String!
+	content: String
+	# Null keeps the content, the tags, the completion and the due date of
+	# the note on update, and noDue removes the due date.
+	tags: [String!]
+	completed: Boolean
+	due: Time
+	noDue: Boolean
+}
+`
+
+type noteLoaderKey struct{}
+
+// GraphQLResolver resolves GraphQL operations through the same services
+// as the REST API.
+type GraphQLResolver struct {
+	noteService         INoteService
+	noteEventSubscriber INoteEventSubscriber
+}
+
+type NoteInput struct {
+	Title     string
+	Content   *string
+	Tags      *[]string
+	Completed *bool
+	Due       *graphql.Time
+	NoDue     *bool
+}
+
+func (ni *NoteInput) NoteRequest() NoteRequest {
+	request := NoteRequest{Title: ni.Title}
+	if ni.Content != nil {
+		request.Content = *ni.Content
+	}
+	if ni.Tags != nil {
+		request.Tags = *ni.Tags
+	}
+	if ni.Completed != nil {
+		request.Completed = *ni.Completed
+	}
+	if ni.Due != nil {
+		request.Due = &ni.Due.Time
+	}
+	return request
+}
+
+// problemError reports a problem as a GraphQL error, with the problem in
+// its extensions.
+type problemError struct {
+	problem Problem
+}
+
+func (pe *problemError) Error() string {
+	if pe.problem.Detail != "" {
+		return pe.problem.Detail
+	}
+	return pe.problem.Title
+}
+
+func (pe *problemError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"type":   pe.problem.Type,
+		"title":  pe.problem.Title,
+		"status": pe.problem.Status,
+	}
+	if len(pe.problem.Errors) > 0 {
+		extensions["errors"] = pe.problem.Errors
+	}
+	return extensions
+}
+
+func getIdFromGraphQLID(id graphql.ID) (uint64, error) {
+	parsed, err := getIdFromParamString(string(id))
+	if err != nil {
+		problem := InvalidIdProblem()
+		problem.Detail = "ID must be an unsigned integer"
+		return 0, &problemError{problem}
+	}
+	return parsed, nil
+}
+
+func toGraphQLID(id uint64) graphql.ID {
+	return graphql.ID(strconv.FormatUint(id, 10))
+}
+
+func (r *GraphQLResolver) Notes(ctx context.Context) []*noteResolver {
+	notes := r.noteService.WithContext(ctx).Get()
+	resolvers := make([]*noteResolver, 0, len(notes))
+	for _, note := range notes {
+		resolvers = append(resolvers, &noteResolver{note})
+	}
+	return resolvers
+}
+
+func (r *GraphQLResolver) Note(ctx context.Context, args struct{ ID graphql.ID }) (*noteResolver, error) {
+	id, err := getIdFromGraphQLID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	var note Note
+	var found bool
+	if loader, ok := ctx.Value(noteLoaderKey{}).(*NoteLoader); ok {
+		note, found = loader.Load(id)
+	} else {
+		note, found = r.noteService.WithContext(ctx).GetById(id)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &noteResolver{note}, nil
+}
+
+func (r *GraphQLResolver) CreateNote(ctx context.Context, args struct{ Input NoteInput }) (*noteResolver, error) {
+	request := args.Input.NoteRequest()
+	if err := Validate(&request); err != nil {
+		return nil, &problemError{ErrorProblem(err)}
+	}
+	note := request.Note()
+	id, err := r.noteService.WithContext(ctx).Create(note)
+	if err != nil {
+		return nil, &problemError{ErrorProblem(err)}
+	}
+	note.ID = id
+	return &noteResolver{note}, nil
+}
+
+func (r *GraphQLResolver) UpdateNote(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input NoteInput
+}) (*noteResolver, error) {
+	id, err := getIdFromGraphQLID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	request := args.Input.NoteRequest()
+	if err := Validate(&request); err != nil {
+		return nil, &problemError{ErrorProblem(err)}
+	}
+	noteService := r.noteService.WithContext(ctx)
+	current, found := noteService.GetById(id)
+	if !found {
+		return nil, &problemError{NotFoundProblem()}
+	}
+	note := request.Note()
+	if args.Input.Content == nil {
+		note.Content = current.Content
+	}
+	if args.Input.Tags == nil {
+		note.Tags = current.Tags
+	}
+	if args.Input.Completed == nil {
+		note.Completed = current.Completed
+	}
+	if args.Input.Due == nil && (args.Input.NoDue == nil || !*args.Input.NoDue) {
+		note.Due = current.Due
+	}
+	if _, err := noteService.Update(id, note); err != nil {
+		return nil, &problemError{ErrorProblem(err)}
+	}
+	note.ID = id
+	return &noteResolver{note}, nil
+}
+
+func (r *GraphQLResolver) DeleteNote(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	id, err := getIdFromGraphQLID(args.ID)
+	if err != nil {
+		return "", err
+	}
+	noteService := r.noteService.WithContext(ctx)
+	if _, found := noteService.GetById(id); !found {
+		return "", &problemError{NotFoundProblem()}
+	}
+	if !noteService.Delete(id) {
+		return "", &problemError{InternalErrorProblem()}
+	}
+	return args.ID, nil
+}
+
+func (r *GraphQLResolver) NoteEvents(ctx context.Context, args struct{ After *graphql.ID }) (<-chan *noteEventResolver, error) {
+	var lastEventId uint64
+	if args.After != nil {
+		id, err := getIdFromGraphQLID(*args.After)
+		if err != nil {
+			return nil, err
+		}
+		lastEventId = id
+	}
+
+	// Subscribe before reading the event log so that no event falls between
+	// the replay and the live stream.
+	events := r.noteEventSubscriber.Subscribe()
+	resolvers := make(chan *noteEventResolver)
+	send := func(event NoteEvent) bool {
+		select {
+		case resolvers <- &noteEventResolver{event}:
+			lastEventId = event.ID
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	go func() {
+		defer close(resolvers)
+		defer r.noteEventSubscriber.Unsubscribe(events)
+
+		if args.After != nil {
+			for _, event := range r.noteEventSubscriber.GetSince(lastEventId) {
+				if !send(event) {
+					return
+				}
+			}
+		}
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if event.ID > lastEventId && !send(event) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return resolvers, nil
+}
+
+type noteResolver struct {
+	note Note
+}
+
+func (nr *noteResolver) ID() graphql.ID {
+	return toGraphQLID(nr.note.ID)
+}
+
+func (nr *noteResolver) Title() string {
+	return nr.note.Title
+}
+
+func (nr *noteResolver) Content() string {
+	return nr.note.Content
+}
+
+func (nr *noteResolver) Tags() []string {
+	if nr.note.Tags == nil {
+		return []string{}
+	}
+	return nr.note.Tags
+}
+
+func (nr *noteResolver) Completed() bool {
+	return nr.note.Completed
+}
+
+func (nr *noteResolver) Due() *graphql.Time {
+	if nr.note.Due == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *nr.note.Due}
+}
+
+var taskListItemPattern = regexp.MustCompile(`(?m)^\s*(?:[-*+]|\d+[.)])\s+\[([ xX])\]`)
+
+func (nr *noteResolver) Checklist() *checklistResolver {
+	checklist := &checklistResolver{}
+	for _, match := range taskListItemPattern.FindAllStringSubmatch(nr.note.Content, -1) {
+		checklist.total++
+		if match[1] != " " {
+			checklist.completed++
+		}
+	}
+	return checklist
+}
+
+type checklistResolver struct {
+	completed int32
+	total     int32
+}
+
+func (cr *checklistResolver) Completed() int32 {
+	return cr.completed
+}
+
+func (cr *checklistResolver) Total() int32 {
+	return cr.total
+}
+
+type noteEventResolver struct {
+	event NoteEvent
+}
+
+func (er *noteEventResolver) ID() graphql.ID {
+	return toGraphQLID(er.event.ID)
+}
+
+func (er *noteEventResolver) Type() string {
+	return er.event.Type
+}
+
+func (er *noteEventResolver) Note() *noteResolver {
+	return &noteResolver{er.event.Note()}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	LIVENESS_PATH  = "/healthz"
+	READINESS_PATH = "/readyz"
+
+	READINESS_TIMEOUT = 2 * time.Second
+
+	HEALTH_OK   = "ok"
+	HEALTH_FAIL = "fail"
+)
+
+// HealthCheck is a dependency the application needs to serve requests.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type HealthCheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+type HealthResponse struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+type HealthController struct {
+	checks []HealthCheck
+}
+
+func NewHealthController(checks ...HealthCheck) HealthController {
+	return HealthController{checks}
+}
+
+// Live responds whenever the process is able to handle requests at all.
+func (hc *HealthController) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: HEALTH_OK})
+}
+
+// Ready runs the checks concurrently within READINESS_TIMEOUT, and responds
+// with 503 if any of them fails.
+func (hc *HealthController) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), READINESS_TIMEOUT)
+	defer cancel()
+
+	results := make([]HealthCheckResult, len(hc.checks))
+	done := make(chan int)
+	for i, check := range hc.checks {
+		go func(i int, check HealthCheck) {
+			start := time.Now()
+			results[i] = HealthCheckResult{Status: HEALTH_OK}
+			if err := check.Check(ctx); err != nil {
+				results[i] = HealthCheckResult{Status: HEALTH_FAIL, Error: err.Error()}
+			}
+			results[i].DurationMs = float64(time.Since(start).Microseconds()) / 1000
+			done <- i
+		}(i, check)
+	}
+	for range hc.checks {
+		<-done
+	}
+
+	response := HealthResponse{Status: HEALTH_OK, Checks: map[string]HealthCheckResult{}}
+	status := http.StatusOK
+	for i, check := range hc.checks {
+		response.Checks[check.Name] = results[i]
+		if results[i].Status != HEALTH_OK {
+			response.Status = HEALTH_FAIL
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(status, response)
+}
+
+// DatabaseHealthCheck pings the database.
+func DatabaseHealthCheck(db *gorm.DB) HealthCheck {
+	return HealthCheck{"database", func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}}
+}
+
+// MigrationHealthCheck checks that the tables and columns of the models
+// exist, so that a replica is not ready before the schema it needs.
+func MigrationHealthCheck(db *gorm.DB, models ...interface{}) HealthCheck {
+	return HealthCheck{"migrations", func(ctx context.Context) error {
+		migrator := db.WithContext(ctx).Migrator()
+		// A failed lookup is reported as its timeout, not as a missing table.
+		missing := func(format string, args ...interface{}) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf(format, args...)
+		}
+		for _, model := range models {
+			statement := &gorm.Statement{DB: db}
+			if err := statement.Parse(model); err != nil {
+				return err
+			}
+			if !migrator.HasTable(model) {
+				return missing("table %s is missing", statement.Table)
+			}
+			for _, field := range statement.Schema.Fields {
+				if field.DBName != "" && !migrator.HasColumn(model, field.DBName) {
+					return missing("column %s.%s is missing", statement.Table, field.DBName)
+				}
+			}
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func serveHealth(healthController HealthController, path string) (int, HealthResponse) {
+	router := NewRouter(&Controllers{healthController: healthController})
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var response HealthResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return w.Code, response
+}
+
+func TestHealthController(t *testing.T) {
+	passing := HealthCheck{"passing", func(ctx context.Context) error { return nil }}
+	failing := HealthCheck{"failing", func(ctx context.Context) error { return errors.New("connection refused") }}
+
+	for _, td := range []struct {
+		title            string
+		path             string
+		checks           []HealthCheck
+		expectedCode     int
+		expectedResponse HealthResponse
+	}{
+		{
+			title:            "Live regardless of the checks",
+			path:             LIVENESS_PATH,
+			checks:           []HealthCheck{failing},
+			expectedCode:     http.StatusOK,
+			expectedResponse: HealthResponse{Status: HEALTH_OK},
+		},
+		{
+			title:        "Ready if all checks pass",
+			path:         READINESS_PATH,
+			checks:       []HealthCheck{passing},
+			expectedCode: http.StatusOK,
+			expectedResponse: HealthResponse{Status: HEALTH_OK, Checks: map[string]HealthCheckResult{
+				"passing": {Status: HEALTH_OK},
+			}},
+		},
+		{
+			title:        "Not ready if any check fails",
+			path:         READINESS_PATH,
+			checks:       []HealthCheck{passing, failing},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedResponse: HealthResponse{Status: HEALTH_FAIL, Checks: map[string]HealthCheckResult{
+				"passing": {Status: HEALTH_OK},
+				"failing": {Status: HEALTH_FAIL, Error: "connection refused"},
+			}},
+		},
+	} {
+		t.Run("HealthController: "+td.title, func(t *testing.T) {
+			code, response := serveHealth(NewHealthController(td.checks...), td.path)
+			for name, result := range response.Checks {
+				result.DurationMs = 0
+				response.Checks[name] = result
+			}
+			assert.Equal(t, td.expectedCode, code)
+			assert.Equal(t, td.expectedResponse, response)
+		})
+	}
+}
+
+func TestDatabaseHealthCheck(t *testing.T) {
+	sqlDB, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	defer sqlDB.Close()
+	mock.ExpectPing()
+	db, _ := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	check := DatabaseHealthCheck(db)
+
+	mock.ExpectPing()
+	assert.NoError(t, check.Check(context.Background()))
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	assert.EqualError(t, check.Check(context.Background()), "connection refused")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrationHealthCheck(t *testing.T) {
+	sqlDB, mock, _ := sqlmock.New()
+	defer sqlDB.Close()
+	db, _ := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	check := MigrationHealthCheck(db, &Reminder{})
+	count := func(n int) *sqlmock.Rows { return sqlmock.NewRows([]string{"count"}).AddRow(n) }
+
+	mock.ExpectQuery(`information_schema.tables`).WillReturnRows(count(1))
+	for range []string{"id", "note_id", "remind_at", "fired_at", "attempts", "next_attempt_at"} {
+		mock.ExpectQuery(`INFORMATION_SCHEMA.columns`).WillReturnRows(count(1))
+	}
+	assert.NoError(t, check.Check(context.Background()))
+
+	mock.ExpectQuery(`information_schema.tables`).WillReturnRows(count(1))
+	mock.ExpectQuery(`INFORMATION_SCHEMA.columns`).WillReturnRows(count(1))
+	mock.ExpectQuery(`INFORMATION_SCHEMA.columns`).WillReturnRows(count(0))
+	assert.EqualError(t, check.Check(context.Background()), "column reminders.note_id is missing")
+
+	mock.ExpectQuery(`information_schema.tables`).WillReturnRows(count(0))
+	assert.EqualError(t, check.Check(context.Background()), "table reminders is missing")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
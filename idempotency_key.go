@@ -0,0 +1,21 @@
+package main
+
+import "time"
+
+// IdempotencyKey records a POST request made with an Idempotency-Key header
+// and, once handled, its response with the headers clients rely on. Status
+// is 0 while the request is being handled.
+type IdempotencyKey struct {
+	Key         string `gorm:"primaryKey"`
+	Fingerprint string
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+func (ik *IdempotencyKey) completed() bool {
+	return ik.Status != 0
+}
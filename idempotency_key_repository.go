@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type IIdempotencyKeyRepository interface {
+	GetByKey(key string) (IdempotencyKey, bool)
+	Create(idempotencyKey IdempotencyKey) bool
+	Complete(key string, status int, contentType string, location string, body []byte) bool
+	Delete(key string) bool
+	DeleteExpired() bool
+}
+
+type IdempotencyKeyRepository struct {
+	db *gorm.DB
+}
+
+func (kr *IdempotencyKeyRepository) GetByKey(key string) (IdempotencyKey, bool) {
+	var idempotencyKey IdempotencyKey
+	result := kr.db.Where("key = ? AND expires_at > ?", key, time.Now()).Take(&idempotencyKey)
+	if result.Error != nil {
+		return idempotencyKey, false
+	}
+	return idempotencyKey, true
+}
+
+// Create returns false if the key already exists, including when another
+// request with the key has just been created.
+func (kr *IdempotencyKeyRepository) Create(idempotencyKey IdempotencyKey) bool {
+	// An expired key can be reused.
+	kr.db.Where("key = ? AND expires_at <= ?", idempotencyKey.Key, time.Now()).Delete(&IdempotencyKey{})
+	result := kr.db.Create(&idempotencyKey)
+	return result.Error == nil
+}
+
+func (kr *IdempotencyKeyRepository) Complete(key string, status int, contentType string, location string, body []byte) bool {
+	result := kr.db.Model(&IdempotencyKey{Key: key}).Updates(IdempotencyKey{
+		Status:      status,
+		ContentType: contentType,
+		Location:    location,
+		Body:        body,
+	})
+	return result.Error == nil
+}
+
+func (kr *IdempotencyKeyRepository) Delete(key string) bool {
+	result := kr.db.Delete(&IdempotencyKey{Key: key})
+	return result.Error == nil
+}
+
+func (kr *IdempotencyKeyRepository) DeleteExpired() bool {
+	result := kr.db.Where("expires_at <= ?", time.Now()).Delete(&IdempotencyKey{})
+	return result.Error == nil
+}
+
+// DeleteExpiredPeriodically keeps the table from growing with keys that
+// can no longer be replayed, until the context is done.
+func DeleteExpiredPeriodically(ctx context.Context, idempotencyKeyRepository IIdempotencyKeyRepository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			idempotencyKeyRepository.DeleteExpired()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
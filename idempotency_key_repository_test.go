@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type IdempotencyKeyRepositoryTestSuite struct {
+	suite.Suite
+	idempotencyKeyRepository IdempotencyKeyRepository
+	mock                     sqlmock.Sqlmock
+}
+
+func (ts *IdempotencyKeyRepositoryTestSuite) SetupTest() {
+	db, mock, _ := sqlmock.New()
+	ts.mock = mock
+	idempotencyKeyRepository := IdempotencyKeyRepository{}
+	idempotencyKeyRepository.db, _ = gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	ts.idempotencyKeyRepository = idempotencyKeyRepository
+}
+
+func (ts *IdempotencyKeyRepositoryTestSuite) TearDownTest() {
+	db, _ := ts.idempotencyKeyRepository.db.DB()
+	db.Close()
+}
+
+func (ts *IdempotencyKeyRepositoryTestSuite) TestIdempotencyKeyRepository_GetByKey() {
+	rows := sqlmock.NewRows([]string{"key", "fingerprint", "status", "body"}).AddRow("key", "abc", 200, []byte("body"))
+	ts.mock.ExpectQuery(`SELECT \* FROM "idempotency_keys" WHERE key = \$1 AND expires_at > \$2 LIMIT 1`).
+		WithArgs("key", sqlmock.AnyArg()).WillReturnRows(rows)
+
+	idempotencyKey, found := ts.idempotencyKeyRepository.GetByKey("key")
+
+	assert.Equal(ts.T(), true, found)
+	assert.Equal(ts.T(), "abc", idempotencyKey.Fingerprint)
+	assert.Equal(ts.T(), []byte("body"), idempotencyKey.Body)
+	assert.Equal(ts.T(), true, idempotencyKey.completed())
+}
+
+func (ts *IdempotencyKeyRepositoryTestSuite) TestIdempotencyKeyRepository_Create() {
+	for _, td := range []struct {
+		title      string
+		insertErr  error
+		expectedOk bool
+	}{
+		{
+			title:      "Returns true if created",
+			expectedOk: true,
+		},
+		{
+			title:      "Returns false if the key exists",
+			insertErr:  gorm.ErrInvalidDB, // A unique violation in practice.
+			expectedOk: false,
+		},
+	} {
+		ts.Run("Create: "+td.title, func() {
+			ts.mock.ExpectBegin()
+			ts.mock.ExpectExec(`DELETE FROM "idempotency_keys" WHERE key = \$1 AND expires_at <= \$2`).
+				WithArgs("key", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+			ts.mock.ExpectCommit()
+			ts.mock.ExpectBegin()
+			insert := ts.mock.ExpectExec(`INSERT INTO "idempotency_keys"`)
+			if td.insertErr != nil {
+				insert.WillReturnError(td.insertErr)
+				ts.mock.ExpectRollback()
+			} else {
+				insert.WillReturnResult(sqlmock.NewResult(0, 1))
+				ts.mock.ExpectCommit()
+			}
+
+			actualOk := ts.idempotencyKeyRepository.Create(IdempotencyKey{Key: "key", Fingerprint: "abc"})
+
+			assert.Equal(ts.T(), td.expectedOk, actualOk)
+			assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func (ts *IdempotencyKeyRepositoryTestSuite) TestIdempotencyKeyRepository_Complete() {
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectExec(`UPDATE "idempotency_keys" SET "status"=\$1,"content_type"=\$2,"location"=\$3,"body"=\$4 WHERE "key" = \$5`).
+		WithArgs(201, "application/json", "/notes/1", []byte("{}"), "key").WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.mock.ExpectCommit()
+
+	actualOk := ts.idempotencyKeyRepository.Complete("key", 201, "application/json", "/notes/1", []byte("{}"))
+
+	assert.Equal(ts.T(), true, actualOk)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *IdempotencyKeyRepositoryTestSuite) TestIdempotencyKeyRepository_DeleteExpired() {
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectExec(`DELETE FROM "idempotency_keys" WHERE expires_at <= \$1`).
+		WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 3))
+	ts.mock.ExpectCommit()
+
+	actualOk := ts.idempotencyKeyRepository.DeleteExpired()
+
+	assert.Equal(ts.T(), true, actualOk)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func TestIdempotencyKeyRepositoryTestSuite(t *testing.T) {
+	suite.Run(t, new(IdempotencyKeyRepositoryTestSuite))
+}
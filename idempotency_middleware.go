@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER     = "Idempotency-Key"
+	MAX_IDEMPOTENCY_KEY_LENGTH = 255
+	// MAX_IDEMPOTENT_BODY_SIZE is the largest body of the routes, that of
+	// imports, as bodies are read into memory to be fingerprinted.
+	MAX_IDEMPOTENT_BODY_SIZE = MAX_IMPORT_SIZE
+)
+
+var idempotencyKeyTTL = 24 * time.Hour
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (rw *recordingWriter) Write(data []byte) (int, error) {
+	rw.body.Write(data)
+	return rw.ResponseWriter.Write(data)
+}
+
+func (rw *recordingWriter) WriteString(s string) (int, error) {
+	rw.body.WriteString(s)
+	return rw.ResponseWriter.WriteString(s)
+}
+
+func fingerprint(method string, uri string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + uri + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// IdempotencyMiddleware makes POST requests with an Idempotency-Key header
+// safe to retry: the response to the first request is stored and replayed
+// for retries with the same key. Reusing a key for a different request is
+// rejected with 422, and bodies over MAX_IDEMPOTENT_BODY_SIZE with 413. A
+// request that fails with a server error or a panic releases its key.
+func IdempotencyMiddleware(idempotencyKeyRepository IIdempotencyKeyRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IDEMPOTENCY_KEY_HEADER)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > MAX_IDEMPOTENCY_KEY_LENGTH {
+			AbortWithProblem(c, InvalidParameterProblem(IDEMPOTENCY_KEY_HEADER, "must be at most 255 characters"))
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MAX_IDEMPOTENT_BODY_SIZE))
+		if err != nil && len(body) >= MAX_IDEMPOTENT_BODY_SIZE {
+			AbortWithProblem(c, RequestTooLargeProblem(MAX_IDEMPOTENT_BODY_SIZE))
+			return
+		}
+		if err != nil {
+			AbortWithProblem(c, InvalidRequestBodyProblem(err))
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		requestFingerprint := fingerprint(c.Request.Method, c.Request.URL.RequestURI(), body)
+
+		created := idempotencyKeyRepository.Create(IdempotencyKey{
+			Key:         key,
+			Fingerprint: requestFingerprint,
+			ExpiresAt:   time.Now().Add(idempotencyKeyTTL),
+		})
+		if !created {
+			stored, found := idempotencyKeyRepository.GetByKey(key)
+			switch {
+			case !found:
+				RespondProblem(c, InternalErrorProblem())
+			case stored.Fingerprint != requestFingerprint:
+				RespondProblem(c, Problem{Type: PROBLEM_KEY_REUSED, Title: "Idempotency-Key reused", Status: http.StatusUnprocessableEntity,
+					Detail: "Idempotency-Key is used for another request"})
+			case !stored.completed():
+				RespondProblem(c, Problem{Type: PROBLEM_REQUEST_IN_PROGRESS, Title: "Request in progress", Status: http.StatusConflict,
+					Detail: "Request with the Idempotency-Key is in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				if stored.Location != "" {
+					c.Header("Location", stored.Location)
+				}
+				c.Data(stored.Status, stored.ContentType, stored.Body)
+			}
+			c.Abort()
+			return
+		}
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				idempotencyKeyRepository.Delete(key)
+				panic(recovered)
+			}
+		}()
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// Server errors are not stored so that the request can be retried.
+		if writer.Status() >= http.StatusInternalServerError {
+			idempotencyKeyRepository.Delete(key)
+			return
+		}
+		header := writer.Header()
+		idempotencyKeyRepository.Complete(key, writer.Status(), header.Get("Content-Type"), header.Get("Location"), writer.body.Bytes())
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockIdempotencyKeyRepository struct {
+	mock.Mock
+}
+
+func (mr *MockIdempotencyKeyRepository) GetByKey(key string) (IdempotencyKey, bool) {
+	ret := mr.Called(key)
+	return ret.Get(0).(IdempotencyKey), ret.Get(1).(bool)
+}
+
+func (mr *MockIdempotencyKeyRepository) Create(idempotencyKey IdempotencyKey) bool {
+	ret := mr.Called(idempotencyKey)
+	return ret.Get(0).(bool)
+}
+
+func (mr *MockIdempotencyKeyRepository) Complete(key string, status int, contentType string, location string, body []byte) bool {
+	ret := mr.Called(key, status, contentType, location, body)
+	return ret.Get(0).(bool)
+}
+
+func (mr *MockIdempotencyKeyRepository) Delete(key string) bool {
+	ret := mr.Called(key)
+	return ret.Get(0).(bool)
+}
+
+func (mr *MockIdempotencyKeyRepository) DeleteExpired() bool {
+	ret := mr.Called()
+	return ret.Get(0).(bool)
+}
+
+func serveIdempotent(repository IIdempotencyKeyRepository, method string, key string, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(response)
+	router.Use(gin.Recovery(), IdempotencyMiddleware(repository))
+	router.Handle(method, "/notes", handler)
+
+	req, _ := http.NewRequest(method, "/notes", bytes.NewBufferString(body))
+	if key != "" {
+		req.Header.Set(IDEMPOTENCY_KEY_HEADER, key)
+	}
+	router.ServeHTTP(response, req)
+	return response
+}
+
+func TestIdempotencyMiddleware_firstRequest(t *testing.T) {
+	mockRepository := &MockIdempotencyKeyRepository{}
+	called := 0
+
+	mockRepository.On("Create", mock.MatchedBy(func(ik IdempotencyKey) bool {
+		return ik.Key == "key" && ik.Fingerprint == fingerprint("POST", "/notes", []byte(`{"title":"t"}`)) &&
+			ik.ExpiresAt.After(time.Now().Add(23*time.Hour))
+	})).Return(true)
+	mockRepository.On("Complete", "key", 200, "text/plain; charset=utf-8", "/notes/1", []byte("created")).Return(true)
+
+	response := serveIdempotent(mockRepository, "POST", "key", `{"title":"t"}`, func(c *gin.Context) {
+		called++
+		body, _ := c.GetRawData()
+		assert.Equal(t, `{"title":"t"}`, string(body))
+		c.Header("Location", "/notes/1")
+		c.String(http.StatusOK, "created")
+	})
+
+	assert.Equal(t, 1, called)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "created", response.Body.String())
+	mockRepository.AssertExpectations(t)
+}
+
+func TestIdempotencyMiddleware_serverError(t *testing.T) {
+	mockRepository := &MockIdempotencyKeyRepository{}
+
+	mockRepository.On("Create", mock.Anything).Return(true)
+	mockRepository.On("Delete", "key").Return(true)
+
+	response := serveIdempotent(mockRepository, "POST", "key", `{}`, func(c *gin.Context) {
+		c.String(http.StatusInternalServerError, "failed")
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	mockRepository.AssertCalled(t, "Delete", "key")
+	mockRepository.AssertNotCalled(t, "Complete", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestIdempotencyMiddleware_panic(t *testing.T) {
+	mockRepository := &MockIdempotencyKeyRepository{}
+
+	mockRepository.On("Create", mock.Anything).Return(true)
+	mockRepository.On("Delete", "key").Return(true)
+
+	response := serveIdempotent(mockRepository, "POST", "key", `{}`, func(c *gin.Context) {
+		panic("failed")
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	mockRepository.AssertCalled(t, "Delete", "key")
+}
+
+func TestIdempotencyMiddleware_tooLarge(t *testing.T) {
+	mockRepository := &MockIdempotencyKeyRepository{}
+
+	response := serveIdempotent(mockRepository, "POST", "key", strings.Repeat("x", MAX_IDEMPOTENT_BODY_SIZE+1), func(c *gin.Context) {
+		t.Error("handler must not be called")
+	})
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	assert.Contains(t, response.Body.String(), "Request body must not exceed 32 MiB")
+	assert.Equal(t, 0, len(mockRepository.Calls))
+}
+
+func TestIdempotencyMiddleware_retry(t *testing.T) {
+	body := `{"title":"t"}`
+	for _, td := range []struct {
+		title            string
+		stored           IdempotencyKey
+		found            bool
+		expectedStatus   int
+		expectedBody     string
+		expectedLocation string
+	}{
+		{
+			title: "Replays stored response",
+			stored: IdempotencyKey{
+				Key:         "key",
+				Fingerprint: fingerprint("POST", "/notes", []byte(body)),
+				Status:      201,
+				ContentType: "text/plain; charset=utf-8",
+				Location:    "/notes/1",
+				Body:        []byte("created"),
+			},
+			found:            true,
+			expectedStatus:   http.StatusCreated,
+			expectedBody:     "created",
+			expectedLocation: "/notes/1",
+		},
+		{
+			title: "Rejects reuse of key for another request with 422",
+			stored: IdempotencyKey{
+				Key:         "key",
+				Fingerprint: fingerprint("POST", "/notes", []byte(`{"title":"other"}`)),
+				Status:      200,
+			},
+			found:          true,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedBody:   PROBLEM_KEY_REUSED,
+		},
+		{
+			title: "Rejects request while the first one is in progress with 409",
+			stored: IdempotencyKey{
+				Key:         "key",
+				Fingerprint: fingerprint("POST", "/notes", []byte(body)),
+			},
+			found:          true,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   PROBLEM_REQUEST_IN_PROGRESS,
+		},
+	} {
+		t.Run("Retry: "+td.title, func(t *testing.T) {
+			mockRepository := &MockIdempotencyKeyRepository{}
+
+			mockRepository.On("Create", mock.Anything).Return(false)
+			mockRepository.On("GetByKey", "key").Return(td.stored, td.found)
+
+			response := serveIdempotent(mockRepository, "POST", "key", body, func(c *gin.Context) {
+				t.Error("handler must not be called")
+			})
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			assert.Contains(t, response.Body.String(), td.expectedBody)
+			assert.Equal(t, td.expectedLocation, response.Header().Get("Location"))
+		})
+	}
+}
+
+func TestIdempotencyMiddleware_passThrough(t *testing.T) {
+	for _, td := range []struct {
+		title  string
+		method string
+		key    string
+	}{
+		{title: "Ignores requests other than POST", method: "PUT", key: "key"},
+		{title: "Ignores requests without key", method: "POST"},
+	} {
+		t.Run("PassThrough: "+td.title, func(t *testing.T) {
+			mockRepository := &MockIdempotencyKeyRepository{}
+
+			response := serveIdempotent(mockRepository, td.method, td.key, `{}`, func(c *gin.Context) {
+				c.String(http.StatusOK, "ok")
+			})
+
+			assert.Equal(t, "ok", response.Body.String())
+			assert.Equal(t, 0, len(mockRepository.Calls))
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	REQUEST_ID_HEADER     = "X-Request-ID"
+	MAX_REQUEST_ID_LENGTH = 128
+	SLOW_QUERY_THRESHOLD  = 200 * time.Millisecond
+)
+
+type loggerKey struct{}
+
+// NewLogger returns a logger writing JSON lines at the level, one of debug,
+// info (by default), warn and error.
+func NewLogger(w io.Writer, level string) (*slog.Logger, error) {
+	var slogLevel slog.Level
+	if level != "" {
+		if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("log level must be debug, info, warn or error")
+		}
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slogLevel})), nil
+}
+
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFrom returns the logger of the request the context belongs to, or
+// the default logger.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// validRequestId accepts IDs of printable ASCII, so that a propagated ID
+// cannot break log lines or response headers.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestLogMiddleware takes the request ID from X-Request-ID or generates
+// one, returns it in the response, and puts a logger with it, and the trace
+// ID if traced, in the request context. Each request is logged once handled.
+func RequestLogMiddleware(base *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(REQUEST_ID_HEADER)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		c.Header(REQUEST_ID_HEADER, id)
+		logger := base.With("request_id", id)
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			logger = logger.With("trace_id", span.TraceID().String(), "span_id", span.SpanID().String())
+		}
+		c.Request = c.Request.WithContext(ContextWithLogger(c.Request.Context(), logger))
+
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		logger.Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"size", c.Writer.Size(),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// GormLogger logs SQL statements with their durations through the logger of
+// the request: failed ones as errors, slow ones as warnings and the others
+// at debug level.
+type GormLogger struct {
+	level logger.LogLevel
+}
+
+func NewGormLogger() *GormLogger {
+	return &GormLogger{level: logger.Info}
+}
+
+func (gl *GormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &GormLogger{level: level}
+}
+
+func (gl *GormLogger) log(ctx context.Context, level slog.Level, message string, args ...interface{}) {
+	LoggerFrom(ctx).Log(ctx, level, fmt.Sprintf(message, args...), "component", "gorm")
+}
+
+func (gl *GormLogger) Info(ctx context.Context, message string, args ...interface{}) {
+	if gl.level >= logger.Info {
+		gl.log(ctx, slog.LevelInfo, message, args...)
+	}
+}
+
+func (gl *GormLogger) Warn(ctx context.Context, message string, args ...interface{}) {
+	if gl.level >= logger.Warn {
+		gl.log(ctx, slog.LevelWarn, message, args...)
+	}
+}
+
+func (gl *GormLogger) Error(ctx context.Context, message string, args ...interface{}) {
+	if gl.level >= logger.Error {
+		gl.log(ctx, slog.LevelError, message, args...)
+	}
+}
+
+func (gl *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if gl.level <= logger.Silent {
+		return
+	}
+	duration := time.Since(begin)
+	level := slog.LevelDebug
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && gl.level >= logger.Error:
+		level = slog.LevelError
+	case duration > SLOW_QUERY_THRESHOLD && gl.level >= logger.Warn:
+		level = slog.LevelWarn
+	}
+	log := LoggerFrom(ctx)
+	if !log.Enabled(ctx, level) {
+		return
+	}
+	sql, rows := fc()
+	args := []interface{}{
+		"component", "gorm",
+		"sql", strings.TrimSpace(sql),
+		"rows", rows,
+		"duration_ms", float64(duration.Microseconds()) / 1000,
+	}
+	if level == slog.LevelError {
+		args = append(args, "error", err.Error())
+	}
+	log.Log(ctx, level, "sql", args...)
+}
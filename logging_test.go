@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// logLines decodes the JSON lines written by a logger.
+func logLines(buffer *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(line), &fields) == nil {
+			lines = append(lines, fields)
+		}
+	}
+	return lines
+}
+
+func TestNewLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := NewLogger(&buffer, "warn")
+	assert.NoError(t, err)
+	logger.Info("hidden")
+	logger.Warn("shown", "note_id", 1)
+	lines := logLines(&buffer)
+	assert.Len(t, lines, 1)
+	assert.Equal(t, "WARN", lines[0]["level"])
+	assert.Equal(t, "shown", lines[0]["msg"])
+	assert.Equal(t, float64(1), lines[0]["note_id"])
+
+	_, err = NewLogger(&buffer, "verbose")
+	assert.EqualError(t, err, "log level must be debug, info, warn or error")
+}
+
+func TestRequestLogMiddleware(t *testing.T) {
+	for _, td := range []struct {
+		title      string
+		requestId  string
+		propagated bool
+	}{
+		{"Propagates the request ID", "abc-123", true},
+		{"Generates a request ID", "", false},
+		{"Replaces an invalid request ID", "a b\n", false},
+	} {
+		t.Run("RequestLogMiddleware: "+td.title, func(t *testing.T) {
+			var buffer bytes.Buffer
+			logger, _ := NewLogger(&buffer, "debug")
+			router := gin.New()
+			router.Use(RequestLogMiddleware(logger))
+			router.GET("/", func(c *gin.Context) {
+				LoggerFrom(c.Request.Context()).Debug("handling")
+				c.Status(http.StatusTeapot)
+			})
+
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest("GET", "/?q=1", nil)
+			request.Header.Set(REQUEST_ID_HEADER, td.requestId)
+			router.ServeHTTP(response, request)
+
+			id := response.Header().Get(REQUEST_ID_HEADER)
+			if td.propagated {
+				assert.Equal(t, td.requestId, id)
+			} else {
+				assert.Len(t, id, 32)
+			}
+			lines := logLines(&buffer)
+			assert.Len(t, lines, 2)
+			assert.Equal(t, "handling", lines[0]["msg"])
+			assert.Equal(t, id, lines[0]["request_id"])
+			assert.Equal(t, "request", lines[1]["msg"])
+			assert.Equal(t, id, lines[1]["request_id"])
+			assert.Equal(t, "/", lines[1]["path"])
+			assert.Equal(t, float64(http.StatusTeapot), lines[1]["status"])
+		})
+	}
+}
+
+func TestGormLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, _ := NewLogger(&buffer, "debug")
+	ctx := ContextWithLogger(context.Background(), logger.With("request_id", "abc"))
+	gormLogger := NewGormLogger()
+
+	gormLogger.Trace(ctx, time.Now(), func() (string, int64) { return `SELECT * FROM "notes"`, 2 }, nil)
+	gormLogger.Trace(ctx, time.Now().Add(-time.Second), func() (string, int64) { return "SELECT pg_sleep(1)", 1 }, nil)
+	gormLogger.Trace(ctx, time.Now(), func() (string, int64) { return "SELECT 1", 0 }, gorm.ErrRecordNotFound)
+	gormLogger.Trace(ctx, time.Now(), func() (string, int64) { return "SELEC", 0 }, errors.New("syntax error"))
+	gormLogger.LogMode(0).Trace(ctx, time.Now(), func() (string, int64) { return "SELECT 2", 0 }, nil)
+
+	lines := logLines(&buffer)
+	assert.Len(t, lines, 4)
+	var levels, statements []interface{}
+	for _, line := range lines {
+		assert.Equal(t, "abc", line["request_id"])
+		assert.Contains(t, line, "duration_ms")
+		levels = append(levels, line["level"])
+		statements = append(statements, line["sql"])
+	}
+	assert.Equal(t, []interface{}{"DEBUG", "WARN", "DEBUG", "ERROR"}, levels)
+	assert.Equal(t, []interface{}{`SELECT * FROM "notes"`, "SELECT pg_sleep(1)", "SELECT 1", "SELEC"}, statements)
+	assert.Equal(t, "syntax error", lines[3]["error"])
+}
+
+// The request ID is in the logs of the controller, service and repository
+// handling the request.
+func TestLogging_request(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, _ := NewLogger(&buffer, "debug")
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(defaultLogger)
+
+	sqlDB, mock, _ := sqlmock.New()
+	defer sqlDB.Close()
+	db, _ := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: NewGormLogger()})
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT nextval`).WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "notes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE quota_usages`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO note_events`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	noteService := &NoteService{noteRepository: &NoteRepository{db}}
+	router := NewRouter(&Controllers{noteController: NoteController{noteService: noteService}})
+
+	response := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/v1/notes", strings.NewReader(`{"title": "Milk"}`))
+	request.Header.Set(REQUEST_ID_HEADER, "abc")
+	router.ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	messages := map[string]bool{}
+	for _, line := range logLines(&buffer) {
+		assert.Equal(t, "abc", line["request_id"], line)
+		messages[line["msg"].(string)] = true
+	}
+	assert.Equal(t, map[string]bool{"sql": true, "Created note": true, "request": true}, messages)
+}
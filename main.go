@@ -1,39 +1,222 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/cobra"
+
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 func main() {
-	host := os.Getenv("POSTGRES_HOST")
-	user := os.Getenv("POSTGRES_USER")
-	password := os.Getenv("POSTGRES_PASSWORD")
-	dbname := os.Getenv("POSTGRES_DB")
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=5432", host, user, password, dbname)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err := newServerCommand(os.Stdout).Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// newServerCommand returns the command serving the API, configured by the
+// file, environment and flags, with a config print subcommand to show the
+// effective configuration.
+func newServerCommand(stdout io.Writer) *cobra.Command {
+	root := &cobra.Command{
+		Use:           "todo-go-api",
+		Short:         "Serve the notes API",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadValidConfig(cmd)
+			if err != nil {
+				return err
+			}
+			serve(config)
+			return nil
+		},
+	}
+	root.SetOut(stdout)
+	RegisterConfigFlags(root.PersistentFlags())
+
+	configCommand := &cobra.Command{Use: "config", Short: "Inspect the configuration"}
+	var format string
+	printCommand := &cobra.Command{
+		Use:   "print",
+		Short: "Print the effective configuration with secrets redacted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadValidConfig(cmd)
+			if err != nil {
+				return err
+			}
+			return config.Print(cmd.OutOrStdout(), format)
+		},
+	}
+	printCommand.Flags().StringVar(&format, "format", CONFIG_FORMAT_YAML, "output format: yaml or toml")
+	configCommand.AddCommand(printCommand)
+	root.AddCommand(configCommand)
+	return root
+}
+
+func loadValidConfig(cmd *cobra.Command) (*Config, error) {
+	config, err := LoadConfig(cmd.Flags())
+	if err != nil {
+		return nil, err
+	}
+	return config, config.Validate()
+}
+
+func serve(config *Config) {
+	logger, err := NewLogger(os.Stdout, config.Log.Level)
+	if err != nil {
+		panic("failed to configure logging: " + err.Error())
+	}
+	slog.SetDefault(logger)
+
+	tracerProvider, err := NewTracerProvider(context.Background(), config.Tracing)
 	if err != nil {
-		panic("failed to connect database")
+		panic("failed to configure tracing: " + err.Error())
 	}
+	SetTracerProvider(tracerProvider)
 
-	db.AutoMigrate(&Note{})
+	db, err := OpenDatabase(postgres.Open(config.Database.DSN()), &gorm.Config{Logger: NewGormLogger()})
+	if err != nil {
+		panic("failed to connect database: " + err.Error())
+	}
+
+	if err := db.Use(&GormTracing{}); err != nil {
+		panic("failed to register database tracing")
+	}
+
+	metrics := NewMetrics()
+	if err := metrics.RegisterDB(db); err != nil {
+		panic("failed to register database metrics")
+	}
+
+	if err := Migrate(db); err != nil {
+		panic("failed to migrate database: " + err.Error())
+	}
+
+	noteEventRepository := &NoteEventRepository{db}
+	noteEventBroker := NewNoteEventBroker(noteEventRepository)
 
 	noteRepository := &NoteRepository{db}
-	noteService := &NoteService{noteRepository}
-	noteController := NoteController{noteService}
+	noteService := &NoteService{noteRepository: noteRepository, noteEventPublisher: noteEventBroker, quota: config.Quota}
+
+	if err := metrics.RegisterNoteCount(noteService); err != nil {
+		panic("failed to register note metrics")
+	}
+
+	noteSyncService := &NoteSyncService{noteRepository: noteRepository, noteEventPublisher: noteEventBroker, quota: config.Quota}
+
+	noteEditHub := NewNoteEditHub(noteService)
+
+	workers := NewWorkers()
+	workers.Go(func(ctx context.Context) {
+		noteEventBroker.Run(ctx, NOTE_EVENT_POLL_INTERVAL)
+	})
+	workers.Go(func(ctx context.Context) {
+		DeleteOlderPeriodically(ctx, noteEventRepository, time.Duration(config.Events.Retention), time.Hour)
+	})
+	idempotencyKeyRepository := &IdempotencyKeyRepository{db}
+	workers.Go(func(ctx context.Context) {
+		DeleteExpiredPeriodically(ctx, idempotencyKeyRepository, time.Hour)
+	})
 
-	router := gin.Default()
-	group := router.Group("/v1")
+	notifier, err := NewNotifier(config.Notifier)
+	if err != nil {
+		panic("failed to configure notifier: " + err.Error())
+	}
+	reminderRepository := &ReminderRepository{db}
+	reminderService := &ReminderService{reminderRepository}
+	reminderScheduler := &ReminderScheduler{reminderRepository, noteService, notifier}
+	workers.Go(func(ctx context.Context) {
+		reminderScheduler.Run(ctx, REMINDER_POLL_INTERVAL)
+	})
+	noteImportService := NewNoteImportService(noteService)
+
+	controllers := &Controllers{
+		noteController:       NoteController{noteService, NewNoteRenderer(NOTE_RENDER_CACHE_SIZE)},
+		noteEventController:  NoteEventController{noteEventBroker},
+		noteSyncController:   NoteSyncController{noteSyncService},
+		noteEditController:   NoteEditController{noteEditHub: noteEditHub},
+		noteExportController: NoteExportController{noteService},
+		noteImportController: NoteImportController{noteImportService},
+		calendarController:   CalendarController{noteService},
+		reminderController:   ReminderController{noteService, reminderService},
+		metrics:              metrics,
+		graphQLController:    NewGraphQLController(noteService, noteEventBroker),
+		usageController:      UsageController{noteService},
+		healthController:     NewHealthController(DatabaseHealthCheck(db), MigrationHealthCheck(db, migratedModels...)),
+	}
+	var middleware []gin.HandlerFunc
+	if config.RateLimit.Enabled {
+		var rateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+		if config.RateLimit.Store == RATE_LIMIT_STORE_POSTGRES {
+			rateLimitBucketRepository := &RateLimitBucketRepository{db}
+			workers.Go(func(ctx context.Context) {
+				DeleteFullPeriodically(ctx, rateLimitBucketRepository, time.Hour)
+			})
+			rateLimitStore = rateLimitBucketRepository
+		}
+		middleware = append(middleware, RateLimitMiddleware(rateLimitStore, config.RateLimit))
+	}
+	// Responses are checked only in test mode, as that buffers them.
+	if config.OpenAPI.Validation {
+		var onResponseViolation func(c *gin.Context, errors []FieldError)
+		if gin.Mode() == gin.TestMode {
+			onResponseViolation = LogResponseViolations
+		}
+		document := OpenAPIDocument(controllers.Routes())
+		middleware = append(middleware, OpenAPIValidationMiddleware(document, onResponseViolation))
+	}
+	middleware = append(middleware, IdempotencyMiddleware(idempotencyKeyRepository))
+
+	router := NewRouter(controllers, middleware...)
+	grpcListener, err := net.Listen("tcp", config.GRPC.Addr)
+	if err != nil {
+		panic("failed to listen for gRPC")
+	}
+	grpcServer := NewGrpcServer(noteService, noteEventBroker, logger)
+	go grpcServer.Serve(grpcListener)
 
-	group.GET("/notes", noteController.Get)
-	group.GET("/notes/:id", noteController.GetById)
-	group.POST("/notes", noteController.Create)
-	group.PUT("/notes/:id", noteController.Update)
-	group.DELETE("/notes/:id", noteController.Delete)
+	server := NewHTTPServer(config.HTTP, router)
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			panic("failed to serve HTTP: " + err.Error())
+		}
+	}()
 
-	router.Run(":8080")
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-signals.Done()
+	shutdownTimeout := time.Duration(config.HTTP.ShutdownTimeout)
+	slog.Info("Shutting down", "timeout", shutdownTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	steps := httpShutdownSteps(server, noteEventBroker, noteEditHub)
+	Shutdown(ctx, append(steps,
+		ShutdownStep{"grpc", func(ctx context.Context) error { return stopGrpcServer(ctx, grpcServer) }},
+		ShutdownStep{"workers", workers.Stop},
+		ShutdownStep{"imports", noteImportService.Wait},
+		ShutdownStep{"tracing", tracerProvider.Shutdown},
+		ShutdownStep{"database", func(ctx context.Context) error {
+			sqlDB, err := db.DB()
+			if err != nil {
+				return err
+			}
+			return sqlDB.Close()
+		}},
+	)...)
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+const (
+	METRICS_NAMESPACE = "todo"
+	METRICS_PATH      = "/metrics"
+
+	queryStartKey = "metrics:query_start"
+)
+
+// Metrics are exposed at METRICS_PATH in the Prometheus format. Each
+// instance has its own registry, so that tests can create several.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time to handle HTTP requests by route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "db_query_duration_seconds",
+			Help:      "Time to run database statements by kind of operation.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation"}),
+	}
+	m.registry.MustRegister(m.requests, m.requestDuration, m.queryDuration,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// Middleware counts and times requests by the route they matched, so that
+// IDs in paths do not make a series each.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		labels := prometheus.Labels{"method": c.Request.Method, "route": route, "status": strconv.Itoa(c.Writer.Status())}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (m *Metrics) Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// RegisterDB times the statements run through gorm and exposes the stats of
+// the connection pool.
+func (m *Metrics) RegisterDB(db *gorm.DB) error {
+	if err := db.Use(&gormMetricsPlugin{m.queryDuration}); err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return m.registry.Register(collectors.NewDBStatsCollector(sqlDB, METRICS_NAMESPACE))
+}
+
+// RegisterNoteCount exposes the number of notes, counted on every scrape.
+func (m *Metrics) RegisterNoteCount(noteService INoteService) error {
+	return m.registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "notes",
+		Help:      "Number of notes.",
+	}, func() float64 {
+		count, ok := noteService.Count()
+		if !ok {
+			return math.NaN()
+		}
+		return float64(count)
+	}))
+}
+
+type gormMetricsPlugin struct {
+	queryDuration *prometheus.HistogramVec
+}
+
+func (p *gormMetricsPlugin) Name() string {
+	return "metrics"
+}
+
+func (p *gormMetricsPlugin) Initialize(db *gorm.DB) error {
+	return registerGormCallbacks(db, p.Name(), func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			db.InstanceSet(queryStartKey, time.Now())
+		}
+	}, func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			if begin, ok := db.InstanceGet(queryStartKey); ok {
+				p.queryDuration.WithLabelValues(operation).Observe(time.Since(begin.(time.Time)).Seconds())
+			}
+		}
+	})
+}
+
+// registerGormCallbacks registers the callbacks made by before and after
+// around every kind of operation, named after the plugin.
+func registerGormCallbacks(db *gorm.DB, plugin string, before, after func(operation string) func(db *gorm.DB)) error {
+	callback := db.Callback()
+	for _, err := range []error{
+		callback.Create().Before("*").Register(plugin+":before_create", before("create")),
+		callback.Create().After("*").Register(plugin+":after_create", after("create")),
+		callback.Query().Before("*").Register(plugin+":before_query", before("query")),
+		callback.Query().After("*").Register(plugin+":after_query", after("query")),
+		callback.Update().Before("*").Register(plugin+":before_update", before("update")),
+		callback.Update().After("*").Register(plugin+":after_update", after("update")),
+		callback.Delete().Before("*").Register(plugin+":before_delete", before("delete")),
+		callback.Delete().After("*").Register(plugin+":after_delete", after("delete")),
+		callback.Row().Before("*").Register(plugin+":before_row", before("row")),
+		callback.Row().After("*").Register(plugin+":after_row", after("row")),
+		callback.Raw().Before("*").Register(plugin+":before_raw", before("raw")),
+		callback.Raw().After("*").Register(plugin+":after_raw", after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
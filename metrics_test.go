@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// scrapeMetrics returns the metrics served by the router.
+func scrapeMetrics(t *testing.T, router http.Handler) string {
+	response := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", METRICS_PATH, nil)
+	router.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code)
+	body, _ := ioutil.ReadAll(response.Body)
+	return string(body)
+}
+
+func TestMetrics_requests(t *testing.T) {
+	mockService := &MockService{}
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "Milk"}, true)
+	mockService.On("GetById", uint64(2)).Return(Note{}, false)
+	router := NewRouter(&Controllers{noteController: NoteController{noteService: mockService}, metrics: NewMetrics()})
+
+	for _, path := range []string{"/v1/notes/1", "/v1/notes/1", "/v1/notes/2", "/v1/nothing"} {
+		request, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	metrics := scrapeMetrics(t, router)
+
+	assert.Contains(t, metrics, `todo_http_requests_total{method="GET",route="/v1/notes/:id",status="200"} 2`)
+	assert.Contains(t, metrics, `todo_http_requests_total{method="GET",route="/v1/notes/:id",status="404"} 1`)
+	assert.Contains(t, metrics, `todo_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, metrics, `todo_http_request_duration_seconds_count{method="GET",route="/v1/notes/:id",status="200"} 2`)
+	assert.Contains(t, metrics, `todo_http_request_duration_seconds_bucket{method="GET",route="/v1/notes/:id",status="200",le="+Inf"} 2`)
+	assert.Contains(t, metrics, "go_goroutines ")
+}
+
+func TestMetrics_database(t *testing.T) {
+	sqlDB, mock, _ := sqlmock.New()
+	defer sqlDB.Close()
+	db, _ := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	mock.ExpectQuery(`SELECT \* FROM "notes"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "idempotency_keys"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	metrics := NewMetrics()
+	assert.NoError(t, metrics.RegisterDB(db))
+
+	(&NoteRepository{db}).GetById(1)
+	(&IdempotencyKeyRepository{db}).DeleteExpired()
+	scraped := scrapeMetrics(t, NewRouter(&Controllers{metrics: metrics}))
+
+	assert.Contains(t, scraped, `todo_db_query_duration_seconds_count{operation="query"} 1`)
+	assert.Contains(t, scraped, `todo_db_query_duration_seconds_count{operation="delete"} 1`)
+	assert.Contains(t, scraped, `go_sql_open_connections{db_name="todo"} 1`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMetrics_noteCount(t *testing.T) {
+	mockService := &MockService{}
+	mockService.On("Count").Return(int64(3), true).Once()
+	mockService.On("Count").Return(int64(0), false)
+	metrics := NewMetrics()
+	assert.NoError(t, metrics.RegisterNoteCount(mockService))
+	router := NewRouter(&Controllers{metrics: metrics})
+
+	assert.Contains(t, scrapeMetrics(t, router), "todo_notes 3")
+	assert.Contains(t, scrapeMetrics(t, router), "todo_notes NaN")
+}
@@ -1,7 +1,82 @@
 package main
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
 type Note struct {
-	ID uint64 `gorm:"primaryKey" json:"id"`
-	Title string `json:"title"`
-	Content string `json:"content"`
-}
\ No newline at end of file
+	ID        uint64     `gorm:"primaryKey" json:"id"`
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	Tags      Tags       `gorm:"type:jsonb;not null;default:'[]'" json:"tags"`
+	Completed bool       `gorm:"not null;default:false" json:"completed"`
+	Due       *time.Time `json:"due,omitempty"`
+	// CalendarName and CalendarUID are the object name and UID given by the
+	// CalDAV client that created the note, which finds it by them.
+	CalendarName string `gorm:"index" json:"-"`
+	CalendarUID  string `json:"-"`
+	// Sequence is taken from note_sequence on every change so that clients
+	// can fetch what changed since the last sequence they saw.
+	Sequence  uint64         `gorm:"index" json:"-"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// Tags label a note. They are stored as a JSON array, and written as []
+// rather than null when there are none; no tags are nil once read.
+type Tags []string
+
+func (t Tags) MarshalJSON() ([]byte, error) {
+	if t == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *Tags) UnmarshalJSON(data []byte) error {
+	var tags []string
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return err
+	}
+	*t = uniqueTags(tags)
+	return nil
+}
+
+func (t Tags) Value() (driver.Value, error) {
+	data, err := t.MarshalJSON()
+	return string(data), err
+}
+
+func (t *Tags) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		return t.UnmarshalJSON([]byte(value))
+	case []byte:
+		return t.UnmarshalJSON(value)
+	}
+	return fmt.Errorf("cannot scan %T into tags", value)
+}
+
+// uniqueTags returns the tags without duplicates, in the order they are
+// first given.
+func uniqueTags(tags []string) Tags {
+	if len(tags) == 0 {
+		return nil
+	}
+	unique := make(Tags, 0, len(tags))
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			unique = append(unique, tag)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	BATCH_CREATE = "create"
+	BATCH_UPDATE = "update"
+	BATCH_DELETE = "delete"
+
+	BATCH_ATOMIC      = "atomic"
+	BATCH_BEST_EFFORT = "best_effort"
+
+	MAX_BATCH_OPERATIONS = 1000
+)
+
+type BatchOperation struct {
+	Method string      `json:"method" doc:"create, update or delete"`
+	ID     uint64      `json:"id" doc:"ID of note to update or delete"`
+	Note   NoteRequest `json:"note"`
+}
+
+type BatchResult struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	ID      uint64 `json:"id,omitempty"`
+}
+
+func (br *BatchResult) succeeded() bool {
+	return br.Status == http.StatusOK
+}
+
+// Batch applies the operations in order. If atomic, the operations are
+// applied in one transaction and nothing is applied unless all of them
+// succeed; the operations that were not applied get 424. It returns whether
+// all the operations succeeded.
+func (ns *NoteService) Batch(operations []BatchOperation, atomic bool) ([]BatchResult, bool) {
+	traced, span := ns.traced("Batch", attribute.Int("batch.size", len(operations)), attribute.Bool("batch.atomic", atomic))
+	defer span.End()
+	results := make([]BatchResult, len(operations))
+	if !atomic {
+		allSucceeded := true
+		for i, operation := range operations {
+			results[i] = traced.apply(operation)
+			allSucceeded = allSucceeded && results[i].succeeded()
+		}
+		return results, allSucceeded
+	}
+
+	// The events are published once the transaction is committed.
+	committed := traced.noteRepository.Transaction(func(noteRepository INoteRepository) bool {
+		transactional := &NoteService{noteRepository, nil, traced.quota, traced.ctx}
+		for i, operation := range operations {
+			results[i] = transactional.apply(operation)
+			if !results[i].succeeded() {
+				return false
+			}
+		}
+		return true
+	})
+	if committed {
+		publishNoteEvents(ns.noteEventPublisher)
+		return results, true
+	}
+	span.SetStatus(codes.Error, "Batch was rolled back")
+	for i := range results {
+		if results[i].Status == 0 || results[i].succeeded() {
+			results[i] = BatchResult{http.StatusFailedDependency, "Not applied", operations[i].ID}
+		}
+	}
+	return results, false
+}
+
+func validationFailure(err error, id uint64) BatchResult {
+	var messages []string
+	for _, fieldError := range err.(*ValidationError).Errors {
+		messages = append(messages, fieldError.Field+" "+fieldError.Message)
+	}
+	return BatchResult{http.StatusUnprocessableEntity, "Validation failed: " + strings.Join(messages, "; "), id}
+}
+
+func (ns *NoteService) apply(operation BatchOperation) BatchResult {
+	if operation.Method == BATCH_CREATE || operation.Method == BATCH_UPDATE {
+		if err := Validate(&operation.Note); err != nil {
+			return validationFailure(err, operation.ID)
+		}
+	}
+	switch operation.Method {
+	case BATCH_CREATE:
+		id, err := ns.Create(operation.Note.Note())
+		if errors.Is(err, &IllegalIdError{}) {
+			return BatchResult{http.StatusBadRequest, "ID must not be specified", 0}
+		}
+		if quotaExceeded(err) {
+			return BatchResult{http.StatusForbidden, "Quota exceeded: " + err.Error(), 0}
+		}
+		if err != nil {
+			return BatchResult{http.StatusInternalServerError, "Unexpected error", 0}
+		}
+		return BatchResult{http.StatusOK, "Success", id}
+	case BATCH_UPDATE:
+		if _, found := ns.GetById(operation.ID); !found {
+			return BatchResult{http.StatusNotFound, "Not found", operation.ID}
+		}
+		_, err := ns.Update(operation.ID, operation.Note.Note())
+		if errors.Is(err, &IllegalIdError{}) {
+			return BatchResult{http.StatusBadRequest, "Illegal ID in request body", operation.ID}
+		}
+		if quotaExceeded(err) {
+			return BatchResult{http.StatusForbidden, "Quota exceeded: " + err.Error(), operation.ID}
+		}
+		if err != nil {
+			return BatchResult{http.StatusInternalServerError, "Unexpected error", operation.ID}
+		}
+		return BatchResult{http.StatusOK, "Success", operation.ID}
+	case BATCH_DELETE:
+		if _, found := ns.GetById(operation.ID); !found {
+			return BatchResult{http.StatusNotFound, "Not found", operation.ID}
+		}
+		if deleted := ns.Delete(operation.ID); !deleted {
+			return BatchResult{http.StatusInternalServerError, "Unexpected error", operation.ID}
+		}
+		return BatchResult{http.StatusOK, "Success", operation.ID}
+	}
+	return BatchResult{http.StatusBadRequest, "Invalid method", operation.ID}
+}
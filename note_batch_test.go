@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoteService_Batch(t *testing.T) {
+	operations := []BatchOperation{
+		{Method: BATCH_CREATE, Note: NoteRequest{Title: "new"}},
+		{Method: BATCH_UPDATE, ID: 1, Note: NoteRequest{Title: "edited"}},
+		{Method: BATCH_DELETE, ID: 2},
+		{Method: BATCH_DELETE, ID: 3},
+		{Method: "xxx"},
+	}
+	for _, td := range []struct {
+		title             string
+		atomic            bool
+		expectedResults   []BatchResult
+		expectedPublished int
+	}{
+		{
+			title:  "Applies nothing in atomic mode if an operation fails",
+			atomic: true,
+			expectedResults: []BatchResult{
+				{424, "Not applied", 0},
+				{424, "Not applied", 1},
+				{424, "Not applied", 2},
+				{404, "Not found", 3},
+				{424, "Not applied", 0},
+			},
+			expectedPublished: 0,
+		},
+		{
+			title:  "Applies the operations that succeed in best-effort mode",
+			atomic: false,
+			expectedResults: []BatchResult{
+				{200, "Success", 10},
+				{200, "Success", 1},
+				{200, "Success", 2},
+				{404, "Not found", 3},
+				{400, "Invalid method", 0},
+			},
+			expectedPublished: 3,
+		},
+	} {
+		t.Run("Batch: "+td.title, func(t *testing.T) {
+			mockRepository := &MockRepository{}
+			mockPublisher := &MockEventPublisher{}
+			noteService := NoteService{noteRepository: mockRepository, noteEventPublisher: mockPublisher}
+
+			mockRepository.On("Transaction").Return()
+			mockRepository.On("Create", Note{Title: "new"}).Return(uint64(10), true)
+			mockRepository.On("GetById", uint64(1)).Return(Note{ID: 1}, true)
+			mockRepository.On("Update", uint64(1), Note{Title: "edited"}).Return(uint64(1), true)
+			mockRepository.On("GetById", uint64(2)).Return(Note{ID: 2}, true)
+			mockRepository.On("Delete", uint64(2)).Return(true)
+			mockRepository.On("GetById", uint64(3)).Return(Note{}, false)
+			mockPublisher.On("Publish").Return()
+
+			results, allSucceeded := noteService.Batch(operations, td.atomic)
+
+			assert.Equal(t, false, allSucceeded)
+			assert.Equal(t, td.expectedResults, results)
+			assert.Equal(t, td.expectedPublished, len(mockPublisher.Calls))
+		})
+	}
+}
+
+func TestNoteService_Batch_atomicSuccess(t *testing.T) {
+	mockRepository := &MockRepository{}
+	mockPublisher := &MockEventPublisher{}
+	noteService := NoteService{noteRepository: mockRepository, noteEventPublisher: mockPublisher}
+
+	mockRepository.On("Transaction").Return()
+	mockRepository.On("Create", Note{Title: "new"}).Return(uint64(10), true)
+	mockRepository.On("GetById", uint64(2)).Return(Note{ID: 2}, true)
+	mockRepository.On("Delete", uint64(2)).Return(true)
+	mockPublisher.On("Publish").Return()
+
+	results, allSucceeded := noteService.Batch([]BatchOperation{
+		{Method: BATCH_CREATE, Note: NoteRequest{Title: "new"}},
+		{Method: BATCH_DELETE, ID: 2},
+	}, true)
+
+	assert.Equal(t, true, allSucceeded)
+	assert.Equal(t, []BatchResult{{200, "Success", 10}, {200, "Success", 2}}, results)
+	mockRepository.AssertCalled(t, "Transaction")
+	// The transaction is published once, after it is committed.
+	mockPublisher.AssertNumberOfCalls(t, "Publish", 1)
+}
+
+func TestNoteService_Batch_validation(t *testing.T) {
+	mockRepository := &MockRepository{}
+	noteService := NoteService{noteRepository: mockRepository}
+
+	results, allSucceeded := noteService.Batch([]BatchOperation{
+		{Method: BATCH_CREATE, Note: NoteRequest{Title: "line\nbreak"}},
+		{Method: BATCH_UPDATE, ID: 1},
+	}, false)
+
+	assert.Equal(t, false, allSucceeded)
+	assert.Equal(t, []BatchResult{
+		{422, "Validation failed: title must match ^[^\\x00-\\x1f\\x7f]*$", 0},
+		{422, "Validation failed: title is required", 1},
+	}, results)
+	assert.Equal(t, 0, len(mockRepository.Calls))
+}
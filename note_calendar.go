@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	CALENDAR_CONTENT_TYPE      = "text/calendar; charset=utf-8"
+	CALENDAR_PRODID            = "-//hi-watana//todo-go-api//EN"
+	CALENDAR_TIME_FORMAT       = "20060102T150405Z"
+	CALENDAR_LOCAL_TIME_FORMAT = "20060102T150405"
+	CALENDAR_DATE_FORMAT       = "20060102"
+	MAX_CALENDAR_LINE_LENGTH   = 75
+)
+
+var (
+	errInvalidCalendar = errors.New("Calendar data is not valid iCalendar")
+	errNoVTODO         = errors.New("Calendar data must contain a VTODO")
+)
+
+// calendarWriter writes notes as VTODO components of an iCalendar object
+// (RFC 5545). Notes without due dates are undated to-dos.
+type calendarWriter struct {
+	writer io.Writer
+	stamp  time.Time
+	err    error
+}
+
+func newCalendarWriter(w io.Writer, stamp time.Time) *calendarWriter {
+	cw := &calendarWriter{writer: w, stamp: stamp.UTC()}
+	cw.line("BEGIN", "VCALENDAR")
+	cw.line("VERSION", "2.0")
+	cw.line("PRODID", CALENDAR_PRODID)
+	return cw
+}
+
+// noteUID is the UID given by the client that created the note, if any.
+func noteUID(note Note) string {
+	if note.CalendarUID != "" {
+		return note.CalendarUID
+	}
+	return fmt.Sprintf("note-%d@todo-go-api", note.ID)
+}
+
+func (cw *calendarWriter) Write(note Note) error {
+	cw.line("BEGIN", "VTODO")
+	cw.line("UID", noteUID(note))
+	cw.line("DTSTAMP", cw.stamp.Format(CALENDAR_TIME_FORMAT))
+	cw.line("SEQUENCE", fmt.Sprint(note.Sequence))
+	cw.line("SUMMARY", escapeCalendarText(note.Title))
+	if note.Content != "" {
+		cw.line("DESCRIPTION", escapeCalendarText(note.Content))
+	}
+	if len(note.Tags) > 0 {
+		categories := make([]string, len(note.Tags))
+		for i, tag := range note.Tags {
+			categories[i] = escapeCalendarText(tag)
+		}
+		cw.line("CATEGORIES", strings.Join(categories, ","))
+	}
+	if note.Due != nil {
+		cw.line("DUE", note.Due.UTC().Format(CALENDAR_TIME_FORMAT))
+	}
+	if note.Completed {
+		cw.line("STATUS", "COMPLETED")
+	}
+	cw.line("END", "VTODO")
+	return cw.err
+}
+
+func (cw *calendarWriter) Close() error {
+	cw.line("END", "VCALENDAR")
+	return cw.err
+}
+
+// line writes a content line folded into lines of at most
+// MAX_CALENDAR_LINE_LENGTH octets, without splitting characters.
+func (cw *calendarWriter) line(name string, value string) {
+	if cw.err != nil {
+		return
+	}
+	rest := name + ":" + value
+	var folded strings.Builder
+	for limit := MAX_CALENDAR_LINE_LENGTH; len(rest) > limit; limit = MAX_CALENDAR_LINE_LENGTH - 1 {
+		end := limit
+		for end > 0 && !utf8.RuneStart(rest[end]) {
+			end--
+		}
+		folded.WriteString(rest[:end] + "\r\n ")
+		rest = rest[end:]
+	}
+	folded.WriteString(rest + "\r\n")
+	_, cw.err = io.WriteString(cw.writer, folded.String())
+}
+
+var calendarTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func escapeCalendarText(text string) string {
+	return calendarTextEscaper.Replace(text)
+}
+
+func unescapeCalendarText(text string) string {
+	var unescaped strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i+1 == len(text) {
+			unescaped.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch text[i] {
+		case 'n', 'N':
+			unescaped.WriteByte('\n')
+		default:
+			unescaped.WriteByte(text[i])
+		}
+	}
+	return unescaped.String()
+}
+
+// parseVTODO reads the title, content, tags, due date and completion of a note
+// from the SUMMARY, DESCRIPTION, CATEGORIES, DUE and STATUS of the first VTODO
+// in the iCalendar object, and returns its UID. Other properties, such as
+// alarms, have no counterpart in notes and are dropped.
+func parseVTODO(data []byte) (NoteRequest, string, error) {
+	text := strings.TrimPrefix(string(data), "\uFEFF")
+	if !utf8.ValidString(text) {
+		return NoteRequest{}, "", errInvalidCalendar
+	}
+	text = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(text)
+
+	var (
+		components []string
+		request    NoteRequest
+		uid        string
+		found      bool
+	)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		name, params, value, ok := splitContentLine(line)
+		if !ok {
+			return NoteRequest{}, "", errInvalidCalendar
+		}
+		switch {
+		case name == "BEGIN":
+			if len(components) == 0 && !strings.EqualFold(value, "VCALENDAR") {
+				return NoteRequest{}, "", errInvalidCalendar
+			}
+			components = append(components, strings.ToUpper(value))
+		case len(components) == 0:
+			return NoteRequest{}, "", errInvalidCalendar
+		case name == "END":
+			if components[len(components)-1] != strings.ToUpper(value) {
+				return NoteRequest{}, "", errInvalidCalendar
+			}
+			if len(components) == 2 && components[1] == "VTODO" {
+				found = true
+			}
+			components = components[:len(components)-1]
+		case found || len(components) != 2 || components[1] != "VTODO":
+		case name == "UID":
+			uid = value
+		case name == "SUMMARY":
+			request.Title = unescapeCalendarText(value)
+		case name == "DESCRIPTION":
+			request.Content = unescapeCalendarText(value)
+		case name == "CATEGORIES":
+			for _, category := range splitCalendarList(value) {
+				request.Tags = append(request.Tags, unescapeCalendarText(category))
+			}
+		case name == "DUE":
+			due, err := parseCalendarTime(params, value)
+			if err != nil {
+				return NoteRequest{}, "", errInvalidCalendar
+			}
+			request.Due = &due
+		case name == "STATUS":
+			request.Completed = strings.EqualFold(value, "COMPLETED")
+		}
+	}
+	if len(components) != 0 {
+		return NoteRequest{}, "", errInvalidCalendar
+	}
+	if !found {
+		return NoteRequest{}, "", errNoVTODO
+	}
+	return request, uid, nil
+}
+
+// splitCalendarList splits a list value at the commas that are not escaped.
+func splitCalendarList(value string) []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ',':
+			values = append(values, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(values, value[start:])
+}
+
+// splitContentLine splits "NAME;PARAM=...:value" into the upper-cased name,
+// the parameters and the value, skipping colons in quoted parameter values.
+func splitContentLine(line string) (string, string, string, bool) {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ':' && !quoted:
+			name, params := line[:i], ""
+			if j := strings.IndexByte(name, ';'); j >= 0 {
+				name, params = name[:j], name[j+1:]
+			}
+			return strings.ToUpper(name), params, line[i+1:], name != ""
+		}
+	}
+	return "", "", "", false
+}
+
+// calendarParam returns the value of the parameter in "PARAM=...;...", or ""
+// if there is none.
+func calendarParam(params string, name string) string {
+	for _, param := range strings.Split(params, ";") {
+		if i := strings.IndexByte(param, '='); i >= 0 && strings.EqualFold(param[:i], name) {
+			return strings.Trim(param[i+1:], `"`)
+		}
+	}
+	return ""
+}
+
+// parseCalendarTime reads a DATE-TIME in UTC, in the time zone of its TZID
+// or floating, or a DATE, which is taken as the start of the day. Floating
+// times and time zones unknown to the server are taken as UTC.
+func parseCalendarTime(params string, value string) (time.Time, error) {
+	location := time.UTC
+	if tzid := calendarParam(params, "TZID"); tzid != "" {
+		if loaded, err := time.LoadLocation(tzid); err == nil {
+			location = loaded
+		}
+	}
+	switch {
+	case len(value) == len(CALENDAR_DATE_FORMAT):
+		return time.ParseInLocation(CALENDAR_DATE_FORMAT, value, location)
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse(CALENDAR_TIME_FORMAT, value)
+	default:
+		return time.ParseInLocation(CALENDAR_LOCAL_TIME_FORMAT, value, location)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var calendarStamp = time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("JST", 9*60*60))
+
+func calendarTime(t time.Time) *time.Time {
+	return &t
+}
+
+func TestCalendarWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := newCalendarWriter(&buffer, calendarStamp)
+	writer.Write(Note{ID: 1, Title: "Milk, eggs; bread", Content: "a\\b\r\nc", Sequence: 7})
+	writer.Write(Note{ID: 2, Title: "Empty", Tags: Tags{"home", "a;b"}, Completed: true, Due: calendarTime(calendarStamp), CalendarUID: "client-uid"})
+	assert.NoError(t, writer.Close())
+
+	assert.Equal(t, strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:" + CALENDAR_PRODID,
+		"BEGIN:VTODO",
+		"UID:note-1@todo-go-api",
+		"DTSTAMP:20240101T180405Z",
+		"SEQUENCE:7",
+		`SUMMARY:Milk\, eggs\; bread`,
+		`DESCRIPTION:a\\b\nc`,
+		"END:VTODO",
+		"BEGIN:VTODO",
+		"UID:client-uid",
+		"DTSTAMP:20240101T180405Z",
+		"SEQUENCE:0",
+		"SUMMARY:Empty",
+		`CATEGORIES:home,a\;b`,
+		"DUE:20240101T180405Z",
+		"STATUS:COMPLETED",
+		"END:VTODO",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n"), buffer.String())
+}
+
+func TestCalendarWriter_folding(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := newCalendarWriter(&buffer, calendarStamp)
+	title := strings.Repeat("a", 60) + strings.Repeat("あ", 30)
+	writer.Write(Note{ID: 1, Title: title})
+	writer.Close()
+
+	for _, line := range strings.Split(buffer.String(), "\r\n") {
+		assert.LessOrEqual(t, len(line), MAX_CALENDAR_LINE_LENGTH)
+	}
+	request, _, err := parseVTODO(buffer.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, title, request.Title)
+}
+
+func TestParseVTODO(t *testing.T) {
+	for _, td := range []struct {
+		title    string
+		data     string
+		expected NoteRequest
+		uid      string
+		err      error
+	}{
+		{
+			title: "Reads the summary and description",
+			data: "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VTODO\r\nUID:abc\r\nSUMMARY;LANGUAGE=en:Milk\\, eggs\r\n" +
+				"DESCRIPTION:line 1\\nline\r\n  2\r\nDUE:20240105T000000Z\r\nBEGIN:VALARM\r\nDESCRIPTION:Alarm\r\n" +
+				"END:VALARM\r\nEND:VTODO\r\nEND:VCALENDAR\r\n",
+			expected: NoteRequest{Title: "Milk, eggs", Content: "line 1\nline 2", Due: calendarTime(time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))},
+			uid:      "abc",
+		},
+		{
+			title:    "Reads the categories as tags",
+			data:     "BEGIN:VCALENDAR\nBEGIN:VTODO\nSUMMARY:Milk\nCATEGORIES:home,a\\,b\nCATEGORIES:shop\nEND:VTODO\nEND:VCALENDAR\n",
+			expected: NoteRequest{Title: "Milk", Tags: []string{"home", "a,b", "shop"}},
+		},
+		{
+			title:    "Reads the completion from the status",
+			data:     "BEGIN:VCALENDAR\nBEGIN:VTODO\nSUMMARY:Milk\nSTATUS:completed\nEND:VTODO\nEND:VCALENDAR\n",
+			expected: NoteRequest{Title: "Milk", Completed: true},
+		},
+		{
+			title:    "Reads due dates in time zones",
+			data:     "BEGIN:VCALENDAR\nBEGIN:VTODO\nSUMMARY:Milk\nDUE;TZID=Asia/Tokyo:20240105T090000\nEND:VTODO\nEND:VCALENDAR\n",
+			expected: NoteRequest{Title: "Milk", Due: calendarTime(time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))},
+		},
+		{
+			title:    "Reads due dates without times",
+			data:     "BEGIN:VCALENDAR\nBEGIN:VTODO\nSUMMARY:Milk\nDUE;VALUE=DATE:20240105\nEND:VTODO\nEND:VCALENDAR\n",
+			expected: NoteRequest{Title: "Milk", Due: calendarTime(time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))},
+		},
+		{
+			title: "Fails on invalid due dates",
+			data:  "BEGIN:VCALENDAR\nBEGIN:VTODO\nSUMMARY:Milk\nDUE:tomorrow\nEND:VTODO\nEND:VCALENDAR\n",
+			err:   errInvalidCalendar,
+		},
+		{
+			title: "Reads the first to-do of several",
+			data: "BEGIN:VCALENDAR\nBEGIN:VTIMEZONE\nTZID:\"x:y\"\nEND:VTIMEZONE\nBEGIN:VTODO\nSUMMARY:First\n" +
+				"END:VTODO\nBEGIN:VTODO\nSUMMARY:Second\nEND:VTODO\nEND:VCALENDAR",
+			expected: NoteRequest{Title: "First"},
+		},
+		{
+			title: "Fails without a to-do",
+			data:  "BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Meeting\nEND:VEVENT\nEND:VCALENDAR\n",
+			err:   errNoVTODO,
+		},
+		{
+			title: "Fails on unbalanced components",
+			data:  "BEGIN:VCALENDAR\nBEGIN:VTODO\nSUMMARY:Milk\nEND:VCALENDAR\n",
+			err:   errInvalidCalendar,
+		},
+		{
+			title: "Fails on lines without values",
+			data:  "BEGIN:VCALENDAR\nSUMMARY\nEND:VCALENDAR\n",
+			err:   errInvalidCalendar,
+		},
+		{
+			title: "Fails on other formats",
+			data:  `{"title": "Milk"}`,
+			err:   errInvalidCalendar,
+		},
+	} {
+		t.Run("parseVTODO: "+td.title, func(t *testing.T) {
+			request, uid, err := parseVTODO([]byte(td.data))
+			assert.Equal(t, td.err, err)
+			assert.Equal(t, td.uid, uid)
+			if td.expected.Due != nil && request.Due != nil {
+				assert.True(t, td.expected.Due.Equal(*request.Due), "due %v", request.Due)
+				request.Due = td.expected.Due
+			}
+			assert.Equal(t, td.expected, request)
+		})
+	}
+}
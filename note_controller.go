@@ -1,9 +1,11 @@
 package main
 
 import (
-	"errors"
+	"bytes"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,10 +16,21 @@ type INoteController interface {
 	Create(c *gin.Context)
 	Update(c *gin.Context)
 	Delete(c *gin.Context)
+	Batch(c *gin.Context)
 }
 
 type NoteController struct {
-	noteService INoteService
+	noteService  INoteService
+	noteRenderer *NoteRenderer
+}
+
+type BatchRequest struct {
+	Mode       string           `json:"mode" doc:"atomic (default) or best_effort"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
 }
 
 func getIdFromParamString(idString string) (uint64, error) {
@@ -28,8 +41,45 @@ func getIdFromParamString(idString string) (uint64, error) {
 	return id, nil
 }
 
+// bindNoteRequest binds and validates the request body, and responds with a
+// problem if it is invalid.
+func bindNoteRequest(c *gin.Context, request *NoteRequest) bool {
+	body, err := c.GetRawData()
+	if err != nil {
+		RespondProblem(c, InvalidRequestBodyProblem(err))
+		return false
+	}
+	if !utf8.Valid(body) {
+		problem := InvalidRequestBodyProblem(nil)
+		problem.Detail = "Request body must be UTF-8"
+		RespondProblem(c, problem)
+		return false
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err := c.ShouldBindJSON(request); err != nil {
+		RespondProblem(c, InvalidRequestBodyProblem(err))
+		return false
+	}
+	if err := Validate(request); err != nil {
+		RespondProblem(c, ErrorProblem(err))
+		return false
+	}
+	return true
+}
+
+// service returns the note service bound to the context of the request.
+func (nc *NoteController) service(c *gin.Context) INoteService {
+	return nc.noteService.WithContext(c.Request.Context())
+}
+
 func (nc *NoteController) Get(c *gin.Context) {
-	notes := nc.noteService.Get()
+	query, tag := c.Query("q"), c.Query("tag")
+	var notes []Note
+	if query == "" && tag == "" {
+		notes = nc.service(c).Get()
+	} else {
+		notes = nc.service(c).Search(query, tag)
+	}
 	c.IndentedJSON(http.StatusOK, notes)
 }
 
@@ -37,36 +87,47 @@ func (nc *NoteController) GetById(c *gin.Context) {
 	idString := c.Param("id")
 	id, err := getIdFromParamString(idString)
 	if err != nil {
-		response := ApiResponse{400, "Invalid ID"}
-		c.IndentedJSON(http.StatusBadRequest, response)
+		RespondProblem(c, InvalidIdProblem())
+		return
+	}
+	render, rendering := c.GetQuery("render")
+	if rendering && render != RENDER_HTML {
+		RespondProblem(c, InvalidParameterProblem("render", "must be "+RENDER_HTML))
 		return
 	}
-	note, found := nc.noteService.GetById(id)
+	note, found := nc.service(c).GetById(id)
 	if !found {
-		response := ApiResponse{404, "Not found"}
-		c.IndentedJSON(http.StatusNotFound, response)
+		RespondProblem(c, NotFoundProblem())
+		return
+	}
+	c.Header("Vary", "Accept")
+	if rendering || c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		nc.respondHTML(c, note)
 		return
 	}
 	c.IndentedJSON(http.StatusOK, note)
 }
 
-func (nc *NoteController) Create(c *gin.Context) {
-	var note Note
-	if err := c.BindJSON(&note); err != nil {
-		response := ApiResponse{400, "Invalid request body"}
-		c.IndentedJSON(http.StatusBadRequest, response)
+// respondHTML responds with the rendered content of the note. The policy
+// forbids scripts even if something slips through the sanitizer.
+func (nc *NoteController) respondHTML(c *gin.Context, note Note) {
+	html, err := nc.noteRenderer.Render(note)
+	if err != nil {
+		RespondProblem(c, InternalErrorProblem())
 		return
 	}
+	c.Header("Content-Security-Policy", "default-src 'none'; img-src *")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", html)
+}
 
-	_, err := nc.noteService.Create(note)
-	if errors.Is(err, &IllegalIdError{}) {
-		response := ApiResponse{400, "ID must not be specified"}
-		c.IndentedJSON(http.StatusBadRequest, response)
+func (nc *NoteController) Create(c *gin.Context) {
+	var request NoteRequest
+	if !bindNoteRequest(c, &request) {
 		return
 	}
-	if errors.Is(err, &InternalError{}) {
-		response := ApiResponse{500, "Unexpected error"}
-		c.IndentedJSON(http.StatusInternalServerError, response)
+
+	if _, err := nc.service(c).Create(request.Note()); err != nil {
+		RespondProblem(c, ErrorProblem(err))
 		return
 	}
 	response := ApiResponse{200, "Success"}
@@ -77,32 +138,21 @@ func (nc *NoteController) Update(c *gin.Context) {
 	idString := c.Param("id")
 	id, err := getIdFromParamString(idString)
 	if err != nil {
-		response := ApiResponse{400, "Invalid ID"}
-		c.IndentedJSON(http.StatusBadRequest, response)
+		RespondProblem(c, InvalidIdProblem())
 		return
 	}
 
-	var note Note
-	if err := c.BindJSON(&note); err != nil {
-		response := ApiResponse{400, "Invalid request body"}
-		c.IndentedJSON(http.StatusBadRequest, response)
+	var request NoteRequest
+	if !bindNoteRequest(c, &request) {
 		return
 	}
 
-	if _, found := nc.noteService.GetById(id); !found {
-		response := ApiResponse{404, "Not found"}
-		c.IndentedJSON(http.StatusNotFound, response)
-		return
-	}
-	_, err = nc.noteService.Update(id, note)
-	if errors.Is(err, &IllegalIdError{}) {
-		response := ApiResponse{400, "Illegal ID in request body"}
-		c.IndentedJSON(http.StatusBadRequest, response)
+	if _, found := nc.service(c).GetById(id); !found {
+		RespondProblem(c, NotFoundProblem())
 		return
 	}
-	if errors.Is(err, &InternalError{}) {
-		response := ApiResponse{500, "Unexpected error"}
-		c.IndentedJSON(http.StatusInternalServerError, response)
+	if _, err = nc.service(c).Update(id, request.Note()); err != nil {
+		RespondProblem(c, ErrorProblem(err))
 		return
 	}
 	response := ApiResponse{200, "Success"}
@@ -113,20 +163,42 @@ func (nc *NoteController) Delete(c *gin.Context) {
 	idString := c.Param("id")
 	id, err := getIdFromParamString(idString)
 	if err != nil {
-		response := ApiResponse{400, "Invalid ID"}
-		c.IndentedJSON(http.StatusBadRequest, response)
+		RespondProblem(c, InvalidIdProblem())
 		return
 	}
-	if _, found := nc.noteService.GetById(id); !found {
-		response := ApiResponse{404, "Not found"}
-		c.IndentedJSON(http.StatusNotFound, response)
+	if _, found := nc.service(c).GetById(id); !found {
+		RespondProblem(c, NotFoundProblem())
 		return
 	}
-	if deleted := nc.noteService.Delete(id); !deleted {
-		response := ApiResponse{500, "Unexpected error"}
-		c.IndentedJSON(http.StatusInternalServerError, response)
+	if deleted := nc.service(c).Delete(id); !deleted {
+		RespondProblem(c, InternalErrorProblem())
 		return
 	}
 	response := ApiResponse{200, "Success"}
 	c.IndentedJSON(http.StatusOK, response)
 }
+
+func (nc *NoteController) Batch(c *gin.Context) {
+	var request BatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		RespondProblem(c, InvalidRequestBodyProblem(err))
+		return
+	}
+	if request.Mode != "" && request.Mode != BATCH_ATOMIC && request.Mode != BATCH_BEST_EFFORT {
+		problem := InvalidRequestBodyProblem(nil)
+		problem.Detail = "Mode must be atomic or best_effort"
+		problem.Errors = []FieldError{{"mode", "must be atomic or best_effort"}}
+		RespondProblem(c, problem)
+		return
+	}
+	if len(request.Operations) > MAX_BATCH_OPERATIONS {
+		problem := InvalidRequestBodyProblem(nil)
+		problem.Detail = "Too many operations"
+		problem.Errors = []FieldError{{"operations", "must have at most " + strconv.Itoa(MAX_BATCH_OPERATIONS) + " items"}}
+		RespondProblem(c, problem)
+		return
+	}
+
+	results, _ := nc.service(c).Batch(request.Operations, request.Mode != BATCH_BEST_EFFORT)
+	c.IndentedJSON(http.StatusOK, BatchResponse{results})
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -16,6 +18,10 @@ type MockService struct {
 	mock.Mock
 }
 
+func (ms *MockService) WithContext(ctx context.Context) INoteService {
+	return ms
+}
+
 func (ms *MockService) Get() []Note {
 	ret := ms.Called()
 	return ret.Get(0).([]Note)
@@ -26,6 +32,37 @@ func (ms *MockService) GetById(id uint64) (Note, bool) {
 	return ret.Get(0).(Note), ret.Get(1).(bool)
 }
 
+func (ms *MockService) GetByIds(ids []uint64) []Note {
+	ret := ms.Called(ids)
+	return ret.Get(0).([]Note)
+}
+
+func (ms *MockService) GetByCalendarName(name string) (Note, bool) {
+	ret := ms.Called(name)
+	return ret.Get(0).(Note), ret.Get(1).(bool)
+}
+
+func (ms *MockService) Search(query string, tag string) []Note {
+	ret := ms.Called(query, tag)
+	return ret.Get(0).([]Note)
+}
+
+// GetAllInBatches calls fn with each batch returned by the mock.
+func (ms *MockService) GetAllInBatches(size int, fn func(notes []Note) error) error {
+	ret := ms.Called(size)
+	for _, notes := range ret.Get(0).([][]Note) {
+		if err := fn(notes); err != nil {
+			return err
+		}
+	}
+	return ret.Error(1)
+}
+
+func (ms *MockService) Count() (int64, bool) {
+	ret := ms.Called()
+	return ret.Get(0).(int64), ret.Get(1).(bool)
+}
+
 func (ms *MockService) Create(note Note) (uint64, error) {
 	ret := ms.Called(note)
 	return ret.Get(0).(uint64), ret.Error(1)
@@ -36,18 +73,38 @@ func (ms *MockService) Update(id uint64, note Note) (uint64, error) {
 	return ret.Get(0).(uint64), ret.Error(1)
 }
 
+func (ms *MockService) UpdateIfUnchanged(id uint64, sequence uint64, note Note) (uint64, error) {
+	ret := ms.Called(id, sequence, note)
+	return ret.Get(0).(uint64), ret.Error(1)
+}
+
+func (ms *MockService) UpdateContent(id uint64, sequence uint64, content string) (uint64, error) {
+	ret := ms.Called(id, sequence, content)
+	return ret.Get(0).(uint64), ret.Error(1)
+}
+
 func (ms *MockService) Delete(id uint64) bool {
 	ret := ms.Called(id)
 	return ret.Get(0).(bool)
 }
 
+func (ms *MockService) Batch(operations []BatchOperation, atomic bool) ([]BatchResult, bool) {
+	ret := ms.Called(operations, atomic)
+	return ret.Get(0).([]BatchResult), ret.Get(1).(bool)
+}
+
+func (ms *MockService) Usage() (UsageResponse, bool) {
+	ret := ms.Called()
+	return ret.Get(0).(UsageResponse), ret.Get(1).(bool)
+}
+
 func TestNoteController_Get(t *testing.T) {
 	mockService := &MockService{}
-	noteController := NoteController{mockService}
+	noteController := NoteController{noteService: mockService}
 	response := httptest.NewRecorder()
 	ginContext, _ := gin.CreateTestContext(response)
 
-	mockService.On("Get").Return([]Note{{1, "test_title", "test_content"}})
+	mockService.On("Get").Return([]Note{{ID: 1, Title: "test_title", Content: "test_content"}})
 
 	req, _ := http.NewRequest("GET", "/notes", nil)
 	ginContext.Request = req
@@ -55,7 +112,24 @@ func TestNoteController_Get(t *testing.T) {
 	noteController.Get(ginContext)
 
 	assert.Equal(t, http.StatusOK, response.Code)
-	expected, _ := json.MarshalIndent(&[]Note{{1, "test_title", "test_content"}}, "", "    ")
+	expected, _ := json.MarshalIndent(&[]Note{{ID: 1, Title: "test_title", Content: "test_content"}}, "", "    ")
+	assert.Equal(t, expected, response.Body.Bytes())
+}
+
+func TestNoteController_Get_search(t *testing.T) {
+	mockService := &MockService{}
+	noteController := NoteController{noteService: mockService}
+	response := httptest.NewRecorder()
+	ginContext, _ := gin.CreateTestContext(response)
+
+	mockService.On("Search", "milk", "home").Return([]Note{{ID: 1, Title: "shopping", Tags: Tags{"home"}}})
+
+	ginContext.Request, _ = http.NewRequest("GET", "/notes?q=milk&tag=home", nil)
+
+	noteController.Get(ginContext)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	expected, _ := json.MarshalIndent(&[]Note{{ID: 1, Title: "shopping", Tags: Tags{"home"}}}, "", "    ")
 	assert.Equal(t, expected, response.Body.Bytes())
 }
 
@@ -87,31 +161,25 @@ func TestNoteController_GetById(t *testing.T) {
 			},
 		},
 		{
-			title:              "Returns \"Invalid ID\" message if invalid ID was specified",
-			inputPathParameter: "xxx",
-			expectedStatus:     http.StatusBadRequest,
-			expectedResponseObject: &ApiResponse{
-				Status:  400,
-				Message: "Invalid ID",
-			},
+			title:                  "Returns \"Invalid ID\" problem if invalid ID was specified",
+			inputPathParameter:     "xxx",
+			expectedStatus:         http.StatusBadRequest,
+			expectedResponseObject: problemAt(InvalidIdProblem(), "/notes/xxx"),
 		},
 		{
-			title:              "Returns \"Not found\" message if not found",
-			inputId:            2,
-			inputPathParameter: "2",
-			outputNote:         Note{},
-			outputOk:           false,
-			expectedStatus:     http.StatusNotFound,
-			expectedResponseObject: &ApiResponse{
-				Status:  404,
-				Message: "Not found",
-			},
+			title:                  "Returns \"Not found\" problem if not found",
+			inputId:                2,
+			inputPathParameter:     "2",
+			outputNote:             Note{},
+			outputOk:               false,
+			expectedStatus:         http.StatusNotFound,
+			expectedResponseObject: problemAt(NotFoundProblem(), "/notes/2"),
 		},
 	} {
 		t.Run("GetById: "+td.title, func(t *testing.T) {
 			var (
 				mockService    = &MockService{}
-				noteController = NoteController{mockService}
+				noteController = NoteController{noteService: mockService}
 				response       = httptest.NewRecorder()
 				ginContext, _  = gin.CreateTestContext(response)
 				req, _         = http.NewRequest("GET", "/notes/"+td.inputPathParameter, nil)
@@ -131,6 +199,73 @@ func TestNoteController_GetById(t *testing.T) {
 	}
 }
 
+func TestNoteController_GetById_render(t *testing.T) {
+	for _, td := range []struct {
+		title               string
+		query               string
+		accept              string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			title:               "Renders HTML if render=html is given",
+			query:               "?render=html",
+			accept:              "application/json",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        "<p><strong>bold</strong></p>\n",
+		},
+		{
+			title:               "Renders HTML if preferred in Accept",
+			accept:              "text/html,application/xhtml+xml,*/*;q=0.8",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        "<p><strong>bold</strong></p>\n",
+		},
+		{
+			title:               "Returns JSON if preferred in Accept",
+			accept:              "*/*",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json; charset=utf-8",
+			expectedBody:        "{\n    \"id\": 1,\n    \"title\": \"title\",\n    \"content\": \"**bold**\",\n    \"tags\": [],\n    \"completed\": false\n}",
+		},
+		{
+			title:               "Returns \"Invalid parameter\" problem if render is not html",
+			query:               "?render=pdf",
+			expectedStatus:      http.StatusBadRequest,
+			expectedContentType: PROBLEM_CONTENT_TYPE,
+		},
+	} {
+		t.Run("GetById: "+td.title, func(t *testing.T) {
+			var (
+				mockService    = &MockService{}
+				noteController = NoteController{mockService, NewNoteRenderer(NOTE_RENDER_CACHE_SIZE)}
+				response       = httptest.NewRecorder()
+				ginContext, _  = gin.CreateTestContext(response)
+				req, _         = http.NewRequest("GET", "/notes/1"+td.query, nil)
+			)
+
+			mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "title", Content: "**bold**"}, true)
+
+			req.Header.Set("Accept", td.accept)
+			ginContext.Request = req
+			ginContext.Params = append(ginContext.Params, gin.Param{Key: "id", Value: "1"})
+
+			noteController.GetById(ginContext)
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			assert.Equal(t, td.expectedContentType, response.Header().Get("Content-Type"))
+			if td.expectedBody != "" {
+				assert.Equal(t, td.expectedBody, response.Body.String())
+			}
+			if td.expectedContentType == "text/html; charset=utf-8" {
+				assert.Equal(t, "default-src 'none'; img-src *", response.Header().Get("Content-Security-Policy"))
+			}
+		})
+	}
+}
+
 func noteToBytes(note Note) []byte {
 	ret, _ := json.Marshal(note)
 	return ret
@@ -163,16 +298,41 @@ func TestNoteController_Create(t *testing.T) {
 			},
 		},
 		{
-			title:          "Returns \"Invalid request body\" message",
-			requestBody:    []byte("not json"),
+			title:                  "Returns \"Invalid request body\" problem",
+			requestBody:            []byte("not json"),
+			expectedStatus:         http.StatusBadRequest,
+			expectedResponseObject: problemAt(InvalidRequestBodyProblem(&json.SyntaxError{}), "/notes/"),
+		},
+		{
+			title:          "Returns \"Validation failed\" problem if fields are invalid",
+			requestBody:    []byte(`{"title":"","content":"` + strings.Repeat("a", MAX_CONTENT_LENGTH+1) + `"}`),
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedResponseObject: &Problem{
+				Type:     PROBLEM_VALIDATION_FAILED,
+				Title:    "Validation failed",
+				Status:   422,
+				Detail:   "Request body has invalid fields",
+				Instance: "/notes/",
+				Errors: []FieldError{
+					{"title", "is required"},
+					{"content", "must be at most 100000 characters"},
+				},
+			},
+		},
+		{
+			title:          "Returns \"Invalid request body\" problem if request body is not UTF-8",
+			requestBody:    []byte("{\"title\":\"\xff\"}"),
 			expectedStatus: http.StatusBadRequest,
-			expectedResponseObject: &ApiResponse{
-				Status:  400,
-				Message: "Invalid request body",
+			expectedResponseObject: &Problem{
+				Type:     PROBLEM_INVALID_REQUEST_BODY,
+				Title:    "Invalid request body",
+				Status:   400,
+				Detail:   "Request body must be UTF-8",
+				Instance: "/notes/",
 			},
 		},
 		{
-			title: "Returns \"ID must not be specified\" message",
+			title: "Returns \"Illegal ID\" problem if ID is specified",
 			requestBody: noteToBytes(Note{
 				ID:      1,
 				Title:   "test_title",
@@ -183,15 +343,12 @@ func TestNoteController_Create(t *testing.T) {
 				Title:   "test_title",
 				Content: "test_content",
 			},
-			outputError:    &IllegalIdError{},
-			expectedStatus: http.StatusBadRequest,
-			expectedResponseObject: &ApiResponse{
-				Status:  400,
-				Message: "ID must not be specified",
-			},
+			outputError:            &IllegalIdError{},
+			expectedStatus:         http.StatusBadRequest,
+			expectedResponseObject: problemAt(ErrorProblem(&IllegalIdError{}), "/notes/"),
 		},
 		{
-			title: "Returns \"Unexpected error\" message",
+			title: "Returns \"Unexpected error\" problem",
 			requestBody: noteToBytes(Note{
 				Title:   "test_title",
 				Content: "test_content",
@@ -200,17 +357,14 @@ func TestNoteController_Create(t *testing.T) {
 				Title:   "test_title",
 				Content: "test_content",
 			},
-			outputError:    &InternalError{},
-			expectedStatus: http.StatusInternalServerError,
-			expectedResponseObject: &ApiResponse{
-				Status:  500,
-				Message: "Unexpected error",
-			},
+			outputError:            &InternalError{},
+			expectedStatus:         http.StatusInternalServerError,
+			expectedResponseObject: problemAt(InternalErrorProblem(), "/notes/"),
 		},
 	} {
 		t.Run("Create: "+td.title, func(t *testing.T) {
 			mockService := &MockService{}
-			noteController := NoteController{mockService}
+			noteController := NoteController{noteService: mockService}
 			response := httptest.NewRecorder()
 			ginContext, _ := gin.CreateTestContext(response)
 
@@ -262,7 +416,7 @@ func TestNoteController_Update(t *testing.T) {
 			},
 		},
 		{
-			title:              "Returns \"Not found\" message",
+			title:              "Returns \"Not found\" problem",
 			inputId:            1,
 			inputPathParameter: "1",
 			requestBody: noteToBytes(Note{
@@ -273,35 +427,26 @@ func TestNoteController_Update(t *testing.T) {
 				Title:   "test_title",
 				Content: "test_content",
 			},
-			found:          false,
-			expectedStatus: http.StatusNotFound,
-			expectedResponseObject: &ApiResponse{
-				Status:  404,
-				Message: "Not found",
-			},
+			found:                  false,
+			expectedStatus:         http.StatusNotFound,
+			expectedResponseObject: problemAt(NotFoundProblem(), "/notes/1"),
 		},
 		{
-			title:              "Returns \"Invalid ID\" message",
-			inputPathParameter: "xxx",
-			expectedStatus:     http.StatusBadRequest,
-			expectedResponseObject: &ApiResponse{
-				Status:  400,
-				Message: "Invalid ID",
-			},
+			title:                  "Returns \"Invalid ID\" problem",
+			inputPathParameter:     "xxx",
+			expectedStatus:         http.StatusBadRequest,
+			expectedResponseObject: problemAt(InvalidIdProblem(), "/notes/xxx"),
 		},
 		{
-			title:              "Returns \"Invalid request\" message",
-			inputId:            1,
-			inputPathParameter: "1",
-			requestBody:        []byte("not json"),
-			expectedStatus:     http.StatusBadRequest,
-			expectedResponseObject: &ApiResponse{
-				Status:  400,
-				Message: "Invalid request body",
-			},
+			title:                  "Returns \"Invalid request body\" problem",
+			inputId:                1,
+			inputPathParameter:     "1",
+			requestBody:            []byte("not json"),
+			expectedStatus:         http.StatusBadRequest,
+			expectedResponseObject: problemAt(InvalidRequestBodyProblem(&json.SyntaxError{}), "/notes/1"),
 		},
 		{
-			title:              "Returns \"Illegal ID in request body\" message",
+			title:              "Returns \"Illegal ID\" problem if ID is in request body",
 			inputId:            1,
 			inputPathParameter: "1",
 			requestBody: noteToBytes(Note{
@@ -314,16 +459,13 @@ func TestNoteController_Update(t *testing.T) {
 				Title:   "test_title",
 				Content: "test_content",
 			},
-			found:          true,
-			outputError:    &IllegalIdError{},
-			expectedStatus: http.StatusBadRequest,
-			expectedResponseObject: &ApiResponse{
-				Status:  400,
-				Message: "Illegal ID in request body",
-			},
+			found:                  true,
+			outputError:            &IllegalIdError{},
+			expectedStatus:         http.StatusBadRequest,
+			expectedResponseObject: problemAt(ErrorProblem(&IllegalIdError{}), "/notes/1"),
 		},
 		{
-			title:              "Returns \"Unexpected error\" message",
+			title:              "Returns \"Unexpected error\" problem",
 			inputId:            1,
 			inputPathParameter: "1",
 			requestBody: noteToBytes(Note{
@@ -334,18 +476,15 @@ func TestNoteController_Update(t *testing.T) {
 				Title:   "test_title",
 				Content: "test_content",
 			},
-			found:          true,
-			outputError:    &InternalError{},
-			expectedStatus: http.StatusInternalServerError,
-			expectedResponseObject: &ApiResponse{
-				Status:  500,
-				Message: "Unexpected error",
-			},
+			found:                  true,
+			outputError:            &InternalError{},
+			expectedStatus:         http.StatusInternalServerError,
+			expectedResponseObject: problemAt(InternalErrorProblem(), "/notes/1"),
 		},
 	} {
 		t.Run("Update: "+td.title, func(t *testing.T) {
 			mockService := &MockService{}
-			noteController := NoteController{mockService}
+			noteController := NoteController{noteService: mockService}
 			response := httptest.NewRecorder()
 			ginContext, _ := gin.CreateTestContext(response)
 
@@ -388,41 +527,32 @@ func TestNoteController_Delete(t *testing.T) {
 			},
 		},
 		{
-			title:              "Returns \"Invalid ID\" message",
-			inputPathParameter: "xxx",
-			expectedStatus:     http.StatusBadRequest,
-			expectedResponseObject: &ApiResponse{
-				Status:  400,
-				Message: "Invalid ID",
-			},
+			title:                  "Returns \"Invalid ID\" problem",
+			inputPathParameter:     "xxx",
+			expectedStatus:         http.StatusBadRequest,
+			expectedResponseObject: problemAt(InvalidIdProblem(), "/notes/xxx"),
 		},
 		{
-			title:              "Returns \"Not found\" message",
-			inputId:            2,
-			inputPathParameter: "2",
-			found:              false,
-			expectedStatus:     http.StatusNotFound,
-			expectedResponseObject: &ApiResponse{
-				Status:  404,
-				Message: "Not found",
-			},
+			title:                  "Returns \"Not found\" problem",
+			inputId:                2,
+			inputPathParameter:     "2",
+			found:                  false,
+			expectedStatus:         http.StatusNotFound,
+			expectedResponseObject: problemAt(NotFoundProblem(), "/notes/2"),
 		},
 		{
-			title:              "Returns \"Unexpected error\" message",
-			inputId:            1,
-			inputPathParameter: "1",
-			found:              true,
-			outputOk:           false,
-			expectedStatus:     http.StatusInternalServerError,
-			expectedResponseObject: &ApiResponse{
-				Status:  500,
-				Message: "Unexpected error",
-			},
+			title:                  "Returns \"Unexpected error\" problem",
+			inputId:                1,
+			inputPathParameter:     "1",
+			found:                  true,
+			outputOk:               false,
+			expectedStatus:         http.StatusInternalServerError,
+			expectedResponseObject: problemAt(InternalErrorProblem(), "/notes/1"),
 		},
 	} {
 		t.Run("Delete: "+td.title, func(t *testing.T) {
 			mockService := &MockService{}
-			noteController := NoteController{mockService}
+			noteController := NoteController{noteService: mockService}
 			response := httptest.NewRecorder()
 			ginContext, _ := gin.CreateTestContext(response)
 
@@ -441,3 +571,108 @@ func TestNoteController_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestNoteController_Batch(t *testing.T) {
+	for _, td := range []struct {
+		title                  string
+		requestBody            []byte
+		inputOperations        []BatchOperation
+		inputAtomic            bool
+		outputResults          []BatchResult
+		expectedStatus         int
+		expectedResponseObject interface{}
+	}{
+		{
+			title:           "Applies operations atomically by default",
+			requestBody:     []byte(`{"operations":[{"method":"create","note":{"title":"t"}},{"method":"delete","id":2}]}`),
+			inputOperations: []BatchOperation{{Method: BATCH_CREATE, Note: NoteRequest{Title: "t"}}, {Method: BATCH_DELETE, ID: 2}},
+			inputAtomic:     true,
+			outputResults:   []BatchResult{{424, "Not applied", 0}, {404, "Not found", 2}},
+			expectedStatus:  http.StatusOK,
+			expectedResponseObject: &BatchResponse{
+				Results: []BatchResult{{424, "Not applied", 0}, {404, "Not found", 2}},
+			},
+		},
+		{
+			title:           "Applies operations one by one in best-effort mode",
+			requestBody:     []byte(`{"mode":"best_effort","operations":[{"method":"delete","id":2}]}`),
+			inputOperations: []BatchOperation{{Method: BATCH_DELETE, ID: 2}},
+			inputAtomic:     false,
+			outputResults:   []BatchResult{{200, "Success", 2}},
+			expectedStatus:  http.StatusOK,
+			expectedResponseObject: &BatchResponse{
+				Results: []BatchResult{{200, "Success", 2}},
+			},
+		},
+		{
+			title:          "Returns \"Invalid mode\" problem if mode is unknown",
+			requestBody:    []byte(`{"mode":"xxx","operations":[]}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedResponseObject: &Problem{
+				Type:     PROBLEM_INVALID_REQUEST_BODY,
+				Title:    "Invalid request body",
+				Status:   400,
+				Detail:   "Mode must be atomic or best_effort",
+				Instance: "/notes:batch",
+				Errors:   []FieldError{{"mode", "must be atomic or best_effort"}},
+			},
+		},
+		{
+			title:          "Returns \"Too many operations\" problem if there are too many operations",
+			requestBody:    []byte(`{"operations":[` + strings.Repeat(`{"method":"delete","id":1},`, MAX_BATCH_OPERATIONS) + `{"method":"delete","id":1}]}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedResponseObject: &Problem{
+				Type:     PROBLEM_INVALID_REQUEST_BODY,
+				Title:    "Invalid request body",
+				Status:   400,
+				Detail:   "Too many operations",
+				Instance: "/notes:batch",
+				Errors:   []FieldError{{"operations", "must have at most 1000 items"}},
+			},
+		},
+		{
+			title:          "Returns \"Invalid request body\" problem if request body is invalid",
+			requestBody:    []byte("{"),
+			expectedStatus: http.StatusBadRequest,
+			expectedResponseObject: &Problem{
+				Type:     PROBLEM_INVALID_REQUEST_BODY,
+				Title:    "Invalid request body",
+				Status:   400,
+				Detail:   "Request body is not valid JSON",
+				Instance: "/notes:batch",
+			},
+		},
+	} {
+		t.Run("Batch: "+td.title, func(t *testing.T) {
+			var (
+				mockService    = &MockService{}
+				noteController = NoteController{noteService: mockService}
+				response       = httptest.NewRecorder()
+				_, router      = gin.CreateTestContext(response)
+				req, _         = http.NewRequest("POST", "/notes:batch", bytes.NewBuffer(td.requestBody))
+			)
+
+			mockService.On("Batch", td.inputOperations, td.inputAtomic).Return(td.outputResults, false)
+
+			router.POST("/notes:method", customMethods(map[string]gin.HandlerFunc{
+				":batch": noteController.Batch,
+			}))
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			expected, _ := json.MarshalIndent(td.expectedResponseObject, "", "    ")
+			assert.Equal(t, expected, response.Body.Bytes())
+		})
+	}
+}
+
+func TestCustomMethods_unknownMethod(t *testing.T) {
+	response := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(response)
+	req, _ := http.NewRequest("POST", "/notes:unknown", nil)
+
+	router.POST("/notes:method", customMethods(map[string]gin.HandlerFunc{}))
+	router.ServeHTTP(response, req)
+
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type INoteEditController interface {
+	Edit(c *gin.Context)
+}
+
+type NoteEditController struct {
+	noteEditHub INoteEditHub
+	upgrader    websocket.Upgrader
+}
+
+var lastEditClientId uint64
+
+func newEditClient() *EditClient {
+	id := atomic.AddUint64(&lastEditClientId, 1)
+	return &EditClient{
+		ID:       strconv.FormatUint(id, 10),
+		Messages: make(chan EditMessage, EDIT_CLIENT_BUFFER_SIZE),
+	}
+}
+
+func (ec *NoteEditController) Edit(c *gin.Context) {
+	idString := c.Param("id")
+	id, err := getIdFromParamString(idString)
+	if err != nil {
+		RespondProblem(c, InvalidIdProblem())
+		return
+	}
+
+	client := newEditClient()
+	session, found := ec.noteEditHub.Join(id, client)
+	if !found {
+		RespondProblem(c, NotFoundProblem())
+		return
+	}
+	defer ec.noteEditHub.Leave(session, client)
+
+	conn, err := ec.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already replied with an error.
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		for message := range client.Messages {
+			if err := conn.WriteJSON(message); err != nil {
+				break
+			}
+		}
+		// Closing the connection also ends the read loop below.
+		conn.Close()
+	}()
+
+	for {
+		var message EditMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+		switch message.Type {
+		case EDIT_OPERATION:
+			if message.Operation == nil {
+				err = ErrInvalidOperation
+				break
+			}
+			err = session.Apply(client, message.Revision, message.Operation)
+		case EDIT_CURSOR:
+			if message.Cursor == nil {
+				err = ErrInvalidOperation
+				break
+			}
+			err = session.MoveCursor(client, message.Revision, *message.Cursor)
+		default:
+			err = ErrInvalidOperation
+		}
+		if err != nil {
+			session.Reject(client, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newEditTestServer(noteService INoteService) *httptest.Server {
+	noteEditController := NoteEditController{noteEditHub: NewNoteEditHub(noteService)}
+	router := gin.New()
+	router.GET("/notes/:id/edit", noteEditController.Edit)
+	return httptest.NewServer(router)
+}
+
+func dialEdit(t *testing.T, server *httptest.Server, id string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/notes/" + id + "/edit"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Nil(t, err)
+	return conn
+}
+
+func readEditMessage(t *testing.T, conn *websocket.Conn, messageType string) EditMessage {
+	for {
+		var message EditMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Type == messageType {
+			return message
+		}
+	}
+}
+
+func TestNoteEditController_Edit(t *testing.T) {
+	mockService := &MockService{}
+	server := newEditTestServer(mockService)
+	defer server.Close()
+
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "title", Content: "abc", Sequence: 3}, true)
+	persisted := make(chan string, 1)
+	mockService.On("UpdateContent", uint64(1), uint64(3), mock.Anything).Return(uint64(4), nil).Run(func(args mock.Arguments) {
+		persisted <- args.Get(2).(string)
+	})
+
+	alice := dialEdit(t, server, "1")
+	snapshot := readEditMessage(t, alice, EDIT_SNAPSHOT)
+	assert.Equal(t, "abc", snapshot.Content)
+	assert.Equal(t, 0, snapshot.Revision)
+
+	bob := dialEdit(t, server, "1")
+	readEditMessage(t, bob, EDIT_SNAPSHOT)
+	readEditMessage(t, alice, EDIT_JOIN)
+
+	// Both edit revision 0 concurrently.
+	alice.WriteJSON(EditMessage{Type: EDIT_OPERATION, Revision: 0, Operation: (&TextOperation{}).Insert("X").Retain(3)})
+	assert.Equal(t, 1, readEditMessage(t, alice, EDIT_ACK).Revision)
+	bob.WriteJSON(EditMessage{Type: EDIT_OPERATION, Revision: 0, Operation: (&TextOperation{}).Retain(3).Insert("Y")})
+
+	fromAlice := readEditMessage(t, bob, EDIT_OPERATION)
+	assert.Equal(t, 1, fromAlice.Revision)
+	assert.Equal(t, 2, readEditMessage(t, bob, EDIT_ACK).Revision)
+	fromBob := readEditMessage(t, alice, EDIT_OPERATION)
+	assert.Equal(t, 2, fromBob.Revision)
+	aliceContent, _ := fromBob.Operation.Apply("Xabc")
+	assert.Equal(t, "XabcY", aliceContent)
+
+	bob.WriteJSON(EditMessage{Type: EDIT_CURSOR, Revision: 2, Cursor: &EditCursor{Position: 5, Selection: 5}})
+	cursor := readEditMessage(t, alice, EDIT_CURSOR)
+	assert.Equal(t, snapshot.ClientID, fromAlice.ClientID)
+	assert.NotEqual(t, fromAlice.ClientID, cursor.ClientID)
+	assert.Equal(t, 5, cursor.Cursor.Position)
+
+	bob.WriteJSON(EditMessage{Type: EDIT_OPERATION, Revision: 9, Operation: (&TextOperation{}).Retain(5)})
+	assert.Equal(t, ErrRevisionOutOfRange.Error(), readEditMessage(t, bob, EDIT_ERROR).Message)
+
+	alice.Close()
+	readEditMessage(t, bob, EDIT_LEAVE)
+	bob.Close()
+
+	assert.Equal(t, "XabcY", <-persisted)
+}
+
+func TestNoteEditController_Edit_notFound(t *testing.T) {
+	mockService := &MockService{}
+	server := newEditTestServer(mockService)
+	defer server.Close()
+
+	mockService.On("GetById", uint64(2)).Return(Note{}, false)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/notes/2/edit"
+	_, response, err := websocket.DefaultDialer.Dial(url, nil)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestNoteEditHub_Close(t *testing.T) {
+	mockService := &MockService{}
+	noteEditHub := NewNoteEditHub(mockService)
+	noteEditController := NoteEditController{noteEditHub: noteEditHub}
+	router := gin.New()
+	router.GET("/notes/:id/edit", noteEditController.Edit)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "title", Content: "abc", Sequence: 3}, true)
+	mockService.On("UpdateContent", uint64(1), uint64(3), "abcX").Return(uint64(4), nil).Once()
+
+	alice := dialEdit(t, server, "1")
+	readEditMessage(t, alice, EDIT_SNAPSHOT)
+	bob := dialEdit(t, server, "1")
+	readEditMessage(t, bob, EDIT_SNAPSHOT)
+	alice.WriteJSON(EditMessage{Type: EDIT_OPERATION, Revision: 0, Operation: (&TextOperation{}).Retain(3).Insert("X")})
+	readEditMessage(t, alice, EDIT_ACK)
+
+	noteEditHub.Close()
+
+	mockService.AssertExpectations(t)
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		for {
+			var message EditMessage
+			if err := conn.ReadJSON(&message); err != nil {
+				break
+			}
+		}
+	}
+	// Another client starts a new session once both have left the closed one.
+	assert.Eventually(t, func() bool {
+		conn := dialEdit(t, server, "1")
+		defer conn.Close()
+		return readEditMessage(t, conn, EDIT_SNAPSHOT).Content == "abc"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNoteEditHub_conflict(t *testing.T) {
+	defer func(interval time.Duration) { persistInterval = interval }(persistInterval)
+	persistInterval = 10 * time.Millisecond
+	mockService := &MockService{}
+	server := newEditTestServer(mockService)
+	defer server.Close()
+
+	// The note is changed over REST after the session read it, so the
+	// content of the session is not written over the change.
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "title", Content: "abc", Sequence: 3}, true)
+	mockService.On("UpdateContent", uint64(1), uint64(3), "abcX").Return(uint64(0), &NoteConflictError{}).Once()
+
+	alice := dialEdit(t, server, "1")
+	readEditMessage(t, alice, EDIT_SNAPSHOT)
+	alice.WriteJSON(EditMessage{Type: EDIT_OPERATION, Revision: 0, Operation: (&TextOperation{}).Retain(3).Insert("X")})
+	readEditMessage(t, alice, EDIT_ACK)
+
+	assert.Equal(t, (&NoteConflictError{}).Error(), readEditMessage(t, alice, EDIT_ERROR).Message)
+	var message EditMessage
+	assert.Error(t, alice.ReadJSON(&message))
+	mockService.AssertExpectations(t)
+}
+
+func TestNoteEditSession_history(t *testing.T) {
+	session := &NoteEditSession{clients: map[*EditClient]struct{}{}}
+	client := &EditClient{ID: "1", Messages: make(chan EditMessage, EDIT_CLIENT_BUFFER_SIZE)}
+	for i := 0; i <= MAX_EDIT_HISTORY; i++ {
+		assert.NoError(t, session.Apply(client, i, (&TextOperation{}).Retain(i).Insert("x")))
+	}
+
+	assert.Len(t, session.history, MAX_EDIT_HISTORY)
+	assert.Equal(t, ErrRevisionOutOfRange, session.Apply(client, 0, (&TextOperation{}).Insert("y")))
+	assert.NoError(t, session.Apply(client, 1, (&TextOperation{}).Insert("y").Retain(1)))
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	EDIT_CLIENT_BUFFER_SIZE = 64
+	MAX_EDIT_HISTORY        = 1000
+)
+
+var persistInterval = 5 * time.Second
+
+var (
+	ErrRevisionOutOfRange = errors.New("revision out of range")
+)
+
+// EditMessage is exchanged over the WebSocket of a collaborative editing
+// session. Which fields are set depends on Type.
+type EditMessage struct {
+	Type      string         `json:"type"`
+	ClientID  string         `json:"clientId,omitempty"`
+	Revision  int            `json:"revision"`
+	Operation *TextOperation `json:"operation,omitempty"`
+	Content   string         `json:"content,omitempty"`
+	Cursor    *EditCursor    `json:"cursor,omitempty"`
+	Message   string         `json:"message,omitempty"`
+}
+
+type EditCursor struct {
+	Position  int `json:"position"`
+	Selection int `json:"selection"`
+}
+
+const (
+	EDIT_SNAPSHOT  = "snapshot"
+	EDIT_OPERATION = "operation"
+	EDIT_ACK       = "ack"
+	EDIT_CURSOR    = "cursor"
+	EDIT_JOIN      = "join"
+	EDIT_LEAVE     = "leave"
+	EDIT_ERROR     = "error"
+)
+
+type EditClient struct {
+	ID       string
+	Messages chan EditMessage
+	cursor   *EditCursor
+}
+
+// NoteEditSession holds the shared state of a note while it is being
+// edited. Operations from clients are transformed against the operations
+// applied since the revision they were based on, applied to the content and
+// broadcast to the other clients. Only the last MAX_EDIT_HISTORY operations
+// are kept, so clients far behind have to rejoin.
+type NoteEditSession struct {
+	noteId       uint64
+	noteService  INoteService
+	hub          *NoteEditHub
+	mutex        sync.Mutex
+	persistMutex sync.Mutex
+	content      string
+	sequence     uint64
+	revision     int
+	history      []*TextOperation
+	clients      map[*EditClient]struct{}
+	dirty        bool
+	closed       bool
+	stop         chan struct{}
+}
+
+func (es *NoteEditSession) send(client *EditClient, message EditMessage) {
+	if _, found := es.clients[client]; !found {
+		return
+	}
+	select {
+	case client.Messages <- message:
+	default:
+		// The client is too slow; it has to rejoin to get a fresh snapshot.
+		delete(es.clients, client)
+		close(client.Messages)
+	}
+}
+
+func (es *NoteEditSession) broadcast(message EditMessage, except *EditClient) {
+	for client := range es.clients {
+		if client != except {
+			es.send(client, message)
+		}
+	}
+}
+
+// join adds the client, unless the session has been closed.
+func (es *NoteEditSession) join(client *EditClient) bool {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if es.closed {
+		return false
+	}
+	es.clients[client] = struct{}{}
+	es.send(client, EditMessage{
+		Type:     EDIT_SNAPSHOT,
+		ClientID: client.ID,
+		Revision: es.revision,
+		Content:  es.content,
+	})
+	for other := range es.clients {
+		if other != client && other.cursor != nil {
+			es.send(client, EditMessage{Type: EDIT_CURSOR, ClientID: other.ID, Revision: es.revision, Cursor: other.cursor})
+		}
+	}
+	es.broadcast(EditMessage{Type: EDIT_JOIN, ClientID: client.ID}, client)
+	return true
+}
+
+// leave removes the client and reports whether the session is closed. A
+// session is closed once empty, so that no client joins it while it is
+// being persisted.
+func (es *NoteEditSession) leave(client *EditClient) bool {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if _, found := es.clients[client]; found {
+		delete(es.clients, client)
+		close(client.Messages)
+		es.broadcast(EditMessage{Type: EDIT_LEAVE, ClientID: client.ID}, nil)
+	}
+	if len(es.clients) == 0 {
+		es.closed = true
+	}
+	return es.closed
+}
+
+// close disconnects the clients, telling them why if message is not empty.
+func (es *NoteEditSession) close(message string) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	es.closed = true
+	for client := range es.clients {
+		if message != "" {
+			es.send(client, EditMessage{Type: EDIT_ERROR, Revision: es.revision, Message: message})
+		}
+		if _, found := es.clients[client]; found {
+			delete(es.clients, client)
+			close(client.Messages)
+		}
+	}
+}
+
+// since returns the operations applied after the revision.
+func (es *NoteEditSession) since(revision int) ([]*TextOperation, error) {
+	if revision < es.revision-len(es.history) || revision > es.revision {
+		return nil, ErrRevisionOutOfRange
+	}
+	return es.history[len(es.history)-(es.revision-revision):], nil
+}
+
+// Apply transforms an operation made by the client at the given revision
+// and applies it to the content.
+func (es *NoteEditSession) Apply(client *EditClient, revision int, operation *TextOperation) error {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	concurrents, err := es.since(revision)
+	if err != nil {
+		return err
+	}
+	for _, concurrent := range concurrents {
+		transformed, _, err := TransformTextOperations(operation, concurrent)
+		if err != nil {
+			return err
+		}
+		operation = transformed
+	}
+	content, err := operation.Apply(es.content)
+	if err != nil {
+		return err
+	}
+	es.content = content
+	es.revision++
+	es.history = append(es.history, operation)
+	if len(es.history) > MAX_EDIT_HISTORY {
+		es.history = es.history[1:]
+	}
+	es.dirty = true
+
+	es.broadcast(EditMessage{
+		Type:      EDIT_OPERATION,
+		ClientID:  client.ID,
+		Revision:  es.revision,
+		Operation: operation,
+	}, client)
+	es.send(client, EditMessage{Type: EDIT_ACK, Revision: es.revision})
+	return nil
+}
+
+// Reject tells the client that its message could not be handled.
+func (es *NoteEditSession) Reject(client *EditClient, err error) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	es.send(client, EditMessage{Type: EDIT_ERROR, Revision: es.revision, Message: err.Error()})
+}
+
+// MoveCursor broadcasts the cursor of the client. A cursor made at an older
+// revision is transformed the same way as an operation would be.
+func (es *NoteEditSession) MoveCursor(client *EditClient, revision int, cursor EditCursor) error {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	concurrents, err := es.since(revision)
+	if err != nil {
+		return err
+	}
+	for _, concurrent := range concurrents {
+		cursor.Position = transformIndex(cursor.Position, concurrent)
+		cursor.Selection = transformIndex(cursor.Selection, concurrent)
+	}
+	client.cursor = &cursor
+	es.broadcast(EditMessage{Type: EDIT_CURSOR, ClientID: client.ID, Revision: es.revision, Cursor: &cursor}, client)
+	return nil
+}
+
+func transformIndex(index int, operation *TextOperation) int {
+	newIndex, position := index, 0
+	for _, component := range operation.components {
+		if position > index {
+			break
+		}
+		switch {
+		case component.isRetain():
+			position += component.retain
+		case component.isInsert():
+			newIndex += len([]rune(component.insert))
+		case component.isDelete():
+			deleted := component.delete
+			if index-position < deleted {
+				deleted = index - position
+			}
+			newIndex -= deleted
+			position += component.delete
+		}
+	}
+	return newIndex
+}
+
+// Persist writes the content back to the note if it has changed. It
+// returns a NoteConflictError if the note has been changed or deleted
+// otherwise since the session read or last wrote it.
+func (es *NoteEditSession) Persist() error {
+	es.persistMutex.Lock()
+	defer es.persistMutex.Unlock()
+
+	es.mutex.Lock()
+	if !es.dirty {
+		es.mutex.Unlock()
+		return nil
+	}
+	content, sequence := es.content, es.sequence
+	es.dirty = false
+	es.mutex.Unlock()
+
+	newSequence, err := es.noteService.UpdateContent(es.noteId, sequence, content)
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	if err != nil {
+		es.dirty = true
+		return err
+	}
+	es.sequence = newSequence
+	return nil
+}
+
+// persistPeriodically persists the content every persistInterval until the
+// session is stopped. A session whose note has been changed or deleted
+// otherwise is ended rather than overwriting the change.
+func (es *NoteEditSession) persistPeriodically() {
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var conflict *NoteConflictError
+			if err := es.Persist(); errors.As(err, &conflict) {
+				es.hub.end(es, err.Error())
+				return
+			}
+		case <-es.stop:
+			return
+		}
+	}
+}
+
+type INoteEditHub interface {
+	Join(noteId uint64, client *EditClient) (*NoteEditSession, bool)
+	Leave(session *NoteEditSession, client *EditClient)
+}
+
+// NoteEditHub keeps one editing session per note that has clients
+// connected. The database is not used while holding its lock, so that a
+// slow query does not hold up the sessions of other notes.
+type NoteEditHub struct {
+	noteService INoteService
+	mutex       sync.Mutex
+	sessions    map[uint64]*NoteEditSession
+}
+
+func NewNoteEditHub(noteService INoteService) *NoteEditHub {
+	return &NoteEditHub{
+		noteService: noteService,
+		sessions:    map[uint64]*NoteEditSession{},
+	}
+}
+
+// Join adds the client to the session of the note, starting a session if
+// needed. It returns false if the note does not exist.
+func (eh *NoteEditHub) Join(noteId uint64, client *EditClient) (*NoteEditSession, bool) {
+	for {
+		eh.mutex.Lock()
+		session, found := eh.sessions[noteId]
+		eh.mutex.Unlock()
+		if !found {
+			note, found := eh.noteService.GetById(noteId)
+			if !found {
+				return nil, false
+			}
+			session = eh.start(note)
+		}
+		// A session closed in the meantime is replaced by a new one.
+		if session.join(client) {
+			return session, true
+		}
+	}
+}
+
+// start returns the session of the note, starting one with the note unless
+// another has been started since the note was read.
+func (eh *NoteEditHub) start(note Note) *NoteEditSession {
+	eh.mutex.Lock()
+	defer eh.mutex.Unlock()
+
+	if session, found := eh.sessions[note.ID]; found {
+		return session
+	}
+	session := &NoteEditSession{
+		noteId:      note.ID,
+		noteService: eh.noteService,
+		hub:         eh,
+		content:     note.Content,
+		sequence:    note.Sequence,
+		clients:     map[*EditClient]struct{}{},
+		stop:        make(chan struct{}),
+	}
+	eh.sessions[note.ID] = session
+	go session.persistPeriodically()
+	return session
+}
+
+// remove removes the session, and reports whether it was still there.
+func (eh *NoteEditHub) remove(session *NoteEditSession) bool {
+	eh.mutex.Lock()
+	defer eh.mutex.Unlock()
+
+	if eh.sessions[session.noteId] != session {
+		return false
+	}
+	delete(eh.sessions, session.noteId)
+	return true
+}
+
+// Leave removes the client from the session. The last client to leave
+// closes the session, persisting any pending changes.
+func (eh *NoteEditHub) Leave(session *NoteEditSession, client *EditClient) {
+	if closed := session.leave(client); !closed || !eh.remove(session) {
+		return
+	}
+	close(session.stop)
+	session.Persist()
+}
+
+// end closes the session of a note changed or deleted otherwise, telling
+// its clients why.
+func (eh *NoteEditHub) end(session *NoteEditSession, message string) {
+	eh.remove(session)
+	session.close(message)
+}
+
+// Close disconnects the clients of all sessions and persists their pending
+// changes, so that no edit is lost on shutdown. It returns once they are
+// persisted.
+func (eh *NoteEditHub) Close() {
+	eh.mutex.Lock()
+	sessions := eh.sessions
+	eh.sessions = map[uint64]*NoteEditSession{}
+	eh.mutex.Unlock()
+
+	for _, session := range sessions {
+		session.close("")
+		close(session.stop)
+		session.Persist()
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+const (
+	NOTE_CREATED = "created"
+	NOTE_UPDATED = "updated"
+	NOTE_DELETED = "deleted"
+)
+
+type NoteEvent struct {
+	ID        uint64     `gorm:"primaryKey" json:"id"`
+	Type      string     `json:"type"`
+	NoteID    uint64     `json:"noteId"`
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	Tags      Tags       `gorm:"type:jsonb;not null;default:'[]'" json:"tags"`
+	Completed bool       `gorm:"not null;default:false" json:"completed"`
+	Due       *time.Time `json:"due,omitempty"`
+	CreatedAt time.Time  `gorm:"index" json:"createdAt"`
+}
+
+// Note returns the note the event refers to as it was after the change.
+func (ne *NoteEvent) Note() Note {
+	return Note{
+		ID:        ne.NoteID,
+		Title:     ne.Title,
+		Content:   ne.Content,
+		Tags:      ne.Tags,
+		Completed: ne.Completed,
+		Due:       ne.Due,
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	SUBSCRIBER_BUFFER_SIZE   = 64
+	NOTE_EVENT_POLL_INTERVAL = time.Second
+)
+
+// INoteEventPublisher is told when changes have been committed, with their
+// events, to the event log.
+type INoteEventPublisher interface {
+	Publish()
+}
+
+type INoteEventSubscriber interface {
+	Subscribe() chan NoteEvent
+	Unsubscribe(events chan NoteEvent)
+	GetSince(id uint64) []NoteEvent
+}
+
+// NoteEventBroker fans the events committed to the event log out to the
+// subscribers in the same process. Events are written by the changes
+// themselves, in their transactions, so none is lost if the process stops
+// before they are sent.
+type NoteEventBroker struct {
+	noteEventRepository INoteEventRepository
+	mutex               sync.Mutex
+	subscribers         map[chan NoteEvent]struct{}
+	closed              bool
+	committed           chan struct{}
+}
+
+func NewNoteEventBroker(noteEventRepository INoteEventRepository) *NoteEventBroker {
+	return &NoteEventBroker{
+		noteEventRepository: noteEventRepository,
+		subscribers:         map[chan NoteEvent]struct{}{},
+		committed:           make(chan struct{}, 1),
+	}
+}
+
+// Publish wakes Run to send the events committed since it last did. It
+// does not wait for them to be sent.
+func (eb *NoteEventBroker) Publish() {
+	select {
+	case eb.committed <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the events committed to the log after it starts, in the order
+// of their IDs, whenever Publish is called and every interval for those
+// committed by other replicas, until the context is done.
+func (eb *NoteEventBroker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastId, started := eb.noteEventRepository.LastId()
+	for {
+		select {
+		case <-eb.committed:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if !started {
+			lastId, started = eb.noteEventRepository.LastId()
+			continue
+		}
+		lastId = eb.send(lastId)
+	}
+}
+
+// send reads the events after the ID from the log, without holding the
+// lock, and sends them to the subscribers. It returns the ID of the last
+// event sent.
+func (eb *NoteEventBroker) send(lastId uint64) uint64 {
+	events := eb.noteEventRepository.GetSince(lastId)
+	if len(events) == 0 {
+		return lastId
+	}
+
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+	for _, event := range events {
+		for subscriber := range eb.subscribers {
+			select {
+			case subscriber <- event:
+			default:
+				// A subscriber that cannot keep up is dropped. It can
+				// reconnect with Last-Event-ID to catch up from the event log.
+				delete(eb.subscribers, subscriber)
+				close(subscriber)
+			}
+		}
+	}
+	return events[len(events)-1].ID
+}
+
+func (eb *NoteEventBroker) Subscribe() chan NoteEvent {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	events := make(chan NoteEvent, SUBSCRIBER_BUFFER_SIZE)
+	if eb.closed {
+		close(events)
+		return events
+	}
+	eb.subscribers[events] = struct{}{}
+	return events
+}
+
+// Close ends the streams of all subscribers, present and future, so that
+// they do not hold up shutdown.
+func (eb *NoteEventBroker) Close() {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	eb.closed = true
+	for events := range eb.subscribers {
+		delete(eb.subscribers, events)
+		close(events)
+	}
+}
+
+func (eb *NoteEventBroker) Unsubscribe(events chan NoteEvent) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	if _, found := eb.subscribers[events]; found {
+		delete(eb.subscribers, events)
+		close(events)
+	}
+}
+
+func (eb *NoteEventBroker) GetSince(id uint64) []NoteEvent {
+	return eb.noteEventRepository.GetSince(id)
+}
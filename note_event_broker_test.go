@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockEventRepository struct {
+	mock.Mock
+}
+
+func (mr *MockEventRepository) GetSince(id uint64) []NoteEvent {
+	ret := mr.Called(id)
+	return ret.Get(0).([]NoteEvent)
+}
+
+func (mr *MockEventRepository) LastId() (uint64, bool) {
+	ret := mr.Called()
+	return ret.Get(0).(uint64), ret.Get(1).(bool)
+}
+
+func (mr *MockEventRepository) DeleteOlderThan(before time.Time) bool {
+	ret := mr.Called(before)
+	return ret.Bool(0)
+}
+
+func TestNoteEventBroker_Run(t *testing.T) {
+	mockRepository := &MockEventRepository{}
+	broker := NewNoteEventBroker(mockRepository)
+	event := NoteEvent{ID: 10, Type: NOTE_CREATED, NoteID: 1, Title: "test_title", Content: "test_content"}
+
+	mockRepository.On("LastId").Return(uint64(9), true)
+	mockRepository.On("GetSince", uint64(9)).Return([]NoteEvent{event})
+	mockRepository.On("GetSince", uint64(10)).Return([]NoteEvent{})
+
+	events := broker.Subscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go broker.Run(ctx, time.Hour)
+	broker.Publish()
+
+	assert.Equal(t, event, <-events)
+}
+
+func TestNoteEventBroker_Run_notStarted(t *testing.T) {
+	mockRepository := &MockEventRepository{}
+	broker := NewNoteEventBroker(mockRepository)
+	event := NoteEvent{ID: 3, Type: NOTE_DELETED, NoteID: 1}
+
+	// Events committed before the last ID is known are not sent.
+	mockRepository.On("LastId").Return(uint64(0), false).Once()
+	mockRepository.On("LastId").Return(uint64(2), true).Once()
+	mockRepository.On("GetSince", uint64(2)).Return([]NoteEvent{event})
+	mockRepository.On("GetSince", uint64(3)).Return([]NoteEvent{})
+
+	events := broker.Subscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go broker.Run(ctx, time.Millisecond)
+
+	assert.Equal(t, event, <-events)
+}
+
+func TestNoteEventBroker_send(t *testing.T) {
+	mockRepository := &MockEventRepository{}
+	broker := NewNoteEventBroker(mockRepository)
+
+	mockRepository.On("GetSince", uint64(4)).Return([]NoteEvent{{ID: 5}, {ID: 7}})
+	mockRepository.On("GetSince", uint64(7)).Return([]NoteEvent{})
+
+	events := broker.Subscribe()
+
+	assert.Equal(t, uint64(7), broker.send(4))
+	assert.Equal(t, uint64(7), broker.send(7))
+	assert.Equal(t, NoteEvent{ID: 5}, <-events)
+	assert.Equal(t, NoteEvent{ID: 7}, <-events)
+	assert.Equal(t, 0, len(events))
+}
+
+func TestNoteEventBroker_send_dropsSlowSubscriber(t *testing.T) {
+	mockRepository := &MockEventRepository{}
+	broker := NewNoteEventBroker(mockRepository)
+	pending := make([]NoteEvent, SUBSCRIBER_BUFFER_SIZE+1)
+	for i := range pending {
+		pending[i] = NoteEvent{ID: uint64(i + 1)}
+	}
+
+	mockRepository.On("GetSince", uint64(0)).Return(pending)
+
+	events := broker.Subscribe()
+	broker.send(0)
+
+	received := 0
+	for range events {
+		received++
+	}
+	assert.Equal(t, SUBSCRIBER_BUFFER_SIZE, received)
+	broker.Unsubscribe(events) // Must not close the channel twice.
+}
+
+func TestNoteEventBroker_Publish(t *testing.T) {
+	broker := NewNoteEventBroker(&MockEventRepository{})
+
+	// Publishing does not wait for the events to be sent.
+	broker.Publish()
+	broker.Publish()
+
+	assert.Equal(t, 1, len(broker.committed))
+}
+
+func TestNoteEventBroker_Unsubscribe(t *testing.T) {
+	mockRepository := &MockEventRepository{}
+	broker := NewNoteEventBroker(mockRepository)
+
+	mockRepository.On("GetSince", uint64(0)).Return([]NoteEvent{{ID: 1}})
+
+	events := broker.Subscribe()
+	broker.Unsubscribe(events)
+	broker.send(0)
+
+	_, ok := <-events
+	assert.Equal(t, false, ok)
+}
+
+func TestNoteEventBroker_Close(t *testing.T) {
+	broker := NewNoteEventBroker(&MockEventRepository{})
+
+	events := broker.Subscribe()
+	broker.Close()
+	_, ok := <-events
+	assert.False(t, ok)
+
+	_, ok = <-broker.Subscribe()
+	assert.False(t, ok)
+	broker.Unsubscribe(events) // Must not close the channel twice.
+}
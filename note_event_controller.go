@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+var keepAliveInterval = 15 * time.Second
+
+type INoteEventController interface {
+	Stream(c *gin.Context)
+}
+
+type NoteEventController struct {
+	noteEventSubscriber INoteEventSubscriber
+}
+
+func renderNoteEvent(c *gin.Context, event NoteEvent) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatUint(event.ID, 10),
+		Event: event.Type,
+		Data:  event.Note(),
+	})
+	c.Writer.Flush()
+}
+
+func (ec *NoteEventController) Stream(c *gin.Context) {
+	var lastEventId uint64
+	lastEventIdString := c.GetHeader("Last-Event-ID")
+	if lastEventIdString != "" {
+		id, err := strconv.ParseUint(lastEventIdString, 10, 64)
+		if err != nil {
+			RespondProblem(c, InvalidParameterProblem("Last-Event-ID", "must be an event ID"))
+			return
+		}
+		lastEventId = id
+	}
+
+	// Subscribe before reading the event log so that no event falls between
+	// the replay and the live stream.
+	events := ec.noteEventSubscriber.Subscribe()
+	defer ec.noteEventSubscriber.Unsubscribe(events)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	if lastEventIdString != "" {
+		for _, event := range ec.noteEventSubscriber.GetSince(lastEventId) {
+			renderNoteEvent(c, event)
+			lastEventId = event.ID
+		}
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.ID <= lastEventId {
+				continue
+			}
+			renderNoteEvent(c, event)
+			lastEventId = event.ID
+		case <-keepAlive.C:
+			c.Writer.WriteString(": keep-alive\n\n")
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
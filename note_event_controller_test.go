@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockEventSubscriber struct {
+	mock.Mock
+	events chan NoteEvent
+}
+
+func (ms *MockEventSubscriber) Subscribe() chan NoteEvent {
+	ms.Called()
+	return ms.events
+}
+
+func (ms *MockEventSubscriber) Unsubscribe(events chan NoteEvent) {
+	ms.Called(events)
+}
+
+func (ms *MockEventSubscriber) GetSince(id uint64) []NoteEvent {
+	ret := ms.Called(id)
+	return ret.Get(0).([]NoteEvent)
+}
+
+func TestNoteEventController_Stream(t *testing.T) {
+	for _, td := range []struct {
+		title          string
+		lastEventId    string
+		replayedEvents []NoteEvent
+		liveEvents     []NoteEvent
+		expectedBody   string
+	}{
+		{
+			title: "Streams live events if Last-Event-ID is not specified",
+			liveEvents: []NoteEvent{
+				{ID: 1, Type: NOTE_CREATED, NoteID: 1, Title: "title", Content: "content"},
+				{ID: 2, Type: NOTE_DELETED, NoteID: 1},
+			},
+			expectedBody: "id:1\nevent:created\ndata:{\"id\":1,\"title\":\"title\",\"content\":\"content\",\"tags\":[],\"completed\":false}\n\n" +
+				"id:2\nevent:deleted\ndata:{\"id\":1,\"title\":\"\",\"content\":\"\",\"tags\":[],\"completed\":false}\n\n",
+		},
+		{
+			title:       "Replays events after Last-Event-ID and skips duplicated live events",
+			lastEventId: "1",
+			replayedEvents: []NoteEvent{
+				{ID: 2, Type: NOTE_UPDATED, NoteID: 1, Title: "title2", Content: "content2"},
+			},
+			liveEvents: []NoteEvent{
+				{ID: 2, Type: NOTE_UPDATED, NoteID: 1, Title: "title2", Content: "content2"},
+				{ID: 3, Type: NOTE_DELETED, NoteID: 1},
+			},
+			expectedBody: "id:2\nevent:updated\ndata:{\"id\":1,\"title\":\"title2\",\"content\":\"content2\",\"tags\":[],\"completed\":false}\n\n" +
+				"id:3\nevent:deleted\ndata:{\"id\":1,\"title\":\"\",\"content\":\"\",\"tags\":[],\"completed\":false}\n\n",
+		},
+	} {
+		t.Run("Stream: "+td.title, func(t *testing.T) {
+			var (
+				mockSubscriber      = &MockEventSubscriber{events: make(chan NoteEvent, len(td.liveEvents))}
+				noteEventController = NoteEventController{mockSubscriber}
+				response            = httptest.NewRecorder()
+				ginContext, _       = gin.CreateTestContext(response)
+				req, _              = http.NewRequest("GET", "/notes/events", nil)
+			)
+
+			mockSubscriber.On("Subscribe").Return()
+			mockSubscriber.On("Unsubscribe", mockSubscriber.events).Return()
+			mockSubscriber.On("GetSince", uint64(1)).Return(td.replayedEvents)
+
+			for _, event := range td.liveEvents {
+				mockSubscriber.events <- event
+			}
+			close(mockSubscriber.events)
+
+			if td.lastEventId != "" {
+				req.Header.Set("Last-Event-ID", td.lastEventId)
+			}
+			ginContext.Request = req
+
+			noteEventController.Stream(ginContext)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, "text/event-stream", response.Header().Get("Content-Type"))
+			assert.Equal(t, td.expectedBody, response.Body.String())
+			mockSubscriber.AssertCalled(t, "Unsubscribe", mockSubscriber.events)
+		})
+	}
+}
+
+func TestNoteEventController_Stream_clientGone(t *testing.T) {
+	var (
+		mockSubscriber      = &MockEventSubscriber{events: make(chan NoteEvent)}
+		noteEventController = NoteEventController{mockSubscriber}
+		response            = httptest.NewRecorder()
+		ginContext, _       = gin.CreateTestContext(response)
+		ctx, cancel         = context.WithCancel(context.Background())
+		req, _              = http.NewRequestWithContext(ctx, "GET", "/notes/events", nil)
+	)
+
+	mockSubscriber.On("Subscribe").Return()
+	mockSubscriber.On("Unsubscribe", mockSubscriber.events).Return()
+
+	ginContext.Request = req
+	cancel()
+
+	noteEventController.Stream(ginContext)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "", response.Body.String())
+	mockSubscriber.AssertCalled(t, "Unsubscribe", mockSubscriber.events)
+}
+
+func TestNoteEventController_Stream_invalidLastEventId(t *testing.T) {
+	var (
+		mockSubscriber      = &MockEventSubscriber{}
+		noteEventController = NoteEventController{mockSubscriber}
+		response            = httptest.NewRecorder()
+		ginContext, _       = gin.CreateTestContext(response)
+		req, _              = http.NewRequest("GET", "/notes/events", nil)
+	)
+
+	req.Header.Set("Last-Event-ID", "xxx")
+	ginContext.Request = req
+
+	noteEventController.Stream(ginContext)
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	mockSubscriber.AssertNotCalled(t, "Subscribe")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type INoteEventRepository interface {
+	GetSince(id uint64) []NoteEvent
+	LastId() (uint64, bool)
+	DeleteOlderThan(before time.Time) bool
+}
+
+type NoteEventRepository struct {
+	db *gorm.DB
+}
+
+func (er *NoteEventRepository) GetSince(id uint64) []NoteEvent {
+	var events []NoteEvent
+	er.db.Where("id > ?", id).Order("id").Find(&events)
+	return events
+}
+
+// LastId returns the ID of the last event in the log, or 0 if it is empty.
+func (er *NoteEventRepository) LastId() (uint64, bool) {
+	var id uint64
+	result := er.db.Model(&NoteEvent{}).Select("COALESCE(MAX(id), 0)").Scan(&id)
+	return id, result.Error == nil
+}
+
+// DeleteOlderThan deletes the events created before the time. Clients
+// catching up from a deleted event miss the changes up to the oldest event
+// kept.
+func (er *NoteEventRepository) DeleteOlderThan(before time.Time) bool {
+	result := er.db.Where("created_at < ?", before).Delete(&NoteEvent{})
+	return result.Error == nil
+}
+
+// DeleteOlderPeriodically keeps the event log from growing without bound by
+// deleting the events older than the retention, until the context is done.
+func DeleteOlderPeriodically(ctx context.Context, noteEventRepository INoteEventRepository, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			noteEventRepository.DeleteOlderThan(now.Add(-retention))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// createEvent appends the change of the note to the event log in the
+// transaction of the change, with the note as it is after the change. As
+// changes hold the sequence lock until they are committed, events are
+// committed in the order of their IDs.
+func createEvent(tx *gorm.DB, eventType string, id uint64) error {
+	if eventType == NOTE_DELETED {
+		return tx.Create(&NoteEvent{Type: eventType, NoteID: id}).Error
+	}
+	return tx.Exec("INSERT INTO note_events (type, note_id, title, content, tags, completed, due, created_at) "+
+		"SELECT ?, id, title, content, tags, completed, due, ? FROM notes WHERE id = ?", eventType, time.Now(), id).Error
+}
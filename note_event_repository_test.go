@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type NoteEventRepositoryTestSuite struct {
+	suite.Suite
+	noteEventRepository NoteEventRepository
+	mock                sqlmock.Sqlmock
+}
+
+func (ts *NoteEventRepositoryTestSuite) SetupTest() {
+	db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	ts.mock = mock
+	noteEventRepository := NoteEventRepository{}
+	noteEventRepository.db, _ = gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	ts.noteEventRepository = noteEventRepository
+}
+
+func (ts *NoteEventRepositoryTestSuite) TearDownTest() {
+	db, _ := ts.noteEventRepository.db.DB()
+	db.Close()
+}
+
+func (ts *NoteEventRepositoryTestSuite) TestNoteEventRepository_GetSince() {
+	var (
+		since uint64 = 3
+	)
+
+	rows := sqlmock.NewRows([]string{"id", "type", "note_id", "title", "content"})
+	rows = rows.AddRow(4, NOTE_CREATED, 1, "title", "content")
+	rows = rows.AddRow(5, NOTE_DELETED, 1, "", "")
+	query := ts.noteEventRepository.db.Session(&gorm.Session{DryRun: true}).Where("id > ?", since).Order("id").Find(&[]NoteEvent{}).Statement.SQL.String()
+	ts.mock.ExpectQuery(query).WithArgs(since).WillReturnRows(rows)
+
+	events := ts.noteEventRepository.GetSince(since)
+
+	assert.Equal(ts.T(), 2, len(events))
+	assert.Equal(ts.T(), uint64(4), events[0].ID)
+	assert.Equal(ts.T(), NOTE_CREATED, events[0].Type)
+	assert.Equal(ts.T(), Note{ID: 1, Title: "title", Content: "content"}, events[0].Note())
+	assert.Equal(ts.T(), uint64(5), events[1].ID)
+	assert.Equal(ts.T(), NOTE_DELETED, events[1].Type)
+}
+
+func (ts *NoteEventRepositoryTestSuite) TestNoteEventRepository_LastId() {
+	ts.mock.ExpectQuery(`SELECT COALESCE(MAX(id), 0) FROM "note_events"`).
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(7))
+
+	id, ok := ts.noteEventRepository.LastId()
+
+	assert.Equal(ts.T(), true, ok)
+	assert.Equal(ts.T(), uint64(7), id)
+}
+
+func (ts *NoteEventRepositoryTestSuite) TestNoteEventRepository_LastId_failed() {
+	ts.mock.ExpectQuery(`SELECT COALESCE(MAX(id), 0) FROM "note_events"`).WillReturnError(gorm.ErrInvalidDB)
+
+	_, ok := ts.noteEventRepository.LastId()
+
+	assert.Equal(ts.T(), false, ok)
+}
+
+func (ts *NoteEventRepositoryTestSuite) TestNoteEventRepository_DeleteOlderThan() {
+	before := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectExec(`DELETE FROM "note_events" WHERE created_at < $1`).
+		WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 3))
+	ts.mock.ExpectCommit()
+
+	ok := ts.noteEventRepository.DeleteOlderThan(before)
+
+	assert.Equal(ts.T(), true, ok)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func TestNoteEventRepositoryTestSuite(t *testing.T) {
+	suite.Run(t, new(NoteEventRepositoryTestSuite))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EXPORT_ZIP  = "zip"
+	EXPORT_JSON = "json"
+	EXPORT_CSV  = "csv"
+
+	EXPORT_BATCH_SIZE     = 100
+	MAX_FILE_TITLE_LENGTH = 50
+)
+
+// noteExporter writes notes in a format. Nothing is written until the first
+// note or Close, so that an error on loading the first batch can still be
+// responded as a problem.
+type noteExporter interface {
+	Write(note Note) error
+	Flush() error
+	Close() error
+}
+
+type exportFormat struct {
+	contentType string
+	newExporter func(w io.Writer, exportedAt time.Time) noteExporter
+}
+
+var exportFormats = map[string]exportFormat{
+	EXPORT_ZIP: {"application/zip", func(w io.Writer, exportedAt time.Time) noteExporter {
+		return &zipExporter{writer: zip.NewWriter(w), exportedAt: exportedAt}
+	}},
+	EXPORT_JSON: {"application/json", func(w io.Writer, exportedAt time.Time) noteExporter {
+		return &jsonExporter{writer: w}
+	}},
+	EXPORT_CSV: {"text/csv", func(w io.Writer, exportedAt time.Time) noteExporter {
+		return &csvExporter{writer: csv.NewWriter(w)}
+	}},
+}
+
+// zipExporter writes each note as a Markdown file with YAML front matter.
+type zipExporter struct {
+	writer     *zip.Writer
+	exportedAt time.Time
+}
+
+type frontMatter struct {
+	ID        uint64     `yaml:"id"`
+	Title     string     `yaml:"title"`
+	Tags      []string   `yaml:"tags,omitempty"`
+	Completed bool       `yaml:"completed,omitempty"`
+	Due       *time.Time `yaml:"due,omitempty"`
+}
+
+// noteFileName is the ID and the title made safe for file systems, so that
+// files are unique and recognizable.
+func noteFileName(note Note) string {
+	var name strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(note.Title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && name.Len() > 0 {
+				name.WriteRune('-')
+			}
+			name.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	title := []rune(name.String())
+	if len(title) > MAX_FILE_TITLE_LENGTH {
+		title = []rune(strings.TrimRight(string(title[:MAX_FILE_TITLE_LENGTH]), "-"))
+	}
+	if len(title) == 0 {
+		return strconv.FormatUint(note.ID, 10) + ".md"
+	}
+	return fmt.Sprintf("%d-%s.md", note.ID, string(title))
+}
+
+func (ze *zipExporter) Write(note Note) error {
+	file, err := ze.writer.CreateHeader(&zip.FileHeader{
+		Name:     noteFileName(note),
+		Method:   zip.Deflate,
+		Modified: ze.exportedAt,
+	})
+	if err != nil {
+		return err
+	}
+	matter, err := yaml.Marshal(frontMatter{note.ID, note.Title, note.Tags, note.Completed, note.Due})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "---\n%s---\n\n%s", matter, note.Content)
+	return err
+}
+
+func (ze *zipExporter) Flush() error {
+	return ze.writer.Flush()
+}
+
+func (ze *zipExporter) Close() error {
+	return ze.writer.Close()
+}
+
+// jsonExporter writes an array of the notes one by one.
+type jsonExporter struct {
+	writer  io.Writer
+	written bool
+}
+
+func (je *jsonExporter) Write(note Note) error {
+	separator := ",\n"
+	if !je.written {
+		separator = "[\n"
+		je.written = true
+	}
+	data, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(je.writer, separator+string(data))
+	return err
+}
+
+func (je *jsonExporter) Flush() error {
+	return nil
+}
+
+func (je *jsonExporter) Close() error {
+	end := "\n]\n"
+	if !je.written {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(je.writer, end)
+	return err
+}
+
+// csvExporter writes a row per note. Tags are separated by commas, which
+// they cannot contain, and the due date is empty if there is none.
+type csvExporter struct {
+	writer  *csv.Writer
+	written bool
+}
+
+func (ce *csvExporter) writeHeader() {
+	if !ce.written {
+		ce.writer.Write([]string{"id", "title", "content", "tags", "completed", "due"})
+		ce.written = true
+	}
+}
+
+func (ce *csvExporter) Write(note Note) error {
+	ce.writeHeader()
+	due := ""
+	if note.Due != nil {
+		due = note.Due.Format(time.RFC3339)
+	}
+	ce.writer.Write([]string{
+		strconv.FormatUint(note.ID, 10), note.Title, note.Content,
+		strings.Join(note.Tags, ","), strconv.FormatBool(note.Completed), due,
+	})
+	return ce.writer.Error()
+}
+
+func (ce *csvExporter) Flush() error {
+	ce.writer.Flush()
+	return ce.writer.Error()
+}
+
+func (ce *csvExporter) Close() error {
+	ce.writeHeader()
+	ce.writer.Flush()
+	return ce.writer.Error()
+}
+
+type INoteExportController interface {
+	Export(c *gin.Context)
+}
+
+type NoteExportController struct {
+	noteService INoteService
+}
+
+// Export streams all notes in batches, so that they are never all in
+// memory.
+func (nec *NoteExportController) Export(c *gin.Context) {
+	format := c.DefaultQuery("format", EXPORT_ZIP)
+	exportFormat, found := exportFormats[format]
+	if !found {
+		RespondProblem(c, InvalidParameterProblem("format", "must be zip, json or csv"))
+		return
+	}
+
+	exportedAt := time.Now()
+	c.Header("Content-Type", exportFormat.contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="notes-%s.%s"`, exportedAt.Format("20060102"), format))
+	exporter := exportFormat.newExporter(c.Writer, exportedAt)
+	err := nec.noteService.WithContext(c.Request.Context()).GetAllInBatches(EXPORT_BATCH_SIZE, func(notes []Note) error {
+		for _, note := range notes {
+			if err := exporter.Write(note); err != nil {
+				return err
+			}
+		}
+		if err := exporter.Flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err == nil {
+		err = exporter.Close()
+	}
+	if err == nil {
+		return
+	}
+
+	LoggerFrom(c.Request.Context()).Error("Failed to export notes", "error", err)
+	if !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Disposition")
+		RespondProblem(c, InternalErrorProblem())
+		return
+	}
+	// Closing the connection without finishing the response tells the client
+	// that the export is incomplete.
+	if conn, buffer, err := c.Writer.Hijack(); err == nil {
+		buffer.Flush()
+		conn.Close()
+	}
+	c.Abort()
+}
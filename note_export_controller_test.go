@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoteFileName(t *testing.T) {
+	assert.Equal(t, "1-shopping-list.md", noteFileName(Note{ID: 1, Title: "Shopping list"}))
+	assert.Equal(t, "2-a-b.md", noteFileName(Note{ID: 2, Title: "  ../a//b?  "}))
+	assert.Equal(t, "3-日本語のメモ.md", noteFileName(Note{ID: 3, Title: "日本語のメモ"}))
+	assert.Equal(t, "4.md", noteFileName(Note{ID: 4, Title: "***"}))
+	assert.Equal(t, "5-"+strings.Repeat("a", 49)+".md", noteFileName(Note{ID: 5, Title: strings.Repeat("a", 49) + " b"}))
+}
+
+func TestNoteExportController_Export(t *testing.T) {
+	due := time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+	batches := [][]Note{
+		{{ID: 1, Title: "Shopping", Content: "- milk\n- eggs", Tags: Tags{"home", "errand"}, Completed: true, Due: &due}, {ID: 2, Title: "Quote: \"hi\"", Content: "a, b"}},
+		{{ID: 3, Title: "Empty"}},
+	}
+	for _, td := range []struct {
+		title               string
+		query               string
+		batches             [][]Note
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			title:               "Exports JSON",
+			query:               "?format=json",
+			batches:             batches,
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody: "[\n" +
+				`{"id":1,"title":"Shopping","content":"- milk\n- eggs","tags":["home","errand"],"completed":true,"due":"2024-01-05T09:00:00Z"},` + "\n" +
+				`{"id":2,"title":"Quote: \"hi\"","content":"a, b","tags":[],"completed":false},` + "\n" +
+				`{"id":3,"title":"Empty","content":"","tags":[],"completed":false}` + "\n]\n",
+		},
+		{
+			title:               "Exports an empty JSON array",
+			query:               "?format=json",
+			batches:             [][]Note{},
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody:        "[]\n",
+		},
+		{
+			title:               "Exports CSV",
+			query:               "?format=csv",
+			batches:             batches,
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/csv",
+			expectedBody: "id,title,content,tags,completed,due\n" +
+				"1,Shopping,\"- milk\n- eggs\",\"home,errand\",true,2024-01-05T09:00:00Z\n" +
+				"2,\"Quote: \"\"hi\"\"\",\"a, b\",,false,\n" +
+				"3,Empty,,,false,\n",
+		},
+		{
+			title:               "Exports CSV with only the header",
+			query:               "?format=csv",
+			batches:             [][]Note{},
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/csv",
+			expectedBody:        "id,title,content,tags,completed,due\n",
+		},
+		{
+			title:               "Returns \"Invalid parameter\" problem if format is unknown",
+			query:               "?format=xml",
+			expectedStatus:      http.StatusBadRequest,
+			expectedContentType: PROBLEM_CONTENT_TYPE,
+		},
+	} {
+		t.Run("Export: "+td.title, func(t *testing.T) {
+			var (
+				mockService          = &MockService{}
+				noteExportController = NoteExportController{mockService}
+				response             = httptest.NewRecorder()
+				ginContext, _        = gin.CreateTestContext(response)
+			)
+			mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return(td.batches, nil)
+			ginContext.Request, _ = http.NewRequest("GET", "/export"+td.query, nil)
+
+			noteExportController.Export(ginContext)
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			assert.Equal(t, td.expectedContentType, response.Header().Get("Content-Type"))
+			if td.expectedBody != "" {
+				assert.Equal(t, td.expectedBody, response.Body.String())
+				assert.Regexp(t, `^attachment; filename="notes-\d{8}\.`+strings.TrimPrefix(td.query, "?format=")+`"$`,
+					response.Header().Get("Content-Disposition"))
+			}
+		})
+	}
+}
+
+func TestNoteExportController_Export_zip(t *testing.T) {
+	mockService := &MockService{}
+	noteExportController := NoteExportController{mockService}
+	response := httptest.NewRecorder()
+	ginContext, _ := gin.CreateTestContext(response)
+	due := time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{
+		{{ID: 1, Title: "Shopping", Content: "- milk\n- eggs", Tags: Tags{"home"}, Completed: true, Due: &due}},
+		{{ID: 2, Title: "Key: value", Content: ""}},
+	}, nil)
+	ginContext.Request, _ = http.NewRequest("GET", "/export", nil)
+
+	noteExportController.Export(ginContext)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/zip", response.Header().Get("Content-Type"))
+	archive, err := zip.NewReader(bytes.NewReader(response.Body.Bytes()), int64(response.Body.Len()))
+	assert.NoError(t, err)
+	files := map[string]string{}
+	for _, file := range archive.File {
+		reader, _ := file.Open()
+		content, _ := ioutil.ReadAll(reader)
+		files[file.Name] = string(content)
+	}
+	assert.Equal(t, map[string]string{
+		"1-shopping.md":  "---\nid: 1\ntitle: Shopping\ntags:\n  - home\ncompleted: true\ndue: 2024-01-05T09:00:00Z\n---\n\n- milk\n- eggs",
+		"2-key-value.md": "---\nid: 2\ntitle: 'Key: value'\n---\n\n",
+	}, files)
+}
+
+func TestNoteExportController_Export_failed(t *testing.T) {
+	mockService := &MockService{}
+	noteExportController := NoteExportController{mockService}
+	response := httptest.NewRecorder()
+	ginContext, _ := gin.CreateTestContext(response)
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{}, errors.New("connection refused"))
+	ginContext.Request, _ = http.NewRequest("GET", "/export?format=csv", nil)
+
+	noteExportController.Export(ginContext)
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Equal(t, PROBLEM_CONTENT_TYPE, response.Header().Get("Content-Type"))
+	assert.Empty(t, response.Header().Get("Content-Disposition"))
+}
+
+func TestNoteExportController_Export_interrupted(t *testing.T) {
+	mockService := &MockService{}
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{{{ID: 1, Title: "title"}}}, errors.New("connection reset"))
+	server := httptest.NewServer(NewRouter(&Controllers{noteExportController: NoteExportController{mockService}}))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/v1/export?format=csv")
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	body, err := ioutil.ReadAll(response.Body)
+	assert.Error(t, err, "the response must not end as if complete")
+	assert.Equal(t, "id,title,content,tags,completed,due\n1,title,,,false,\n", string(body))
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	IMPORT_ZIP      = "zip"
+	IMPORT_ENEX     = "enex"
+	IMPORT_TODO_TXT = "todotxt"
+
+	MAX_IMPORT_SIZE       = 32 << 20
+	MAX_IMPORT_NOTES      = 10000
+	MAX_IMPORT_FILE_SIZE  = 1 << 20
+	MAX_IMPORT_TOTAL_SIZE = 256 << 20
+)
+
+var errTooManyNotes = fmt.Errorf("Request body has more than %d notes", MAX_IMPORT_NOTES)
+
+// ImportedNote is a note read from an uploaded file, not yet validated.
+// Source tells the user where in the upload it came from.
+type ImportedNote struct {
+	Source    string
+	Title     string
+	Content   string
+	Tags      []string
+	Completed bool
+	Due       *time.Time
+}
+
+// importParsers read the notes in the formats. Their errors are details of
+// problems.
+var importParsers = map[string]func(data []byte) ([]ImportedNote, error){
+	IMPORT_ZIP:      parseMarkdownZip,
+	IMPORT_ENEX:     parseENEX,
+	IMPORT_TODO_TXT: parseTodoTxt,
+}
+
+func normalizeNewlines(text string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(text, "\uFEFF"), "\r\n", "\n")
+}
+
+var headingPattern = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*\s*$`)
+
+// parseMarkdown reads the title, tags, completion and due date from the
+// YAML front matter, as written by the export, or else the title from the
+// heading on the first line or the file name.
+func parseMarkdown(name string, text string) ImportedNote {
+	text = normalizeNewlines(text)
+	note := ImportedNote{Source: name, Content: text}
+	if strings.HasPrefix(text, "---\n") {
+		rest := text[len("---\n"):]
+		if end := strings.Index("\n"+rest, "\n---\n"); end >= 0 {
+			var matter frontMatter
+			if err := yaml.Unmarshal([]byte(rest[:end]), &matter); err == nil {
+				note.Title = matter.Title
+				note.Tags, note.Completed, note.Due = matter.Tags, matter.Completed, matter.Due
+				note.Content = strings.TrimPrefix(rest[end+len("---\n"):], "\n")
+			}
+		}
+	}
+	if note.Title == "" {
+		firstLine := strings.SplitN(note.Content, "\n", 2)[0]
+		if match := headingPattern.FindStringSubmatch(firstLine); match != nil {
+			note.Title = match[1]
+		} else {
+			note.Title = strings.TrimSuffix(path.Base(name), path.Ext(name))
+		}
+	}
+	note.Title = strings.TrimSpace(note.Title)
+	return note
+}
+
+// parseMarkdownZip reads the Markdown files in the zip, ignoring other
+// files. Files are read up to a limit, so that a small archive cannot expand
+// without bound.
+func parseMarkdownZip(data []byte) ([]ImportedNote, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("Request body is not a zip archive")
+	}
+	notes := []ImportedNote{}
+	total := 0
+	for _, file := range archive.File {
+		extension := strings.ToLower(path.Ext(file.Name))
+		if file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") ||
+			(extension != ".md" && extension != ".markdown") {
+			continue
+		}
+		if len(notes) == MAX_IMPORT_NOTES {
+			return nil, errTooManyNotes
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s cannot be read from the zip archive", file.Name)
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(reader, MAX_IMPORT_FILE_SIZE))
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s cannot be read from the zip archive", file.Name)
+		}
+		if total += len(content); total > MAX_IMPORT_TOTAL_SIZE {
+			return nil, errors.New("Files in the zip archive are too large in total")
+		}
+		notes = append(notes, parseMarkdown(file.Name, string(content)))
+	}
+	return notes, nil
+}
+
+type enexNote struct {
+	Title   string `xml:"title"`
+	Content string `xml:"content"`
+}
+
+// parseENEX reads the notes exported from Evernote, with their content
+// converted from ENML to Markdown.
+func parseENEX(data []byte) ([]ImportedNote, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	notes := []ImportedNote{}
+	root := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("Request body is not valid XML")
+		}
+		element, isStart := token.(xml.StartElement)
+		if !isStart {
+			continue
+		}
+		if root == "" {
+			root = element.Name.Local
+			if root != "en-export" {
+				return nil, errors.New("Request body is not an Evernote export")
+			}
+			continue
+		}
+		if element.Name.Local != "note" {
+			decoder.Skip()
+			continue
+		}
+		if len(notes) == MAX_IMPORT_NOTES {
+			return nil, errTooManyNotes
+		}
+		var note enexNote
+		if err := decoder.DecodeElement(&note, &element); err != nil {
+			return nil, errors.New("Request body is not valid XML")
+		}
+		notes = append(notes, ImportedNote{
+			Source:  "note " + strconv.Itoa(len(notes)+1),
+			Title:   strings.TrimSpace(note.Title),
+			Content: enmlToMarkdown(note.Content),
+		})
+	}
+	if root == "" {
+		return nil, errors.New("Request body is not an Evernote export")
+	}
+	return notes, nil
+}
+
+var (
+	blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+	headingTagPattern = regexp.MustCompile(`^h[1-6]$`)
+	blockTagPattern   = regexp.MustCompile(`^(div|p|ul|ol|table|tr|blockquote)$`)
+)
+
+// startLine breaks the line unless nothing but spaces follows the last
+// break, so that nested blocks do not leave blank lines.
+func startLine(markdown *strings.Builder) {
+	text := markdown.String()
+	if strings.TrimSpace(text[strings.LastIndex(text, "\n")+1:]) != "" {
+		markdown.WriteString("\n")
+	}
+}
+
+// enmlToMarkdown keeps the text, line breaks, lists, headings and to-dos of
+// the ENML, the HTML of Evernote; other formatting is dropped.
+func enmlToMarkdown(enml string) string {
+	var markdown strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(enml))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		switch tokenType {
+		case html.TextToken:
+			// As in HTML, line breaks in text are spaces
+			markdown.WriteString(whitespacePattern.ReplaceAllString(string(tokenizer.Text()), " "))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttributes := tokenizer.TagName()
+			tag := string(name)
+			switch {
+			case tag == "br":
+				markdown.WriteString("\n")
+			case blockTagPattern.MatchString(tag):
+				startLine(&markdown)
+			case tag == "td" || tag == "th":
+				markdown.WriteString(" ")
+			case tag == "li":
+				startLine(&markdown)
+				markdown.WriteString("- ")
+			case headingTagPattern.MatchString(tag):
+				level, _ := strconv.Atoi(tag[1:])
+				markdown.WriteString("\n\n" + strings.Repeat("#", level) + " ")
+			case tag == "en-todo":
+				checked := false
+				for hasAttributes {
+					var key, value []byte
+					key, value, hasAttributes = tokenizer.TagAttr()
+					if string(key) == "checked" && string(value) == "true" {
+						checked = true
+					}
+				}
+				if checked {
+					markdown.WriteString("- [x] ")
+				} else {
+					markdown.WriteString("- [ ] ")
+				}
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			switch {
+			case blockTagPattern.MatchString(tag):
+				startLine(&markdown)
+			case headingTagPattern.MatchString(tag):
+				markdown.WriteString("\n\n")
+			}
+		}
+	}
+
+	// Indentation in the markup is not meaningful
+	lines := strings.Split(markdown.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// A task in todo.txt: an optional completion mark, priority, completion and
+// creation dates, then the description.
+var todoTxtPattern = regexp.MustCompile(`^(x(?:\s+|$))?(?:\([A-Z]\)(?:\s+|$))?(?:\d{4}-\d{2}-\d{2}(?:\s+|$)){0,2}(.*)$`)
+
+// todoTxtDuePattern is the due date of a task, a tag of the description.
+var todoTxtDuePattern = regexp.MustCompile(`(?:^|\s)due:(\d{4}-\d{2}-\d{2})(?:\s|$)`)
+
+// parseTodoTxt reads each task as a note titled with its description,
+// completed if marked so and due at the start of its due date in UTC. The
+// whole line is kept as the content, as notes have no priority or creation
+// and completion dates.
+func parseTodoTxt(data []byte) ([]ImportedNote, error) {
+	notes := []ImportedNote{}
+	for i, line := range strings.Split(normalizeNewlines(string(data)), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(notes) == MAX_IMPORT_NOTES {
+			return nil, errTooManyNotes
+		}
+		match := todoTxtPattern.FindStringSubmatch(line)
+		note := ImportedNote{
+			Source:    "line " + strconv.Itoa(i+1),
+			Title:     strings.TrimSpace(match[2]),
+			Content:   line,
+			Completed: match[1] != "",
+		}
+		if due := todoTxtDuePattern.FindStringSubmatchIndex(note.Title); due != nil {
+			if date, err := time.Parse("2006-01-02", note.Title[due[2]:due[3]]); err == nil {
+				note.Due = &date
+				note.Title = strings.TrimSpace(note.Title[:due[0]] + " " + note.Title[due[1]:])
+			}
+		}
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type INoteImportController interface {
+	Import(c *gin.Context)
+	GetJob(c *gin.Context)
+}
+
+type NoteImportController struct {
+	noteImportService INoteImportService
+}
+
+// Import reads the uploaded notes and starts a job importing them, or
+// responds with the preview in a dry run.
+func (ic *NoteImportController) Import(c *gin.Context) {
+	parse, found := importParsers[c.Query("format")]
+	if !found {
+		RespondProblem(c, InvalidParameterProblem("format", "must be zip, enex or todotxt"))
+		return
+	}
+	dryRun := false
+	if raw, found := c.GetQuery("dry_run"); found {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			RespondProblem(c, InvalidParameterProblem("dry_run", "must be a boolean"))
+			return
+		}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, MAX_IMPORT_SIZE+1))
+	if err != nil {
+		RespondProblem(c, InvalidRequestBodyProblem(err))
+		return
+	}
+	if len(body) > MAX_IMPORT_SIZE {
+		RespondProblem(c, RequestTooLargeProblem(MAX_IMPORT_SIZE))
+		return
+	}
+	notes, err := parse(body)
+	if err != nil {
+		problem := InvalidRequestBodyProblem(nil)
+		problem.Detail = err.Error()
+		RespondProblem(c, problem)
+		return
+	}
+
+	job := ic.noteImportService.Import(notes, dryRun)
+	if dryRun {
+		c.IndentedJSON(http.StatusOK, job)
+		return
+	}
+	c.Header("Location", API_BASE_PATH+"/import/jobs/"+job.ID)
+	c.IndentedJSON(http.StatusAccepted, job)
+}
+
+func (ic *NoteImportController) GetJob(c *gin.Context) {
+	job, found := ic.noteImportService.GetJob(c.Param("id"))
+	if !found {
+		problem := NotFoundProblem()
+		problem.Detail = "Import job does not exist"
+		RespondProblem(c, problem)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, job)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoteImportController_Import(t *testing.T) {
+	for _, td := range []struct {
+		title          string
+		query          string
+		body           []byte
+		expectedStatus int
+		expectedDetail string
+	}{
+		{
+			title:          "Previews a dry run",
+			query:          "?format=zip&dry_run=true",
+			body:           zipOf(map[string]string{"a.md": "# A"}),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			title:          "Starts a job",
+			query:          "?format=todotxt",
+			body:           []byte("(A) Call Mom\n"),
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			title:          "Returns \"Invalid parameter\" problem if format is unknown",
+			query:          "?format=docx",
+			expectedStatus: http.StatusBadRequest,
+			expectedDetail: "format must be zip, enex or todotxt",
+		},
+		{
+			title:          "Returns \"Invalid parameter\" problem if dry_run is not a boolean",
+			query:          "?format=zip&dry_run=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedDetail: "dry_run must be a boolean",
+		},
+		{
+			title:          "Returns \"Invalid request body\" problem if the file cannot be parsed",
+			query:          "?format=enex",
+			body:           []byte("<html></html>"),
+			expectedStatus: http.StatusBadRequest,
+			expectedDetail: "Request body is not an Evernote export",
+		},
+		{
+			title:          "Returns \"Invalid request body\" problem if the file is too large",
+			query:          "?format=todotxt",
+			body:           make([]byte, MAX_IMPORT_SIZE+1),
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedDetail: "Request body must not exceed 32 MiB",
+		},
+	} {
+		t.Run("Import: "+td.title, func(t *testing.T) {
+			mockService := &MockService{}
+			mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{}, nil)
+			mockService.On("Create", Note{Title: "Call Mom", Content: "(A) Call Mom"}).Return(uint64(1), nil)
+			noteImportService := NewNoteImportService(mockService)
+			router := NewRouter(&Controllers{noteImportController: NoteImportController{noteImportService}})
+
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/v1/import"+td.query, bytes.NewReader(td.body))
+			router.ServeHTTP(response, request)
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			if td.expectedDetail != "" {
+				var problem Problem
+				json.Unmarshal(response.Body.Bytes(), &problem)
+				assert.Equal(t, td.expectedDetail, problem.Detail)
+				return
+			}
+
+			var job ImportJob
+			json.Unmarshal(response.Body.Bytes(), &job)
+			assert.Equal(t, 1, job.Total)
+			if td.expectedStatus == http.StatusOK {
+				assert.Equal(t, []ImportResult{{Source: "a.md", Title: "A", Status: IMPORT_NEW}}, job.Results)
+				return
+			}
+			assert.Equal(t, "/v1/import/jobs/"+job.ID, response.Header().Get("Location"))
+			job = waitForImportJob(t, noteImportService, job.ID)
+			assert.Equal(t, []ImportResult{{Source: "line 1", Title: "Call Mom", Status: IMPORT_CREATED, ID: 1}}, job.Results)
+		})
+	}
+}
+
+func TestNoteImportController_GetJob(t *testing.T) {
+	mockService := &MockService{}
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{}, nil)
+	noteImportService := NewNoteImportService(mockService)
+	started := noteImportService.Import([]ImportedNote{}, false)
+	waitForImportJob(t, noteImportService, started.ID)
+	router := NewRouter(&Controllers{noteImportController: NoteImportController{noteImportService}})
+
+	for _, td := range []struct {
+		path           string
+		expectedStatus int
+		expectedDetail string
+	}{
+		{"/v1/import/jobs/" + started.ID, http.StatusOK, ""},
+		{"/v1/import/jobs/00000000-0000-4000-8000-000000000000", http.StatusNotFound, "Import job does not exist"},
+		{"/v1/import/jobs/1", http.StatusNotFound, "Import job does not exist"},
+	} {
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", td.path, nil)
+		router.ServeHTTP(response, request)
+
+		assert.Equal(t, td.expectedStatus, response.Code, td.path)
+		if td.expectedDetail != "" {
+			var problem Problem
+			json.Unmarshal(response.Body.Bytes(), &problem)
+			assert.Equal(t, td.expectedDetail, problem.Detail)
+			continue
+		}
+		var job ImportJob
+		json.Unmarshal(response.Body.Bytes(), &job)
+		assert.Equal(t, JOB_SUCCEEDED, job.Status)
+		assert.Equal(t, started.ID, job.ID)
+	}
+}
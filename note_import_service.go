@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	IMPORT_NEW       = "new"
+	IMPORT_CREATED   = "created"
+	IMPORT_DUPLICATE = "duplicate"
+	IMPORT_INVALID   = "invalid"
+	IMPORT_FAILED    = "failed"
+
+	JOB_PENDING   = "pending"
+	JOB_RUNNING   = "running"
+	JOB_SUCCEEDED = "succeeded"
+	JOB_FAILED    = "failed"
+
+	IMPORT_JOB_TTL = 24 * time.Hour
+)
+
+type ImportResult struct {
+	Source string       `json:"source" doc:"File, Evernote note or todo.txt line the note came from"`
+	Title  string       `json:"title"`
+	Status string       `json:"status" doc:"new (in dry runs), created, duplicate, invalid or failed"`
+	ID     uint64       `json:"id,omitempty" doc:"ID of the created note"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// ImportJob is an import running in the background, or the preview of one
+// in a dry run.
+type ImportJob struct {
+	ID         string         `json:"id,omitempty" doc:"Random UUID, kept in memory only on the server running the job"`
+	Status     string         `json:"status" doc:"pending, running, succeeded or failed"`
+	DryRun     bool           `json:"dry_run"`
+	Total      int            `json:"total" doc:"Number of notes in the upload"`
+	Counts     map[string]int `json:"counts" doc:"Number of results by status"`
+	Results    []ImportResult `json:"results"`
+	Error      string         `json:"error,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+type INoteImportService interface {
+	Import(notes []ImportedNote, dryRun bool) ImportJob
+	GetJob(id string) (ImportJob, bool)
+}
+
+// NoteImportService creates imported notes unless they are invalid or
+// duplicates, that is, have the same title and content as an existing note
+// or an earlier one in the upload. Jobs are kept in memory for
+// IMPORT_JOB_TTL after they finish, so they are found only on the server
+// running them and are lost when it restarts. Their IDs are random, so
+// that the URL of a lost job does not find a new one.
+type NoteImportService struct {
+	noteService INoteService
+
+	mu      sync.Mutex
+	jobs    map[string]*ImportJob
+	running sync.WaitGroup
+}
+
+func NewNoteImportService(noteService INoteService) *NoteImportService {
+	return &NoteImportService{noteService: noteService, jobs: map[string]*ImportJob{}}
+}
+
+// newJobId returns a random (version 4) UUID.
+func newJobId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// Import starts a job importing the notes, or runs it to the end without
+// creating notes in a dry run.
+func (is *NoteImportService) Import(notes []ImportedNote, dryRun bool) ImportJob {
+	job := &ImportJob{
+		Status:    JOB_PENDING,
+		DryRun:    dryRun,
+		Total:     len(notes),
+		Counts:    map[string]int{},
+		Results:   []ImportResult{},
+		CreatedAt: time.Now(),
+	}
+	if dryRun {
+		is.run(job, notes)
+		return *job
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.deleteExpired(job.CreatedAt)
+	job.ID = newJobId()
+	is.jobs[job.ID] = job
+	is.running.Add(1)
+	go func() {
+		defer is.running.Done()
+		is.run(job, notes)
+	}()
+	return copyImportJob(job)
+}
+
+// Wait waits for the running jobs to finish until the context is done.
+func (is *NoteImportService) Wait(ctx context.Context) error {
+	return waitFor(ctx, &is.running)
+}
+
+func (is *NoteImportService) GetJob(id string) (ImportJob, bool) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	job, found := is.jobs[id]
+	if !found {
+		return ImportJob{}, false
+	}
+	return copyImportJob(job), true
+}
+
+func copyImportJob(job *ImportJob) ImportJob {
+	copied := *job
+	copied.Counts = map[string]int{}
+	for status, count := range job.Counts {
+		copied.Counts[status] = count
+	}
+	copied.Results = append([]ImportResult{}, job.Results...)
+	return copied
+}
+
+func (is *NoteImportService) deleteExpired(now time.Time) {
+	for id, job := range is.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > IMPORT_JOB_TTL {
+			delete(is.jobs, id)
+		}
+	}
+}
+
+// update changes the job while no one reads it.
+func (is *NoteImportService) update(job *ImportJob, fn func()) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	fn()
+}
+
+func (is *NoteImportService) run(job *ImportJob, notes []ImportedNote) {
+	is.update(job, func() { job.Status = JOB_RUNNING })
+
+	existing := map[[sha256.Size]byte]bool{}
+	err := is.noteService.GetAllInBatches(EXPORT_BATCH_SIZE, func(batch []Note) error {
+		for _, note := range batch {
+			existing[noteFingerprint(note.Title, note.Content)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		is.finish(job, JOB_FAILED, "Existing notes could not be loaded to detect duplicates")
+		return
+	}
+
+	for _, note := range notes {
+		result := is.importNote(note, existing, job.DryRun)
+		is.update(job, func() {
+			job.Results = append(job.Results, result)
+			job.Counts[result.Status]++
+		})
+	}
+	is.finish(job, JOB_SUCCEEDED, "")
+}
+
+func (is *NoteImportService) finish(job *ImportJob, status string, message string) {
+	is.update(job, func() {
+		finishedAt := time.Now()
+		job.Status = status
+		job.Error = message
+		job.FinishedAt = &finishedAt
+	})
+}
+
+// noteFingerprint identifies a note by its title and content without keeping
+// them in memory. Titles have no control characters, so the NUL between them
+// cannot be part of the title.
+func noteFingerprint(title string, content string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(title + "\x00" + content))
+}
+
+func (is *NoteImportService) importNote(note ImportedNote, existing map[[sha256.Size]byte]bool, dryRun bool) ImportResult {
+	result := ImportResult{Source: note.Source, Title: note.Title}
+	request := NoteRequest{Title: note.Title, Content: note.Content, Tags: note.Tags, Completed: note.Completed, Due: note.Due}
+	var validationError *ValidationError
+	if err := Validate(&request); errors.As(err, &validationError) {
+		result.Status = IMPORT_INVALID
+		result.Errors = validationError.Errors
+		return result
+	}
+
+	fingerprint := noteFingerprint(note.Title, note.Content)
+	if existing[fingerprint] {
+		result.Status = IMPORT_DUPLICATE
+		return result
+	}
+	existing[fingerprint] = true
+	if dryRun {
+		result.Status = IMPORT_NEW
+		return result
+	}
+
+	id, err := is.noteService.Create(request.Note())
+	if err != nil {
+		result.Status = IMPORT_FAILED
+		if quotaExceeded(err) {
+			result.Errors = []FieldError{{"quota", err.Error()}}
+		}
+		return result
+	}
+	result.Status = IMPORT_CREATED
+	result.ID = id
+	return result
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var importedNotes = []ImportedNote{
+	{Source: "a.md", Title: "Existing", Content: "content"},
+	{Source: "b.md", Title: "New", Content: "content", Tags: []string{"work"}, Completed: true},
+	{Source: "c.md", Title: "New", Content: "content"},
+	{Source: "d.md", Title: "", Content: "no title"},
+	{Source: "e.md", Title: "Failing", Content: ""},
+	{Source: "f.md", Title: strings.Repeat("a", MAX_TITLE_LENGTH+1), Content: ""},
+}
+
+var uuidPattern = `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`
+
+func waitForImportJob(t *testing.T, noteImportService *NoteImportService, id string) ImportJob {
+	var job ImportJob
+	assert.Eventually(t, func() bool {
+		job, _ = noteImportService.GetJob(id)
+		return job.FinishedAt != nil
+	}, time.Second, time.Millisecond)
+	return job
+}
+
+func TestNoteImportService_Import(t *testing.T) {
+	mockService := &MockService{}
+	noteImportService := NewNoteImportService(mockService)
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{{{ID: 1, Title: "Existing", Content: "content"}}}, nil)
+	mockService.On("Create", Note{Title: "New", Content: "content", Tags: Tags{"work"}, Completed: true}).Return(uint64(2), nil)
+	mockService.On("Create", Note{Title: "Failing"}).Return(uint64(0), &InternalError{})
+
+	started := noteImportService.Import(importedNotes, false)
+	assert.Regexp(t, uuidPattern, started.ID)
+	assert.Equal(t, JOB_PENDING, started.Status)
+	assert.Equal(t, 6, started.Total)
+
+	job := waitForImportJob(t, noteImportService, started.ID)
+	assert.Equal(t, JOB_SUCCEEDED, job.Status)
+	assert.Equal(t, []ImportResult{
+		{Source: "a.md", Title: "Existing", Status: IMPORT_DUPLICATE},
+		{Source: "b.md", Title: "New", Status: IMPORT_CREATED, ID: 2},
+		{Source: "c.md", Title: "New", Status: IMPORT_DUPLICATE},
+		{Source: "d.md", Status: IMPORT_INVALID, Errors: []FieldError{{"title", "is required"}}},
+		{Source: "e.md", Title: "Failing", Status: IMPORT_FAILED},
+		{Source: "f.md", Title: strings.Repeat("a", MAX_TITLE_LENGTH+1), Status: IMPORT_INVALID,
+			Errors: []FieldError{{"title", "must be at most 200 characters"}}},
+	}, job.Results)
+	assert.Equal(t, map[string]int{IMPORT_CREATED: 1, IMPORT_DUPLICATE: 2, IMPORT_INVALID: 2, IMPORT_FAILED: 1}, job.Counts)
+	mockService.AssertNumberOfCalls(t, "Create", 2)
+
+	// Another job gets another ID.
+	assert.NotEqual(t, started.ID, noteImportService.Import(importedNotes[:0], false).ID)
+	_, found := noteImportService.GetJob("00000000-0000-4000-8000-000000000000")
+	assert.False(t, found)
+}
+
+func TestNoteImportService_Import_dryRun(t *testing.T) {
+	mockService := &MockService{}
+	noteImportService := NewNoteImportService(mockService)
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{{{ID: 1, Title: "Existing", Content: "content"}}}, nil)
+
+	job := noteImportService.Import(importedNotes[:3], true)
+
+	assert.Empty(t, job.ID)
+	assert.Equal(t, JOB_SUCCEEDED, job.Status)
+	assert.Equal(t, []string{IMPORT_DUPLICATE, IMPORT_NEW, IMPORT_DUPLICATE},
+		[]string{job.Results[0].Status, job.Results[1].Status, job.Results[2].Status})
+	mockService.AssertNotCalled(t, "Create")
+}
+
+func TestNoteImportService_Import_failed(t *testing.T) {
+	mockService := &MockService{}
+	noteImportService := NewNoteImportService(mockService)
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{}, errors.New("connection refused"))
+
+	job := noteImportService.Import(importedNotes, false)
+	job = waitForImportJob(t, noteImportService, job.ID)
+
+	assert.Equal(t, JOB_FAILED, job.Status)
+	assert.Equal(t, "Existing notes could not be loaded to detect duplicates", job.Error)
+	assert.Empty(t, job.Results)
+	mockService.AssertNotCalled(t, "Create")
+}
+
+func TestNoteImportService_deleteExpired(t *testing.T) {
+	noteImportService := NewNoteImportService(&MockService{})
+	finishedAt := time.Now().Add(-IMPORT_JOB_TTL - time.Minute)
+	noteImportService.jobs["expired"] = &ImportJob{ID: "expired", FinishedAt: &finishedAt}
+	noteImportService.jobs["running"] = &ImportJob{ID: "running"}
+
+	noteImportService.deleteExpired(time.Now())
+
+	_, found := noteImportService.GetJob("expired")
+	assert.False(t, found)
+	_, found = noteImportService.GetJob("running")
+	assert.True(t, found)
+}
+
+func TestNoteImportService_Wait(t *testing.T) {
+	mockService := &MockService{}
+	noteImportService := NewNoteImportService(mockService)
+	release := make(chan time.Time)
+	mockService.On("GetAllInBatches", EXPORT_BATCH_SIZE).Return([][]Note{}, nil).WaitUntil(release)
+	mockService.On("Create", Note{Title: "Existing", Content: "content"}).Return(uint64(1), nil)
+
+	started := noteImportService.Import(importedNotes[:1], false)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, noteImportService.Wait(ctx))
+
+	close(release)
+	assert.NoError(t, noteImportService.Wait(context.Background()))
+	job, _ := noteImportService.GetJob(started.ID)
+	assert.Equal(t, JOB_SUCCEEDED, job.Status)
+}
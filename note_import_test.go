@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func zipOf(files map[string]string) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for name, content := range files {
+		file, _ := writer.Create(name)
+		file.Write([]byte(content))
+	}
+	writer.Close()
+	return buffer.Bytes()
+}
+
+var importDue = time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+
+func TestParseMarkdown(t *testing.T) {
+	for _, td := range []struct {
+		title    string
+		name     string
+		text     string
+		expected ImportedNote
+	}{
+		{
+			title:    "Reads the title from the front matter",
+			name:     "notes/1-shopping.md",
+			text:     "---\nid: 1\ntitle: 'Shopping: weekly'\n---\n\n- milk\n",
+			expected: ImportedNote{Source: "notes/1-shopping.md", Title: "Shopping: weekly", Content: "- milk\n"},
+		},
+		{
+			title: "Reads the tags, completion and due date from the front matter",
+			name:  "2-call.md",
+			text:  "---\nid: 2\ntitle: Call\ntags:\n  - phone\ncompleted: true\ndue: 2024-01-05T09:00:00Z\n---\n\n",
+			expected: ImportedNote{Source: "2-call.md", Title: "Call", Content: "", Tags: []string{"phone"}, Completed: true,
+				Due: &importDue},
+		},
+		{
+			title:    "Reads the title from the first heading",
+			name:     "a.md",
+			text:     "# Plans #\n\nText",
+			expected: ImportedNote{Source: "a.md", Title: "Plans", Content: "# Plans #\n\nText"},
+		},
+		{
+			title:    "Reads the title from the file name",
+			name:     "dir/Meeting notes.markdown",
+			text:     "\uFEFFText\r\nmore",
+			expected: ImportedNote{Source: "dir/Meeting notes.markdown", Title: "Meeting notes", Content: "Text\nmore"},
+		},
+		{
+			title:    "Keeps a thematic break that is not front matter",
+			name:     "b.md",
+			text:     "---\nText: with colon\n\n- [ ] item\n",
+			expected: ImportedNote{Source: "b.md", Title: "b", Content: "---\nText: with colon\n\n- [ ] item\n"},
+		},
+	} {
+		t.Run("parseMarkdown: "+td.title, func(t *testing.T) {
+			assert.Equal(t, td.expected, parseMarkdown(td.name, td.text))
+		})
+	}
+}
+
+func TestParseMarkdownZip(t *testing.T) {
+	notes, err := parseMarkdownZip(zipOf(map[string]string{
+		"a.md":               "# A\n\nText",
+		"images/a.png":       "PNG",
+		"__MACOSX/._a.md":    "",
+		"dir/":               "",
+		"dir/b.MD":           "Text",
+		"large.md":           strings.Repeat("a", MAX_IMPORT_FILE_SIZE+10),
+		"dir/empty.markdown": "",
+	}))
+	assert.NoError(t, err)
+	titles := map[string]int{}
+	for _, note := range notes {
+		titles[note.Title] = len(note.Content)
+	}
+	assert.Equal(t, map[string]int{"A": 9, "b": 4, "large": MAX_IMPORT_FILE_SIZE, "empty": 0}, titles)
+
+	_, err = parseMarkdownZip([]byte("not a zip"))
+	assert.EqualError(t, err, "Request body is not a zip archive")
+}
+
+// Notes exported as a zip are imported as they were.
+func TestParseMarkdownZip_export(t *testing.T) {
+	notes := []Note{
+		{ID: 1, Title: "Shopping: weekly", Content: "- milk\n- eggs", Tags: Tags{"home", "errand"}, Completed: true, Due: &importDue},
+		{ID: 2, Title: "---", Content: "---\n\ntext\n"},
+		{ID: 3, Title: "# Not a heading"},
+	}
+	var buffer bytes.Buffer
+	exporter := exportFormats[EXPORT_ZIP].newExporter(&buffer, time.Now())
+	for _, note := range notes {
+		exporter.Write(note)
+	}
+	exporter.Close()
+
+	imported, err := parseMarkdownZip(buffer.Bytes())
+	assert.NoError(t, err)
+	for i, note := range notes {
+		request := NoteRequest{Title: imported[i].Title, Content: imported[i].Content, Tags: imported[i].Tags,
+			Completed: imported[i].Completed, Due: imported[i].Due}
+		note.ID = 0
+		assert.Equal(t, note, request.Note())
+	}
+}
+
+const enex = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export export-date="20240101T000000Z" application="Evernote" version="10">
+  <note>
+    <title>Groceries &amp; more</title>
+    <created>20231231T120000Z</created>
+    <content><![CDATA[<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note>
+  <h1>This week</h1>
+  <div><en-todo checked="true"/>Milk</div>
+  <div><en-todo checked="false"/>Eggs &amp; bread</div>
+  <div><br/></div>
+  <div>See <a href="https://example.com">the <b>list</b></a></div>
+  <ul><li>one</li><li>two</li></ul>
+</en-note>]]></content>
+    <tag>home</tag>
+  </note>
+  <note>
+    <title>Empty</title>
+    <content><![CDATA[<en-note></en-note>]]></content>
+  </note>
+</en-export>`
+
+func TestParseENEX(t *testing.T) {
+	notes, err := parseENEX([]byte(enex))
+	assert.NoError(t, err)
+	assert.Equal(t, []ImportedNote{
+		{Source: "note 1", Title: "Groceries & more", Content: "# This week\n\n- [x] Milk\n- [ ] Eggs & bread\n\nSee the list\n- one\n- two"},
+		{Source: "note 2", Title: "Empty", Content: ""},
+	}, notes)
+
+	for _, data := range []string{"", "<html></html>", "<en-export><note>"} {
+		_, err = parseENEX([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestParseTodoTxt(t *testing.T) {
+	notes, err := parseTodoTxt([]byte("(A) Call Mom @phone +Family\r\n\n" +
+		"x 2024-01-02 2024-01-01 Pay bills due:2024-01-05 +Home\n" +
+		"2024-01-01 xylophone lessons due:2024-13-01\n" +
+		"x (B) \n"))
+	due := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, err)
+	assert.Equal(t, []ImportedNote{
+		{Source: "line 1", Title: "Call Mom @phone +Family", Content: "(A) Call Mom @phone +Family"},
+		{Source: "line 3", Title: "Pay bills +Home", Content: "x 2024-01-02 2024-01-01 Pay bills due:2024-01-05 +Home",
+			Completed: true, Due: &due},
+		{Source: "line 4", Title: "xylophone lessons due:2024-13-01", Content: "2024-01-01 xylophone lessons due:2024-13-01"},
+		{Source: "line 5", Title: "", Content: "x (B)", Completed: true},
+	}, notes)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// NOTE_LOADER_MAX_BATCH_SIZE caps the IDs looked up in one query.
+const NOTE_LOADER_MAX_BATCH_SIZE = 100
+
+// noteLoaderWait is how long a loader collects IDs before looking them up.
+var noteLoaderWait = time.Millisecond
+
+type noteBatch struct {
+	ids        []uint64
+	notes      map[uint64]Note
+	dispatched sync.Once
+	done       chan struct{}
+}
+
+// NoteLoader batches the lookups of notes by ID made concurrently while
+// resolving one GraphQL request, so that resolving N notes takes one query
+// instead of N.
+type NoteLoader struct {
+	noteService INoteService
+	mutex       sync.Mutex
+	batch       *noteBatch
+}
+
+func NewNoteLoader(noteService INoteService) *NoteLoader {
+	return &NoteLoader{noteService: noteService}
+}
+
+func (nl *NoteLoader) Load(id uint64) (Note, bool) {
+	nl.mutex.Lock()
+	batch := nl.batch
+	if batch == nil {
+		batch = &noteBatch{done: make(chan struct{})}
+		nl.batch = batch
+		time.AfterFunc(noteLoaderWait, func() { nl.dispatch(batch) })
+	}
+	batch.ids = append(batch.ids, id)
+	full := len(batch.ids) >= NOTE_LOADER_MAX_BATCH_SIZE
+	nl.mutex.Unlock()
+
+	if full {
+		nl.dispatch(batch)
+	}
+	<-batch.done
+	note, found := batch.notes[id]
+	return note, found
+}
+
+// dispatch looks up the IDs of the batch once, whether it is full or its
+// wait is over.
+func (nl *NoteLoader) dispatch(batch *noteBatch) {
+	batch.dispatched.Do(func() {
+		nl.mutex.Lock()
+		if nl.batch == batch {
+			nl.batch = nil
+		}
+		ids := batch.ids
+		nl.mutex.Unlock()
+
+		batch.notes = map[uint64]Note{}
+		for _, note := range nl.noteService.GetByIds(ids) {
+			batch.notes[note.ID] = note
+		}
+		close(batch.done)
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNoteLoader_Load(t *testing.T) {
+	mockService := &MockService{}
+	loader := NewNoteLoader(mockService)
+
+	var ids []uint64
+	mockService.On("GetByIds", mock.Anything).Return([]Note{{ID: 1, Title: "1"}, {ID: 2, Title: "2"}}).Run(func(args mock.Arguments) {
+		ids = args.Get(0).([]uint64)
+	}).Once()
+
+	var wg sync.WaitGroup
+	notes := make([]Note, 3)
+	found := make([]bool, 3)
+	for i := range notes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			notes[i], found[i] = loader.Load(uint64(i + 1))
+		}(i)
+	}
+	wg.Wait()
+
+	mockService.AssertExpectations(t)
+	assert.ElementsMatch(t, []uint64{1, 2, 3}, ids)
+	assert.Equal(t, []Note{{ID: 1, Title: "1"}, {ID: 2, Title: "2"}, {}}, notes)
+	assert.Equal(t, []bool{true, true, false}, found)
+}
+
+func TestNoteLoader_Load_fullBatch(t *testing.T) {
+	defer func(wait time.Duration) { noteLoaderWait = wait }(noteLoaderWait)
+	noteLoaderWait = time.Hour
+
+	mockService := &MockService{}
+	loader := NewNoteLoader(mockService)
+	mockService.On("GetByIds", mock.Anything).Return([]Note{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < NOTE_LOADER_MAX_BATCH_SIZE; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			loader.Load(uint64(i))
+		}(i)
+	}
+	// Returns without waiting for an hour.
+	wg.Wait()
+	mockService.AssertNumberOfCalls(t, "GetByIds", 1)
+}
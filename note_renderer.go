@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"regexp"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+const (
+	RENDER_HTML            = "html"
+	NOTE_RENDER_CACHE_SIZE = 1000
+)
+
+type noteVersion struct {
+	id       uint64
+	sequence uint64
+}
+
+type renderedNote struct {
+	version noteVersion
+	html    []byte
+}
+
+// NoteRenderer renders the content of notes, CommonMark with the GitHub
+// extensions, to HTML. Raw HTML in notes is kept but everything not in the
+// allow-list of the sanitizer is removed, so notes cannot inject scripts.
+// Rendered notes are cached per version, the least recently used first
+// evicted.
+type NoteRenderer struct {
+	markdown goldmark.Markdown
+	policy   *bluemonday.Policy
+	size     int
+
+	mu       sync.Mutex
+	rendered map[noteVersion]*list.Element
+	recent   *list.List
+}
+
+var checkboxPattern = regexp.MustCompile(`^checkbox$`)
+
+func NewNoteRenderer(size int) *NoteRenderer {
+	policy := bluemonday.UGCPolicy()
+	// Task list items
+	policy.AllowAttrs("type").Matching(checkboxPattern).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+
+	return &NoteRenderer{
+		markdown: goldmark.New(
+			// GFM, with the alignment of table cells in attributes rather
+			// than styles, which the sanitizer removes
+			goldmark.WithExtensions(
+				extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+				extension.Strikethrough,
+				extension.Linkify,
+				extension.TaskList,
+			),
+			goldmark.WithRendererOptions(html.WithUnsafe()),
+		),
+		policy:   policy,
+		size:     size,
+		rendered: map[noteVersion]*list.Element{},
+		recent:   list.New(),
+	}
+}
+
+// Render returns the sanitized HTML of the content of the note.
+func (nr *NoteRenderer) Render(note Note) ([]byte, error) {
+	version := noteVersion{note.ID, note.Sequence}
+	if html, found := nr.cached(version); found {
+		return html, nil
+	}
+
+	var buffer bytes.Buffer
+	if err := nr.markdown.Convert([]byte(note.Content), &buffer); err != nil {
+		return nil, err
+	}
+	html := nr.policy.SanitizeBytes(buffer.Bytes())
+	nr.store(version, html)
+	return html, nil
+}
+
+func (nr *NoteRenderer) cached(version noteVersion) ([]byte, bool) {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	element, found := nr.rendered[version]
+	if !found {
+		return nil, false
+	}
+	nr.recent.MoveToFront(element)
+	return element.Value.(*renderedNote).html, true
+}
+
+func (nr *NoteRenderer) store(version noteVersion, html []byte) {
+	nr.mu.Lock()
+	defer nr.mu.Unlock()
+	if _, found := nr.rendered[version]; found {
+		return
+	}
+	nr.rendered[version] = nr.recent.PushFront(&renderedNote{version, html})
+	for nr.recent.Len() > nr.size {
+		oldest := nr.recent.Back()
+		nr.recent.Remove(oldest)
+		delete(nr.rendered, oldest.Value.(*renderedNote).version)
+	}
+}
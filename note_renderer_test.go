@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoteRenderer_Render(t *testing.T) {
+	for _, td := range []struct {
+		title    string
+		content  string
+		expected string
+	}{
+		{
+			title:    "Renders CommonMark",
+			content:  "# Title\n\nSome *emphasis* and [a link](https://example.com).",
+			expected: "<h1>Title</h1>\n<p>Some <em>emphasis</em> and <a href=\"https://example.com\" rel=\"nofollow\">a link</a>.</p>\n",
+		},
+		{
+			title:   "Renders tables",
+			content: "| a | b |\n|:--|--:|\n| 1 | 2 |",
+			expected: "<table>\n<thead>\n<tr>\n<th align=\"left\">a</th>\n<th align=\"right\">b</th>\n</tr>\n</thead>\n" +
+				"<tbody>\n<tr>\n<td align=\"left\">1</td>\n<td align=\"right\">2</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		{
+			title:   "Renders task lists",
+			content: "- [x] done\n- [ ] todo",
+			expected: "<ul>\n<li><input checked=\"\" disabled=\"\" type=\"checkbox\"> done</li>\n" +
+				"<li><input disabled=\"\" type=\"checkbox\"> todo</li>\n</ul>\n",
+		},
+		{
+			title:    "Renders strikethrough and autolinks",
+			content:  "~~old~~ www.example.com",
+			expected: "<p><del>old</del> <a href=\"http://www.example.com\" rel=\"nofollow\">www.example.com</a></p>\n",
+		},
+		{
+			title:    "Keeps allowed raw HTML",
+			content:  "<details><summary>More</summary>\n\nHidden\n\n</details>",
+			expected: "<details><summary>More</summary>\n<p>Hidden</p>\n</details>",
+		},
+		{
+			title:    "Removes scripts",
+			content:  "<script>alert(1)</script>\n\ntext",
+			expected: "\n<p>text</p>\n",
+		},
+		{
+			title:    "Removes event handlers",
+			content:  "<img src=\"x.png\" onerror=\"alert(1)\">",
+			expected: "<img src=\"x.png\">",
+		},
+		{
+			title:    "Removes javascript URLs",
+			content:  "[click](javascript:alert(1)) <a href=\"javascript:alert(1)\">here</a>",
+			expected: "<p>click here</p>\n",
+		},
+		{
+			title:    "Removes inputs other than checkboxes",
+			content:  "<input type=\"text\" value=\"x\">",
+			expected: "",
+		},
+	} {
+		t.Run("Render: "+td.title, func(t *testing.T) {
+			html, err := NewNoteRenderer(NOTE_RENDER_CACHE_SIZE).Render(Note{ID: 1, Content: td.content})
+			assert.NoError(t, err)
+			assert.Equal(t, td.expected, string(html))
+		})
+	}
+}
+
+func TestNoteRenderer_cache(t *testing.T) {
+	noteRenderer := NewNoteRenderer(2)
+
+	// Cached per version
+	html, _ := noteRenderer.Render(Note{ID: 1, Sequence: 1, Content: "first"})
+	assert.Equal(t, "<p>first</p>\n", string(html))
+	html, _ = noteRenderer.Render(Note{ID: 1, Sequence: 1, Content: "not rendered"})
+	assert.Equal(t, "<p>first</p>\n", string(html))
+	html, _ = noteRenderer.Render(Note{ID: 1, Sequence: 2, Content: "second"})
+	assert.Equal(t, "<p>second</p>\n", string(html))
+
+	// The least recently used is evicted
+	noteRenderer.Render(Note{ID: 1, Sequence: 1})
+	noteRenderer.Render(Note{ID: 2, Sequence: 3, Content: "other"})
+	assert.Equal(t, 2, noteRenderer.recent.Len())
+	_, found := noteRenderer.cached(noteVersion{1, 2})
+	assert.False(t, found)
+	_, found = noteRenderer.cached(noteVersion{1, 1})
+	assert.True(t, found)
+}
@@ -1,23 +1,91 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+const (
+	NOTE_SEQUENCE_LOCK = 1
+)
+
+var errRollback = errors.New("rollback")
+
+// noteColumns are the columns Update writes, so that empty values replace
+// the old ones as well.
+var noteColumns = []string{"title", "content", "tags", "completed", "due", "sequence"}
+
 type INoteRepository interface {
+	WithContext(ctx context.Context) INoteRepository
 	GetAll() []Note
 	GetById(id uint64) (Note, bool)
+	GetByIds(ids []uint64) []Note
+	GetByCalendarName(name string) (Note, bool)
+	Search(query string, tag string) []Note
+	GetAllInBatches(size int, fn func(notes []Note) error) error
+	Count() (int64, bool)
+	GetChangedSince(sequence uint64) []Note
+	GetChangeById(id uint64) (Note, bool)
 	Create(note Note) (uint64, bool)
 	Update(id uint64, note Note) (uint64, bool)
+	UpdateContent(id uint64, sequence uint64, content string) (uint64, bool)
+	UpdateIfUnchanged(id uint64, sequence uint64, note Note) (uint64, bool)
 	Delete(id uint64) bool
+	DeleteIfUnchanged(id uint64, sequence uint64) (uint64, bool)
+	Transaction(fn func(noteRepository INoteRepository) bool) bool
+	Usage() (QuotaUsage, bool)
 }
 
 type NoteRepository struct {
 	db *gorm.DB
 }
 
+// WithContext returns the repository with the context passed to gorm, whose
+// logger takes the logger of the request from it.
+func (nr *NoteRepository) WithContext(ctx context.Context) INoteRepository {
+	return &NoteRepository{nr.db.WithContext(ctx)}
+}
+
+// nextSequence returns the sequence for a change made in the transaction.
+// Changes are serialized so that they are committed in the order of their
+// sequences; otherwise a client could miss a change committed late with a
+// sequence lower than one it has already seen.
+func nextSequence(tx *gorm.DB) (uint64, error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", NOTE_SEQUENCE_LOCK).Error; err != nil {
+		return 0, err
+	}
+	var sequence uint64
+	if err := tx.Raw("SELECT nextval('note_sequence')").Scan(&sequence).Error; err != nil {
+		return 0, err
+	}
+	return sequence, nil
+}
+
+// noteUsageSQL selects what the note counts against the quota, if it is
+// not deleted.
+const noteUsageSQL = `SELECT count(*) AS notes,
+	COALESCE(SUM(octet_length(COALESCE(title, '')) + octet_length(COALESCE(content, ''))), 0) AS bytes
+	FROM notes WHERE deleted_at IS NULL`
+
+// trackUsage adds the note to the usage of the workspace, or subtracts it
+// if sign is -1. A change subtracts the note before and adds it after, in
+// the transaction of the change.
+func trackUsage(tx *gorm.DB, id uint64, sign int) error {
+	return tx.Exec("UPDATE quota_usages SET notes = quota_usages.notes + ? * n.notes, bytes = quota_usages.bytes + ? * n.bytes "+
+		"FROM ("+noteUsageSQL+" AND id = ?) AS n WHERE workspace = ?", sign, sign, id, DEFAULT_WORKSPACE).Error
+}
+
+// InitUsage counts the notes already in the database, before the usage was
+// tracked.
+func InitUsage(db *gorm.DB) error {
+	return db.Exec("INSERT INTO quota_usages (workspace, notes, bytes) SELECT ?, n.notes, n.bytes FROM ("+noteUsageSQL+") AS n "+
+		"ON CONFLICT DO NOTHING", DEFAULT_WORKSPACE).Error
+}
+
 func (nr *NoteRepository) GetAll() []Note {
 	var notes []Note
 	nr.db.Find(&notes)
@@ -33,25 +101,250 @@ func (nr *NoteRepository) GetById(id uint64) (Note, bool) {
 	return note, true
 }
 
-func (nr *NoteRepository) Create(note Note) (uint64, bool) {
-	result := nr.db.Create(&note)
+// GetByIds returns the notes found in one query, in no particular order.
+func (nr *NoteRepository) GetByIds(ids []uint64) []Note {
+	var notes []Note
+	nr.db.Find(&notes, ids)
+	return notes
+}
+
+// GetByCalendarName returns the note created by a CalDAV client under the
+// object name.
+func (nr *NoteRepository) GetByCalendarName(name string) (Note, bool) {
+	var note Note
+	result := nr.db.Where("calendar_name = ?", name).Take(&note)
+	return note, result.Error == nil
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Search returns the notes whose title or content contains the query,
+// ignoring case, and that have the tag. An empty query or tag matches all
+// notes.
+func (nr *NoteRepository) Search(query string, tag string) []Note {
+	db := nr.db
+	if query != "" {
+		pattern := "%" + likeEscaper.Replace(query) + "%"
+		db = db.Where("title ILIKE ? OR content ILIKE ?", pattern, pattern)
+	}
+	if tag != "" {
+		db = db.Where("tags @> ?", Tags{tag})
+	}
+	var notes []Note
+	db.Find(&notes)
+	return notes
+}
+
+// GetAllInBatches calls fn with the notes in batches of the size in the
+// order of their IDs, so that all notes need not be loaded at once. An error
+// returned by fn stops the iteration.
+func (nr *NoteRepository) GetAllInBatches(size int, fn func(notes []Note) error) error {
+	var notes []Note
+	return nr.db.FindInBatches(&notes, size, func(tx *gorm.DB, batch int) error {
+		return fn(notes)
+	}).Error
+}
+
+// GetChangedSince returns the notes, including deleted ones, changed after
+// the sequence in the order of their changes.
+func (nr *NoteRepository) GetChangedSince(sequence uint64) []Note {
+	var notes []Note
+	nr.db.Unscoped().Where("sequence > ?", sequence).Order("sequence").Find(&notes)
+	return notes
+}
+
+// Count returns the number of notes, not counting deleted ones.
+func (nr *NoteRepository) Count() (int64, bool) {
+	var count int64
+	result := nr.db.Model(&Note{}).Count(&count)
+	return count, result.Error == nil
+}
+
+// GetChangeById returns the note even if it has been deleted.
+func (nr *NoteRepository) GetChangeById(id uint64) (Note, bool) {
+	var note Note
+	result := nr.db.Unscoped().First(&note, id)
 	if result.Error != nil {
+		return note, false
+	}
+	return note, true
+}
+
+func (nr *NoteRepository) Create(note Note) (uint64, bool) {
+	err := nr.db.Transaction(func(tx *gorm.DB) error {
+		sequence, err := nextSequence(tx)
+		if err != nil {
+			return err
+		}
+		note.Sequence = sequence
+		if err := tx.Create(&note).Error; err != nil {
+			return err
+		}
+		if err := trackUsage(tx, note.ID, 1); err != nil {
+			return err
+		}
+		return createEvent(tx, NOTE_CREATED, note.ID)
+	})
+	if err != nil {
 		return 0, false
 	}
 	return note.ID, true
 }
 
 func (nr *NoteRepository) Update(id uint64, note Note) (uint64, bool) {
-	result := nr.db.Model(&Note{ID: id}).Updates(note)
-	if result.Error != nil {
+	err := nr.db.Transaction(func(tx *gorm.DB) error {
+		sequence, err := nextSequence(tx)
+		if err != nil {
+			return err
+		}
+		note.Sequence = sequence
+		if err := trackUsage(tx, id, -1); err != nil {
+			return err
+		}
+		if err := tx.Model(&Note{ID: id}).Select(noteColumns).Updates(&note).Error; err != nil {
+			return err
+		}
+		if err := trackUsage(tx, id, 1); err != nil {
+			return err
+		}
+		return createEvent(tx, NOTE_UPDATED, id)
+	})
+	if err != nil {
 		return 0, false
 	}
 	return id, true
 }
 
+// UpdateContent changes only the content of the note, which is written
+// even if empty, if the note has not been changed or deleted since the
+// sequence. It returns the new sequence.
+func (nr *NoteRepository) UpdateContent(id uint64, sequence uint64, content string) (uint64, bool) {
+	note := Note{Content: content}
+	err := nr.db.Transaction(func(tx *gorm.DB) error {
+		newSequence, err := nextSequence(tx)
+		if err != nil {
+			return err
+		}
+		note.Sequence = newSequence
+		if err := trackUsage(tx, id, -1); err != nil {
+			return err
+		}
+		result := tx.Model(&Note{ID: id}).Where("sequence = ?", sequence).Select("content", "sequence").Updates(&note)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := trackUsage(tx, id, 1); err != nil {
+			return err
+		}
+		return createEvent(tx, NOTE_UPDATED, id)
+	})
+	if err != nil {
+		return 0, false
+	}
+	return note.Sequence, true
+}
+
+// UpdateIfUnchanged updates the note only if it has not been changed since
+// the sequence, and returns the new sequence.
+func (nr *NoteRepository) UpdateIfUnchanged(id uint64, sequence uint64, note Note) (uint64, bool) {
+	err := nr.db.Transaction(func(tx *gorm.DB) error {
+		newSequence, err := nextSequence(tx)
+		if err != nil {
+			return err
+		}
+		note.Sequence = newSequence
+		if err := trackUsage(tx, id, -1); err != nil {
+			return err
+		}
+		result := tx.Model(&Note{ID: id}).Where("sequence = ?", sequence).Select(noteColumns).Updates(&note)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := trackUsage(tx, id, 1); err != nil {
+			return err
+		}
+		return createEvent(tx, NOTE_UPDATED, id)
+	})
+	if err != nil {
+		return 0, false
+	}
+	return note.Sequence, true
+}
+
+// Delete leaves the note as a tombstone so that the deletion can be synced.
 func (nr *NoteRepository) Delete(id uint64) bool {
-	if result := nr.db.Delete(&Note{}, id); result.Error != nil {
-		return false
+	err := nr.db.Transaction(func(tx *gorm.DB) error {
+		sequence, err := nextSequence(tx)
+		if err != nil {
+			return err
+		}
+		if err := trackUsage(tx, id, -1); err != nil {
+			return err
+		}
+		if err := tx.Model(&Note{ID: id}).Updates(tombstone(sequence)).Error; err != nil {
+			return err
+		}
+		return createEvent(tx, NOTE_DELETED, id)
+	})
+	return err == nil
+}
+
+// DeleteIfUnchanged deletes the note only if it has not been changed since
+// the sequence, and returns the sequence of the deletion.
+func (nr *NoteRepository) DeleteIfUnchanged(id uint64, sequence uint64) (uint64, bool) {
+	var newSequence uint64
+	err := nr.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		newSequence, err = nextSequence(tx)
+		if err != nil {
+			return err
+		}
+		if err := trackUsage(tx, id, -1); err != nil {
+			return err
+		}
+		result := tx.Model(&Note{ID: id}).Where("sequence = ?", sequence).Updates(tombstone(newSequence))
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		return createEvent(tx, NOTE_DELETED, id)
+	})
+	if err != nil {
+		return 0, false
+	}
+	return newSequence, true
+}
+
+func tombstone(sequence uint64) Note {
+	return Note{
+		Sequence:  sequence,
+		DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true},
 	}
-	return true
+}
+
+// Transaction calls fn with a repository whose operations are made in one
+// transaction. The transaction is rolled back if fn returns false.
+func (nr *NoteRepository) Transaction(fn func(noteRepository INoteRepository) bool) bool {
+	err := nr.db.Transaction(func(tx *gorm.DB) error {
+		if !fn(&NoteRepository{tx}) {
+			return errRollback
+		}
+		return nil
+	})
+	return err == nil
+}
+
+func (nr *NoteRepository) Usage() (QuotaUsage, bool) {
+	var usage QuotaUsage
+	result := nr.db.Take(&usage, "workspace = ?", DEFAULT_WORKSPACE)
+	return usage, result.Error == nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -97,12 +98,146 @@ func (ts *NoteRepositoryTestSuite) TestNoteRepository_GetById() {
 	}
 }
 
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_GetByIds() {
+	ids := []uint64{1, 2}
+	rows := sqlmock.NewRows([]string{"id", "title", "content"}).AddRow(2, "title2", "content2").AddRow(1, "title", "content")
+	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Find(&[]Note{}, ids).Statement.SQL.String()
+	ts.mock.ExpectQuery(query).WithArgs(1, 2).WillReturnRows(rows)
+
+	notes := ts.noteRepository.GetByIds(ids)
+
+	assert.Equal(ts.T(), []Note{{ID: 2, Title: "title2", Content: "content2"}, {ID: 1, Title: "title", Content: "content"}}, notes)
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_GetByCalendarName() {
+	rows := sqlmock.NewRows([]string{"id", "title", "calendar_name"}).AddRow(2, "title", "client-uid.ics")
+	ts.mock.ExpectQuery(`SELECT * FROM "notes" WHERE calendar_name = $1 AND "notes"."deleted_at" IS NULL LIMIT 1`).
+		WithArgs("client-uid.ics").WillReturnRows(rows)
+
+	note, ok := ts.noteRepository.GetByCalendarName("client-uid.ics")
+
+	assert.True(ts.T(), ok)
+	assert.Equal(ts.T(), Note{ID: 2, Title: "title", CalendarName: "client-uid.ics"}, note)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_Search() {
+	rows := sqlmock.NewRows([]string{"id", "title", "tags"}).AddRow(1, "shopping", `["home"]`)
+	ts.mock.ExpectQuery(`SELECT * FROM "notes" WHERE (title ILIKE $1 OR content ILIKE $2) AND tags @> $3 AND "notes"."deleted_at" IS NULL`).
+		WithArgs(`%50\%%`, `%50\%%`, `["home"]`).WillReturnRows(rows)
+
+	notes := ts.noteRepository.Search("50%", "home")
+
+	assert.Equal(ts.T(), []Note{{ID: 1, Title: "shopping", Tags: Tags{"home"}}}, notes)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_GetAllInBatches() {
+	ts.mock.ExpectQuery(`SELECT * FROM "notes" WHERE "notes"."deleted_at" IS NULL ORDER BY "notes"."id" LIMIT 2`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "title").AddRow(2, "title2"))
+	ts.mock.ExpectQuery(`SELECT * FROM "notes" WHERE "notes"."id" > $1 AND "notes"."deleted_at" IS NULL ORDER BY "notes"."id" LIMIT 2`).
+		WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(3, "title3"))
+
+	var batches [][]Note
+	err := ts.noteRepository.GetAllInBatches(2, func(notes []Note) error {
+		batches = append(batches, append([]Note{}, notes...))
+		return nil
+	})
+
+	assert.NoError(ts.T(), err)
+	assert.Equal(ts.T(), [][]Note{{{ID: 1, Title: "title"}, {ID: 2, Title: "title2"}}, {{ID: 3, Title: "title3"}}}, batches)
+	assert.NoError(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_Count() {
+	ts.mock.ExpectQuery(`SELECT count(*) FROM "notes" WHERE "notes"."deleted_at" IS NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	count, ok := ts.noteRepository.Count()
+
+	assert.Equal(ts.T(), true, ok)
+	assert.Equal(ts.T(), int64(3), count)
+	assert.NoError(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_GetAllInBatches_stopped() {
+	ts.mock.ExpectQuery(`SELECT * FROM "notes" WHERE "notes"."deleted_at" IS NULL ORDER BY "notes"."id" LIMIT 2`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "title").AddRow(2, "title2"))
+
+	err := ts.noteRepository.GetAllInBatches(2, func(notes []Note) error {
+		return errRollback
+	})
+
+	assert.ErrorIs(ts.T(), err, errRollback)
+	assert.NoError(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) expectNextSequence(sequence uint64) {
+	ts.mock.ExpectExec("SELECT pg_advisory_xact_lock($1)").WithArgs(NOTE_SEQUENCE_LOCK).WillReturnResult(sqlmock.NewResult(0, 0))
+	ts.mock.ExpectQuery("SELECT nextval('note_sequence')").WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(sequence))
+}
+
+func (ts *NoteRepositoryTestSuite) expectTrackUsage(id uint64, sign int) {
+	ts.mock.ExpectExec("UPDATE quota_usages SET notes = quota_usages.notes + $1 * n.notes, bytes = quota_usages.bytes + $2 * n.bytes " +
+		"FROM (SELECT count(*) AS notes, COALESCE(SUM(octet_length(COALESCE(title, '')) + octet_length(COALESCE(content, ''))), 0) AS bytes " +
+		"FROM notes WHERE deleted_at IS NULL AND id = $3) AS n WHERE workspace = $4").
+		WithArgs(sign, sign, id, DEFAULT_WORKSPACE).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func (ts *NoteRepositoryTestSuite) expectCreateEvent(eventType string, id uint64) {
+	if eventType == NOTE_DELETED {
+		ts.mock.ExpectQuery(`INSERT INTO "note_events" ("type","note_id","title","content","tags","completed","due","created_at") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`).
+			WithArgs(eventType, id, "", "", "[]", false, nil, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		return
+	}
+	ts.mock.ExpectExec("INSERT INTO note_events (type, note_id, title, content, tags, completed, due, created_at) "+
+		"SELECT $1, id, title, content, tags, completed, due, $2 FROM notes WHERE id = $3").
+		WithArgs(eventType, sqlmock.AnyArg(), id).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_GetChangedSince() {
+	var (
+		since uint64 = 5
+	)
+
+	rows := sqlmock.NewRows([]string{"id", "title", "content", "sequence", "deleted_at"})
+	rows = rows.AddRow(1, "title", "content", 6, nil)
+	rows = rows.AddRow(2, "", "", 7, time.Now())
+	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Unscoped().Where("sequence > ?", since).Order("sequence").Find(&[]Note{}).Statement.SQL.String()
+	ts.mock.ExpectQuery(query).WithArgs(since).WillReturnRows(rows)
+
+	notes := ts.noteRepository.GetChangedSince(since)
+
+	assert.Equal(ts.T(), 2, len(notes))
+	assert.Equal(ts.T(), uint64(6), notes[0].Sequence)
+	assert.Equal(ts.T(), false, notes[0].DeletedAt.Valid)
+	assert.Equal(ts.T(), uint64(7), notes[1].Sequence)
+	assert.Equal(ts.T(), true, notes[1].DeletedAt.Valid)
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_GetChangeById() {
+	var (
+		id uint64 = 2
+	)
+
+	rows := sqlmock.NewRows([]string{"id", "sequence", "deleted_at"}).AddRow(id, 3, time.Now())
+	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Unscoped().First(&Note{}, id).Statement.SQL.String()
+	ts.mock.ExpectQuery(query).WillReturnRows(rows)
+
+	note, found := ts.noteRepository.GetChangeById(id)
+
+	assert.Equal(ts.T(), true, found)
+	assert.Equal(ts.T(), uint64(3), note.Sequence)
+	assert.Equal(ts.T(), true, note.DeletedAt.Valid)
+}
+
 func (ts *NoteRepositoryTestSuite) TestNoteRepository_Create_success() {
 	var (
 		id   uint64 = 1
 		note      = Note{
-			Title:   "test_title",
-			Content: "test_content",
+			Title:    "test_title",
+			Content:  "test_content",
+			Sequence: 10,
 		}
 		rows = sqlmock.NewRows([]string{"id"}).AddRow(id)
 	)
@@ -110,30 +245,36 @@ func (ts *NoteRepositoryTestSuite) TestNoteRepository_Create_success() {
 	ts.mock.ExpectCommit()
 	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Create(&note).Statement.SQL.String()
 	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
 	ts.mock.ExpectQuery(query).WillReturnRows(rows)
+	ts.expectTrackUsage(id, 1)
+	ts.expectCreateEvent(NOTE_CREATED, id)
 	ts.mock.ExpectCommit()
 
-	actualId, actualOk := ts.noteRepository.Create(note)
+	actualId, actualOk := ts.noteRepository.Create(Note{Title: note.Title, Content: note.Content})
 
 	assert.Equal(ts.T(), true, actualOk)
 	assert.Equal(ts.T(), id, actualId)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
 }
 
 func (ts *NoteRepositoryTestSuite) TestNoteRepository_Create_failed() {
 	var (
 		note = Note{
-			Title:   "test_title",
-			Content: "test_content",
+			Title:    "test_title",
+			Content:  "test_content",
+			Sequence: 10,
 		}
 	)
 	ts.mock.ExpectBegin()
 	ts.mock.ExpectCommit()
 	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Create(&note).Statement.SQL.String()
 	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
 	ts.mock.ExpectQuery(query).WillReturnError(gorm.ErrInvalidDB) // Anything error will do.
 	ts.mock.ExpectRollback()
 
-	actualId, actualOk := ts.noteRepository.Create(note)
+	actualId, actualOk := ts.noteRepository.Create(Note{Title: note.Title, Content: note.Content})
 
 	assert.Equal(ts.T(), false, actualOk)
 	assert.Equal(ts.T(), UNSPECIFIED_ID, actualId)
@@ -143,58 +284,199 @@ func (ts *NoteRepositoryTestSuite) TestNoteRepository_Update_success() {
 	var (
 		id   uint64 = 1
 		note      = Note{
-			Title:   "test_title",
-			Content: "test_content",
+			Title:    "test_title",
+			Content:  "test_content",
+			Sequence: 10,
 		}
 	)
 	ts.mock.ExpectBegin()
 	ts.mock.ExpectCommit()
-	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Model(&Note{ID: id}).Updates(note).Statement.SQL.String()
+	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Model(&Note{ID: id}).Select(noteColumns).Updates(&note).Statement.SQL.String()
 	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
+	ts.expectTrackUsage(id, -1)
 	ts.mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.expectTrackUsage(id, 1)
+	ts.expectCreateEvent(NOTE_UPDATED, id)
 	ts.mock.ExpectCommit()
 
-	actualId, actualOk := ts.noteRepository.Update(id, note)
+	actualId, actualOk := ts.noteRepository.Update(id, Note{Title: note.Title, Content: note.Content})
 
 	assert.Equal(ts.T(), true, actualOk)
 	assert.Equal(ts.T(), id, actualId)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
 }
 
 func (ts *NoteRepositoryTestSuite) TestNoteRepository_Update_failed() {
 	var (
 		id   uint64 = 1
 		note      = Note{
-			Title:   "test_title",
-			Content: "test_content",
+			Title:    "test_title",
+			Content:  "test_content",
+			Sequence: 10,
 		}
 	)
 	ts.mock.ExpectBegin()
 	ts.mock.ExpectCommit()
-	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Model(&Note{ID: id}).Updates(note).Statement.SQL.String()
+	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Model(&Note{ID: id}).Select(noteColumns).Updates(&note).Statement.SQL.String()
 	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
+	ts.expectTrackUsage(id, -1)
 	ts.mock.ExpectExec(query).WillReturnError(gorm.ErrInvalidDB)
 	ts.mock.ExpectRollback()
 
-	actualId, actualOk := ts.noteRepository.Update(id, note)
+	actualId, actualOk := ts.noteRepository.Update(id, Note{Title: note.Title, Content: note.Content})
 
 	assert.Equal(ts.T(), false, actualOk)
 	assert.Equal(ts.T(), UNSPECIFIED_ID, actualId)
 }
 
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_Update_clearsContent() {
+	var (
+		id uint64 = 1
+	)
+	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
+	ts.expectTrackUsage(id, -1)
+	ts.mock.ExpectExec(`UPDATE "notes" SET "title"=$1,"content"=$2,"tags"=$3,"completed"=$4,"due"=$5,"sequence"=$6 WHERE "id" = $7`).
+		WithArgs("test_title", "", "[]", false, nil, 10, id).WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.expectTrackUsage(id, 1)
+	ts.expectCreateEvent(NOTE_UPDATED, id)
+	ts.mock.ExpectCommit()
+
+	actualId, actualOk := ts.noteRepository.Update(id, Note{Title: "test_title"})
+
+	assert.Equal(ts.T(), true, actualOk)
+	assert.Equal(ts.T(), id, actualId)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_UpdateIfUnchanged_clearsContent() {
+	var (
+		id uint64 = 1
+	)
+	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
+	ts.expectTrackUsage(id, -1)
+	ts.mock.ExpectExec(`UPDATE "notes" SET "title"=$1,"content"=$2,"tags"=$3,"completed"=$4,"due"=$5,"sequence"=$6 WHERE sequence = $7 AND "notes"."deleted_at" IS NULL AND "id" = $8`).
+		WithArgs("test_title", "", "[]", false, nil, 10, 4, id).WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.expectTrackUsage(id, 1)
+	ts.expectCreateEvent(NOTE_UPDATED, id)
+	ts.mock.ExpectCommit()
+
+	actualSequence, actualOk := ts.noteRepository.UpdateIfUnchanged(id, 4, Note{Title: "test_title"})
+
+	assert.Equal(ts.T(), true, actualOk)
+	assert.Equal(ts.T(), uint64(10), actualSequence)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_UpdateContent_empty() {
+	var (
+		id uint64 = 1
+	)
+	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
+	ts.expectTrackUsage(id, -1)
+	ts.mock.ExpectExec(`UPDATE "notes" SET "content"=$1,"sequence"=$2 WHERE sequence = $3 AND "notes"."deleted_at" IS NULL AND "id" = $4`).
+		WithArgs("", 10, 4, id).WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.expectTrackUsage(id, 1)
+	ts.expectCreateEvent(NOTE_UPDATED, id)
+	ts.mock.ExpectCommit()
+
+	actualSequence, actualOk := ts.noteRepository.UpdateContent(id, 4, "")
+
+	assert.Equal(ts.T(), true, actualOk)
+	assert.Equal(ts.T(), uint64(10), actualSequence)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_UpdateContent_changed() {
+	var (
+		id uint64 = 1
+	)
+	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
+	ts.expectTrackUsage(id, -1)
+	ts.mock.ExpectExec(`UPDATE "notes" SET "content"=$1,"sequence"=$2 WHERE sequence = $3 AND "notes"."deleted_at" IS NULL AND "id" = $4`).
+		WithArgs("abc", 10, 4, id).WillReturnResult(sqlmock.NewResult(0, 0))
+	// No event is written for a deleted or changed note.
+	ts.mock.ExpectRollback()
+
+	actualSequence, actualOk := ts.noteRepository.UpdateContent(id, 4, "abc")
+
+	assert.Equal(ts.T(), false, actualOk)
+	assert.Equal(ts.T(), uint64(0), actualSequence)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_UpdateIfUnchanged() {
+	for _, td := range []struct {
+		title            string
+		rowsAffected     int64
+		expectedSequence uint64
+		expectedOk       bool
+	}{
+		{
+			title:            "Returns new sequence and true if updated",
+			rowsAffected:     1,
+			expectedSequence: 10,
+			expectedOk:       true,
+		},
+		{
+			title:            "Returns false if changed since the sequence",
+			rowsAffected:     0,
+			expectedSequence: 0,
+			expectedOk:       false,
+		},
+	} {
+		ts.Run("UpdateIfUnchanged: "+td.title, func() {
+			var (
+				id   uint64 = 1
+				note        = Note{Title: "test_title", Sequence: 10}
+			)
+			ts.mock.ExpectBegin()
+			ts.mock.ExpectCommit()
+			query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Model(&Note{ID: id}).Where("sequence = ?", 4).Select(noteColumns).Updates(&note).Statement.SQL.String()
+			ts.mock.ExpectBegin()
+			ts.expectNextSequence(10)
+			ts.expectTrackUsage(id, -1)
+			ts.mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, td.rowsAffected))
+			if td.expectedOk {
+				ts.expectTrackUsage(id, 1)
+				ts.expectCreateEvent(NOTE_UPDATED, id)
+				ts.mock.ExpectCommit()
+			} else {
+				ts.mock.ExpectRollback()
+			}
+
+			actualSequence, actualOk := ts.noteRepository.UpdateIfUnchanged(id, 4, Note{Title: "test_title"})
+
+			assert.Equal(ts.T(), td.expectedOk, actualOk)
+			assert.Equal(ts.T(), td.expectedSequence, actualSequence)
+			assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+		})
+	}
+}
+
 func (ts *NoteRepositoryTestSuite) TestNoteRepository_Delete_success() {
 	var (
 		id uint64 = 1
 	)
 	ts.mock.ExpectBegin()
 	ts.mock.ExpectCommit()
-	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Delete(&Note{}, id).Statement.SQL.String()
+	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Model(&Note{ID: id}).Updates(tombstone(10)).Statement.SQL.String()
 	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
+	ts.expectTrackUsage(id, -1)
 	ts.mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.expectCreateEvent(NOTE_DELETED, id)
 	ts.mock.ExpectCommit()
 
 	actualOk := ts.noteRepository.Delete(id)
 
 	assert.Equal(ts.T(), true, actualOk)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
 }
 
 func (ts *NoteRepositoryTestSuite) TestNoteRepository_Delete_failed() {
@@ -203,16 +485,86 @@ func (ts *NoteRepositoryTestSuite) TestNoteRepository_Delete_failed() {
 	)
 	ts.mock.ExpectBegin()
 	ts.mock.ExpectCommit()
-	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Delete(&Note{}, id).Statement.SQL.String()
+	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Model(&Note{ID: id}).Updates(tombstone(10)).Statement.SQL.String()
 	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
+	ts.expectTrackUsage(id, -1)
 	ts.mock.ExpectExec(query).WillReturnError(gorm.ErrInvalidDB)
-	ts.mock.ExpectCommit()
+	ts.mock.ExpectRollback()
 
 	actualOk := ts.noteRepository.Delete(id)
 
 	assert.Equal(ts.T(), false, actualOk)
 }
 
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_DeleteIfUnchanged() {
+	var (
+		id uint64 = 1
+	)
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectCommit()
+	query := ts.noteRepository.db.Session(&gorm.Session{DryRun: true}).Model(&Note{ID: id}).Where("sequence = ?", 4).Updates(tombstone(10)).Statement.SQL.String()
+	ts.mock.ExpectBegin()
+	ts.expectNextSequence(10)
+	ts.expectTrackUsage(id, -1)
+	ts.mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.expectCreateEvent(NOTE_DELETED, id)
+	ts.mock.ExpectCommit()
+
+	actualSequence, actualOk := ts.noteRepository.DeleteIfUnchanged(id, 4)
+
+	assert.Equal(ts.T(), true, actualOk)
+	assert.Equal(ts.T(), uint64(10), actualSequence)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_Transaction() {
+	for _, td := range []struct {
+		title      string
+		commit     bool
+		expectedOk bool
+	}{
+		{
+			title:      "Commits if fn returns true",
+			commit:     true,
+			expectedOk: true,
+		},
+		{
+			title:      "Rolls back if fn returns false",
+			commit:     false,
+			expectedOk: false,
+		},
+	} {
+		ts.Run("Transaction: "+td.title, func() {
+			ts.mock.ExpectBegin()
+			if td.commit {
+				ts.mock.ExpectCommit()
+			} else {
+				ts.mock.ExpectRollback()
+			}
+
+			actualOk := ts.noteRepository.Transaction(func(noteRepository INoteRepository) bool {
+				assert.NotEqual(ts.T(), &ts.noteRepository, noteRepository)
+				return td.commit
+			})
+
+			assert.Equal(ts.T(), td.expectedOk, actualOk)
+			assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func (ts *NoteRepositoryTestSuite) TestNoteRepository_Usage() {
+	rows := sqlmock.NewRows([]string{"workspace", "notes", "bytes"}).AddRow(DEFAULT_WORKSPACE, 3, 120)
+	ts.mock.ExpectQuery(`SELECT * FROM "quota_usages" WHERE workspace = $1 LIMIT 1`).
+		WithArgs(DEFAULT_WORKSPACE).WillReturnRows(rows)
+
+	usage, ok := ts.noteRepository.Usage()
+
+	assert.Equal(ts.T(), true, ok)
+	assert.Equal(ts.T(), QuotaUsage{DEFAULT_WORKSPACE, 3, 120}, usage)
+}
+
 func TestNoteRepositoryTestSuite(t *testing.T) {
 	suite.Run(t, new(NoteRepositoryTestSuite))
 }
@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+const (
+	MAX_TITLE_LENGTH   = 200
+	MAX_CONTENT_LENGTH = 100000
+	MAX_TAGS           = 20
+	MAX_TAG_LENGTH     = 50
+)
+
+// NoteRequest is the body of requests creating or updating a note. Titles
+// are a single line without control characters, and tags are words without
+// spaces or commas.
+type NoteRequest struct {
+	ID        uint64     `json:"id" doc:"Must not be specified"`
+	Title     string     `json:"title" validate:"required;maxLength=200;pattern=^[^\\x00-\\x1f\\x7f]*$"`
+	Content   string     `json:"content" validate:"maxLength=100000"`
+	Tags      []string   `json:"tags" validate:"maxItems=20;required;maxLength=50;pattern=^[^\\s,]*$"`
+	Completed bool       `json:"completed"`
+	Due       *time.Time `json:"due,omitempty"`
+}
+
+func (nr *NoteRequest) Note() Note {
+	return Note{
+		ID:        nr.ID,
+		Title:     nr.Title,
+		Content:   nr.Content,
+		Tags:      uniqueTags(nr.Tags),
+		Completed: nr.Completed,
+		Due:       nr.Due,
+	}
+}
@@ -1,56 +1,252 @@
 package main
 
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
 const (
 	UNSPECIFIED_ID uint64 = 0
 )
 
 type INoteService interface {
+	WithContext(ctx context.Context) INoteService
 	Get() []Note
 	GetById(id uint64) (Note, bool)
+	GetByIds(ids []uint64) []Note
+	GetByCalendarName(name string) (Note, bool)
+	Search(query string, tag string) []Note
+	GetAllInBatches(size int, fn func(notes []Note) error) error
+	Count() (int64, bool)
 	Create(note Note) (uint64, error)
 	Update(id uint64, note Note) (uint64, error)
+	UpdateIfUnchanged(id uint64, sequence uint64, note Note) (uint64, error)
+	UpdateContent(id uint64, sequence uint64, content string) (uint64, error)
 	Delete(id uint64) bool
+	Batch(operations []BatchOperation, atomic bool) ([]BatchResult, bool)
+	Usage() (UsageResponse, bool)
 }
 
 type NoteService struct {
-	noteRepository INoteRepository
+	noteRepository     INoteRepository
+	noteEventPublisher INoteEventPublisher
+	quota              QuotaConfig
+	ctx                context.Context
+}
+
+// WithContext returns the service logging, and querying the database, with
+// the logger in the context of a request.
+func (ns *NoteService) WithContext(ctx context.Context) INoteService {
+	return &NoteService{ns.noteRepository.WithContext(ctx), ns.noteEventPublisher, ns.quota, ctx}
+}
+
+// traced starts a span of the method, and returns the service working in it
+// so that its queries are traced as children.
+func (ns *NoteService) traced(method string, attributes ...attribute.KeyValue) (*NoteService, trace.Span) {
+	ctx, span := startSpan(ns.ctx, "NoteService."+method, trace.WithAttributes(attributes...))
+	return &NoteService{ns.noteRepository.WithContext(ctx), ns.noteEventPublisher, ns.quota, ctx}, span
+}
+
+func noteIdAttribute(id uint64) attribute.KeyValue {
+	return attribute.Int64("note.id", int64(id))
+}
+
+// publishNoteEvents tells the publisher, if any, that changes have been
+// committed.
+func publishNoteEvents(publisher INoteEventPublisher) {
+	if publisher != nil {
+		publisher.Publish()
+	}
+}
+
+// withinQuota makes the change with fn within the quota of the service.
+func (ns *NoteService) withinQuota(fn func(noteRepository INoteRepository) bool) (bool, error) {
+	return ns.quota.within(ns.noteRepository, fn)
 }
 
 func (ns *NoteService) Get() []Note {
-	notes := ns.noteRepository.GetAll()
+	traced, span := ns.traced("Get")
+	defer span.End()
+	notes := traced.noteRepository.GetAll()
 	return notes
 }
 
 func (ns *NoteService) GetById(id uint64) (Note, bool) {
-	note, found := ns.noteRepository.GetById(id)
+	traced, span := ns.traced("GetById", noteIdAttribute(id))
+	defer span.End()
+	note, found := traced.noteRepository.GetById(id)
 	return note, found
 }
 
+func (ns *NoteService) GetByIds(ids []uint64) []Note {
+	traced, span := ns.traced("GetByIds", attribute.Int("note.count", len(ids)))
+	defer span.End()
+	return traced.noteRepository.GetByIds(ids)
+}
+
+func (ns *NoteService) GetByCalendarName(name string) (Note, bool) {
+	traced, span := ns.traced("GetByCalendarName")
+	defer span.End()
+	return traced.noteRepository.GetByCalendarName(name)
+}
+
+func (ns *NoteService) Search(query string, tag string) []Note {
+	traced, span := ns.traced("Search")
+	defer span.End()
+	return traced.noteRepository.Search(query, tag)
+}
+
+func (ns *NoteService) GetAllInBatches(size int, fn func(notes []Note) error) error {
+	traced, span := ns.traced("GetAllInBatches")
+	defer span.End()
+	err := traced.noteRepository.GetAllInBatches(size, fn)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (ns *NoteService) Count() (int64, bool) {
+	traced, span := ns.traced("Count")
+	defer span.End()
+	return traced.noteRepository.Count()
+}
+
 func (ns *NoteService) Create(note Note) (uint64, error) {
+	traced, span := ns.traced("Create")
+	defer span.End()
 	if note.ID != UNSPECIFIED_ID {
+		span.SetStatus(codes.Error, "ID must not be specified")
 		return UNSPECIFIED_ID, &IllegalIdError{}
 	}
 
-	id, ok := ns.noteRepository.Create(note)
+	var id uint64
+	ok, err := traced.withinQuota(func(noteRepository INoteRepository) bool {
+		var ok bool
+		id, ok = noteRepository.Create(note)
+		return ok
+	})
+	if err != nil {
+		LoggerFrom(traced.ctx).Info("Quota exceeded", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+		return UNSPECIFIED_ID, err
+	}
 	if !ok {
+		LoggerFrom(traced.ctx).Error("Failed to create note")
+		span.SetStatus(codes.Error, "Failed to create note")
 		return UNSPECIFIED_ID, &InternalError{}
 	}
+	span.SetAttributes(noteIdAttribute(id))
+	LoggerFrom(traced.ctx).Info("Created note", "note_id", id)
+	publishNoteEvents(ns.noteEventPublisher)
 	return id, nil
 }
 
 func (ns *NoteService) Update(id uint64, note Note) (uint64, error) {
+	traced, span := ns.traced("Update", noteIdAttribute(id))
+	defer span.End()
 	if note.ID != UNSPECIFIED_ID {
+		span.SetStatus(codes.Error, "ID must not be specified")
 		return UNSPECIFIED_ID, &IllegalIdError{}
 	}
+	return traced.update(span, id, func(noteRepository INoteRepository) bool {
+		_, ok := noteRepository.Update(id, note)
+		return ok
+	})
+}
+
+// UpdateIfUnchanged updates the note if it is still at the sequence, as
+// conditional requests do. It returns the new sequence, or a
+// NoteConflictError if the note has been changed or deleted since.
+func (ns *NoteService) UpdateIfUnchanged(id uint64, sequence uint64, note Note) (uint64, error) {
+	traced, span := ns.traced("UpdateIfUnchanged", noteIdAttribute(id))
+	defer span.End()
+	if note.ID != UNSPECIFIED_ID {
+		span.SetStatus(codes.Error, "ID must not be specified")
+		return UNSPECIFIED_ID, &IllegalIdError{}
+	}
+	return traced.updateIfUnchanged(span, id, sequence, func(noteRepository INoteRepository) (uint64, bool) {
+		return noteRepository.UpdateIfUnchanged(id, sequence, note)
+	})
+}
+
+// UpdateContent changes only the content of the note, as collaborative
+// editing sessions do, if the note is still at the sequence. It returns the
+// new sequence, or a NoteConflictError if the note has been changed or
+// deleted since.
+func (ns *NoteService) UpdateContent(id uint64, sequence uint64, content string) (uint64, error) {
+	traced, span := ns.traced("UpdateContent", noteIdAttribute(id))
+	defer span.End()
+	return traced.updateIfUnchanged(span, id, sequence, func(noteRepository INoteRepository) (uint64, bool) {
+		return noteRepository.UpdateContent(id, sequence, content)
+	})
+}
+
+// updateIfUnchanged makes the change of the note at the sequence with fn,
+// which returns the new sequence, within the quota.
+func (ns *NoteService) updateIfUnchanged(span trace.Span, id uint64, sequence uint64, fn func(noteRepository INoteRepository) (uint64, bool)) (uint64, error) {
+	var newSequence uint64
+	_, err := ns.update(span, id, func(noteRepository INoteRepository) bool {
+		var ok bool
+		newSequence, ok = fn(noteRepository)
+		return ok
+	})
+	var internalError *InternalError
+	if errors.As(err, &internalError) {
+		if current, found := ns.noteRepository.GetChangeById(id); !found || current.DeletedAt.Valid || current.Sequence != sequence {
+			return 0, &NoteConflictError{}
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newSequence, nil
+}
 
-	id, ok := ns.noteRepository.Update(id, note)
+// update makes the change of the note with fn within the quota.
+func (ns *NoteService) update(span trace.Span, id uint64, fn func(noteRepository INoteRepository) bool) (uint64, error) {
+	ok, err := ns.withinQuota(fn)
+	if err != nil {
+		LoggerFrom(ns.ctx).Info("Quota exceeded", "note_id", id, "error", err)
+		span.SetStatus(codes.Error, err.Error())
+		return UNSPECIFIED_ID, err
+	}
 	if !ok {
+		LoggerFrom(ns.ctx).Error("Failed to update note", "note_id", id)
+		span.SetStatus(codes.Error, "Failed to update note")
 		return UNSPECIFIED_ID, &InternalError{}
 	}
+	LoggerFrom(ns.ctx).Info("Updated note", "note_id", id)
+	publishNoteEvents(ns.noteEventPublisher)
 	return id, nil
 }
 
 func (ns *NoteService) Delete(id uint64) bool {
-	ok := ns.noteRepository.Delete(id)
-	return ok
+	traced, span := ns.traced("Delete", noteIdAttribute(id))
+	defer span.End()
+	ok := traced.noteRepository.Delete(id)
+	if !ok {
+		LoggerFrom(traced.ctx).Error("Failed to delete note", "note_id", id)
+		span.SetStatus(codes.Error, "Failed to delete note")
+		return false
+	}
+	LoggerFrom(traced.ctx).Info("Deleted note", "note_id", id)
+	publishNoteEvents(ns.noteEventPublisher)
+	return true
+}
+
+// Usage returns the usage of the workspace against its quota.
+func (ns *NoteService) Usage() (UsageResponse, bool) {
+	traced, span := ns.traced("Usage")
+	defer span.End()
+	usage, ok := traced.noteRepository.Usage()
+	if !ok {
+		span.SetStatus(codes.Error, "Failed to get usage")
+		return UsageResponse{}, false
+	}
+	return ns.quota.usageResponse(usage), true
 }
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
 type MockRepository struct {
 	mock.Mock
 }
 
+func (mr *MockRepository) WithContext(ctx context.Context) INoteRepository {
+	return mr
+}
+
 func (mr *MockRepository) GetAll() []Note {
 	ret := mr.Called()
 	return ret.Get(0).([]Note)
@@ -21,6 +28,47 @@ func (mr *MockRepository) GetById(id uint64) (Note, bool) {
 	return ret.Get(0).(Note), ret.Get(1).(bool)
 }
 
+func (mr *MockRepository) GetByIds(ids []uint64) []Note {
+	ret := mr.Called(ids)
+	return ret.Get(0).([]Note)
+}
+
+func (mr *MockRepository) GetByCalendarName(name string) (Note, bool) {
+	ret := mr.Called(name)
+	return ret.Get(0).(Note), ret.Get(1).(bool)
+}
+
+func (mr *MockRepository) Search(query string, tag string) []Note {
+	ret := mr.Called(query, tag)
+	return ret.Get(0).([]Note)
+}
+
+// GetAllInBatches calls fn with each batch returned by the mock.
+func (mr *MockRepository) GetAllInBatches(size int, fn func(notes []Note) error) error {
+	ret := mr.Called(size)
+	for _, notes := range ret.Get(0).([][]Note) {
+		if err := fn(notes); err != nil {
+			return err
+		}
+	}
+	return ret.Error(1)
+}
+
+func (mr *MockRepository) Count() (int64, bool) {
+	ret := mr.Called()
+	return ret.Get(0).(int64), ret.Get(1).(bool)
+}
+
+func (mr *MockRepository) GetChangedSince(sequence uint64) []Note {
+	ret := mr.Called(sequence)
+	return ret.Get(0).([]Note)
+}
+
+func (mr *MockRepository) GetChangeById(id uint64) (Note, bool) {
+	ret := mr.Called(id)
+	return ret.Get(0).(Note), ret.Get(1).(bool)
+}
+
 func (mr *MockRepository) Create(note Note) (uint64, bool) {
 	ret := mr.Called(note)
 	return ret.Get(0).(uint64), ret.Get(1).(bool)
@@ -31,14 +79,39 @@ func (mr *MockRepository) Update(id uint64, note Note) (uint64, bool) {
 	return ret.Get(0).(uint64), ret.Get(1).(bool)
 }
 
+func (mr *MockRepository) UpdateContent(id uint64, sequence uint64, content string) (uint64, bool) {
+	ret := mr.Called(id, sequence, content)
+	return ret.Get(0).(uint64), ret.Get(1).(bool)
+}
+
+func (mr *MockRepository) UpdateIfUnchanged(id uint64, sequence uint64, note Note) (uint64, bool) {
+	ret := mr.Called(id, sequence, note)
+	return ret.Get(0).(uint64), ret.Get(1).(bool)
+}
+
 func (mr *MockRepository) Delete(id uint64) bool {
 	ret := mr.Called(id)
 	return ret.Get(0).(bool)
 }
 
+func (mr *MockRepository) Transaction(fn func(noteRepository INoteRepository) bool) bool {
+	mr.Called()
+	return fn(mr)
+}
+
+func (mr *MockRepository) DeleteIfUnchanged(id uint64, sequence uint64) (uint64, bool) {
+	ret := mr.Called(id, sequence)
+	return ret.Get(0).(uint64), ret.Get(1).(bool)
+}
+
+func (mr *MockRepository) Usage() (QuotaUsage, bool) {
+	ret := mr.Called()
+	return ret.Get(0).(QuotaUsage), ret.Get(1).(bool)
+}
+
 func TestNoteService_Get(t *testing.T) {
 	mockRepository := &MockRepository{}
-	noteService := NoteService{mockRepository}
+	noteService := NoteService{noteRepository: mockRepository}
 
 	notes := []Note{
 		{
@@ -80,7 +153,7 @@ func TestNoteService_GetById(t *testing.T) {
 	} {
 		t.Run("GetById: " + td.title, func(t *testing.T) {
 			mockRepository := &MockRepository{}
-			noteService := NoteService{mockRepository}
+			noteService := NoteService{noteRepository: mockRepository}
 
 			mockRepository.On("GetById", td.inputId).Return(td.outputNote, td.outputOk)
 
@@ -91,6 +164,32 @@ func TestNoteService_GetById(t *testing.T) {
 	}
 }
 
+func TestNoteService_GetByIds(t *testing.T) {
+	mockRepository := &MockRepository{}
+	noteService := NoteService{noteRepository: mockRepository}
+
+	notes := []Note{{ID: 2, Title: "title2"}, {ID: 1, Title: "title"}}
+	mockRepository.On("GetByIds", []uint64{1, 2}).Return(notes)
+
+	assert.Equal(t, notes, noteService.GetByIds([]uint64{1, 2}))
+}
+
+func TestNoteService_GetAllInBatches(t *testing.T) {
+	mockRepository := &MockRepository{}
+	noteService := NoteService{noteRepository: mockRepository}
+
+	batches := [][]Note{{{ID: 1, Title: "title"}}, {{ID: 2, Title: "title2"}}}
+	mockRepository.On("GetAllInBatches", 10).Return(batches, nil)
+
+	var got [][]Note
+	err := noteService.GetAllInBatches(10, func(notes []Note) error {
+		got = append(got, notes)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, batches, got)
+}
+
 func TestNoteService_Create(t *testing.T) {
 	for _, td := range []struct {
 		title string
@@ -132,7 +231,7 @@ func TestNoteService_Create(t *testing.T) {
 	} {
 		t.Run("Create: " + td.title, func(t *testing.T) {
 			mockRepository := &MockRepository{}
-			noteService := NoteService{mockRepository}
+			noteService := NoteService{noteRepository: mockRepository}
 
 			mockRepository.On("Create", td.inputNote).Return(td.outputId, td.okFromRepository)
 
@@ -187,7 +286,7 @@ func TestNoteService_Update(t *testing.T) {
 	} {
 		t.Run("Update: " + td.title, func(t *testing.T) {
 			mockRepository := &MockRepository{}
-			noteService := NoteService{mockRepository}
+			noteService := NoteService{noteRepository: mockRepository}
 
 			mockRepository.On("Update", td.inputId, td.inputNote).Return(td.outputId, td.okFromRepository)
 
@@ -197,6 +296,71 @@ func TestNoteService_Update(t *testing.T) {
 	}
 }
 
+func TestNoteService_UpdateContent(t *testing.T) {
+	deletedAt := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	for _, td := range []struct {
+		title            string
+		outputOk         bool
+		current          Note
+		found            bool
+		expectedSequence uint64
+		expectedError    error
+	}{
+		{"Returns the new sequence", true, Note{}, false, 5, nil},
+		{"Returns NoteConflictError if changed since", false, Note{ID: 1, Sequence: 6}, true, 0, &NoteConflictError{}},
+		{"Returns NoteConflictError if deleted", false, Note{ID: 1, Sequence: 4, DeletedAt: deletedAt}, true, 0, &NoteConflictError{}},
+		{"Returns NoteConflictError if not found", false, Note{}, false, 0, &NoteConflictError{}},
+		{"Returns InternalError otherwise", false, Note{ID: 1, Sequence: 4}, true, 0, &InternalError{}},
+	} {
+		t.Run("UpdateContent: "+td.title, func(t *testing.T) {
+			mockRepository := &MockRepository{}
+			noteService := NoteService{noteRepository: mockRepository}
+			sequence := uint64(0)
+			if td.outputOk {
+				sequence = 5
+			}
+			mockRepository.On("UpdateContent", uint64(1), uint64(4), "abc").Return(sequence, td.outputOk)
+			mockRepository.On("GetChangeById", uint64(1)).Return(td.current, td.found)
+
+			actualSequence, err := noteService.UpdateContent(1, 4, "abc")
+
+			assert.Equal(t, td.expectedSequence, actualSequence)
+			assert.Equal(t, td.expectedError, err)
+		})
+	}
+}
+
+func TestNoteService_UpdateIfUnchanged(t *testing.T) {
+	for _, td := range []struct {
+		title            string
+		inputNote        Note
+		outputOk         bool
+		current          Note
+		expectedSequence uint64
+		expectedError    error
+	}{
+		{"Returns the new sequence", Note{Title: "title"}, true, Note{}, 5, nil},
+		{"Returns NoteConflictError if changed since", Note{Title: "title"}, false, Note{ID: 1, Sequence: 6}, 0, &NoteConflictError{}},
+		{"Returns IllegalIdError if ID is specified", Note{ID: 2, Title: "title"}, false, Note{}, 0, &IllegalIdError{}},
+	} {
+		t.Run("UpdateIfUnchanged: "+td.title, func(t *testing.T) {
+			mockRepository := &MockRepository{}
+			noteService := NoteService{noteRepository: mockRepository}
+			sequence := uint64(0)
+			if td.outputOk {
+				sequence = 5
+			}
+			mockRepository.On("UpdateIfUnchanged", uint64(1), uint64(4), td.inputNote).Return(sequence, td.outputOk)
+			mockRepository.On("GetChangeById", uint64(1)).Return(td.current, true)
+
+			actualSequence, err := noteService.UpdateIfUnchanged(1, 4, td.inputNote)
+
+			assert.Equal(t, td.expectedSequence, actualSequence)
+			assert.Equal(t, td.expectedError, err)
+		})
+	}
+}
+
 func TestNoteService_Delete(t *testing.T) {
 	for _, td := range []struct {
 		title string
@@ -216,7 +380,7 @@ func TestNoteService_Delete(t *testing.T) {
 	} {
 		t.Run("GetById: " + td.title, func(t *testing.T) {
 			mockRepository := &MockRepository{}
-			noteService := NoteService{mockRepository}
+			noteService := NoteService{noteRepository: mockRepository}
 
 			mockRepository.On("Delete", td.inputId).Return(td.outputOk)
 
@@ -224,4 +388,79 @@ func TestNoteService_Delete(t *testing.T) {
 			assert.Equal(t, td.outputOk, ok)
 		})
 	}
-}
\ No newline at end of file
+}
+type MockEventPublisher struct {
+	mock.Mock
+}
+
+func (mp *MockEventPublisher) Publish() {
+	mp.Called()
+}
+
+func TestNoteService_publishesEvents(t *testing.T) {
+	mockRepository := &MockRepository{}
+	mockPublisher := &MockEventPublisher{}
+	noteService := NoteService{noteRepository: mockRepository, noteEventPublisher: mockPublisher}
+
+	mockRepository.On("Create", Note{Title: "test_title"}).Return(uint64(1), true)
+	mockRepository.On("Update", uint64(1), Note{Title: "test_title2"}).Return(uint64(1), true)
+	mockRepository.On("Update", uint64(2), Note{Title: "test_title2"}).Return(UNSPECIFIED_ID, false)
+	mockRepository.On("Delete", uint64(1)).Return(true)
+	mockPublisher.On("Publish").Return()
+
+	noteService.Create(Note{Title: "test_title"})
+	noteService.Update(1, Note{Title: "test_title2"})
+	noteService.Update(2, Note{Title: "test_title2"})
+	noteService.Delete(1)
+
+	// Only the changes committed are published.
+	mockPublisher.AssertNumberOfCalls(t, "Publish", 3)
+}
+
+func TestNoteService_Create_quota(t *testing.T) {
+	for _, td := range []struct {
+		title         string
+		after         QuotaUsage
+		expectedId    uint64
+		expectedError error
+	}{
+		{
+			title:      "Creates the note within the quota",
+			after:      QuotaUsage{DEFAULT_WORKSPACE, 2, 20},
+			expectedId: 1,
+		},
+		{
+			title:         "Rolls back and returns QuotaExceededError over the quota",
+			after:         QuotaUsage{DEFAULT_WORKSPACE, 3, 30},
+			expectedId:    UNSPECIFIED_ID,
+			expectedError: &QuotaExceededError{QUOTA_NOTES, UsageResponse{DEFAULT_WORKSPACE, QuotaAmount{2, 2}, QuotaAmount{10, 0}}},
+		},
+	} {
+		t.Run("Create: "+td.title, func(t *testing.T) {
+			mockRepository := &MockRepository{}
+			noteService := NoteService{noteRepository: mockRepository, quota: QuotaConfig{MaxNotes: 2}}
+			note := Note{Title: "test_title", Content: "test_content"}
+			mockRepository.On("Transaction").Return()
+			mockRepository.On("Usage").Return(QuotaUsage{DEFAULT_WORKSPACE, 2, 10}, true).Once()
+			mockRepository.On("Create", note).Return(uint64(1), true)
+			mockRepository.On("Usage").Return(td.after, true).Once()
+
+			id, err := noteService.Create(note)
+
+			assert.Equal(t, td.expectedId, id)
+			assert.Equal(t, td.expectedError, err)
+			mockRepository.AssertExpectations(t)
+		})
+	}
+}
+
+func TestNoteService_Usage(t *testing.T) {
+	mockRepository := &MockRepository{}
+	noteService := NoteService{noteRepository: mockRepository, quota: QuotaConfig{MaxBytes: 1000}}
+	mockRepository.On("Usage").Return(QuotaUsage{DEFAULT_WORKSPACE, 3, 120}, true)
+
+	usage, ok := noteService.Usage()
+
+	assert.True(t, ok)
+	assert.Equal(t, UsageResponse{DEFAULT_WORKSPACE, QuotaAmount{3, 0}, QuotaAmount{120, 1000}}, usage)
+}
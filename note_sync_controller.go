@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type INoteSyncController interface {
+	Get(c *gin.Context)
+	Apply(c *gin.Context)
+}
+
+type NoteSyncController struct {
+	noteSyncService INoteSyncService
+}
+
+type SyncChangesResponse struct {
+	Token   string     `json:"token"`
+	Changes []SyncNote `json:"changes"`
+}
+
+// MAX_SYNC_CHANGES limits the changes of a sync request like the operations
+// of a batch.
+const MAX_SYNC_CHANGES = MAX_BATCH_OPERATIONS
+
+type SyncRequest struct {
+	Changes []SyncChange `json:"changes"`
+}
+
+type SyncResultsResponse struct {
+	Results []SyncResult `json:"results"`
+}
+
+// Tokens are opaque to clients; they are the sequence of the last change.
+func getSequenceFromToken(token string) (uint64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(token, 10, 64)
+}
+
+func (sc *NoteSyncController) service(c *gin.Context) INoteSyncService {
+	return sc.noteSyncService.WithContext(c.Request.Context())
+}
+
+func (sc *NoteSyncController) Get(c *gin.Context) {
+	since, err := getSequenceFromToken(c.Query("since"))
+	if err != nil {
+		RespondProblem(c, InvalidParameterProblem("since", "must be a token returned by sync"))
+		return
+	}
+	changes, last := sc.service(c).GetChanges(since)
+	response := SyncChangesResponse{strconv.FormatUint(last, 10), changes}
+	c.IndentedJSON(http.StatusOK, response)
+}
+
+func (sc *NoteSyncController) Apply(c *gin.Context) {
+	var request SyncRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		RespondProblem(c, InvalidRequestBodyProblem(err))
+		return
+	}
+	if len(request.Changes) > MAX_SYNC_CHANGES {
+		problem := InvalidRequestBodyProblem(nil)
+		problem.Detail = "Too many changes"
+		problem.Errors = []FieldError{{"changes", "must have at most " + strconv.Itoa(MAX_SYNC_CHANGES) + " items"}}
+		RespondProblem(c, problem)
+		return
+	}
+	results := sc.service(c).Apply(request.Changes)
+	c.IndentedJSON(http.StatusOK, SyncResultsResponse{results})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockSyncService struct {
+	mock.Mock
+}
+
+func (ms *MockSyncService) WithContext(ctx context.Context) INoteSyncService {
+	return ms
+}
+
+func (ms *MockSyncService) GetChanges(since uint64) ([]SyncNote, uint64) {
+	ret := ms.Called(since)
+	return ret.Get(0).([]SyncNote), ret.Get(1).(uint64)
+}
+
+func (ms *MockSyncService) Apply(changes []SyncChange) []SyncResult {
+	ret := ms.Called(changes)
+	return ret.Get(0).([]SyncResult)
+}
+
+func TestNoteSyncController_Get(t *testing.T) {
+	for _, td := range []struct {
+		title                  string
+		query                  string
+		inputSince             uint64
+		outputChanges          []SyncNote
+		outputLast             uint64
+		expectedStatus         int
+		expectedResponseObject interface{}
+	}{
+		{
+			title:          "Returns changes and next token",
+			query:          "?since=3",
+			inputSince:     3,
+			outputChanges:  []SyncNote{{ID: 1, Sequence: 4, Deleted: true}},
+			outputLast:     4,
+			expectedStatus: http.StatusOK,
+			expectedResponseObject: &SyncChangesResponse{
+				Token:   "4",
+				Changes: []SyncNote{{ID: 1, Sequence: 4, Deleted: true}},
+			},
+		},
+		{
+			title:          "Returns all notes without token",
+			inputSince:     0,
+			outputChanges:  []SyncNote{},
+			outputLast:     0,
+			expectedStatus: http.StatusOK,
+			expectedResponseObject: &SyncChangesResponse{
+				Token:   "0",
+				Changes: []SyncNote{},
+			},
+		},
+		{
+			title:          "Returns \"Invalid parameter\" problem if token is invalid",
+			query:          "?since=xxx",
+			expectedStatus: http.StatusBadRequest,
+			expectedResponseObject: problemAt(InvalidParameterProblem("since", "must be a token returned by sync"), "/sync"),
+		},
+	} {
+		t.Run("Get: "+td.title, func(t *testing.T) {
+			var (
+				mockService        = &MockSyncService{}
+				noteSyncController = NoteSyncController{mockService}
+				response           = httptest.NewRecorder()
+				ginContext, _      = gin.CreateTestContext(response)
+				req, _             = http.NewRequest("GET", "/sync"+td.query, nil)
+			)
+
+			mockService.On("GetChanges", td.inputSince).Return(td.outputChanges, td.outputLast)
+
+			ginContext.Request = req
+
+			noteSyncController.Get(ginContext)
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			expected, _ := json.MarshalIndent(td.expectedResponseObject, "", "    ")
+			assert.Equal(t, expected, response.Body.Bytes())
+		})
+	}
+}
+
+func TestNoteSyncController_Apply(t *testing.T) {
+	for _, td := range []struct {
+		title                  string
+		requestBody            []byte
+		inputChanges           []SyncChange
+		outputResults          []SyncResult
+		expectedStatus         int
+		expectedResponseObject interface{}
+	}{
+		{
+			title:          "Returns results of changes",
+			requestBody:    []byte(`{"changes":[{"id":1,"sequence":2,"deleted":true}]}`),
+			inputChanges:   []SyncChange{{ID: 1, Sequence: 2, Deleted: true}},
+			outputResults:  []SyncResult{{Status: SYNC_CONFLICT, Note: &SyncNote{ID: 1, Title: "title", Sequence: 3}}},
+			expectedStatus: http.StatusOK,
+			expectedResponseObject: &SyncResultsResponse{
+				Results: []SyncResult{{Status: SYNC_CONFLICT, Note: &SyncNote{ID: 1, Title: "title", Sequence: 3}}},
+			},
+		},
+		{
+			title:          "Returns \"Invalid request body\" problem if request body is invalid",
+			requestBody:    []byte("{"),
+			expectedStatus: http.StatusBadRequest,
+			expectedResponseObject: problemAt(InvalidRequestBodyProblem(&json.SyntaxError{}), "/sync"),
+		},
+		{
+			title:          "Returns \"Invalid request body\" problem if there are too many changes",
+			requestBody:    []byte(`{"changes":[` + strings.Repeat(`{"title":"t"},`, MAX_SYNC_CHANGES) + `{"title":"t"}]}`),
+			expectedStatus: http.StatusBadRequest,
+			expectedResponseObject: func() *Problem {
+				problem := InvalidRequestBodyProblem(nil)
+				problem.Detail = "Too many changes"
+				problem.Errors = []FieldError{{"changes", "must have at most 1000 items"}}
+				return problemAt(problem, "/sync")
+			}(),
+		},
+	} {
+		t.Run("Apply: "+td.title, func(t *testing.T) {
+			var (
+				mockService        = &MockSyncService{}
+				noteSyncController = NoteSyncController{mockService}
+				response           = httptest.NewRecorder()
+				ginContext, _      = gin.CreateTestContext(response)
+				req, _             = http.NewRequest("POST", "/sync", bytes.NewBuffer(td.requestBody))
+			)
+
+			mockService.On("Apply", td.inputChanges).Return(td.outputResults)
+
+			ginContext.Request = req
+
+			noteSyncController.Apply(ginContext)
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			expected, _ := json.MarshalIndent(td.expectedResponseObject, "", "    ")
+			assert.Equal(t, expected, response.Body.Bytes())
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	SYNC_CREATED   = "created"
+	SYNC_UPDATED   = "updated"
+	SYNC_DELETED   = "deleted"
+	SYNC_CONFLICT  = "conflict"
+	SYNC_NOT_FOUND = "not_found"
+	SYNC_INVALID   = "invalid"
+	SYNC_FAILED    = "failed"
+
+	SYNC_QUOTA_EXCEEDED = "quota_exceeded"
+)
+
+// SyncNote is a note as seen by offline-capable clients. A deleted note is
+// returned as a tombstone with only its ID and sequence.
+type SyncNote struct {
+	ID        uint64     `json:"id"`
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	Tags      Tags       `json:"tags"`
+	Completed bool       `json:"completed"`
+	Due       *time.Time `json:"due,omitempty"`
+	Sequence  uint64     `json:"sequence"`
+	Deleted   bool       `json:"deleted"`
+}
+
+// SyncChange is a change made by a client. ID is 0 for a new note, and
+// Sequence is the sequence of the note the change was based on. A change
+// without tags, completion or due date keeps those of the note, for clients
+// that do not know them; NoDue removes the due date.
+type SyncChange struct {
+	ID        uint64     `json:"id" doc:"0 to create a new note"`
+	Title     string     `json:"title" validate:"maxLength=200;pattern=^[^\\x00-\\x1f\\x7f]*$" doc:"Required unless deleted"`
+	Content   string     `json:"content" validate:"maxLength=100000"`
+	Tags      []string   `json:"tags" validate:"maxItems=20;required;maxLength=50;pattern=^[^\\s,]*$" doc:"The tags of the note are kept if omitted"`
+	Completed *bool      `json:"completed" doc:"The completion of the note is kept if omitted"`
+	Due       *time.Time `json:"due,omitempty" doc:"The due date of the note is kept if omitted"`
+	NoDue     bool       `json:"no_due" doc:"Removes the due date of the note"`
+	Sequence  uint64     `json:"sequence" doc:"Sequence of the note the change was based on"`
+	Deleted   bool       `json:"deleted"`
+}
+
+// SyncResult is the result of a change. A change taking the usage over the
+// quota is not made and results in quota_exceeded.
+type SyncResult struct {
+	Status string    `json:"status" doc:"created, updated, deleted, conflict, not_found, invalid, quota_exceeded or failed"`
+	Note   *SyncNote `json:"note,omitempty"`
+}
+
+func toSyncNote(note Note) *SyncNote {
+	if note.DeletedAt.Valid {
+		return &SyncNote{ID: note.ID, Sequence: note.Sequence, Deleted: true}
+	}
+	return &SyncNote{
+		ID:        note.ID,
+		Title:     note.Title,
+		Content:   note.Content,
+		Tags:      note.Tags,
+		Completed: note.Completed,
+		Due:       note.Due,
+		Sequence:  note.Sequence,
+	}
+}
+
+type INoteSyncService interface {
+	WithContext(ctx context.Context) INoteSyncService
+	GetChanges(since uint64) ([]SyncNote, uint64)
+	Apply(changes []SyncChange) []SyncResult
+}
+
+type NoteSyncService struct {
+	noteRepository     INoteRepository
+	noteEventPublisher INoteEventPublisher
+	quota              QuotaConfig
+	ctx                context.Context
+}
+
+// WithContext returns the service querying the database with the logger in
+// the context of a request, and tracing in its span.
+func (ss *NoteSyncService) WithContext(ctx context.Context) INoteSyncService {
+	return &NoteSyncService{ss.noteRepository.WithContext(ctx), ss.noteEventPublisher, ss.quota, ctx}
+}
+
+// traced starts a span of the method, as NoteService.traced does.
+func (ss *NoteSyncService) traced(method string) (*NoteSyncService, trace.Span) {
+	ctx, span := startSpan(ss.ctx, "NoteSyncService."+method)
+	return &NoteSyncService{ss.noteRepository.WithContext(ctx), ss.noteEventPublisher, ss.quota, ctx}, span
+}
+
+// GetChanges returns the notes changed after the sequence and the sequence
+// to fetch the next changes from. Sequence 0 returns all notes without
+// tombstones.
+func (ss *NoteSyncService) GetChanges(since uint64) ([]SyncNote, uint64) {
+	traced, span := ss.traced("GetChanges")
+	defer span.End()
+	var notes []Note
+	if since == 0 {
+		notes = traced.noteRepository.GetAll()
+	} else {
+		notes = traced.noteRepository.GetChangedSince(since)
+	}
+
+	changes := make([]SyncNote, 0, len(notes))
+	for _, note := range notes {
+		changes = append(changes, *toSyncNote(note))
+		if note.Sequence > since {
+			since = note.Sequence
+		}
+	}
+	return changes, since
+}
+
+// Apply applies the changes one by one and returns the result of each. A
+// change to a note changed by someone else since the client saw it results
+// in a conflict with the current note.
+func (ss *NoteSyncService) Apply(changes []SyncChange) []SyncResult {
+	traced, span := ss.traced("Apply")
+	defer span.End()
+	span.SetAttributes(attribute.Int("sync.changes", len(changes)))
+	results := make([]SyncResult, 0, len(changes))
+	for _, change := range changes {
+		results = append(results, traced.apply(change))
+	}
+	return results
+}
+
+func (ss *NoteSyncService) apply(change SyncChange) SyncResult {
+	note := Note{Title: change.Title, Content: change.Content, Tags: uniqueTags(change.Tags), Due: change.Due}
+	if change.Completed != nil {
+		note.Completed = *change.Completed
+	}
+	// Deletions need no title, so it is not required by the rules.
+	if !change.Deleted && (Validate(&change) != nil || strings.TrimSpace(change.Title) == "") {
+		return SyncResult{Status: SYNC_INVALID}
+	}
+	if change.ID == UNSPECIFIED_ID {
+		if change.Deleted {
+			return SyncResult{Status: SYNC_INVALID}
+		}
+		var id uint64
+		ok, err := ss.quota.within(ss.noteRepository, func(noteRepository INoteRepository) bool {
+			var ok bool
+			id, ok = noteRepository.Create(note)
+			return ok
+		})
+		if err != nil {
+			return SyncResult{Status: SYNC_QUOTA_EXCEEDED}
+		}
+		if !ok {
+			return SyncResult{Status: SYNC_FAILED}
+		}
+		publishNoteEvents(ss.noteEventPublisher)
+		return ss.resultOf(SYNC_CREATED, id)
+	}
+
+	if change.Deleted {
+		sequence, ok := ss.noteRepository.DeleteIfUnchanged(change.ID, change.Sequence)
+		if !ok {
+			return ss.resultOfFailure(change)
+		}
+		publishNoteEvents(ss.noteEventPublisher)
+		return SyncResult{Status: SYNC_DELETED, Note: &SyncNote{ID: change.ID, Sequence: sequence, Deleted: true}}
+	}
+
+	keepDue := change.Due == nil && !change.NoDue
+	if change.Tags == nil || change.Completed == nil || keepDue {
+		// The note cannot change before the update, which is made only if
+		// the note is still at the sequence.
+		if current, found := ss.noteRepository.GetChangeById(change.ID); found && current.Sequence == change.Sequence {
+			if change.Tags == nil {
+				note.Tags = current.Tags
+			}
+			if change.Completed == nil {
+				note.Completed = current.Completed
+			}
+			if keepDue {
+				note.Due = current.Due
+			}
+		}
+	}
+	ok, err := ss.quota.within(ss.noteRepository, func(noteRepository INoteRepository) bool {
+		_, ok := noteRepository.UpdateIfUnchanged(change.ID, change.Sequence, note)
+		return ok
+	})
+	if err != nil {
+		return SyncResult{Status: SYNC_QUOTA_EXCEEDED}
+	}
+	if !ok {
+		return ss.resultOfFailure(change)
+	}
+	publishNoteEvents(ss.noteEventPublisher)
+	return ss.resultOf(SYNC_UPDATED, change.ID)
+}
+
+func (ss *NoteSyncService) resultOf(status string, id uint64) SyncResult {
+	current, found := ss.noteRepository.GetChangeById(id)
+	if !found {
+		return SyncResult{Status: status}
+	}
+	return SyncResult{Status: status, Note: toSyncNote(current)}
+}
+
+func (ss *NoteSyncService) resultOfFailure(change SyncChange) SyncResult {
+	current, found := ss.noteRepository.GetChangeById(change.ID)
+	if !found {
+		return SyncResult{Status: SYNC_NOT_FOUND}
+	}
+	if current.Sequence != change.Sequence || current.DeletedAt.Valid {
+		return SyncResult{Status: SYNC_CONFLICT, Note: toSyncNote(current)}
+	}
+	return SyncResult{Status: SYNC_FAILED}
+}
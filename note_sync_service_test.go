@@ -0,0 +1,171 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestNoteSyncService_GetChanges(t *testing.T) {
+	deletedAt := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	for _, td := range []struct {
+		title           string
+		since           uint64
+		method          string
+		outputNotes     []Note
+		expectedChanges []SyncNote
+		expectedLast    uint64
+	}{
+		{
+			title:  "Returns all notes if sequence is 0",
+			since:  0,
+			method: "GetAll",
+			outputNotes: []Note{
+				{ID: 1, Title: "title", Content: "content", Sequence: 3},
+				{ID: 2, Title: "title2", Content: "content2", Sequence: 2},
+			},
+			expectedChanges: []SyncNote{
+				{ID: 1, Title: "title", Content: "content", Sequence: 3},
+				{ID: 2, Title: "title2", Content: "content2", Sequence: 2},
+			},
+			expectedLast: 3,
+		},
+		{
+			title:  "Returns changes and tombstones since the sequence",
+			since:  3,
+			method: "GetChangedSince",
+			outputNotes: []Note{
+				{ID: 2, Title: "title2", Content: "content3", Sequence: 4},
+				{ID: 1, Title: "title", Content: "content", Sequence: 5, DeletedAt: deletedAt},
+			},
+			expectedChanges: []SyncNote{
+				{ID: 2, Title: "title2", Content: "content3", Sequence: 4},
+				{ID: 1, Sequence: 5, Deleted: true},
+			},
+			expectedLast: 5,
+		},
+		{
+			title:           "Returns the same sequence if nothing changed",
+			since:           5,
+			method:          "GetChangedSince",
+			outputNotes:     []Note{},
+			expectedChanges: []SyncNote{},
+			expectedLast:    5,
+		},
+	} {
+		t.Run("GetChanges: "+td.title, func(t *testing.T) {
+			mockRepository := &MockRepository{}
+			noteSyncService := NoteSyncService{noteRepository: mockRepository}
+
+			if td.method == "GetAll" {
+				mockRepository.On("GetAll").Return(td.outputNotes)
+			} else {
+				mockRepository.On("GetChangedSince", td.since).Return(td.outputNotes)
+			}
+
+			changes, last := noteSyncService.GetChanges(td.since)
+			assert.Equal(t, td.expectedChanges, changes)
+			assert.Equal(t, td.expectedLast, last)
+		})
+	}
+}
+
+func TestNoteSyncService_Apply(t *testing.T) {
+	deletedAt := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	mockRepository := &MockRepository{}
+	mockPublisher := &MockEventPublisher{}
+	noteSyncService := NoteSyncService{noteRepository: mockRepository, noteEventPublisher: mockPublisher}
+
+	mockPublisher.On("Publish").Return()
+	// Created
+	mockRepository.On("Create", Note{Title: "new"}).Return(uint64(10), true)
+	mockRepository.On("GetChangeById", uint64(10)).Return(Note{ID: 10, Title: "new", Sequence: 20}, true)
+	// Updated
+	mockRepository.On("UpdateIfUnchanged", uint64(1), uint64(5), Note{Title: "edited"}).Return(uint64(21), true)
+	mockRepository.On("GetChangeById", uint64(1)).Return(Note{ID: 1, Title: "edited", Content: "content", Sequence: 21}, true)
+	// Deleted
+	mockRepository.On("DeleteIfUnchanged", uint64(2), uint64(6)).Return(uint64(22), true)
+	// Conflict with a newer change
+	mockRepository.On("UpdateIfUnchanged", uint64(3), uint64(7), Note{Title: "stale"}).Return(uint64(0), false)
+	mockRepository.On("GetChangeById", uint64(3)).Return(Note{ID: 3, Title: "newer", Sequence: 8}, true)
+	// Conflict with a deletion
+	mockRepository.On("UpdateIfUnchanged", uint64(4), uint64(9), Note{Title: "stale"}).Return(uint64(0), false)
+	mockRepository.On("GetChangeById", uint64(4)).Return(Note{ID: 4, Sequence: 9, DeletedAt: deletedAt}, true)
+	// Not found
+	mockRepository.On("DeleteIfUnchanged", uint64(99), uint64(1)).Return(uint64(0), false)
+	mockRepository.On("GetChangeById", uint64(99)).Return(Note{}, false)
+
+	results := noteSyncService.Apply([]SyncChange{
+		{Title: "new"},
+		{ID: 1, Sequence: 5, Title: "edited"},
+		{ID: 2, Sequence: 6, Deleted: true},
+		{ID: 3, Sequence: 7, Title: "stale"},
+		{ID: 4, Sequence: 9, Title: "stale"},
+		{ID: 99, Sequence: 1, Deleted: true},
+		{Deleted: true},
+		{ID: 5, Sequence: 1},
+	})
+
+	assert.Equal(t, []SyncResult{
+		{Status: SYNC_CREATED, Note: &SyncNote{ID: 10, Title: "new", Sequence: 20}},
+		{Status: SYNC_UPDATED, Note: &SyncNote{ID: 1, Title: "edited", Content: "content", Sequence: 21}},
+		{Status: SYNC_DELETED, Note: &SyncNote{ID: 2, Sequence: 22, Deleted: true}},
+		{Status: SYNC_CONFLICT, Note: &SyncNote{ID: 3, Title: "newer", Sequence: 8}},
+		{Status: SYNC_CONFLICT, Note: &SyncNote{ID: 4, Sequence: 9, Deleted: true}},
+		{Status: SYNC_NOT_FOUND},
+		{Status: SYNC_INVALID},
+		{Status: SYNC_INVALID},
+	}, results)
+	mockPublisher.AssertNumberOfCalls(t, "Publish", 3)
+}
+
+func TestNoteSyncService_Apply_omittedFields(t *testing.T) {
+	mockRepository := &MockRepository{}
+	noteSyncService := NoteSyncService{noteRepository: mockRepository}
+	completed := false
+	due := time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+
+	// Tags, completion and due date are kept if the change has none, and
+	// replaced if it has.
+	mockRepository.On("GetChangeById", uint64(1)).Return(Note{ID: 1, Title: "title", Tags: Tags{"home"}, Completed: true, Due: &due, Sequence: 5}, true)
+	mockRepository.On("UpdateIfUnchanged", uint64(1), uint64(5), Note{Title: "kept", Tags: Tags{"home"}, Completed: true, Due: &due}).Return(uint64(6), true).Once()
+	mockRepository.On("UpdateIfUnchanged", uint64(1), uint64(5), Note{Title: "cleared"}).Return(uint64(6), true).Once()
+
+	noteSyncService.Apply([]SyncChange{
+		{ID: 1, Sequence: 5, Title: "kept"},
+		{ID: 1, Sequence: 5, Title: "cleared", Tags: []string{}, Completed: &completed, NoDue: true},
+	})
+
+	mockRepository.AssertExpectations(t)
+}
+
+func TestNoteSyncService_Apply_quota(t *testing.T) {
+	mockRepository := &MockRepository{}
+	mockPublisher := &MockEventPublisher{}
+	noteSyncService := NoteSyncService{noteRepository: mockRepository, noteEventPublisher: mockPublisher, quota: QuotaConfig{MaxNotes: 2}}
+	mockRepository.On("Transaction").Return()
+	// The create takes the usage over the quota and is rolled back.
+	mockRepository.On("Usage").Return(QuotaUsage{DEFAULT_WORKSPACE, 2, 10}, true).Once()
+	mockRepository.On("Create", Note{Title: "new"}).Return(uint64(10), true)
+	mockRepository.On("Usage").Return(QuotaUsage{DEFAULT_WORKSPACE, 3, 13}, true).Once()
+	// The update does not add a note.
+	mockRepository.On("Usage").Return(QuotaUsage{DEFAULT_WORKSPACE, 2, 10}, true).Once()
+	mockRepository.On("UpdateIfUnchanged", uint64(1), uint64(5), Note{Title: "edited"}).Return(uint64(21), true)
+	mockRepository.On("Usage").Return(QuotaUsage{DEFAULT_WORKSPACE, 2, 13}, true).Once()
+	mockRepository.On("GetChangeById", uint64(1)).Return(Note{ID: 1, Title: "edited", Sequence: 21}, true)
+	mockPublisher.On("Publish").Return()
+
+	results := noteSyncService.Apply([]SyncChange{
+		{Title: "new"},
+		{ID: 1, Sequence: 5, Title: "edited", Tags: []string{}, Completed: new(bool), NoDue: true},
+	})
+
+	assert.Equal(t, []SyncResult{
+		{Status: SYNC_QUOTA_EXCEEDED},
+		{Status: SYNC_UPDATED, Note: &SyncNote{ID: 1, Title: "edited", Sequence: 21}},
+	}, results)
+	mockRepository.AssertExpectations(t)
+	mockPublisher.AssertNumberOfCalls(t, "Publish", 1)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"hi-watana/todo-go-api/notespb"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var noteEventTypes = map[string]notespb.NoteEvent_Type{
+	NOTE_CREATED: notespb.NoteEvent_CREATED,
+	NOTE_UPDATED: notespb.NoteEvent_UPDATED,
+	NOTE_DELETED: notespb.NoteEvent_DELETED,
+}
+
+// NotesGrpcServer serves the note operations over gRPC through the same
+// services as the REST API, so that both respond alike.
+type NotesGrpcServer struct {
+	notespb.UnimplementedNotesServiceServer
+	noteService         INoteService
+	noteEventSubscriber INoteEventSubscriber
+}
+
+func NewGrpcServer(noteService INoteService, noteEventSubscriber INoteEventSubscriber, logger *slog.Logger) *grpc.Server {
+	// Calls are traced first, so that their logs have the trace ID.
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), unaryLogInterceptor(logger)),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(), streamLogInterceptor(logger)),
+	)
+	notespb.RegisterNotesServiceServer(server, &NotesGrpcServer{
+		noteService:         noteService,
+		noteEventSubscriber: noteEventSubscriber,
+	})
+	return server
+}
+
+// callLogger takes the request ID from the x-request-id metadata or
+// generates one, as RequestLogMiddleware does, and puts a logger with it,
+// and the trace ID if traced, in the context of the call.
+func callLogger(ctx context.Context, base *slog.Logger) (context.Context, *slog.Logger) {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(REQUEST_ID_HEADER)) > 0 {
+		id = md.Get(REQUEST_ID_HEADER)[0]
+	}
+	if !validRequestId(id) {
+		id = newRequestId()
+	}
+	logger := base.With("request_id", id)
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		logger = logger.With("trace_id", span.TraceID().String(), "span_id", span.SpanID().String())
+	}
+	return ContextWithLogger(ctx, logger), logger
+}
+
+// logCall logs the call once handled. A panic in the handler is logged and
+// returned as an internal error, so that it does not stop the server.
+func logCall(ctx context.Context, logger *slog.Logger, method string, start time.Time, recovered interface{}, err *error) {
+	if recovered != nil {
+		logger.ErrorContext(ctx, "panic", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+		*err = status.Error(codes.Internal, "Internal Server Error")
+	}
+	code := status.Code(*err)
+	level := slog.LevelInfo
+	if code == codes.Internal || code == codes.Unknown {
+		level = slog.LevelError
+	}
+	logger.Log(ctx, level, "call",
+		"method", method,
+		"code", code.String(),
+		"duration_ms", float64(time.Since(start).Microseconds())/1000,
+	)
+}
+
+func unaryLogInterceptor(base *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (response interface{}, err error) {
+		start := time.Now()
+		ctx, logger := callLogger(ctx, base)
+		defer func() {
+			logCall(ctx, logger, info.FullMethod, start, recover(), &err)
+		}()
+		return handler(ctx, request)
+	}
+}
+
+// loggedServerStream gives the handler the context with the logger.
+type loggedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ls *loggedServerStream) Context() context.Context {
+	return ls.ctx
+}
+
+func streamLogInterceptor(base *slog.Logger) grpc.StreamServerInterceptor {
+	return func(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		start := time.Now()
+		ctx, logger := callLogger(stream.Context(), base)
+		defer func() {
+			logCall(ctx, logger, info.FullMethod, start, recover(), &err)
+		}()
+		return handler(server, &loggedServerStream{stream, ctx})
+	}
+}
+
+// problemStatus maps the problem the REST API responds with to a status
+// with the same detail.
+func problemStatus(problem Problem) error {
+	code := codes.Internal
+	switch problem.Status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusForbidden:
+		code = codes.ResourceExhausted
+	}
+	message := problem.Detail
+	if message == "" {
+		message = problem.Title
+	}
+	for _, fieldError := range problem.Errors {
+		message += "; " + fieldError.Field + " " + fieldError.Message
+	}
+	return status.Error(code, message)
+}
+
+func toNoteMessage(note Note) *notespb.Note {
+	message := &notespb.Note{Id: note.ID, Title: note.Title, Content: note.Content, Tags: note.Tags, Completed: note.Completed}
+	if note.Due != nil {
+		message.Due = timestamppb.New(*note.Due)
+	}
+	return message
+}
+
+// fromTimestamp returns nil for a timestamp that is not set.
+func fromTimestamp(timestamp *timestamppb.Timestamp) *time.Time {
+	if timestamp == nil {
+		return nil
+	}
+	due := timestamp.AsTime()
+	return &due
+}
+
+func (gs *NotesGrpcServer) Get(ctx context.Context, request *notespb.GetRequest) (*notespb.GetResponse, error) {
+	notes := gs.noteService.WithContext(ctx).Get()
+	response := &notespb.GetResponse{Notes: make([]*notespb.Note, 0, len(notes))}
+	for _, note := range notes {
+		response.Notes = append(response.Notes, toNoteMessage(note))
+	}
+	return response, nil
+}
+
+func (gs *NotesGrpcServer) GetById(ctx context.Context, request *notespb.GetByIdRequest) (*notespb.Note, error) {
+	note, found := gs.noteService.WithContext(ctx).GetById(request.Id)
+	if !found {
+		return nil, problemStatus(NotFoundProblem())
+	}
+	return toNoteMessage(note), nil
+}
+
+func (gs *NotesGrpcServer) Create(ctx context.Context, request *notespb.CreateRequest) (*notespb.Note, error) {
+	noteRequest := NoteRequest{
+		Title:     request.Title,
+		Content:   request.Content,
+		Tags:      request.Tags,
+		Completed: request.Completed,
+		Due:       fromTimestamp(request.Due),
+	}
+	if err := Validate(&noteRequest); err != nil {
+		return nil, problemStatus(ErrorProblem(err))
+	}
+	note := noteRequest.Note()
+	id, err := gs.noteService.WithContext(ctx).Create(note)
+	if err != nil {
+		return nil, problemStatus(ErrorProblem(err))
+	}
+	note.ID = id
+	return toNoteMessage(note), nil
+}
+
+func (gs *NotesGrpcServer) Update(ctx context.Context, request *notespb.UpdateRequest) (*notespb.Note, error) {
+	noteRequest := NoteRequest{
+		Title:     request.Title,
+		Content:   request.Content,
+		Tags:      request.Tags,
+		Completed: request.Completed,
+		Due:       fromTimestamp(request.Due),
+	}
+	if err := Validate(&noteRequest); err != nil {
+		return nil, problemStatus(ErrorProblem(err))
+	}
+	noteService := gs.noteService.WithContext(ctx)
+	if _, found := noteService.GetById(request.Id); !found {
+		return nil, problemStatus(NotFoundProblem())
+	}
+	note := noteRequest.Note()
+	if _, err := noteService.Update(request.Id, note); err != nil {
+		return nil, problemStatus(ErrorProblem(err))
+	}
+	note.ID = request.Id
+	return toNoteMessage(note), nil
+}
+
+func (gs *NotesGrpcServer) Delete(ctx context.Context, request *notespb.DeleteRequest) (*notespb.DeleteResponse, error) {
+	noteService := gs.noteService.WithContext(ctx)
+	if _, found := noteService.GetById(request.Id); !found {
+		return nil, problemStatus(NotFoundProblem())
+	}
+	if !noteService.Delete(request.Id) {
+		return nil, problemStatus(InternalErrorProblem())
+	}
+	return &notespb.DeleteResponse{}, nil
+}
+
+func (gs *NotesGrpcServer) Watch(request *notespb.WatchRequest, stream notespb.NotesService_WatchServer) error {
+	// Subscribe before reading the event log so that no event falls between
+	// the replay and the live stream.
+	events := gs.noteEventSubscriber.Subscribe()
+	defer gs.noteEventSubscriber.Unsubscribe(events)
+
+	send := func(event NoteEvent) error {
+		return stream.Send(&notespb.NoteEvent{
+			Id:   event.ID,
+			Type: noteEventTypes[event.Type],
+			Note: toNoteMessage(event.Note()),
+		})
+	}
+
+	lastEventId := request.GetAfterEventId()
+	if request.AfterEventId != nil {
+		for _, event := range gs.noteEventSubscriber.GetSince(lastEventId) {
+			if err := send(event); err != nil {
+				return err
+			}
+			lastEventId = event.ID
+		}
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "Watch fell behind the events")
+			}
+			if event.ID <= lastEventId {
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+			lastEventId = event.ID
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
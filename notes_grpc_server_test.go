@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"hi-watana/todo-go-api/notespb"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func dialGrpc(t *testing.T, noteService INoteService, noteEventSubscriber INoteEventSubscriber, logger *slog.Logger) (notespb.NotesServiceClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewGrpcServer(noteService, noteEventSubscriber, logger)
+	go server.Serve(listener)
+	conn, err := grpc.Dial("bufconn", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	assert.Nil(t, err)
+	return notespb.NewNotesServiceClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+var restStatusCodes = map[int]codes.Code{
+	http.StatusOK:                  codes.OK,
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnprocessableEntity: codes.InvalidArgument,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+func serviceCalls(mockService *MockService) []string {
+	var calls []string
+	for _, call := range mockService.Calls {
+		calls = append(calls, fmt.Sprint(call.Method, call.Arguments))
+	}
+	return calls
+}
+
+func noteOf(message *notespb.Note) Note {
+	return Note{
+		ID:        message.Id,
+		Title:     message.Title,
+		Content:   message.Content,
+		Tags:      uniqueTags(message.Tags),
+		Completed: message.Completed,
+		Due:       fromTimestamp(message.Due),
+	}
+}
+
+func notesOf(response interface{}) []Note {
+	switch response := response.(type) {
+	case *notespb.GetResponse:
+		var notes []Note
+		for _, note := range response.Notes {
+			notes = append(notes, noteOf(note))
+		}
+		return notes
+	case *notespb.Note:
+		return []Note{noteOf(response)}
+	}
+	return nil
+}
+
+// The gRPC service must respond like the REST API to the same calls: with
+// the corresponding status and the same service calls and notes.
+func TestNotesGrpcServer_parity(t *testing.T) {
+	internalError := errors.New("internal")
+	due := time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+	for _, td := range []struct {
+		title          string
+		setUp          func(mockService *MockService)
+		restRequest    func() *http.Request
+		grpcCall       func(client notespb.NotesServiceClient) (interface{}, error)
+		expectedStatus int
+		expectedNotes  []Note
+	}{
+		{
+			title: "Get",
+			setUp: func(mockService *MockService) {
+				mockService.On("Get").Return([]Note{
+					{ID: 1, Title: "title", Content: "content", Tags: Tags{"work"}, Completed: true, Due: &due},
+					{ID: 2, Title: "title2"},
+				})
+			},
+			restRequest: func() *http.Request { return httptest.NewRequest("GET", "/v1/notes", nil) },
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Get(context.Background(), &notespb.GetRequest{})
+			},
+			expectedStatus: http.StatusOK,
+			expectedNotes: []Note{
+				{ID: 1, Title: "title", Content: "content", Tags: Tags{"work"}, Completed: true, Due: &due},
+				{ID: 2, Title: "title2"},
+			},
+		},
+		{
+			title: "GetById",
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "title", Content: "content"}, true)
+			},
+			restRequest: func() *http.Request { return httptest.NewRequest("GET", "/v1/notes/1", nil) },
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.GetById(context.Background(), &notespb.GetByIdRequest{Id: 1})
+			},
+			expectedStatus: http.StatusOK,
+			expectedNotes:  []Note{{ID: 1, Title: "title", Content: "content"}},
+		},
+		{
+			title: "GetById of missing note",
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{}, false)
+			},
+			restRequest: func() *http.Request { return httptest.NewRequest("GET", "/v1/notes/1", nil) },
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.GetById(context.Background(), &notespb.GetByIdRequest{Id: 1})
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			title: "Create",
+			setUp: func(mockService *MockService) {
+				mockService.On("Create", Note{Title: "title", Content: "content"}).Return(uint64(1), nil)
+			},
+			restRequest: func() *http.Request {
+				return httptest.NewRequest("POST", "/v1/notes", strings.NewReader(`{"title": "title", "content": "content"}`))
+			},
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Create(context.Background(), &notespb.CreateRequest{Title: "title", Content: "content"})
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			title: "Create with tags, completion and due date",
+			setUp: func(mockService *MockService) {
+				mockService.On("Create", Note{Title: "title", Tags: Tags{"work"}, Completed: true, Due: &due}).Return(uint64(1), nil)
+			},
+			restRequest: func() *http.Request {
+				return httptest.NewRequest("POST", "/v1/notes", strings.NewReader(`{"title": "title", "tags": ["work", "work"], "completed": true, "due": "2024-01-05T09:00:00Z"}`))
+			},
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Create(context.Background(), &notespb.CreateRequest{
+					Title: "title", Tags: []string{"work", "work"}, Completed: true, Due: timestamppb.New(due),
+				})
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			title: "Create with invalid tags",
+			setUp: func(mockService *MockService) {},
+			restRequest: func() *http.Request {
+				return httptest.NewRequest("POST", "/v1/notes", strings.NewReader(`{"title": "title", "tags": ["a b"]}`))
+			},
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Create(context.Background(), &notespb.CreateRequest{Title: "title", Tags: []string{"a b"}})
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			title: "Create invalid note",
+			setUp: func(mockService *MockService) {},
+			restRequest: func() *http.Request {
+				return httptest.NewRequest("POST", "/v1/notes", strings.NewReader(`{"title": "", "content": "content"}`))
+			},
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Create(context.Background(), &notespb.CreateRequest{Title: "", Content: "content"})
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			title: "Create failing",
+			setUp: func(mockService *MockService) {
+				mockService.On("Create", Note{Title: "title"}).Return(UNSPECIFIED_ID, internalError)
+			},
+			restRequest: func() *http.Request {
+				return httptest.NewRequest("POST", "/v1/notes", strings.NewReader(`{"title": "title"}`))
+			},
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Create(context.Background(), &notespb.CreateRequest{Title: "title"})
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			title: "Update",
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{ID: 1}, true)
+				mockService.On("Update", uint64(1), Note{Title: "title"}).Return(uint64(1), nil)
+			},
+			restRequest: func() *http.Request {
+				return httptest.NewRequest("PUT", "/v1/notes/1", strings.NewReader(`{"title": "title"}`))
+			},
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Update(context.Background(), &notespb.UpdateRequest{Id: 1, Title: "title"})
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			title: "Update replacing tags, completion and due date",
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Tags: Tags{"home"}, Due: &due}, true)
+				mockService.On("Update", uint64(1), Note{Title: "title", Tags: Tags{"work"}, Completed: true}).Return(uint64(1), nil)
+			},
+			restRequest: func() *http.Request {
+				return httptest.NewRequest("PUT", "/v1/notes/1", strings.NewReader(`{"title": "title", "tags": ["work"], "completed": true}`))
+			},
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Update(context.Background(), &notespb.UpdateRequest{Id: 1, Title: "title", Tags: []string{"work"}, Completed: true})
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			title: "Update missing note",
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{}, false)
+			},
+			restRequest: func() *http.Request {
+				return httptest.NewRequest("PUT", "/v1/notes/1", strings.NewReader(`{"title": "title"}`))
+			},
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Update(context.Background(), &notespb.UpdateRequest{Id: 1, Title: "title"})
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			title: "Delete",
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{ID: 1}, true)
+				mockService.On("Delete", uint64(1)).Return(true)
+			},
+			restRequest: func() *http.Request { return httptest.NewRequest("DELETE", "/v1/notes/1", nil) },
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Delete(context.Background(), &notespb.DeleteRequest{Id: 1})
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			title: "Delete failing",
+			setUp: func(mockService *MockService) {
+				mockService.On("GetById", uint64(1)).Return(Note{ID: 1}, true)
+				mockService.On("Delete", uint64(1)).Return(false)
+			},
+			restRequest: func() *http.Request { return httptest.NewRequest("DELETE", "/v1/notes/1", nil) },
+			grpcCall: func(client notespb.NotesServiceClient) (interface{}, error) {
+				return client.Delete(context.Background(), &notespb.DeleteRequest{Id: 1})
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			restService := &MockService{}
+			td.setUp(restService)
+			response := httptest.NewRecorder()
+			NewRouter(&Controllers{noteController: NoteController{noteService: restService}}).ServeHTTP(response, td.restRequest())
+			assert.Equal(t, td.expectedStatus, response.Code)
+
+			grpcService := &MockService{}
+			td.setUp(grpcService)
+			client, closeClient := dialGrpc(t, grpcService, nil, slog.Default())
+			defer closeClient()
+			grpcResponse, err := td.grpcCall(client)
+			assert.Equal(t, restStatusCodes[td.expectedStatus], status.Code(err))
+
+			assert.Equal(t, serviceCalls(restService), serviceCalls(grpcService))
+			if td.expectedStatus != http.StatusOK {
+				var problem Problem
+				assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &problem))
+				assert.True(t, strings.HasPrefix(status.Convert(err).Message(), problem.Detail), status.Convert(err).Message())
+				return
+			}
+			if td.expectedNotes != nil {
+				var restNotes []Note
+				if err := json.Unmarshal(response.Body.Bytes(), &restNotes); err != nil {
+					restNotes = []Note{{}}
+					assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &restNotes[0]))
+				}
+				assert.Equal(t, td.expectedNotes, restNotes)
+				assert.Equal(t, td.expectedNotes, notesOf(grpcResponse))
+			}
+		})
+	}
+}
+
+func TestNotesGrpcServer_Watch(t *testing.T) {
+	mockSubscriber := &MockEventSubscriber{events: make(chan NoteEvent, 2)}
+	mockSubscriber.On("Subscribe").Return()
+	unsubscribed := make(chan struct{})
+	mockSubscriber.On("Unsubscribe", mock.Anything).Return().Run(func(args mock.Arguments) {
+		close(unsubscribed)
+	})
+	mockSubscriber.On("GetSince", uint64(1)).Return([]NoteEvent{{ID: 2, Type: NOTE_CREATED, NoteID: 1, Title: "title"}})
+	mockSubscriber.events <- NoteEvent{ID: 2, Type: NOTE_CREATED, NoteID: 1, Title: "title"}
+	mockSubscriber.events <- NoteEvent{ID: 3, Type: NOTE_DELETED, NoteID: 1}
+
+	client, closeClient := dialGrpc(t, &MockService{}, mockSubscriber, slog.Default())
+	defer closeClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	afterEventId := uint64(1)
+	stream, err := client.Watch(ctx, &notespb.WatchRequest{AfterEventId: &afterEventId})
+	assert.Nil(t, err)
+
+	for _, expected := range []*notespb.NoteEvent{
+		{Id: 2, Type: notespb.NoteEvent_CREATED, Note: &notespb.Note{Id: 1, Title: "title"}},
+		{Id: 3, Type: notespb.NoteEvent_DELETED, Note: &notespb.Note{Id: 1}},
+	} {
+		event, err := stream.Recv()
+		assert.Nil(t, err)
+		assert.Equal(t, expected.Id, event.Id)
+		assert.Equal(t, expected.Type, event.Type)
+		assert.Equal(t, notesOf(expected.Note), notesOf(event.Note))
+	}
+
+	cancel()
+	<-unsubscribed
+	mockSubscriber.AssertExpectations(t)
+}
+
+func TestNotesGrpcServer_logging(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, _ := NewLogger(&buffer, "debug")
+	mockService := &MockService{}
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "Milk"}, true)
+	client, closeClient := dialGrpc(t, mockService, nil, logger)
+	defer closeClient()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "abc-123")
+
+	_, err := client.GetById(ctx, &notespb.GetByIdRequest{Id: 1})
+	assert.NoError(t, err)
+	// The mock panics on calls it does not expect.
+	_, err = client.GetById(ctx, &notespb.GetByIdRequest{Id: 2})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	_, err = client.GetById(ctx, &notespb.GetByIdRequest{Id: 1})
+	assert.NoError(t, err, "the server must keep serving after a panic")
+
+	lines := logLines(&buffer)
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "call", lines[0]["msg"])
+	assert.Equal(t, "abc-123", lines[0]["request_id"])
+	assert.Equal(t, "/notes.v1.NotesService/GetById", lines[0]["method"])
+	assert.Equal(t, "OK", lines[0]["code"])
+	assert.Equal(t, "panic", lines[1]["msg"])
+	assert.Equal(t, "ERROR", lines[2]["level"])
+	assert.Equal(t, "Internal", lines[2]["code"])
+}
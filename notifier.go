@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const (
+	NOTIFIER_LOG     = "log"
+	NOTIFIER_WEBHOOK = "webhook"
+	NOTIFIER_SMTP    = "smtp"
+
+	WEBHOOK_TIMEOUT = 10 * time.Second
+)
+
+// Notifier sends the notification of a reminder about a note.
+type Notifier interface {
+	Notify(ctx context.Context, reminder Reminder, note Note) error
+}
+
+// NewNotifier returns the notifier of the kind configured, which has been
+// validated.
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	switch config.Kind {
+	case NOTIFIER_LOG:
+		return &LogNotifier{}, nil
+	case NOTIFIER_WEBHOOK:
+		return NewWebhookNotifier(config.WebhookURL), nil
+	case NOTIFIER_SMTP:
+		notifier := &SMTPNotifier{addr: config.SMTP.Addr, from: config.SMTP.From, to: config.SMTP.To}
+		if config.SMTP.Username != "" {
+			host, _, _ := net.SplitHostPort(notifier.addr)
+			notifier.auth = smtp.PlainAuth("", config.SMTP.Username, config.SMTP.Password, host)
+		}
+		return notifier, nil
+	}
+	return nil, errors.New("notifier must be log, webhook or smtp")
+}
+
+type LogNotifier struct{}
+
+func (ln *LogNotifier) Notify(ctx context.Context, reminder Reminder, note Note) error {
+	LoggerFrom(ctx).Info("Reminder is due", "reminder_id", reminder.ID, "note_id", note.ID, "title", note.Title,
+		"remind_at", reminder.RemindAt.Format(time.RFC3339))
+	return nil
+}
+
+// WebhookPayload is posted as JSON to the webhook.
+type WebhookPayload struct {
+	Reminder Reminder `json:"reminder"`
+	Note     Note     `json:"note"`
+}
+
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier returns the notifier posting to the URL, propagating
+// the trace of the reminder.
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	client := &http.Client{Timeout: WEBHOOK_TIMEOUT, Transport: NewTracingTransport(http.DefaultTransport)}
+	return &WebhookNotifier{url: url, client: client}
+}
+
+func (wn *WebhookNotifier) Notify(ctx context.Context, reminder Reminder, note Note) error {
+	body, err := json.Marshal(WebhookPayload{reminder, note})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := wn.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier mails the note, titled in the subject. STARTTLS is used if
+// the server supports it.
+type SMTPNotifier struct {
+	addr string
+	from string
+	to   []string
+	auth smtp.Auth
+}
+
+func (sn *SMTPNotifier) Notify(ctx context.Context, reminder Reminder, note Note) error {
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", sn.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(sn.to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Reminder: "+note.Title))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(strings.ReplaceAll(note.Content, "\r\n", "\n"), "\n", "\r\n"))
+	message.WriteString("\r\n")
+	return smtp.SendMail(sn.addr, sn.auth, sn.from, sn.to, message.Bytes())
+}
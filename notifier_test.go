@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	notifiedReminder = Reminder{ID: 1, NoteID: 2, RemindAt: time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)}
+	notifiedNote     = Note{ID: 2, Title: "Café", Content: "Milk\n.\nEggs"}
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var payload WebhookPayload
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	notifier := &WebhookNotifier{url: server.URL, client: server.Client()}
+
+	assert.NoError(t, notifier.Notify(context.Background(), notifiedReminder, notifiedNote))
+	assert.Equal(t, WebhookPayload{notifiedReminder, notifiedNote}, payload)
+
+	status = http.StatusBadGateway
+	assert.EqualError(t, notifier.Notify(context.Background(), notifiedReminder, notifiedNote), "webhook responded with 502 Bad Gateway")
+}
+
+// serveFakeSMTP accepts one mail without authentication or TLS and sends
+// its envelope and data to the channel.
+func serveFakeSMTP(t *testing.T, mails chan<- []string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		var mail []string
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimRight(line, "\r\n")
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "MAIL"), strings.HasPrefix(command, "RCPT"):
+				mail = append(mail, command)
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 Go ahead")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				mails <- append(mail, data.String())
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	mails := make(chan []string, 1)
+	addr := serveFakeSMTP(t, mails)
+	notifier := &SMTPNotifier{addr: addr, from: "todo@example.com", to: []string{"a@example.com", "b@example.com"}}
+
+	assert.NoError(t, notifier.Notify(context.Background(), notifiedReminder, notifiedNote))
+
+	mail := <-mails
+	assert.Equal(t, []string{"MAIL FROM:<todo@example.com>", "RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>"}, mail[:3])
+	assert.Contains(t, mail[3], "To: a@example.com, b@example.com\r\n")
+	assert.Contains(t, mail[3], "Subject: =?utf-8?q?Reminder:_Caf=C3=A9?=\r\n")
+	assert.True(t, strings.HasSuffix(mail[3], "\r\n\r\nMilk\r\n..\r\nEggs\r\n"), mail[3])
+}
+
+func TestNewNotifier(t *testing.T) {
+	notifier, err := NewNotifier(NotifierConfig{Kind: NOTIFIER_LOG})
+	assert.NoError(t, err)
+	assert.Equal(t, &LogNotifier{}, notifier)
+
+	notifier, err = NewNotifier(NotifierConfig{Kind: NOTIFIER_SMTP, SMTP: SMTPConfig{
+		Addr: "localhost:25", From: "todo@example.com", To: []string{"a@example.com", "b@example.com"},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, &SMTPNotifier{addr: "localhost:25", from: "todo@example.com", to: []string{"a@example.com", "b@example.com"}}, notifier)
+
+	_, err = NewNotifier(NotifierConfig{Kind: "pager"})
+	assert.EqualError(t, err, "notifier must be log, webhook or smtp")
+}
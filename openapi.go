@@ -0,0 +1,266 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OPENAPI_VERSION = "3.0.3"
+	API_TITLE       = "Simple Todo API"
+	API_VERSION     = "1.0.0"
+	API_DESCRIPTION = "This is a simple -- even too simple -- API for todo notes."
+	API_BASE_PATH   = "/v1"
+)
+
+var tagDescriptions = map[string]string{
+	"notes":    "Everything about your notes",
+	"sync":     "Syncing notes with offline-capable clients",
+	"backup":   "Backing up and migrating notes",
+	"calendar": "Notes in calendar apps",
+	"usage":    "Usage of notes against quotas",
+}
+
+// Parameter describes a query or header parameter of a route. Path
+// parameters are derived from the path as IDs of notes unless described.
+type Parameter struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	Schema      map[string]interface{}
+}
+
+// Response describes a response of a route. Body is a value of the type of
+// the body, or a map for a schema that no Go type describes.
+type Response struct {
+	Status      int
+	Description string
+	ContentType string
+	Body        interface{}
+}
+
+// ':' that does not follow '/' starts a custom method, not a parameter.
+var pathParameterPattern = regexp.MustCompile(`/:(\w+)`)
+
+func openAPIPath(path string) string {
+	return pathParameterPattern.ReplaceAllString(path, "/{$1}")
+}
+
+type openAPIGenerator struct {
+	schemas map[string]interface{}
+}
+
+func (g *openAPIGenerator) schemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		if _, found := g.schemas[t.Name()]; !found {
+			g.schemas[t.Name()] = nil // Stops recursion into the same type.
+			g.schemas[t.Name()] = g.objectSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": g.schemaOf(t.Elem())}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+// objectSchema describes the JSON fields of the struct, with the validation
+// rules of its string fields and the `doc` tags as descriptions.
+func (g *openAPIGenerator) objectSchema(t reflect.Type) map[string]interface{} {
+	rules := map[int][]validationRule{}
+	for _, field := range rulesOf(t) {
+		rules[field.index] = field.rules
+	}
+
+	properties := map[string]interface{}{}
+	var required []interface{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := g.schemaOf(field.Type)
+		if description, found := field.Tag.Lookup("doc"); found {
+			if _, isRef := property["$ref"]; isRef {
+				property = map[string]interface{}{"allOf": []interface{}{property}}
+			}
+			property["description"] = description
+		}
+		if exportRules(rules[i], property) {
+			required = append(required, name)
+		}
+		properties[name] = property
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (g *openAPIGenerator) bodySchema(body interface{}) map[string]interface{} {
+	if schema, isSchema := body.(map[string]interface{}); isSchema {
+		return schema
+	}
+	return g.schemaOf(reflect.TypeOf(body))
+}
+
+func (g *openAPIGenerator) operation(route Route) map[string]interface{} {
+	operation := map[string]interface{}{
+		"tags":    []interface{}{route.Tag},
+		"summary": route.Summary,
+	}
+	if route.Description != "" {
+		operation["description"] = route.Description
+	}
+
+	describedParameters := map[string]bool{}
+	for _, parameter := range route.Parameters {
+		describedParameters[parameter.In+" "+parameter.Name] = true
+	}
+	var parameters []interface{}
+	for _, match := range pathParameterPattern.FindAllStringSubmatch(route.Path, -1) {
+		if describedParameters["path "+match[1]] {
+			continue
+		}
+		parameters = append(parameters, map[string]interface{}{
+			"name":        match[1],
+			"in":          "path",
+			"required":    true,
+			"description": "ID of note",
+			"schema":      map[string]interface{}{"type": "integer", "format": "int64"},
+		})
+	}
+	for _, parameter := range route.Parameters {
+		parameters = append(parameters, map[string]interface{}{
+			"name":        parameter.Name,
+			"in":          parameter.In,
+			"required":    parameter.Required,
+			"description": parameter.Description,
+			"schema":      parameter.Schema,
+		})
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if route.Request != nil {
+		contentType := route.RequestContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				contentType: map[string]interface{}{"schema": g.bodySchema(route.Request)},
+			},
+		}
+	}
+
+	responses := map[string]interface{}{}
+	routeResponses := append(append([]Response(nil), route.Responses...), rateLimitedResponse)
+	for _, response := range routeResponses {
+		// Responses of the same status in other content types are merged into
+		// the first one.
+		status := strconv.Itoa(response.Status)
+		described, found := responses[status].(map[string]interface{})
+		if !found {
+			described = map[string]interface{}{"description": response.Description}
+			responses[status] = described
+		}
+		if response.Body != nil {
+			contentType := response.ContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			content, found := described["content"].(map[string]interface{})
+			if !found {
+				content = map[string]interface{}{}
+				described["content"] = content
+			}
+			content[contentType] = map[string]interface{}{"schema": g.bodySchema(response.Body)}
+		}
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+// OpenAPIDocument describes the routes and the types of their bodies.
+func OpenAPIDocument(routes []Route) map[string]interface{} {
+	g := &openAPIGenerator{schemas: map[string]interface{}{}}
+	paths := map[string]interface{}{}
+	usedTags := map[string]bool{}
+	for _, route := range routes {
+		path := openAPIPath(route.Path)
+		if _, found := paths[path]; !found {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path].(map[string]interface{})[strings.ToLower(route.Method)] = g.operation(route)
+		usedTags[route.Tag] = true
+	}
+
+	var tagNames []string
+	for tag := range usedTags {
+		tagNames = append(tagNames, tag)
+	}
+	sort.Strings(tagNames)
+	var tags []interface{}
+	for _, tag := range tagNames {
+		tags = append(tags, map[string]interface{}{"name": tag, "description": tagDescriptions[tag]})
+	}
+
+	return map[string]interface{}{
+		"openapi": OPENAPI_VERSION,
+		"info": map[string]interface{}{
+			"title":       API_TITLE,
+			"version":     API_VERSION,
+			"description": API_DESCRIPTION,
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": API_BASE_PATH}},
+		"tags":       tags,
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": g.schemas},
+	}
+}
+
+// rateLimitedResponse is added to every route, as RateLimitMiddleware may
+// reject any request.
+var rateLimitedResponse = problemResponse(http.StatusTooManyRequests,
+	"Rate limit exceeded; see Retry-After. Clients are limited by their API key, given as a bearer token or in "+
+		"X-API-Key, if it is a configured one, and otherwise by their IP address. The body is a problem, like those "+
+		"of the other errors, rather than a status and message.")
+
+func problemResponse(status int, description string) Response {
+	return Response{status, description, PROBLEM_CONTENT_TYPE, Problem{}}
+}
+
+func successResponse(description string) Response {
+	return Response{http.StatusOK, description, "", ApiResponse{}}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "update swagger.yaml")
+
+func TestOpenAPIPath(t *testing.T) {
+	assert.Equal(t, "/notes/{id}/edit", openAPIPath("/notes/:id/edit"))
+	assert.Equal(t, "/notes:batch", openAPIPath("/notes:batch"))
+}
+
+func TestObjectSchema(t *testing.T) {
+	g := &openAPIGenerator{schemas: map[string]interface{}{}}
+	assert.Equal(t, map[string]interface{}{"$ref": "#/components/schemas/validationTestRequest"},
+		g.schemaOf(reflect.TypeOf(validationTestRequest{})))
+	assert.Equal(t, map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"name"},
+		"properties": map[string]interface{}{
+			"name":   map[string]interface{}{"type": "string", "minLength": 2, "maxLength": 5},
+			"code":   map[string]interface{}{"type": "string", "pattern": "^[a-z]+$"},
+			"Color":  map[string]interface{}{"type": "string", "enum": []interface{}{"red", "green"}},
+			"ignore": map[string]interface{}{"type": "string"},
+		},
+	}, g.schemas["validationTestRequest"])
+
+	assert.Equal(t, map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/components/schemas/Note"}},
+		g.schemaOf(reflect.TypeOf([]Note{})))
+	assert.Equal(t, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id":        map[string]interface{}{"type": "integer", "format": "int64"},
+			"title":     map[string]interface{}{"type": "string"},
+			"content":   map[string]interface{}{"type": "string"},
+			"tags":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"completed": map[string]interface{}{"type": "boolean"},
+			"due":       map[string]interface{}{"type": "string", "format": "date-time"},
+		},
+	}, g.schemas["Note"])
+}
+
+// undocumentedRoutes are the routes served outside the API, which the
+// OpenAPI document does not describe, by method and path prefix.
+var undocumentedRoutes = map[string]string{
+	"GET /openapi.json":            "the document itself",
+	"GET /docs":                    "renders the document",
+	"GET /graphql":                 "described by the GraphQL schema",
+	"POST /graphql":                "described by the GraphQL schema",
+	"GET " + LIVENESS_PATH:         "probe of the orchestrator",
+	"GET " + READINESS_PATH:        "probe of the orchestrator",
+	"GET " + METRICS_PATH:          "scraped by Prometheus",
+	"GET /.well-known/caldav":      "CalDAV, described by RFC 4791",
+	"PROPFIND /.well-known/caldav": "CalDAV, described by RFC 4791",
+	"GET " + CALDAV_ROOT_PATH:      "CalDAV, described by RFC 4791",
+	"HEAD " + CALDAV_ROOT_PATH:     "CalDAV, described by RFC 4791",
+	"PUT " + CALDAV_ROOT_PATH:      "CalDAV, described by RFC 4791",
+	"DELETE " + CALDAV_ROOT_PATH:   "CalDAV, described by RFC 4791",
+	"REPORT " + CALDAV_ROOT_PATH:   "CalDAV, described by RFC 4791",
+	"OPTIONS " + CALDAV_ROOT_PATH:  "CalDAV, described by RFC 4791",
+	"PROPFIND " + CALDAV_ROOT_PATH: "CalDAV, described by RFC 4791",
+}
+
+func isUndocumented(method string, path string) bool {
+	for route := range undocumentedRoutes {
+		prefix := strings.SplitN(route, " ", 2)
+		if method == prefix[0] && strings.HasPrefix(path, prefix[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Every route served by NewRouter must be described in the document it
+// serves, or be listed in undocumentedRoutes, and every operation in the
+// document must be served.
+func TestOpenAPIDocument_routes(t *testing.T) {
+	router := NewRouter(&Controllers{metrics: NewMetrics()})
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var document map[string]interface{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &document))
+	paths := document["paths"].(map[string]interface{})
+
+	served := map[string]bool{}
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, API_BASE_PATH+"/") {
+			assert.True(t, isUndocumented(route.Method, route.Path), "%s %s is neither documented nor excluded", route.Method, route.Path)
+			continue
+		}
+		path := strings.TrimPrefix(route.Path, API_BASE_PATH)
+		method := strings.ToLower(route.Method)
+		if strings.HasSuffix(path, ":method") {
+			prefix := strings.TrimSuffix(path, "method")
+			described := false
+			for documented, operations := range paths {
+				if _, found := operations.(map[string]interface{})[method]; found && strings.HasPrefix(documented, openAPIPath(prefix)) {
+					described = true
+					served[method+" "+documented] = true
+				}
+			}
+			assert.True(t, described, route.Method+" "+route.Path)
+			continue
+		}
+		served[method+" "+openAPIPath(path)] = true
+		operations, found := paths[openAPIPath(path)]
+		if assert.True(t, found, route.Method+" "+route.Path) {
+			assert.Contains(t, operations, method, route.Method+" "+route.Path)
+		}
+	}
+
+	for path, operations := range paths {
+		for method := range operations.(map[string]interface{}) {
+			assert.True(t, served[method+" "+path], "%s %s is documented but not served", strings.ToUpper(method), path)
+		}
+	}
+}
+
+func TestOpenAPIDocument_swagger(t *testing.T) {
+	controllers := &Controllers{}
+	var generated bytes.Buffer
+	generated.WriteString("# Generated from the routes by `make openapi`. DO NOT EDIT.\n")
+	encoder := yaml.NewEncoder(&generated)
+	encoder.SetIndent(2)
+	assert.Nil(t, encoder.Encode(OpenAPIDocument(controllers.Routes())))
+	if *update {
+		assert.Nil(t, ioutil.WriteFile("swagger.yaml", generated.Bytes(), 0644))
+	}
+	data, err := ioutil.ReadFile("swagger.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, generated.String(), string(data), "swagger.yaml is outdated; run `make openapi`")
+}
+
+func TestNewRouter_openapi(t *testing.T) {
+	router := NewRouter(&Controllers{})
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	var document map[string]interface{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &document))
+	assert.Equal(t, OPENAPI_VERSION, document["openapi"])
+	assert.Contains(t, document["paths"], "/notes/{id}")
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "text/html; charset=utf-8", response.Header().Get("Content-Type"))
+	assert.Contains(t, response.Body.String(), `spec-url="/openapi.json"`)
+}
+
+func TestNewRouter_customMethods(t *testing.T) {
+	router := NewRouter(&Controllers{})
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/v1/notes:batch", strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), PROBLEM_INVALID_REQUEST_BODY)
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/v1/notes:unknown", nil))
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Contains(t, response.Body.String(), "Method does not exist")
+}
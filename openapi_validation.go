@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// schemaValidator checks JSON values decoded with UseNumber against the
+// schemas of the OpenAPI document. Only the keywords OpenAPIDocument
+// generates are supported. String keywords are checked like the
+// validation rules they are exported from.
+type schemaValidator struct {
+	schemas map[string]interface{}
+	errors  []FieldError
+}
+
+func newSchemaValidator(document map[string]interface{}) *schemaValidator {
+	components, _ := document["components"].(map[string]interface{})
+	schemas, _ := components["schemas"].(map[string]interface{})
+	return &schemaValidator{schemas: schemas}
+}
+
+func (v *schemaValidator) fail(field string, message string) {
+	v.errors = append(v.errors, FieldError{field, message})
+}
+
+func childField(parent string, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "." + child
+}
+
+func (v *schemaValidator) validate(field string, schema map[string]interface{}, value interface{}) {
+	if ref, found := schema["$ref"].(string); found {
+		resolved, _ := v.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{})
+		v.validate(field, resolved, value)
+		return
+	}
+	if allOf, found := schema["allOf"].([]interface{}); found {
+		for _, s := range allOf {
+			v.validate(field, s.(map[string]interface{}), value)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(field, "must be an object")
+			return
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, found := object[name.(string)]; !found {
+				v.fail(childField(field, name.(string)), "is required")
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, propertyValue := range object {
+			if property, found := properties[name]; found {
+				v.validate(childField(field, name), property.(map[string]interface{}), propertyValue)
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			v.fail(field, "must be an array")
+			return
+		}
+		if maxItems, found := schema["maxItems"].(int); found && len(array) > maxItems {
+			v.fail(field, "must have at most "+strconv.Itoa(maxItems)+" items")
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range array {
+			v.validate(field+"["+strconv.Itoa(i)+"]", items, item)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			v.fail(field, "must be a string")
+			return
+		}
+		v.validateString(field, schema, s)
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			v.fail(field, "must be an integer")
+			return
+		}
+		bitSize := 64
+		if schema["format"] == "int32" {
+			bitSize = 32
+		}
+		if _, err := strconv.ParseInt(number.String(), 10, bitSize); err != nil {
+			// IDs are uint64 documented as int64.
+			if _, err := strconv.ParseUint(number.String(), 10, bitSize); err != nil {
+				v.fail(field, "must be an integer")
+			}
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			v.fail(field, "must be a number")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(field, "must be a boolean")
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(field string, schema map[string]interface{}, value string) {
+	var rules []validationRule
+	for _, keyword := range []string{"minLength", "maxLength", "pattern"} {
+		if keywordValue, found := schema[keyword]; found {
+			rules = append(rules, validationRule{keyword, fmt.Sprint(keywordValue)})
+		}
+	}
+	if enum, found := schema["enum"].([]interface{}); found {
+		var values []string
+		for _, v := range enum {
+			values = append(values, fmt.Sprint(v))
+		}
+		rules = append(rules, validationRule{"enum", strings.Join(values, "|")})
+	}
+	for _, rule := range rules {
+		if message, ok := checkRule(rule, value); !ok {
+			v.fail(field, message)
+		}
+	}
+	if schema["format"] == "date-time" {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			v.fail(field, "must be a date-time")
+		}
+	}
+}
+
+// validateParameter checks a path, query or header parameter, which is
+// always a string on the wire.
+func (v *schemaValidator) validateParameter(name string, schema map[string]interface{}, raw string) {
+	if schema["type"] == "integer" || schema["type"] == "number" {
+		v.validate(name, schema, json.Number(raw))
+		return
+	}
+	v.validate(name, schema, raw)
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+// operationOf finds the operation of the matched route in the document.
+func operationOf(c *gin.Context, paths map[string]interface{}) (map[string]interface{}, bool) {
+	path := strings.TrimPrefix(c.FullPath(), API_BASE_PATH)
+	if strings.HasSuffix(path, ":method") {
+		path = strings.TrimSuffix(path, ":method") + c.Param("method")
+	}
+	operations, found := paths[openAPIPath(path)].(map[string]interface{})
+	if !found {
+		return nil, false
+	}
+	operation, found := operations[strings.ToLower(c.Request.Method)].(map[string]interface{})
+	return operation, found
+}
+
+func (v *schemaValidator) validateRequest(c *gin.Context, operation map[string]interface{}) *Problem {
+	parameters, _ := operation["parameters"].([]interface{})
+	for _, p := range parameters {
+		parameter := p.(map[string]interface{})
+		name := parameter["name"].(string)
+		var raw string
+		var found bool
+		switch parameter["in"] {
+		case "path":
+			raw, found = c.Param(name), true
+		case "query":
+			raw, found = c.GetQuery(name)
+		case "header":
+			raw = c.GetHeader(name)
+			found = raw != ""
+		}
+		if !found {
+			if parameter["required"] == true {
+				v.fail(name, "is required")
+			}
+			continue
+		}
+		v.validateParameter(name, parameter["schema"].(map[string]interface{}), raw)
+	}
+
+	requestBody, found := operation["requestBody"].(map[string]interface{})
+	content, _ := requestBody["content"].(map[string]interface{})
+	if media, isJSON := content["application/json"].(map[string]interface{}); found && isJSON {
+		body, err := c.GetRawData()
+		if err != nil {
+			problem := InvalidRequestBodyProblem(err)
+			return &problem
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		value, err := decodeJSON(body)
+		if err != nil {
+			problem := InvalidRequestBodyProblem(err)
+			return &problem
+		}
+		v.validate("", media["schema"].(map[string]interface{}), value)
+	}
+
+	if len(v.errors) > 0 {
+		problem := SchemaViolationProblem(v.errors)
+		return &problem
+	}
+	return nil
+}
+
+// recordable reports whether all the responses of the operation are JSON,
+// so that recording them does not buffer streams.
+func recordable(operation map[string]interface{}) bool {
+	for _, response := range operation["responses"].(map[string]interface{}) {
+		content, _ := response.(map[string]interface{})["content"].(map[string]interface{})
+		for contentType := range content {
+			if contentType != "application/json" && contentType != PROBLEM_CONTENT_TYPE {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (v *schemaValidator) validateResponse(operation map[string]interface{}, status int, contentType string, body []byte) {
+	response, found := operation["responses"].(map[string]interface{})[strconv.Itoa(status)].(map[string]interface{})
+	if !found {
+		v.fail("", "status "+strconv.Itoa(status)+" is not documented")
+		return
+	}
+	content, _ := response["content"].(map[string]interface{})
+	if len(content) == 0 {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, found := content[mediaType].(map[string]interface{})
+	if !found {
+		v.fail("", "content type "+contentType+" is not documented")
+		return
+	}
+	value, err := decodeJSON(body)
+	if err != nil {
+		v.fail("", "body is not valid JSON")
+		return
+	}
+	v.validate("", media["schema"].(map[string]interface{}), value)
+}
+
+// LogResponseViolations reports responses that do not match the document.
+func LogResponseViolations(c *gin.Context, errors []FieldError) {
+	for _, e := range errors {
+		LoggerFrom(c.Request.Context()).Warn("Response does not match the OpenAPI document",
+			"status", c.Writer.Status(), "field", e.Field, "message", e.Message)
+	}
+}
+
+// OpenAPIValidationMiddleware rejects requests that do not match the
+// document with 400 and the schema errors. If onResponseViolation is not
+// nil, the responses are checked against the document too, and violations
+// are passed to it.
+func OpenAPIValidationMiddleware(document map[string]interface{}, onResponseViolation func(c *gin.Context, errors []FieldError)) gin.HandlerFunc {
+	paths := document["paths"].(map[string]interface{})
+	return func(c *gin.Context) {
+		operation, found := operationOf(c, paths)
+		if !found {
+			c.Next()
+			return
+		}
+		if problem := newSchemaValidator(document).validateRequest(c, operation); problem != nil {
+			AbortWithProblem(c, *problem)
+			return
+		}
+		if onResponseViolation == nil || c.IsWebsocket() || !recordable(operation) {
+			c.Next()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		validator := newSchemaValidator(document)
+		validator.validateResponse(operation, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes())
+		if len(validator.errors) > 0 {
+			onResponseViolation(c, validator.errors)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaValidator_validate(t *testing.T) {
+	controllers := &Controllers{}
+	document := OpenAPIDocument(controllers.Routes())
+	schema := map[string]interface{}{"$ref": "#/components/schemas/BatchRequest"}
+
+	for _, td := range []struct {
+		title          string
+		body           string
+		expectedErrors []FieldError
+	}{
+		{"Valid", `{"mode": "atomic", "operations": [{"method": "create", "note": {"title": "a"}}]}`, nil},
+		{"Unknown fields", `{"unknown": 1}`, nil},
+		{"Not an object", `[]`, []FieldError{{"", "must be an object"}}},
+		{"Not an array", `{"operations": {}}`, []FieldError{{"operations", "must be an array"}}},
+		{"Nested", `{"operations": [{}, {"id": "1", "note": {"content": 1}}]}`, []FieldError{
+			{"operations[1].id", "must be an integer"},
+			{"operations[1].note.title", "is required"},
+			{"operations[1].note.content", "must be a string"},
+		}},
+		{"Rules", `{"operations": [{"note": {"title": "a\nb"}}]}`, []FieldError{
+			{"operations[0].note.title", `must match ^[^\x00-\x1f\x7f]*$`},
+		}},
+		{"Fraction", `{"operations": [{"id": 1.5}]}`, []FieldError{{"operations[0].id", "must be an integer"}}},
+		{"Unsigned", `{"operations": [{"id": 18446744073709551615}]}`, nil},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			value, err := decodeJSON([]byte(td.body))
+			assert.Nil(t, err)
+			validator := newSchemaValidator(document)
+			validator.validate("", schema, value)
+			assert.ElementsMatch(t, td.expectedErrors, validator.errors)
+		})
+	}
+}
+
+func serveValidated(onResponseViolation func(c *gin.Context, errors []FieldError), handler gin.HandlerFunc, request *http.Request) *httptest.ResponseRecorder {
+	routes := []Route{
+		{Method: http.MethodPost, Path: "/notes", Handler: handler, Tag: "notes", Request: NoteRequest{},
+			Parameters: []Parameter{idempotencyKeyParameter},
+			Responses:  []Response{successResponse("Successfully added"), problemResponse(http.StatusBadRequest, "Invalid input")}},
+		{Method: http.MethodGet, Path: "/notes/:id", Handler: handler, Tag: "notes",
+			Responses: []Response{{http.StatusOK, "Successful operation", "", Note{}}}},
+		{Method: http.MethodGet, Path: "/sync", Handler: handler, Tag: "sync",
+			Parameters: []Parameter{{Name: "since", In: "query", Required: true, Schema: map[string]interface{}{"type": "integer"}}},
+			Responses:  []Response{{Status: http.StatusOK, Description: "Successful operation"}}},
+		{Method: http.MethodPost, Path: "/import", Handler: handler, Tag: "backup",
+			Request: map[string]interface{}{"type": "string", "format": "binary"}, RequestContentType: "application/octet-stream",
+			Responses: []Response{successResponse("Imported")}},
+	}
+	response := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(response)
+	group := router.Group(API_BASE_PATH)
+	group.Use(OpenAPIValidationMiddleware(OpenAPIDocument(routes), onResponseViolation))
+	for _, route := range routes {
+		group.Handle(route.Method, route.Path, route.Handler)
+	}
+	router.ServeHTTP(response, request)
+	return response
+}
+
+func TestOpenAPIValidationMiddleware_request(t *testing.T) {
+	respond := func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, ApiResponse{http.StatusOK, "Success"})
+	}
+	for _, td := range []struct {
+		title          string
+		request        *http.Request
+		expectedStatus int
+		expectedErrors []FieldError
+	}{
+		{"Valid body", httptest.NewRequest(http.MethodPost, "/v1/notes", strings.NewReader(`{"title": "a"}`)), http.StatusOK, nil},
+		{"Invalid body", httptest.NewRequest(http.MethodPost, "/v1/notes", strings.NewReader(`{"content": 1}`)), http.StatusBadRequest,
+			[]FieldError{{"title", "is required"}, {"content", "must be a string"}}},
+		{"Not JSON", httptest.NewRequest(http.MethodPost, "/v1/notes", strings.NewReader(`{`)), http.StatusBadRequest, nil},
+		{"Valid path parameter", httptest.NewRequest(http.MethodGet, "/v1/notes/1", nil), http.StatusOK, nil},
+		{"Invalid path parameter", httptest.NewRequest(http.MethodGet, "/v1/notes/a", nil), http.StatusBadRequest,
+			[]FieldError{{"id", "must be an integer"}}},
+		{"Missing query parameter", httptest.NewRequest(http.MethodGet, "/v1/sync", nil), http.StatusBadRequest,
+			[]FieldError{{"since", "is required"}}},
+		{"Valid query parameter", httptest.NewRequest(http.MethodGet, "/v1/sync?since=1", nil), http.StatusOK, nil},
+		{"Body that is not JSON", httptest.NewRequest(http.MethodPost, "/v1/import", strings.NewReader("PK")), http.StatusOK, nil},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			response := serveValidated(nil, respond, td.request)
+			assert.Equal(t, td.expectedStatus, response.Code)
+			if td.expectedErrors != nil {
+				assert.Equal(t, PROBLEM_CONTENT_TYPE, response.Header().Get("Content-Type"))
+				var problem Problem
+				assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &problem))
+				assert.Equal(t, PROBLEM_SCHEMA_VIOLATION, problem.Type)
+				assert.ElementsMatch(t, td.expectedErrors, problem.Errors)
+			}
+		})
+	}
+
+	t.Run("Header parameter", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/v1/notes", strings.NewReader(`{"title": "a"}`))
+		request.Header.Set(IDEMPOTENCY_KEY_HEADER, strings.Repeat("k", 256))
+		response := serveValidated(nil, respond, request)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.Contains(t, response.Body.String(), "must be at most 255 characters")
+	})
+
+	t.Run("Body is kept for the handler", func(t *testing.T) {
+		var request NoteRequest
+		response := serveValidated(nil, func(c *gin.Context) {
+			assert.Nil(t, c.ShouldBindJSON(&request))
+			respond(c)
+		}, httptest.NewRequest(http.MethodPost, "/v1/notes", strings.NewReader(`{"title": "a"}`)))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, NoteRequest{Title: "a"}, request)
+	})
+}
+
+func TestOpenAPIValidationMiddleware_response(t *testing.T) {
+	for _, td := range []struct {
+		title              string
+		handler            gin.HandlerFunc
+		expectedViolations []FieldError
+	}{
+		{"Valid", func(c *gin.Context) {
+			c.IndentedJSON(http.StatusOK, Note{ID: 1, Title: "a"})
+		}, nil},
+		{"Invalid body", func(c *gin.Context) {
+			c.IndentedJSON(http.StatusOK, map[string]interface{}{"id": "1", "title": 2})
+		}, []FieldError{{"id", "must be an integer"}, {"title", "must be a string"}}},
+		{"Undocumented status", func(c *gin.Context) {
+			c.Status(http.StatusTeapot)
+		}, []FieldError{{"", "status 418 is not documented"}}},
+		{"Undocumented content type", func(c *gin.Context) {
+			c.String(http.StatusOK, "note")
+		}, []FieldError{{"", "content type text/plain; charset=utf-8 is not documented"}}},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			var violations []FieldError
+			response := serveValidated(func(c *gin.Context, errors []FieldError) {
+				violations = errors
+			}, td.handler, httptest.NewRequest(http.MethodGet, "/v1/notes/1", nil))
+			assert.NotEqual(t, http.StatusBadRequest, response.Code)
+			assert.ElementsMatch(t, td.expectedViolations, violations)
+		})
+	}
+}
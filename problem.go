@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	PROBLEM_CONTENT_TYPE = "application/problem+json"
+
+	// Problem types are stable identifiers; clients can match on them
+	// instead of on titles or details.
+	PROBLEM_TYPE_BASE            = "https://github.com/hi-watana/todo-go-api/problems/"
+	PROBLEM_INVALID_ID           = PROBLEM_TYPE_BASE + "invalid-id"
+	PROBLEM_INVALID_REQUEST_BODY = PROBLEM_TYPE_BASE + "invalid-request-body"
+	PROBLEM_ILLEGAL_ID           = PROBLEM_TYPE_BASE + "illegal-id"
+	PROBLEM_NOT_FOUND            = PROBLEM_TYPE_BASE + "not-found"
+	PROBLEM_INVALID_PARAMETER    = PROBLEM_TYPE_BASE + "invalid-parameter"
+	PROBLEM_VALIDATION_FAILED    = PROBLEM_TYPE_BASE + "validation-failed"
+	PROBLEM_INTERNAL_ERROR       = PROBLEM_TYPE_BASE + "internal-error"
+	PROBLEM_KEY_REUSED           = PROBLEM_TYPE_BASE + "idempotency-key-reused"
+	PROBLEM_REQUEST_IN_PROGRESS  = PROBLEM_TYPE_BASE + "request-in-progress"
+	PROBLEM_SCHEMA_VIOLATION     = PROBLEM_TYPE_BASE + "schema-violation"
+	PROBLEM_RATE_LIMITED         = PROBLEM_TYPE_BASE + "rate-limited"
+	PROBLEM_QUOTA_EXCEEDED       = PROBLEM_TYPE_BASE + "quota-exceeded"
+)
+
+// Problem is an error response in the format of RFC 7807.
+type Problem struct {
+	Type     string       `json:"type" doc:"Stable identifier of the kind of the error"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	// Quota is the usage of the workspace, when a quota is exceeded.
+	Quota *UsageResponse `json:"quota,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func InvalidIdProblem() Problem {
+	return Problem{Type: PROBLEM_INVALID_ID, Title: "Invalid ID", Status: http.StatusBadRequest,
+		Detail: "ID in path must be an unsigned integer"}
+}
+
+// InvalidParameterProblem is for invalid query parameters and headers.
+func InvalidParameterProblem(name string, message string) Problem {
+	return Problem{Type: PROBLEM_INVALID_PARAMETER, Title: "Invalid parameter", Status: http.StatusBadRequest,
+		Detail: name + " " + message, Errors: []FieldError{{name, message}}}
+}
+
+func NotFoundProblem() Problem {
+	return Problem{Type: PROBLEM_NOT_FOUND, Title: "Not found", Status: http.StatusNotFound,
+		Detail: "Note does not exist"}
+}
+
+func InternalErrorProblem() Problem {
+	return Problem{Type: PROBLEM_INTERNAL_ERROR, Title: "Unexpected error", Status: http.StatusInternalServerError}
+}
+
+// InvalidRequestBodyProblem describes why the request body could not be
+// bound, with the fields of wrong types if any.
+func InvalidRequestBodyProblem(err error) Problem {
+	problem := Problem{Type: PROBLEM_INVALID_REQUEST_BODY, Title: "Invalid request body", Status: http.StatusBadRequest}
+	var typeError *json.UnmarshalTypeError
+	var syntaxError *json.SyntaxError
+	switch {
+	case errors.As(err, &typeError):
+		problem.Detail = "Request body has fields of wrong types"
+		problem.Errors = []FieldError{{typeError.Field, "must be " + typeError.Type.String()}}
+	case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+		problem.Detail = "Request body is not valid JSON"
+	case errors.Is(err, io.EOF):
+		problem.Detail = "Request body is empty"
+	default:
+		problem.Detail = "Request body must be a JSON object"
+	}
+	return problem
+}
+
+func SchemaViolationProblem(errors []FieldError) Problem {
+	return Problem{Type: PROBLEM_SCHEMA_VIOLATION, Title: "Schema violation", Status: http.StatusBadRequest,
+		Detail: "Request does not match the OpenAPI document", Errors: errors}
+}
+
+// RequestTooLargeProblem rejects a body of more than maxSize bytes.
+func RequestTooLargeProblem(maxSize int) Problem {
+	problem := InvalidRequestBodyProblem(nil)
+	problem.Status = http.StatusRequestEntityTooLarge
+	problem.Detail = fmt.Sprintf("Request body must not exceed %d MiB", maxSize>>20)
+	return problem
+}
+
+func RateLimitedProblem(retryAfter int) Problem {
+	return Problem{Type: PROBLEM_RATE_LIMITED, Title: "Too many requests", Status: http.StatusTooManyRequests,
+		Detail: fmt.Sprintf("Rate limit exceeded; retry after %d seconds", retryAfter)}
+}
+
+// ErrorProblem maps errors returned by services to problems.
+func ErrorProblem(err error) Problem {
+	var validationError *ValidationError
+	var quotaExceededError *QuotaExceededError
+	switch {
+	case errors.As(err, &validationError):
+		return Problem{Type: PROBLEM_VALIDATION_FAILED, Title: "Validation failed", Status: http.StatusUnprocessableEntity,
+			Detail: "Request body has invalid fields", Errors: validationError.Errors}
+	case errors.Is(err, &IllegalIdError{}):
+		return Problem{Type: PROBLEM_ILLEGAL_ID, Title: "Illegal ID", Status: http.StatusBadRequest,
+			Detail: "ID must not be specified in request body", Errors: []FieldError{{"id", "must not be specified"}}}
+	case errors.As(err, &quotaExceededError):
+		return Problem{Type: PROBLEM_QUOTA_EXCEEDED, Title: "Quota exceeded", Status: http.StatusForbidden,
+			Detail: fmt.Sprintf("Change would exceed the quota of %d %s", quotaExceededError.limit(), quotaExceededError.Quota),
+			Quota:  &quotaExceededError.Usage}
+	default:
+		return InternalErrorProblem()
+	}
+}
+
+// RespondProblem writes the problem as application/problem+json, with the
+// request path as its instance unless set.
+func RespondProblem(c *gin.Context, problem Problem) {
+	if problem.Instance == "" {
+		problem.Instance = c.Request.URL.Path
+	}
+	c.Header("Content-Type", PROBLEM_CONTENT_TYPE)
+	c.IndentedJSON(problem.Status, problem)
+}
+
+// AbortWithProblem is RespondProblem for middleware.
+func AbortWithProblem(c *gin.Context, problem Problem) {
+	RespondProblem(c, problem)
+	c.Abort()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func problemAt(problem Problem, instance string) *Problem {
+	problem.Instance = instance
+	return &problem
+}
+
+func TestRespondProblem(t *testing.T) {
+	response := httptest.NewRecorder()
+	ginContext, _ := gin.CreateTestContext(response)
+	ginContext.Request, _ = http.NewRequest("POST", "/v1/notes", nil)
+
+	RespondProblem(ginContext, ErrorProblem(&IllegalIdError{}))
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Equal(t, "application/problem+json", response.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "https://github.com/hi-watana/todo-go-api/problems/illegal-id",
+		"title": "Illegal ID",
+		"status": 400,
+		"detail": "ID must not be specified in request body",
+		"instance": "/v1/notes",
+		"errors": [{"field": "id", "message": "must not be specified"}]
+	}`, response.Body.String())
+}
+
+func TestErrorProblem(t *testing.T) {
+	assert.Equal(t, PROBLEM_ILLEGAL_ID, ErrorProblem(&IllegalIdError{}).Type)
+	assert.Equal(t, PROBLEM_INTERNAL_ERROR, ErrorProblem(&InternalError{}).Type)
+	assert.Equal(t, http.StatusInternalServerError, ErrorProblem(errors.New("unknown")).Status)
+}
+
+func TestInvalidRequestBodyProblem(t *testing.T) {
+	for _, td := range []struct {
+		title          string
+		err            error
+		expectedDetail string
+		expectedErrors []FieldError
+	}{
+		{
+			title:          "Reports field of wrong type",
+			err:            &json.UnmarshalTypeError{Field: "title", Type: reflect.TypeOf("")},
+			expectedDetail: "Request body has fields of wrong types",
+			expectedErrors: []FieldError{{"title", "must be string"}},
+		},
+		{
+			title:          "Reports invalid JSON",
+			err:            &json.SyntaxError{},
+			expectedDetail: "Request body is not valid JSON",
+		},
+		{
+			title:          "Reports truncated JSON",
+			err:            io.ErrUnexpectedEOF,
+			expectedDetail: "Request body is not valid JSON",
+		},
+		{
+			title:          "Reports empty body",
+			err:            io.EOF,
+			expectedDetail: "Request body is empty",
+		},
+		{
+			title:          "Reports anything else as not an object",
+			err:            errors.New("unknown"),
+			expectedDetail: "Request body must be a JSON object",
+		},
+	} {
+		t.Run("InvalidRequestBodyProblem: "+td.title, func(t *testing.T) {
+			problem := InvalidRequestBodyProblem(td.err)
+
+			assert.Equal(t, PROBLEM_INVALID_REQUEST_BODY, problem.Type)
+			assert.Equal(t, http.StatusBadRequest, problem.Status)
+			assert.Equal(t, td.expectedDetail, problem.Detail)
+			assert.Equal(t, td.expectedErrors, problem.Errors)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// DEFAULT_WORKSPACE is the workspace of all notes, as the API has no
+	// users or workspaces of its own yet. The quota is thus shared by all
+	// clients rather than limiting each user.
+	DEFAULT_WORKSPACE = "default"
+
+	QUOTA_NOTES = "notes"
+	QUOTA_BYTES = "bytes"
+)
+
+// QuotaUsage is what the notes of a workspace count against its quota: the
+// number of notes and the bytes of their titles and contents. It is kept up
+// to date in the transactions changing the notes.
+type QuotaUsage struct {
+	Workspace string `gorm:"primaryKey"`
+	Notes     int64
+	Bytes     int64
+}
+
+type QuotaAmount struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit,omitempty" doc:"Limit of the quota; absent if unlimited"`
+}
+
+type UsageResponse struct {
+	Workspace string      `json:"workspace"`
+	Notes     QuotaAmount `json:"notes"`
+	Bytes     QuotaAmount `json:"bytes"`
+}
+
+func (qc QuotaConfig) unlimited() bool {
+	return qc.MaxNotes == 0 && qc.MaxBytes == 0
+}
+
+func (qc QuotaConfig) usageResponse(usage QuotaUsage) UsageResponse {
+	return UsageResponse{
+		Workspace: usage.Workspace,
+		Notes:     QuotaAmount{usage.Notes, int64(qc.MaxNotes)},
+		Bytes:     QuotaAmount{usage.Bytes, int64(qc.MaxBytes)},
+	}
+}
+
+// check returns a QuotaExceededError if a change took the usage over a
+// limit. A change that does not increase the usage is allowed even over the
+// limit, so that notes can still be shrunk and deleted when the quota is
+// lowered.
+func (qc QuotaConfig) check(before QuotaUsage, after QuotaUsage) error {
+	exceeded := func(limit int, before int64, after int64) bool {
+		return limit > 0 && after > int64(limit) && after > before
+	}
+	switch {
+	case exceeded(qc.MaxNotes, before.Notes, after.Notes):
+		return &QuotaExceededError{QUOTA_NOTES, qc.usageResponse(before)}
+	case exceeded(qc.MaxBytes, before.Bytes, after.Bytes):
+		return &QuotaExceededError{QUOTA_BYTES, qc.usageResponse(before)}
+	}
+	return nil
+}
+
+// within makes the change with fn, in a transaction rolled back with a
+// QuotaExceededError if the change takes the usage over the quota. It
+// returns false if fn does.
+func (qc QuotaConfig) within(noteRepository INoteRepository, fn func(noteRepository INoteRepository) bool) (bool, error) {
+	if qc.unlimited() {
+		return fn(noteRepository), nil
+	}
+	var err error
+	ok := noteRepository.Transaction(func(noteRepository INoteRepository) bool {
+		before, ok := noteRepository.Usage()
+		if !ok || !fn(noteRepository) {
+			return false
+		}
+		after, ok := noteRepository.Usage()
+		if !ok {
+			return false
+		}
+		err = qc.check(before, after)
+		return err == nil
+	})
+	return ok || err != nil, err
+}
+
+// QuotaExceededError is returned for a change that would take the usage of
+// the workspace over the limit of Quota, notes or bytes.
+type QuotaExceededError struct {
+	Quota string
+	Usage UsageResponse
+}
+
+func quotaExceeded(err error) bool {
+	var quotaExceededError *QuotaExceededError
+	return errors.As(err, &quotaExceededError)
+}
+
+func (e *QuotaExceededError) limit() int64 {
+	if e.Quota == QUOTA_BYTES {
+		return e.Usage.Bytes.Limit
+	}
+	return e.Usage.Notes.Limit
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota of %d %s exceeded", e.limit(), e.Quota)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaConfig_check(t *testing.T) {
+	quota := QuotaConfig{MaxNotes: 2, MaxBytes: 100}
+	usage := func(notes int64, bytes int64) QuotaUsage {
+		return QuotaUsage{DEFAULT_WORKSPACE, notes, bytes}
+	}
+	for _, td := range []struct {
+		title         string
+		before, after QuotaUsage
+		expectedQuota string
+	}{
+		{"Allows changes within the quota", usage(1, 50), usage(2, 100), ""},
+		{"Rejects too many notes", usage(2, 50), usage(3, 60), QUOTA_NOTES},
+		{"Rejects too many bytes", usage(1, 90), usage(1, 101), QUOTA_BYTES},
+		{"Allows shrinking over the quota", usage(3, 150), usage(3, 120), ""},
+		{"Allows deleting over the quota", usage(3, 150), usage(2, 150), ""},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			err := quota.check(td.before, td.after)
+			if td.expectedQuota == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, &QuotaExceededError{td.expectedQuota, quota.usageResponse(td.before)}, err)
+		})
+	}
+
+	assert.NoError(t, QuotaConfig{}.check(usage(0, 0), usage(1000, 1<<30)))
+}
+
+func TestErrorProblem_quotaExceeded(t *testing.T) {
+	usage := UsageResponse{DEFAULT_WORKSPACE, QuotaAmount{2, 2}, QuotaAmount{10, 0}}
+
+	problem := ErrorProblem(&QuotaExceededError{QUOTA_NOTES, usage})
+
+	assert.Equal(t, Problem{Type: PROBLEM_QUOTA_EXCEEDED, Title: "Quota exceeded", Status: http.StatusForbidden,
+		Detail: "Change would exceed the quota of 2 notes", Quota: &usage}, problem)
+}
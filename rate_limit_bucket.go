@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimitBucket is the token bucket of a client: the tokens left when it
+// was last refilled. A bucket that is full can be deleted, as a new bucket
+// is full.
+type RateLimitBucket struct {
+	Key        string `gorm:"primaryKey"`
+	Tokens     float64
+	RefilledAt time.Time
+	FullAt     time.Time `gorm:"index"`
+}
+
+// RateLimitResult is the state of a bucket after a token is taken from it.
+// Reset is the time until the bucket is full again, and RetryAfter, if the
+// request is not allowed, the time until the next token.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+func (rl RateLimit) burst() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return float64(rl.Requests)
+}
+
+// rate is the number of tokens added to a bucket per second.
+func (rl RateLimit) rate() float64 {
+	return float64(rl.Requests) / time.Duration(rl.Period).Seconds()
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// take refills the bucket for the time since it was last refilled, and
+// takes a token from it if there is one. A bucket never refilled is full.
+func (b RateLimitBucket) take(limit RateLimit, now time.Time) (RateLimitBucket, RateLimitResult) {
+	burst, rate := limit.burst(), limit.rate()
+	tokens := burst
+	if !b.RefilledAt.IsZero() {
+		elapsed := math.Max(now.Sub(b.RefilledAt).Seconds(), 0)
+		tokens = math.Min(burst, b.Tokens+elapsed*rate)
+	}
+
+	var result RateLimitResult
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - tokens) / rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = secondsDuration((burst - tokens) / rate)
+	return RateLimitBucket{Key: b.Key, Tokens: tokens, RefilledAt: now, FullAt: now.Add(result.Reset)}, result
+}
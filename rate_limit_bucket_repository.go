@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const RATE_LIMIT_SWEEP_INTERVAL = time.Minute
+
+// RateLimitStore keeps the token buckets of the clients.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of the key, limited by the limit.
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// MemoryRateLimitStore keeps the buckets in the process, so that each
+// replica limits the clients on its own.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]RateLimitBucket
+	now     func() time.Time
+	sweptAt time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]RateLimitBucket{}, now: time.Now}
+}
+
+func (ms *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := ms.now()
+	if now.Sub(ms.sweptAt) >= RATE_LIMIT_SWEEP_INTERVAL {
+		for key, bucket := range ms.buckets {
+			if !bucket.FullAt.After(now) {
+				delete(ms.buckets, key)
+			}
+		}
+		ms.sweptAt = now
+	}
+
+	bucket, result := ms.buckets[key].take(limit, now)
+	bucket.Key = key
+	ms.buckets[key] = bucket
+	return result, nil
+}
+
+// RateLimitBucketRepository keeps the buckets in the database, so that the
+// replicas share them.
+type RateLimitBucketRepository struct {
+	db *gorm.DB
+}
+
+// Take locks the row of the bucket, creating it full if needed, so that
+// concurrent requests of the client wait for each other.
+func (br *RateLimitBucketRepository) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	var result RateLimitResult
+	err := br.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		full := RateLimitBucket{Key: key, Tokens: limit.burst(), RefilledAt: now, FullAt: now}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&full).Error; err != nil {
+			return err
+		}
+		var bucket RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).Take(&bucket).Error; err != nil {
+			return err
+		}
+		bucket, result = bucket.take(limit, now)
+		return tx.Save(&bucket).Error
+	})
+	return result, err
+}
+
+func (br *RateLimitBucketRepository) DeleteFull() bool {
+	result := br.db.Where("full_at <= ?", time.Now()).Delete(&RateLimitBucket{})
+	return result.Error == nil
+}
+
+// DeleteFullPeriodically keeps the table from growing with the buckets of
+// clients that are gone, until the context is done.
+func DeleteFullPeriodically(ctx context.Context, rateLimitBucketRepository *RateLimitBucketRepository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rateLimitBucketRepository.DeleteFull()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
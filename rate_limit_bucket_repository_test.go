@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type RateLimitBucketRepositoryTestSuite struct {
+	suite.Suite
+	rateLimitBucketRepository RateLimitBucketRepository
+	mock                      sqlmock.Sqlmock
+}
+
+func (ts *RateLimitBucketRepositoryTestSuite) SetupTest() {
+	db, mock, _ := sqlmock.New()
+	ts.mock = mock
+	rateLimitBucketRepository := RateLimitBucketRepository{}
+	rateLimitBucketRepository.db, _ = gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	ts.rateLimitBucketRepository = rateLimitBucketRepository
+}
+
+func (ts *RateLimitBucketRepositoryTestSuite) TearDownTest() {
+	db, _ := ts.rateLimitBucketRepository.db.DB()
+	db.Close()
+}
+
+func (ts *RateLimitBucketRepositoryTestSuite) TestRateLimitBucketRepository_Take() {
+	limit := RateLimit{Requests: 10, Period: Duration(time.Minute)}
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectExec(`INSERT INTO "rate_limit_buckets" .* ON CONFLICT DO NOTHING`).
+		WithArgs("key", 10.0, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"key", "tokens", "refilled_at", "full_at"}).
+		AddRow("key", 0.5, time.Now().Add(-3*time.Second), time.Now().Add(time.Minute))
+	ts.mock.ExpectQuery(`SELECT \* FROM "rate_limit_buckets" WHERE key = \$1 LIMIT 1 FOR UPDATE`).
+		WithArgs("key").WillReturnRows(rows)
+	ts.mock.ExpectExec(`UPDATE "rate_limit_buckets" SET "tokens"=\$1,"refilled_at"=\$2,"full_at"=\$3 WHERE "key" = \$4`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "key").WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.mock.ExpectCommit()
+
+	result, err := ts.rateLimitBucketRepository.Take(context.Background(), "key", limit)
+
+	assert.NoError(ts.T(), err)
+	assert.True(ts.T(), result.Allowed)
+	assert.Equal(ts.T(), 0, result.Remaining)
+	assert.NoError(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *RateLimitBucketRepositoryTestSuite) TestRateLimitBucketRepository_Take_error() {
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectExec(`INSERT INTO "rate_limit_buckets"`).WillReturnError(gorm.ErrInvalidDB)
+	ts.mock.ExpectRollback()
+
+	_, err := ts.rateLimitBucketRepository.Take(context.Background(), "key", RateLimit{Requests: 10, Period: Duration(time.Minute)})
+
+	assert.Equal(ts.T(), gorm.ErrInvalidDB, err)
+	assert.NoError(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *RateLimitBucketRepositoryTestSuite) TestRateLimitBucketRepository_DeleteFull() {
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectExec(`DELETE FROM "rate_limit_buckets" WHERE full_at <= \$1`).
+		WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 2))
+	ts.mock.ExpectCommit()
+
+	assert.True(ts.T(), ts.rateLimitBucketRepository.DeleteFull())
+	assert.NoError(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func TestRateLimitBucketRepositoryTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitBucketRepositoryTestSuite))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RATE_LIMIT_STORE_MEMORY   = "memory"
+	RATE_LIMIT_STORE_POSTGRES = "postgres"
+
+	API_KEY_HEADER = "X-API-Key"
+
+	RATE_LIMIT_LIMIT_HEADER     = "RateLimit-Limit"
+	RATE_LIMIT_REMAINING_HEADER = "RateLimit-Remaining"
+	RATE_LIMIT_RESET_HEADER     = "RateLimit-Reset"
+	RATE_LIMIT_POLICY_HEADER    = "RateLimit-Policy"
+	RETRY_AFTER_HEADER          = "Retry-After"
+)
+
+// rateLimitClient identifies the client by a hash of its API key, given as a
+// bearer token or in X-API-Key, if it is one of the configured keys, or else
+// by its IP address, so that clients cannot get fresh buckets by making up
+// keys.
+func rateLimitClient(c *gin.Context, apiKeys map[[sha256.Size]byte]bool) string {
+	key := c.GetHeader(API_KEY_HEADER)
+	if authorization := c.GetHeader("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		key = strings.TrimPrefix(authorization, "Bearer ")
+	}
+	hash := sha256.Sum256([]byte(key))
+	if key == "" || !apiKeys[hash] {
+		return "ip:" + c.ClientIP()
+	}
+	return "key:" + hex.EncodeToString(hash[:16])
+}
+
+// routeOf returns the method and path of the route of the request as in
+// Routes, with the custom method in place of its parameter.
+func routeOf(c *gin.Context) string {
+	path := strings.TrimPrefix(c.FullPath(), API_BASE_PATH)
+	if strings.HasSuffix(path, ":method") {
+		path = strings.TrimSuffix(path, ":method") + c.Param("method")
+	}
+	return c.Request.Method + " " + path
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// RateLimitMiddleware takes a token for each request from the bucket of the
+// client for its route, or for the routes without limits of their own, and
+// rejects the request with 429 if there is none. The 429 is a problem, like
+// the other errors, rather than an ApiResponse; its status and detail take
+// the place of the status and message. The RateLimit-* headers tell clients
+// how many requests they have left. Requests are let through if the store
+// fails, rather than failing with it.
+func RateLimitMiddleware(store RateLimitStore, config RateLimitConfig) gin.HandlerFunc {
+	routes := map[string]RateLimit{}
+	for _, route := range config.Routes {
+		routes[strings.ToUpper(route.Method)+" "+route.Path] = route.Limit
+	}
+	apiKeys := map[[sha256.Size]byte]bool{}
+	for _, key := range config.APIKeys {
+		apiKeys[sha256.Sum256([]byte(key))] = true
+	}
+	return func(c *gin.Context) {
+		key, limit := rateLimitClient(c, apiKeys), config.Default
+		route := routeOf(c)
+		if routeLimit, found := routes[route]; found {
+			key, limit = key+" "+route, routeLimit
+		}
+
+		result, err := store.Take(c.Request.Context(), key, limit)
+		if err != nil {
+			LoggerFrom(c.Request.Context()).Error("Failed to take rate limit token", "error", err)
+			c.Next()
+			return
+		}
+		burst := strconv.Itoa(int(limit.burst()))
+		c.Header(RATE_LIMIT_LIMIT_HEADER, burst)
+		c.Header(RATE_LIMIT_REMAINING_HEADER, strconv.Itoa(result.Remaining))
+		c.Header(RATE_LIMIT_RESET_HEADER, strconv.Itoa(ceilSeconds(result.Reset)))
+		c.Header(RATE_LIMIT_POLICY_HEADER, burst+";w="+strconv.Itoa(ceilSeconds(secondsDuration(limit.burst()/limit.rate()))))
+		if !result.Allowed {
+			retryAfter := ceilSeconds(result.RetryAfter)
+			c.Header(RETRY_AFTER_HEADER, strconv.Itoa(retryAfter))
+			AbortWithProblem(c, RateLimitedProblem(retryAfter))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockRateLimitStore struct {
+	mock.Mock
+}
+
+func (ms *MockRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	ret := ms.Called(key, limit)
+	return ret.Get(0).(RateLimitResult), ret.Error(1)
+}
+
+func TestRateLimitBucket_take(t *testing.T) {
+	limit := RateLimit{Requests: 2, Period: Duration(time.Second), Burst: 3}
+	now := time.Now()
+
+	bucket, result := RateLimitBucket{Key: "key"}.take(limit, now)
+	assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 2, Reset: 500 * time.Millisecond}, result)
+	assert.Equal(t, RateLimitBucket{Key: "key", Tokens: 2, RefilledAt: now, FullAt: now.Add(500 * time.Millisecond)}, bucket)
+
+	bucket, _ = bucket.take(limit, now)
+	bucket, _ = bucket.take(limit, now)
+	bucket, result = bucket.take(limit, now)
+	assert.Equal(t, RateLimitResult{Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}, result)
+
+	bucket, result = bucket.take(limit, now.Add(750*time.Millisecond))
+	assert.True(t, result.Allowed)
+	assert.InDelta(t, 0.5, bucket.Tokens, 1e-9)
+
+	_, result = bucket.take(limit, now.Add(time.Hour))
+	assert.Equal(t, 2, result.Remaining)
+}
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Requests: 1, Period: Duration(time.Second)}
+
+	result, err := store.Take(context.Background(), "a", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, _ = store.Take(context.Background(), "a", limit)
+	assert.False(t, result.Allowed)
+	result, _ = store.Take(context.Background(), "b", limit)
+	assert.True(t, result.Allowed)
+
+	// Full buckets are swept.
+	now = now.Add(RATE_LIMIT_SWEEP_INTERVAL)
+	store.Take(context.Background(), "a", limit)
+	assert.Equal(t, []string{"a"}, func() (keys []string) {
+		for key := range store.buckets {
+			keys = append(keys, key)
+		}
+		return
+	}())
+}
+
+func serveRateLimited(store RateLimitStore, config RateLimitConfig, method string, path string, header http.Header) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	_, router := gin.CreateTestContext(response)
+	group := router.Group(API_BASE_PATH)
+	group.Use(RateLimitMiddleware(store, config))
+	handler := func(c *gin.Context) { c.Status(http.StatusOK) }
+	group.GET("/notes", handler)
+	group.POST("/notes", handler)
+	group.POST("/notes:method", customMethods(map[string]gin.HandlerFunc{":batch": handler}))
+
+	req, _ := http.NewRequest(method, API_BASE_PATH+path, nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	for name, values := range header {
+		req.Header.Set(name, values[0])
+	}
+	router.ServeHTTP(response, req)
+	return response
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	config := RateLimitConfig{
+		Default: RateLimit{Requests: 100, Period: Duration(time.Minute)},
+		Routes: []RouteRateLimit{
+			{Method: "post", Path: "/notes", Limit: RateLimit{Requests: 10, Period: Duration(time.Minute), Burst: 5}},
+			{Method: "POST", Path: "/notes:batch", Limit: RateLimit{Requests: 1, Period: Duration(time.Minute)}},
+		},
+		APIKeys: []string{"secret"},
+	}
+	apiKey := "key:2bb80d537b1da3e38bd30361aa855686"
+	for _, td := range []struct {
+		title       string
+		method      string
+		path        string
+		header      http.Header
+		expectedKey string
+		limit       RateLimit
+	}{
+		{"Limits other routes by the default", http.MethodGet, "/notes", nil, "ip:192.0.2.1", config.Default},
+		{"Limits a route by its limit", http.MethodPost, "/notes", nil, "ip:192.0.2.1 POST /notes", config.Routes[0].Limit},
+		{"Limits a custom method by its limit", http.MethodPost, "/notes:batch", nil, "ip:192.0.2.1 POST /notes:batch", config.Routes[1].Limit},
+		{"Identifies clients by bearer tokens", http.MethodGet, "/notes", http.Header{"Authorization": {"Bearer secret"}}, apiKey, config.Default},
+		{"Identifies clients by X-API-Key", http.MethodGet, "/notes", http.Header{API_KEY_HEADER: {"secret"}}, apiKey, config.Default},
+		{"Identifies clients with unknown keys by IP", http.MethodGet, "/notes", http.Header{API_KEY_HEADER: {"made-up"}}, "ip:192.0.2.1", config.Default},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			store := &MockRateLimitStore{}
+			store.On("Take", td.expectedKey, td.limit).Return(RateLimitResult{Allowed: true, Remaining: 4, Reset: 6 * time.Second}, nil)
+
+			response := serveRateLimited(store, config, td.method, td.path, td.header)
+
+			assert.Equal(t, http.StatusOK, response.Code)
+			store.AssertExpectations(t)
+		})
+	}
+
+	store := &MockRateLimitStore{}
+	store.On("Take", "ip:192.0.2.1 POST /notes", config.Routes[0].Limit).
+		Return(RateLimitResult{Allowed: true, Remaining: 4, Reset: 5500 * time.Millisecond}, nil)
+	response := serveRateLimited(store, config, http.MethodPost, "/notes", nil)
+	assert.Equal(t, "5", response.Header().Get(RATE_LIMIT_LIMIT_HEADER))
+	assert.Equal(t, "4", response.Header().Get(RATE_LIMIT_REMAINING_HEADER))
+	assert.Equal(t, "6", response.Header().Get(RATE_LIMIT_RESET_HEADER))
+	assert.Equal(t, "5;w=30", response.Header().Get(RATE_LIMIT_POLICY_HEADER))
+	assert.Empty(t, response.Header().Get(RETRY_AFTER_HEADER))
+}
+
+func TestRateLimitMiddleware_limited(t *testing.T) {
+	config := RateLimitConfig{Default: RateLimit{Requests: 10, Period: Duration(time.Minute)}}
+	store := &MockRateLimitStore{}
+	store.On("Take", "ip:192.0.2.1", config.Default).
+		Return(RateLimitResult{Remaining: 0, Reset: time.Minute, RetryAfter: 5100 * time.Millisecond}, nil)
+
+	response := serveRateLimited(store, config, http.MethodGet, "/notes", nil)
+
+	assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	assert.Equal(t, "6", response.Header().Get(RETRY_AFTER_HEADER))
+	assert.Equal(t, "0", response.Header().Get(RATE_LIMIT_REMAINING_HEADER))
+	assert.Equal(t, PROBLEM_CONTENT_TYPE, response.Header().Get("Content-Type"))
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &problem))
+	assert.Equal(t, Problem{Type: PROBLEM_RATE_LIMITED, Title: "Too many requests", Status: http.StatusTooManyRequests,
+		Detail: "Rate limit exceeded; retry after 6 seconds", Instance: API_BASE_PATH + "/notes"}, problem)
+}
+
+func TestNewRouter_rateLimited(t *testing.T) {
+	config := RateLimitConfig{Default: RateLimit{Requests: 10, Period: Duration(time.Minute)}}
+	store := &MockRateLimitStore{}
+	store.On("Take", "ip:192.0.2.1", config.Default).Return(RateLimitResult{RetryAfter: time.Second}, nil)
+	router := NewRouter(&Controllers{}, RateLimitMiddleware(store, config))
+
+	for _, td := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, API_BASE_PATH + "/notes"},
+		{http.MethodPost, "/graphql"},
+		{"PROPFIND", CALDAV_CALENDAR_PATH},
+		{http.MethodPut, CALDAV_CALENDAR_PATH + "1.ics"},
+	} {
+		t.Run(td.method+" "+td.path, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			req, _ := http.NewRequest(td.method, td.path, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			router.ServeHTTP(response, req)
+
+			assert.Equal(t, http.StatusTooManyRequests, response.Code)
+			assert.Equal(t, "1", response.Header().Get(RETRY_AFTER_HEADER))
+		})
+	}
+}
+
+func TestRateLimitMiddleware_storeFailure(t *testing.T) {
+	config := RateLimitConfig{Default: RateLimit{Requests: 10, Period: Duration(time.Minute)}}
+	store := &MockRateLimitStore{}
+	store.On("Take", "ip:192.0.2.1", config.Default).Return(RateLimitResult{}, errors.New("connection refused"))
+
+	response := serveRateLimited(store, config, http.MethodGet, "/notes", nil)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Empty(t, response.Header().Get(RATE_LIMIT_LIMIT_HEADER))
+}
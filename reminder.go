@@ -0,0 +1,35 @@
+package main
+
+import "time"
+
+const (
+	REMINDER_POLL_INTERVAL = 15 * time.Second
+	REMINDER_BATCH_SIZE    = 10
+	MAX_REMINDER_ATTEMPTS  = 5
+	REMINDER_RETRY_DELAY   = time.Minute
+	REMINDER_CLAIM_LEASE   = 5 * time.Minute
+)
+
+// Reminder notifies about a note at RemindAt. FiredAt is set once the
+// notification is sent. A failed attempt is retried at NextAttemptAt, with
+// the delay doubling after each failure; a reminder that failed
+// MAX_REMINDER_ATTEMPTS times is no longer retried.
+type Reminder struct {
+	ID            uint64     `gorm:"primaryKey" json:"id"`
+	NoteID        uint64     `gorm:"index" json:"note_id"`
+	RemindAt      time.Time  `gorm:"index" json:"remind_at"`
+	FiredAt       *time.Time `json:"fired_at,omitempty"`
+	Attempts      int        `json:"attempts" doc:"Number of failed attempts to send the notification"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at,omitempty" doc:"Time the reminder is tried again at unless fired"`
+}
+
+// retryDelay returns the delay before the next attempt of a reminder that
+// failed attempts times.
+func retryDelay(attempts int) time.Duration {
+	return REMINDER_RETRY_DELAY << (attempts - 1)
+}
+
+// ReminderRequest is the body of requests creating a reminder.
+type ReminderRequest struct {
+	RemindAt time.Time `json:"remind_at" doc:"Time to notify at. A time in the past fires at once."`
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type IReminderController interface {
+	Get(c *gin.Context)
+	Create(c *gin.Context)
+	Delete(c *gin.Context)
+}
+
+type ReminderController struct {
+	noteService     INoteService
+	reminderService IReminderService
+}
+
+// noteIdOf returns the ID of the existing note in the path, or responds with
+// a problem.
+func (rc *ReminderController) noteIdOf(c *gin.Context) (uint64, bool) {
+	id, err := getIdFromParamString(c.Param("id"))
+	if err != nil {
+		RespondProblem(c, InvalidIdProblem())
+		return 0, false
+	}
+	if _, found := rc.noteService.WithContext(c.Request.Context()).GetById(id); !found {
+		RespondProblem(c, NotFoundProblem())
+		return 0, false
+	}
+	return id, true
+}
+
+func (rc *ReminderController) Get(c *gin.Context) {
+	noteId, ok := rc.noteIdOf(c)
+	if !ok {
+		return
+	}
+	c.IndentedJSON(http.StatusOK, rc.reminderService.GetByNoteId(noteId))
+}
+
+func (rc *ReminderController) Create(c *gin.Context) {
+	noteId, ok := rc.noteIdOf(c)
+	if !ok {
+		return
+	}
+	var request ReminderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		RespondProblem(c, InvalidRequestBodyProblem(err))
+		return
+	}
+	if request.RemindAt.IsZero() {
+		RespondProblem(c, ErrorProblem(&ValidationError{[]FieldError{{"remind_at", "is required"}}}))
+		return
+	}
+
+	reminder, err := rc.reminderService.Create(Reminder{NoteID: noteId, RemindAt: request.RemindAt})
+	if err != nil {
+		RespondProblem(c, ErrorProblem(err))
+		return
+	}
+	c.Header("Location", API_BASE_PATH+"/notes/"+strconv.FormatUint(noteId, 10)+"/reminders/"+strconv.FormatUint(reminder.ID, 10))
+	c.IndentedJSON(http.StatusCreated, reminder)
+}
+
+func (rc *ReminderController) Delete(c *gin.Context) {
+	noteId, ok := rc.noteIdOf(c)
+	if !ok {
+		return
+	}
+	id, err := getIdFromParamString(c.Param("reminder_id"))
+	if err != nil {
+		RespondProblem(c, InvalidIdProblem())
+		return
+	}
+	if reminder, found := rc.reminderService.GetById(id); !found || reminder.NoteID != noteId {
+		problem := NotFoundProblem()
+		problem.Detail = "Reminder does not exist"
+		RespondProblem(c, problem)
+		return
+	}
+	if deleted := rc.reminderService.Delete(id); !deleted {
+		RespondProblem(c, InternalErrorProblem())
+		return
+	}
+	response := ApiResponse{200, "Success"}
+	c.IndentedJSON(http.StatusOK, response)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockReminderService struct {
+	mock.Mock
+}
+
+func (ms *MockReminderService) GetById(id uint64) (Reminder, bool) {
+	ret := ms.Called(id)
+	return ret.Get(0).(Reminder), ret.Get(1).(bool)
+}
+
+func (ms *MockReminderService) GetByNoteId(noteId uint64) []Reminder {
+	ret := ms.Called(noteId)
+	return ret.Get(0).([]Reminder)
+}
+
+func (ms *MockReminderService) Create(reminder Reminder) (Reminder, error) {
+	ret := ms.Called(reminder)
+	return ret.Get(0).(Reminder), ret.Error(1)
+}
+
+func (ms *MockReminderService) Delete(id uint64) bool {
+	ret := ms.Called(id)
+	return ret.Get(0).(bool)
+}
+
+func TestReminderController(t *testing.T) {
+	remindAt := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	for _, td := range []struct {
+		title            string
+		method           string
+		path             string
+		body             string
+		expectedStatus   int
+		expectedBody     string
+		expectedLocation string
+	}{
+		{
+			title:          "Lists reminders",
+			method:         "GET",
+			path:           "/v1/notes/1/reminders",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"id":3,"note_id":1,"remind_at":"2024-01-02T09:00:00Z","attempts":0}]`,
+		},
+		{
+			title:          "Returns \"Not found\" problem if the note does not exist",
+			method:         "GET",
+			path:           "/v1/notes/2/reminders",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			title:            "Creates a reminder",
+			method:           "POST",
+			path:             "/v1/notes/1/reminders",
+			body:             `{"remind_at": "2024-01-02T18:00:00+09:00"}`,
+			expectedStatus:   http.StatusCreated,
+			expectedBody:     `{"id":3,"note_id":1,"remind_at":"2024-01-02T09:00:00Z","attempts":0}`,
+			expectedLocation: "/v1/notes/1/reminders/3",
+		},
+		{
+			title:          "Returns \"Validation failed\" problem without a time",
+			method:         "POST",
+			path:           "/v1/notes/1/reminders",
+			body:           `{}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			title:          "Returns \"Invalid request body\" problem if the time is not RFC 3339",
+			method:         "POST",
+			path:           "/v1/notes/1/reminders",
+			body:           `{"remind_at": "9am tomorrow"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			title:          "Deletes a reminder",
+			method:         "DELETE",
+			path:           "/v1/notes/1/reminders/3",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":200,"message":"Success"}`,
+		},
+		{
+			title:          "Returns \"Not found\" problem if the reminder is of another note",
+			method:         "DELETE",
+			path:           "/v1/notes/1/reminders/4",
+			expectedStatus: http.StatusNotFound,
+		},
+	} {
+		t.Run("ReminderController: "+td.title, func(t *testing.T) {
+			mockService := &MockService{}
+			mockService.On("GetById", uint64(1)).Return(Note{ID: 1}, true)
+			mockService.On("GetById", uint64(2)).Return(Note{}, false)
+			reminder := Reminder{ID: 3, NoteID: 1, RemindAt: remindAt}
+			mockReminderService := &MockReminderService{}
+			mockReminderService.On("GetByNoteId", uint64(1)).Return([]Reminder{reminder})
+			mockReminderService.On("Create", Reminder{NoteID: 1, RemindAt: remindAt.In(time.FixedZone("", 9*60*60))}).
+				Return(reminder, nil)
+			mockReminderService.On("GetById", uint64(3)).Return(reminder, true)
+			mockReminderService.On("GetById", uint64(4)).Return(Reminder{ID: 4, NoteID: 2}, true)
+			mockReminderService.On("Delete", uint64(3)).Return(true)
+			router := NewRouter(&Controllers{reminderController: ReminderController{mockService, mockReminderService}})
+
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest(td.method, td.path, strings.NewReader(td.body))
+			router.ServeHTTP(response, request)
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			assert.Equal(t, td.expectedLocation, response.Header().Get("Location"))
+			if td.expectedBody != "" {
+				var expected, actual interface{}
+				json.Unmarshal([]byte(td.expectedBody), &expected)
+				json.Unmarshal(response.Body.Bytes(), &actual)
+				assert.Equal(t, expected, actual)
+			}
+		})
+	}
+}
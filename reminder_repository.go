@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IReminderRepository interface {
+	GetById(id uint64) (Reminder, bool)
+	GetByNoteId(noteId uint64) []Reminder
+	Create(reminder Reminder) (uint64, bool)
+	Delete(id uint64) bool
+	ClaimDue(now time.Time, limit int) ([]Reminder, bool)
+	MarkFired(id uint64, now time.Time) bool
+	MarkFailed(reminder Reminder, now time.Time) bool
+}
+
+type ReminderRepository struct {
+	db *gorm.DB
+}
+
+func (rr *ReminderRepository) GetById(id uint64) (Reminder, bool) {
+	var reminder Reminder
+	result := rr.db.First(&reminder, id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return reminder, false
+	}
+	return reminder, result.Error == nil
+}
+
+func (rr *ReminderRepository) GetByNoteId(noteId uint64) []Reminder {
+	reminders := []Reminder{}
+	rr.db.Where("note_id = ?", noteId).Order("remind_at").Find(&reminders)
+	return reminders
+}
+
+func (rr *ReminderRepository) Create(reminder Reminder) (uint64, bool) {
+	result := rr.db.Create(&reminder)
+	return reminder.ID, result.Error == nil
+}
+
+func (rr *ReminderRepository) Delete(id uint64) bool {
+	result := rr.db.Delete(&Reminder{}, id)
+	return result.Error == nil
+}
+
+// ClaimDue returns up to limit reminders due by now and not yet fired. The
+// claimed reminders are not due again for REMINDER_CLAIM_LEASE, so that
+// other replicas skip them while they are notified outside the transaction,
+// and a replica stopping before recording the attempt leaves them to be
+// retried.
+func (rr *ReminderRepository) ClaimDue(now time.Time, limit int) ([]Reminder, bool) {
+	var reminders []Reminder
+	err := rr.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("fired_at IS NULL AND attempts < ? AND remind_at <= ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)",
+				MAX_REMINDER_ATTEMPTS, now, now).
+			Order("remind_at").Limit(limit).Find(&reminders)
+		if result.Error != nil || len(reminders) == 0 {
+			return result.Error
+		}
+		ids := make([]uint64, 0, len(reminders))
+		for _, reminder := range reminders {
+			ids = append(ids, reminder.ID)
+		}
+		return tx.Model(&Reminder{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(REMINDER_CLAIM_LEASE)).Error
+	})
+	if err != nil {
+		return nil, false
+	}
+	return reminders, true
+}
+
+// MarkFired records that the notification of the reminder was sent.
+func (rr *ReminderRepository) MarkFired(id uint64, now time.Time) bool {
+	result := rr.db.Model(&Reminder{ID: id}).Update("fired_at", now)
+	return result.Error == nil
+}
+
+// MarkFailed records a failed attempt of the reminder, which is retried after
+// a delay doubling with each failure.
+func (rr *ReminderRepository) MarkFailed(reminder Reminder, now time.Time) bool {
+	result := rr.db.Model(&Reminder{ID: reminder.ID}).Updates(map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"next_attempt_at": now.Add(retryDelay(reminder.Attempts + 1)),
+	})
+	return result.Error == nil
+}
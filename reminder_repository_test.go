@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type ReminderRepositoryTestSuite struct {
+	suite.Suite
+	reminderRepository ReminderRepository
+	mock               sqlmock.Sqlmock
+}
+
+func (ts *ReminderRepositoryTestSuite) SetupTest() {
+	db, mock, _ := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	ts.mock = mock
+	reminderRepository := ReminderRepository{}
+	reminderRepository.db, _ = gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	ts.reminderRepository = reminderRepository
+}
+
+func (ts *ReminderRepositoryTestSuite) TearDownTest() {
+	db, _ := ts.reminderRepository.db.DB()
+	db.Close()
+}
+
+func (ts *ReminderRepositoryTestSuite) TestReminderRepository_GetByNoteId() {
+	remindAt := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"id", "note_id", "remind_at", "attempts"}).AddRow(1, 2, remindAt, 0)
+	ts.mock.ExpectQuery(`SELECT * FROM "reminders" WHERE note_id = $1 ORDER BY remind_at`).
+		WithArgs(2).WillReturnRows(rows)
+
+	reminders := ts.reminderRepository.GetByNoteId(2)
+
+	assert.Equal(ts.T(), []Reminder{{ID: 1, NoteID: 2, RemindAt: remindAt}}, reminders)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *ReminderRepositoryTestSuite) TestReminderRepository_Create() {
+	remindAt := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectQuery(`INSERT INTO "reminders" ("note_id","remind_at","fired_at","attempts","next_attempt_at") VALUES ($1,$2,$3,$4,$5) RETURNING "id"`).
+		WithArgs(2, remindAt, nil, 0, nil).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	ts.mock.ExpectCommit()
+
+	id, ok := ts.reminderRepository.Create(Reminder{NoteID: 2, RemindAt: remindAt})
+
+	assert.Equal(ts.T(), true, ok)
+	assert.Equal(ts.T(), uint64(1), id)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *ReminderRepositoryTestSuite) TestReminderRepository_ClaimDue() {
+	now := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"id", "note_id", "remind_at", "attempts"}).
+		AddRow(1, 2, now, 0).
+		AddRow(3, 4, now, 2)
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectQuery(`SELECT * FROM "reminders" WHERE fired_at IS NULL AND attempts < $1 AND remind_at <= $2 `+
+		`AND (next_attempt_at IS NULL OR next_attempt_at <= $3) ORDER BY remind_at LIMIT 10 FOR UPDATE SKIP LOCKED`).
+		WithArgs(MAX_REMINDER_ATTEMPTS, now, now).WillReturnRows(rows)
+	ts.mock.ExpectExec(`UPDATE "reminders" SET "next_attempt_at"=$1 WHERE id IN ($2,$3)`).
+		WithArgs(now.Add(REMINDER_CLAIM_LEASE), 1, 3).WillReturnResult(sqlmock.NewResult(0, 2))
+	ts.mock.ExpectCommit()
+
+	reminders, ok := ts.reminderRepository.ClaimDue(now, REMINDER_BATCH_SIZE)
+
+	assert.Equal(ts.T(), true, ok)
+	assert.Equal(ts.T(), []Reminder{{ID: 1, NoteID: 2, RemindAt: now}, {ID: 3, NoteID: 4, RemindAt: now, Attempts: 2}}, reminders)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *ReminderRepositoryTestSuite) TestReminderRepository_ClaimDue_error() {
+	now := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectQuery(`SELECT * FROM "reminders" WHERE fired_at IS NULL AND attempts < $1 AND remind_at <= $2 `+
+		`AND (next_attempt_at IS NULL OR next_attempt_at <= $3) ORDER BY remind_at LIMIT 10 FOR UPDATE SKIP LOCKED`).
+		WithArgs(MAX_REMINDER_ATTEMPTS, now, now).WillReturnError(errors.New("connection refused"))
+	ts.mock.ExpectRollback()
+
+	reminders, ok := ts.reminderRepository.ClaimDue(now, REMINDER_BATCH_SIZE)
+
+	assert.Equal(ts.T(), false, ok)
+	assert.Empty(ts.T(), reminders)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *ReminderRepositoryTestSuite) TestReminderRepository_MarkFired() {
+	now := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectExec(`UPDATE "reminders" SET "fired_at"=$1 WHERE "id" = $2`).
+		WithArgs(now, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.mock.ExpectCommit()
+
+	ok := ts.reminderRepository.MarkFired(1, now)
+
+	assert.Equal(ts.T(), true, ok)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func (ts *ReminderRepositoryTestSuite) TestReminderRepository_MarkFailed() {
+	now := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	ts.mock.ExpectBegin()
+	ts.mock.ExpectExec(`UPDATE "reminders" SET "attempts"=attempts + 1,"next_attempt_at"=$1 WHERE "id" = $2`).
+		WithArgs(now.Add(4*REMINDER_RETRY_DELAY), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	ts.mock.ExpectCommit()
+
+	ok := ts.reminderRepository.MarkFailed(Reminder{ID: 3, Attempts: 2}, now)
+
+	assert.Equal(ts.T(), true, ok)
+	assert.Nil(ts.T(), ts.mock.ExpectationsWereMet())
+}
+
+func TestReminderRepositoryTestSuite(t *testing.T) {
+	suite.Run(t, new(ReminderRepositoryTestSuite))
+}
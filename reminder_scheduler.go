@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ReminderScheduler fires due reminders through the notifier. Reminders are
+// kept in the database, so those due while no replica was running fire on
+// the next start.
+type ReminderScheduler struct {
+	reminderRepository IReminderRepository
+	noteService        INoteService
+	notifier           Notifier
+}
+
+// FireDue fires the reminders due by now in batches until none is left.
+// Reminders of deleted notes are marked fired without a notification, and
+// failed ones are retried on a later run.
+func (rs *ReminderScheduler) FireDue(now time.Time) {
+	for {
+		reminders, ok := rs.reminderRepository.ClaimDue(now, REMINDER_BATCH_SIZE)
+		if !ok {
+			return
+		}
+		for _, reminder := range reminders {
+			if rs.fire(reminder) == nil {
+				rs.reminderRepository.MarkFired(reminder.ID, now)
+			} else {
+				rs.reminderRepository.MarkFailed(reminder, now)
+			}
+		}
+		if len(reminders) < REMINDER_BATCH_SIZE {
+			return
+		}
+	}
+}
+
+// fire notifies of the reminder in a trace of its own.
+func (rs *ReminderScheduler) fire(reminder Reminder) error {
+	ctx, span := startSpan(context.Background(), "ReminderScheduler.fire",
+		trace.WithAttributes(attribute.Int64("reminder.id", int64(reminder.ID)), noteIdAttribute(reminder.NoteID)))
+	defer span.End()
+	note, found := rs.noteService.WithContext(ctx).GetById(reminder.NoteID)
+	if !found {
+		return nil
+	}
+	if err := rs.notifier.Notify(ctx, reminder, note); err != nil {
+		LoggerFrom(ctx).Error("Failed to send reminder", "reminder_id", reminder.ID, "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Run fires the due reminders every interval until the context is done.
+func (rs *ReminderScheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			rs.FireDue(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReminderRepository claims the due reminders in batches.
+type fakeReminderRepository struct {
+	IReminderRepository
+	due    []Reminder
+	fired  []uint64
+	failed []uint64
+}
+
+func (fr *fakeReminderRepository) ClaimDue(now time.Time, limit int) ([]Reminder, bool) {
+	batch := fr.due
+	if len(batch) > limit {
+		batch = batch[:limit]
+	}
+	fr.due = fr.due[len(batch):]
+	return batch, true
+}
+
+func (fr *fakeReminderRepository) MarkFired(id uint64, now time.Time) bool {
+	fr.fired = append(fr.fired, id)
+	return true
+}
+
+func (fr *fakeReminderRepository) MarkFailed(reminder Reminder, now time.Time) bool {
+	fr.failed = append(fr.failed, reminder.ID)
+	return true
+}
+
+type recordingNotifier struct {
+	notified []uint64
+	err      error
+}
+
+func (rn *recordingNotifier) Notify(ctx context.Context, reminder Reminder, note Note) error {
+	rn.notified = append(rn.notified, note.ID)
+	return rn.err
+}
+
+func TestReminderScheduler_FireDue(t *testing.T) {
+	var due []Reminder
+	for id := uint64(1); id <= REMINDER_BATCH_SIZE+2; id++ {
+		due = append(due, Reminder{ID: id, NoteID: 100 + id%2})
+	}
+	reminderRepository := &fakeReminderRepository{due: due}
+	mockService := &MockService{}
+	mockService.On("GetById", uint64(100)).Return(Note{ID: 100, Title: "Milk"}, true)
+	mockService.On("GetById", uint64(101)).Return(Note{}, false)
+	notifier := &recordingNotifier{}
+	scheduler := &ReminderScheduler{reminderRepository, mockService, notifier}
+
+	scheduler.FireDue(time.Now())
+
+	assert.Empty(t, reminderRepository.due)
+	assert.Len(t, reminderRepository.fired, REMINDER_BATCH_SIZE+2)
+	assert.Len(t, notifier.notified, (REMINDER_BATCH_SIZE+2)/2)
+}
+
+func TestReminderScheduler_FireDue_failed(t *testing.T) {
+	reminderRepository := &fakeReminderRepository{due: []Reminder{{ID: 1, NoteID: 100}}}
+	mockService := &MockService{}
+	mockService.On("GetById", uint64(100)).Return(Note{ID: 100, Title: "Milk"}, true)
+	notifier := &recordingNotifier{err: errors.New("connection refused")}
+	scheduler := &ReminderScheduler{reminderRepository, mockService, notifier}
+
+	scheduler.FireDue(time.Now())
+
+	assert.Equal(t, []uint64{100}, notifier.notified)
+	assert.Empty(t, reminderRepository.fired)
+	assert.Equal(t, []uint64{1}, reminderRepository.failed)
+}
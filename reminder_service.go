@@ -0,0 +1,37 @@
+package main
+
+type IReminderService interface {
+	GetById(id uint64) (Reminder, bool)
+	GetByNoteId(noteId uint64) []Reminder
+	Create(reminder Reminder) (Reminder, error)
+	Delete(id uint64) bool
+}
+
+type ReminderService struct {
+	reminderRepository IReminderRepository
+}
+
+func (rs *ReminderService) GetById(id uint64) (Reminder, bool) {
+	return rs.reminderRepository.GetById(id)
+}
+
+func (rs *ReminderService) GetByNoteId(noteId uint64) []Reminder {
+	return rs.reminderRepository.GetByNoteId(noteId)
+}
+
+func (rs *ReminderService) Create(reminder Reminder) (Reminder, error) {
+	if reminder.ID != UNSPECIFIED_ID {
+		return Reminder{}, &IllegalIdError{}
+	}
+
+	id, ok := rs.reminderRepository.Create(reminder)
+	if !ok {
+		return Reminder{}, &InternalError{}
+	}
+	reminder.ID = id
+	return reminder, nil
+}
+
+func (rs *ReminderService) Delete(id uint64) bool {
+	return rs.reminderRepository.Delete(id)
+}
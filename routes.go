@@ -0,0 +1,424 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route is a handler with the description of it in the OpenAPI document.
+// Path is relative to API_BASE_PATH in the gin syntax; a custom method such
+// as "/notes:batch" is dispatched by customMethods. Request is JSON unless
+// RequestContentType is given.
+type Route struct {
+	Method             string
+	Path               string
+	Handler            gin.HandlerFunc
+	Tag                string
+	Summary            string
+	Description        string
+	Parameters         []Parameter
+	Request            interface{}
+	RequestContentType string
+	Responses          []Response
+}
+
+type Controllers struct {
+	noteController       NoteController
+	noteEventController  NoteEventController
+	noteSyncController   NoteSyncController
+	noteEditController   NoteEditController
+	noteExportController NoteExportController
+	noteImportController NoteImportController
+	calendarController   CalendarController
+	reminderController   ReminderController
+	graphQLController    GraphQLController
+	healthController     HealthController
+	usageController      UsageController
+	metrics              *Metrics
+}
+
+var idempotencyKeyParameter = Parameter{
+	Name: IDEMPOTENCY_KEY_HEADER,
+	In:   "header",
+	Description: "Unique key of the request. A retry with the same key and request gets the response to the first " +
+		"request (with Idempotent-Replayed header) for 24 hours. Reusing the key for another request results in " +
+		"422, and retrying while the first request is being handled results in 409.",
+	Schema: map[string]interface{}{"type": "string", "maxLength": 255},
+}
+
+var quotaExceededResponse = problemResponse(http.StatusForbidden, "Quota of notes or bytes exceeded; the usage is in quota")
+
+var idempotencyResponses = []Response{
+	problemResponse(http.StatusConflict, "Request with the same Idempotency-Key is in progress"),
+	problemResponse(http.StatusUnprocessableEntity, "Validation failed, or Idempotency-Key reused for another request"),
+	problemResponse(http.StatusRequestEntityTooLarge, "Request body with Idempotency-Key over 32 MiB"),
+}
+
+func (cs *Controllers) Routes() []Route {
+	return []Route{
+		{
+			Method: http.MethodGet, Path: "/notes", Handler: cs.noteController.Get, Tag: "notes",
+			Summary: "Find all notes", Description: "Returns all notes, or those matching q and tag",
+			Parameters: []Parameter{
+				{
+					Name: "q", In: "query", Description: "Text in the title or content, ignoring case",
+					Schema: map[string]interface{}{"type": "string"},
+				},
+				{
+					Name: "tag", In: "query", Description: "Tag the notes have",
+					Schema: map[string]interface{}{"type": "string"},
+				},
+			},
+			Responses: []Response{
+				{http.StatusOK, "Successful operation", "", []Note{}},
+				problemResponse(http.StatusInternalServerError, "Unexpected error"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/notes/events", Handler: cs.noteEventController.Stream, Tag: "notes",
+			Summary:     "Stream note changes",
+			Description: "Streams created, updated and deleted events as Server-Sent Events. The data of each event is the changed note.",
+			Parameters: []Parameter{{
+				Name: "Last-Event-ID", In: "header",
+				Description: "ID of the last received event. Events after it are replayed before the live stream, " +
+					"as far as they are kept (7 days by default).",
+				Schema: map[string]interface{}{"type": "integer", "format": "int64"},
+			}},
+			Responses: []Response{
+				{http.StatusOK, "Event stream", "text/event-stream", map[string]interface{}{"type": "string"}},
+				problemResponse(http.StatusBadRequest, "Invalid Last-Event-ID supplied"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/notes/:id", Handler: cs.noteController.GetById, Tag: "notes",
+			Summary: "Find note by ID",
+			Description: "Returns a single note, or its content rendered from Markdown (CommonMark with the GitHub " +
+				"extensions) to sanitized HTML if render=html is given or HTML is preferred in Accept",
+			Parameters: []Parameter{{
+				Name: "render", In: "query", Description: "Format to render the content in",
+				Schema: map[string]interface{}{"type": "string", "enum": []interface{}{RENDER_HTML}},
+			}},
+			Responses: []Response{
+				{http.StatusOK, "Successful operation", "", Note{}},
+				{http.StatusOK, "Successful operation", "text/html", map[string]interface{}{"type": "string"}},
+				problemResponse(http.StatusBadRequest, "Invalid ID or render supplied"),
+				problemResponse(http.StatusNotFound, "Note not found"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/notes", Handler: cs.noteController.Create, Tag: "notes",
+			Summary: "Add a new note", Description: "Tries to create a new note and returns a message",
+			Parameters: []Parameter{idempotencyKeyParameter},
+			Request:    NoteRequest{},
+			Responses: append([]Response{
+				successResponse("Successfully added"),
+				problemResponse(http.StatusBadRequest, "Invalid input"),
+				quotaExceededResponse,
+				problemResponse(http.StatusInternalServerError, "Unexpected error"),
+			}, idempotencyResponses...),
+		},
+		{
+			Method: http.MethodPost, Path: "/notes:batch", Handler: cs.noteController.Batch, Tag: "notes",
+			Summary: "Create, update and delete notes in bulk",
+			Description: "Applies the operations in order and returns the result of each. In atomic mode (default) " +
+				"the operations are applied in one transaction, and if any of them fails, none is applied and the " +
+				"others get 424. In best_effort mode each operation is applied independently.",
+			Parameters: []Parameter{idempotencyKeyParameter},
+			Request:    BatchRequest{},
+			Responses: append([]Response{
+				{http.StatusOK, "Results in the order of the operations", "", BatchResponse{}},
+				problemResponse(http.StatusBadRequest, "Invalid request body, mode or too many operations"),
+			}, idempotencyResponses...),
+		},
+		{
+			Method: http.MethodPut, Path: "/notes/:id", Handler: cs.noteController.Update, Tag: "notes",
+			Summary: "Update an existing note", Description: "Tries to update a note and returns a message",
+			Request: NoteRequest{},
+			Responses: []Response{
+				successResponse("Successfully updated"),
+				problemResponse(http.StatusBadRequest, "Invalid ID or request body supplied"),
+				quotaExceededResponse,
+				problemResponse(http.StatusNotFound, "Note not found"),
+				problemResponse(http.StatusUnprocessableEntity, "Validation failed"),
+				problemResponse(http.StatusInternalServerError, "Unexpected error"),
+			},
+		},
+		{
+			Method: http.MethodDelete, Path: "/notes/:id", Handler: cs.noteController.Delete, Tag: "notes",
+			Summary: "Deletes a note", Description: "Tries to delete a note and returns a message",
+			Responses: []Response{
+				successResponse("Successfully deleted"),
+				problemResponse(http.StatusBadRequest, "Invalid ID supplied"),
+				problemResponse(http.StatusNotFound, "Note not found"),
+				problemResponse(http.StatusInternalServerError, "Unexpected error"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/notes/:id/edit", Handler: cs.noteEditController.Edit, Tag: "notes",
+			Summary: "Edit a note collaboratively",
+			Description: "Upgrades to a WebSocket exchanging JSON messages. The server first sends a snapshot of the " +
+				"content and its revision. Clients send operations (in the ot.js format) and cursors based on a " +
+				"revision, and receive the operations and cursors of the other clients. The merged content is saved " +
+				"periodically and when the last client leaves.",
+			Responses: []Response{
+				{Status: http.StatusSwitchingProtocols, Description: "Switching to the WebSocket protocol"},
+				problemResponse(http.StatusBadRequest, "Invalid ID supplied"),
+				problemResponse(http.StatusNotFound, "Note not found"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/notes/:id/reminders", Handler: cs.reminderController.Get, Tag: "notes",
+			Summary: "Find reminders of a note", Description: "Returns the reminders of a note in the order they fire",
+			Responses: []Response{
+				{http.StatusOK, "Successful operation", "", []Reminder{}},
+				problemResponse(http.StatusBadRequest, "Invalid ID supplied"),
+				problemResponse(http.StatusNotFound, "Note not found"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/notes/:id/reminders", Handler: cs.reminderController.Create, Tag: "notes",
+			Summary: "Remind of a note",
+			Description: "Creates a reminder sending a notification about the note at the time, through the log, " +
+				"a webhook or mail as the server is configured",
+			Parameters: []Parameter{idempotencyKeyParameter},
+			Request:    ReminderRequest{},
+			Responses: append([]Response{
+				{http.StatusCreated, "Successfully added", "", Reminder{}},
+				problemResponse(http.StatusBadRequest, "Invalid ID or request body supplied"),
+				problemResponse(http.StatusNotFound, "Note not found"),
+				problemResponse(http.StatusInternalServerError, "Unexpected error"),
+			}, idempotencyResponses...),
+		},
+		{
+			Method: http.MethodDelete, Path: "/notes/:id/reminders/:reminder_id", Handler: cs.reminderController.Delete,
+			Tag: "notes", Summary: "Deletes a reminder", Description: "Tries to delete a reminder and returns a message",
+			Parameters: []Parameter{{
+				Name: "reminder_id", In: "path", Description: "ID of reminder", Required: true,
+				Schema: map[string]interface{}{"type": "integer", "format": "int64"},
+			}},
+			Responses: []Response{
+				successResponse("Successfully deleted"),
+				problemResponse(http.StatusBadRequest, "Invalid ID supplied"),
+				problemResponse(http.StatusNotFound, "Note or reminder not found"),
+				problemResponse(http.StatusInternalServerError, "Unexpected error"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/export", Handler: cs.noteExportController.Export, Tag: "backup",
+			Summary: "Export all notes",
+			Description: "Streams all notes as a zip of Markdown files with YAML front matter (id, title, and " +
+				"tags, completed and due if set), or as flat JSON or CSV with columns id, title, content, tags " +
+				"(separated by commas), completed and due. The zip can be imported again.",
+			Parameters: []Parameter{{
+				Name: "format", In: "query", Description: "Format of the export (zip by default)",
+				Schema: map[string]interface{}{"type": "string", "enum": []interface{}{EXPORT_ZIP, EXPORT_JSON, EXPORT_CSV}},
+			}},
+			Responses: []Response{
+				{http.StatusOK, "Exported notes", "application/zip", map[string]interface{}{"type": "string", "format": "binary"}},
+				{http.StatusOK, "Exported notes", "application/json", []Note{}},
+				{http.StatusOK, "Exported notes", "text/csv", map[string]interface{}{"type": "string"}},
+				problemResponse(http.StatusBadRequest, "Invalid format supplied"),
+				problemResponse(http.StatusInternalServerError, "Unexpected error"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/import", Handler: cs.noteImportController.Import, Tag: "backup",
+			Summary: "Import notes",
+			Description: "Reads notes from a zip of Markdown files (titled by the front matter, the first heading or " +
+				"the file name), an Evernote export or a todo.txt file, and starts a job creating them. Notes with " +
+				"the same title and content as an existing note are skipped as duplicates. In a dry run, the results " +
+				"are returned without creating notes. Tags, completion and due dates are read from the front matter and from " +
+				"todo.txt completion marks and due: tags. Jobs are kept in the memory of the server running them for 24 " +
+				"hours after they finish, and are lost when it restarts.",
+			Parameters: []Parameter{
+				{
+					Name: "format", In: "query", Description: "Format of the upload", Required: true,
+					Schema: map[string]interface{}{"type": "string", "enum": []interface{}{IMPORT_ZIP, IMPORT_ENEX, IMPORT_TODO_TXT}},
+				},
+				{
+					Name: "dry_run", In: "query", Description: "Preview the import without creating notes",
+					Schema: map[string]interface{}{"type": "boolean"},
+				},
+				idempotencyKeyParameter,
+			},
+			Request:            map[string]interface{}{"type": "string", "format": "binary", "maxLength": MAX_IMPORT_SIZE},
+			RequestContentType: "application/octet-stream",
+			Responses: append([]Response{
+				{http.StatusOK, "Results of the dry run", "", ImportJob{}},
+				{http.StatusAccepted, "Import job started", "", ImportJob{}},
+				problemResponse(http.StatusBadRequest, "Invalid format or file supplied"),
+				problemResponse(http.StatusRequestEntityTooLarge, "File too large"),
+			}, idempotencyResponses...),
+		},
+		{
+			Method: http.MethodGet, Path: "/import/jobs/:id", Handler: cs.noteImportController.GetJob, Tag: "backup",
+			Summary: "Find import job by ID",
+			Description: "Returns the progress and results of an import job. Jobs are found only on the server " +
+				"running them, until 24 hours after they finish or the server restarts.",
+			Parameters: []Parameter{{
+				Name: "id", In: "path", Description: "ID of import job", Required: true,
+				Schema: map[string]interface{}{"type": "string", "format": "uuid"},
+			}},
+			Responses: []Response{
+				{http.StatusOK, "Successful operation", "", ImportJob{}},
+				problemResponse(http.StatusNotFound, "Import job not found"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/calendar.ics", Handler: cs.calendarController.Feed, Tag: "calendar",
+			Summary: "Subscribe to due notes as to-dos",
+			Description: "Returns the notes with due dates as VTODOs of an iCalendar feed. There are no users, so the " +
+				"feed is the same for everyone. All notes can also be read and written as VTODOs over CalDAV at " +
+				CALDAV_CALENDAR_PATH + ", discoverable at /.well-known/caldav.",
+			Parameters: []Parameter{{
+				Name: "If-None-Match", In: "header", Description: "ETag of the feed fetched before",
+				Schema: map[string]interface{}{"type": "string"},
+			}},
+			Responses: []Response{
+				{http.StatusOK, "Calendar feed", "text/calendar", map[string]interface{}{"type": "string"}},
+				{Status: http.StatusNotModified, Description: "No note changed since the feed with the ETag"},
+				problemResponse(http.StatusInternalServerError, "Unexpected error"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/sync", Handler: cs.noteSyncController.Get, Tag: "sync",
+			Summary: "Fetch changes since a token",
+			Description: "Returns the notes changed or deleted since the token, and the token to fetch the next " +
+				"changes with. Without a token, all notes are returned.",
+			Parameters: []Parameter{{
+				Name: "since", In: "query", Description: "Token returned by the previous sync",
+				Schema: map[string]interface{}{"type": "string"},
+			}},
+			Responses: []Response{
+				{http.StatusOK, "Successful operation", "", SyncChangesResponse{}},
+				problemResponse(http.StatusBadRequest, "Invalid token supplied"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/sync", Handler: cs.noteSyncController.Apply, Tag: "sync",
+			Summary: "Apply changes made offline",
+			Description: "Applies the changes one by one and returns the result of each. A change based on an " +
+				"outdated sequence is not applied and results in a conflict with the current note. " +
+				"A request has at most 1000 changes.",
+			Parameters: []Parameter{idempotencyKeyParameter},
+			Request:    SyncRequest{},
+			Responses: append([]Response{
+				{http.StatusOK, "Results in the order of the changes", "", SyncResultsResponse{}},
+				problemResponse(http.StatusBadRequest, "Invalid request body or too many changes"),
+			}, idempotencyResponses...),
+		},
+		{
+			Method: http.MethodGet, Path: "/usage", Handler: cs.usageController.Get, Tag: "usage",
+			Summary: "Find usage against quotas",
+			Description: "Returns the number of notes and the bytes of their titles and contents, and the limits of " +
+				"the quota on them if any. Creating or growing notes over a limit results in 403, or in quota_exceeded " +
+				"when syncing. As the API has no users, all notes are in one workspace and its quota is shared by all clients.",
+			Responses: []Response{
+				{http.StatusOK, "Successful operation", "", UsageResponse{}},
+				problemResponse(http.StatusInternalServerError, "Unexpected error"),
+			},
+		},
+	}
+}
+
+// calDAVRoutes are served outside API_BASE_PATH, where CalDAV clients look
+// for them, and are not described in the OpenAPI document.
+func (cs *Controllers) calDAVRoutes() []Route {
+	cc := &cs.calendarController
+	routes := []Route{
+		{Method: http.MethodGet, Path: "/.well-known/caldav", Handler: cc.WellKnown},
+		{Method: "PROPFIND", Path: "/.well-known/caldav", Handler: cc.WellKnown},
+		{Method: http.MethodGet, Path: CALDAV_CALENDAR_PATH + ":name", Handler: cc.GetObject},
+		{Method: http.MethodHead, Path: CALDAV_CALENDAR_PATH + ":name", Handler: cc.GetObject},
+		{Method: http.MethodPut, Path: CALDAV_CALENDAR_PATH + ":name", Handler: cc.PutObject},
+		{Method: http.MethodDelete, Path: CALDAV_CALENDAR_PATH + ":name", Handler: cc.DeleteObject},
+		{Method: "REPORT", Path: CALDAV_CALENDAR_PATH, Handler: cc.Report},
+	}
+	for _, path := range []string{CALDAV_ROOT_PATH, CALDAV_CALENDAR_PATH, CALDAV_CALENDAR_PATH + ":name"} {
+		routes = append(routes,
+			Route{Method: http.MethodOptions, Path: path, Handler: cc.Options},
+			Route{Method: "PROPFIND", Path: path, Handler: cc.Propfind})
+	}
+	return routes
+}
+
+const docsPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>` + API_TITLE + `</title>
+    <meta charset="utf-8">
+  </head>
+  <body>
+    <redoc spec-url="/openapi.json"></redoc>
+    <script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+  </body>
+</html>
+`
+
+// splitCustomMethod splits "/notes:batch" into "/notes" and ":batch".
+func splitCustomMethod(path string) (string, string) {
+	segment := path[strings.LastIndex(path, "/")+1:]
+	if i := strings.Index(segment, ":"); i > 0 {
+		return path[:len(path)-len(segment)+i], segment[i:]
+	}
+	return path, ""
+}
+
+// NewRouter traces every request and logs it with the default logger,
+// registers the routes under API_BASE_PATH, the GraphQL API at /graphql and
+// CalDAV at CALDAV_ROOT_PATH with the middleware, serves the OpenAPI
+// document describing the routes at /openapi.json and /docs, the health
+// checks at LIVENESS_PATH and READINESS_PATH, and the metrics, if any, at
+// METRICS_PATH.
+func NewRouter(controllers *Controllers, middleware ...gin.HandlerFunc) *gin.Engine {
+	routes := controllers.Routes()
+
+	router := gin.New()
+	router.Use(TracingMiddleware(), RequestLogMiddleware(slog.Default()))
+	if controllers.metrics != nil {
+		router.Use(controllers.metrics.Middleware())
+	}
+	router.Use(gin.Recovery())
+	group := router.Group(API_BASE_PATH)
+	group.Use(middleware...)
+
+	customs := map[string]map[string]gin.HandlerFunc{}
+	for _, route := range routes {
+		path, method := splitCustomMethod(route.Path)
+		if method == "" {
+			group.Handle(route.Method, route.Path, route.Handler)
+			continue
+		}
+		key := route.Method + " " + path
+		if _, found := customs[key]; !found {
+			customs[key] = map[string]gin.HandlerFunc{}
+			group.Handle(route.Method, path+":method", customMethods(customs[key]))
+		}
+		customs[key][method] = route.Handler
+	}
+
+	document := OpenAPIDocument(routes)
+	router.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, document)
+	})
+	router.GET("/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+	})
+
+	graphQL := router.Group("/graphql", middleware...)
+	graphQL.GET("", controllers.graphQLController.Query)
+	graphQL.POST("", controllers.graphQLController.Query)
+	router.GET(LIVENESS_PATH, controllers.healthController.Live)
+	router.GET(READINESS_PATH, controllers.healthController.Ready)
+	if controllers.metrics != nil {
+		router.GET(METRICS_PATH, controllers.metrics.Handler())
+	}
+	calDAV := router.Group("", middleware...)
+	for _, route := range controllers.calDAVRoutes() {
+		calDAV.Handle(route.Method, route.Path, route.Handler)
+	}
+	return router
+}
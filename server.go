@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func NewHTTPServer(config HTTPConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              config.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(config.ReadTimeout),
+		WriteTimeout:      time.Duration(config.WriteTimeout),
+		IdleTimeout:       time.Duration(config.IdleTimeout),
+	}
+}
+
+// waitFor waits for the group until the context is done.
+func waitFor(ctx context.Context, group *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Workers run background loops until they are stopped.
+type Workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	group  sync.WaitGroup
+}
+
+func NewWorkers() *Workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Workers{ctx: ctx, cancel: cancel}
+}
+
+// Go runs the loop, which is to return once its context is done.
+func (w *Workers) Go(loop func(ctx context.Context)) {
+	w.group.Add(1)
+	go func() {
+		defer w.group.Done()
+		loop(w.ctx)
+	}()
+}
+
+// Stop cancels the loops and waits for them to return until the context is
+// done.
+func (w *Workers) Stop(ctx context.Context) error {
+	w.cancel()
+	return waitFor(ctx, &w.group)
+}
+
+// stopGrpcServer lets the RPCs in flight finish, or cancels them once the
+// context is done.
+func stopGrpcServer(ctx context.Context, server *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return ctx.Err()
+	}
+}
+
+// ShutdownStep is a part of the application stopped on shutdown.
+type ShutdownStep struct {
+	Name string
+	Stop func(ctx context.Context) error
+}
+
+// httpShutdownSteps stop the server with its event streams and editing
+// sessions. The streams are ended first, as the server would otherwise wait
+// for them until the deadline. The sessions are closed once no request can
+// join them, so that their pending edits are persisted before the database
+// is closed by a later step.
+func httpShutdownSteps(server *http.Server, noteEventBroker *NoteEventBroker, noteEditHub *NoteEditHub) []ShutdownStep {
+	return []ShutdownStep{
+		{"events", func(ctx context.Context) error {
+			noteEventBroker.Close()
+			return nil
+		}},
+		{"http", server.Shutdown},
+		{"editing", func(ctx context.Context) error {
+			noteEditHub.Close()
+			return nil
+		}},
+	}
+}
+
+// Shutdown runs the steps in order, all within the deadline of the context.
+// A step that fails is logged and does not keep the others from running.
+func Shutdown(ctx context.Context, steps ...ShutdownStep) {
+	for _, step := range steps {
+		start := time.Now()
+		if err := step.Stop(ctx); err != nil {
+			slog.Error("Failed to stop", "component", step.Name, "error", err)
+			continue
+		}
+		slog.Info("Stopped", "component", step.Name, "duration_ms", float64(time.Since(start).Microseconds())/1000)
+	}
+}
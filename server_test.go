@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewHTTPServer(t *testing.T) {
+	config := DefaultConfig().HTTP
+	config.WriteTimeout = Duration(time.Minute)
+
+	server := NewHTTPServer(config, nil)
+
+	assert.Equal(t, ":8080", server.Addr)
+	assert.Equal(t, time.Minute, server.WriteTimeout)
+	assert.Equal(t, 10*time.Second, server.ReadHeaderTimeout)
+	assert.Equal(t, time.Duration(0), server.ReadTimeout)
+}
+
+func TestWorkers_Stop(t *testing.T) {
+	workers := NewWorkers()
+	stopped := make(chan struct{})
+	workers.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+	assert.NoError(t, workers.Stop(context.Background()))
+	<-stopped
+
+	stuck := NewWorkers()
+	release := make(chan struct{})
+	defer close(release)
+	stuck.Go(func(ctx context.Context) {
+		<-release
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, stuck.Stop(ctx))
+}
+
+func TestShutdown(t *testing.T) {
+	var stopped []string
+	step := func(name string, err error) ShutdownStep {
+		return ShutdownStep{name, func(ctx context.Context) error {
+			stopped = append(stopped, name)
+			return err
+		}}
+	}
+
+	Shutdown(context.Background(), step("http", nil), step("workers", errors.New("timed out")), step("database", nil))
+
+	assert.Equal(t, []string{"http", "workers", "database"}, stopped)
+}
+
+func TestHttpShutdownSteps(t *testing.T) {
+	mockService := &MockService{}
+	noteEditHub := NewNoteEditHub(mockService)
+	noteEditController := NoteEditController{noteEditHub: noteEditHub}
+	noteEventBroker := NewNoteEventBroker(nil)
+	router := gin.New()
+	router.GET("/notes/:id/edit", noteEditController.Edit)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "title", Content: "abc", Sequence: 3}, true)
+	persisted := make(chan string, 1)
+	mockService.On("UpdateContent", uint64(1), uint64(3), mock.Anything).Return(uint64(4), nil).Run(func(args mock.Arguments) {
+		persisted <- args.Get(2).(string)
+	})
+	events := noteEventBroker.Subscribe()
+	conn := dialEdit(t, server, "1")
+	defer conn.Close()
+	readEditMessage(t, conn, EDIT_SNAPSHOT)
+	conn.WriteJSON(EditMessage{Type: EDIT_OPERATION, Revision: 0, Operation: (&TextOperation{}).Retain(3).Insert("X")})
+	readEditMessage(t, conn, EDIT_ACK)
+
+	var persistedBeforeDatabase string
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	Shutdown(ctx, append(httpShutdownSteps(server.Config, noteEventBroker, noteEditHub),
+		ShutdownStep{"database", func(ctx context.Context) error {
+			select {
+			case content := <-persisted:
+				persistedBeforeDatabase = content
+			default:
+			}
+			return nil
+		}})...)
+
+	assert.Equal(t, "abcX", persistedBeforeDatabase, "the pending edit must be persisted before the database is closed")
+	assert.NoError(t, ctx.Err(), "the shutdown must not wait for the deadline")
+	_, open := <-events
+	assert.False(t, open)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+)
+
+// TextOperation is an operational-transform operation on a plain text
+// document, compatible with the JSON format of ot.js: a list of components
+// where a positive number retains characters, a negative number deletes
+// characters and a string inserts text. Lengths count Unicode code points.
+type TextOperation struct {
+	components   []textComponent
+	BaseLength   int
+	TargetLength int
+}
+
+type textComponent struct {
+	retain int
+	delete int
+	insert string
+}
+
+var (
+	ErrInvalidOperation     = errors.New("invalid operation")
+	ErrOperationMismatch    = errors.New("operation does not match the document")
+	ErrConcurrentNotAligned = errors.New("concurrent operations have different base lengths")
+)
+
+func (tc textComponent) isRetain() bool { return tc.retain > 0 }
+func (tc textComponent) isDelete() bool { return tc.delete > 0 }
+func (tc textComponent) isInsert() bool { return tc.insert != "" }
+
+func (to *TextOperation) Retain(n int) *TextOperation {
+	if n <= 0 {
+		return to
+	}
+	to.BaseLength += n
+	to.TargetLength += n
+	if last := len(to.components) - 1; last >= 0 && to.components[last].isRetain() {
+		to.components[last].retain += n
+		return to
+	}
+	to.components = append(to.components, textComponent{retain: n})
+	return to
+}
+
+func (to *TextOperation) Insert(s string) *TextOperation {
+	if s == "" {
+		return to
+	}
+	to.TargetLength += utf8.RuneCountInString(s)
+	last := len(to.components) - 1
+	if last >= 0 && to.components[last].isInsert() {
+		to.components[last].insert += s
+		return to
+	}
+	// Inserts are kept before deletes so that equivalent operations have
+	// the same components.
+	if last >= 0 && to.components[last].isDelete() {
+		if last >= 1 && to.components[last-1].isInsert() {
+			to.components[last-1].insert += s
+			return to
+		}
+		to.components = append(to.components, to.components[last])
+		to.components[last] = textComponent{insert: s}
+		return to
+	}
+	to.components = append(to.components, textComponent{insert: s})
+	return to
+}
+
+func (to *TextOperation) Delete(n int) *TextOperation {
+	if n <= 0 {
+		return to
+	}
+	to.BaseLength += n
+	if last := len(to.components) - 1; last >= 0 && to.components[last].isDelete() {
+		to.components[last].delete += n
+		return to
+	}
+	to.components = append(to.components, textComponent{delete: n})
+	return to
+}
+
+func (to *TextOperation) IsNoop() bool {
+	return len(to.components) == 0 || (len(to.components) == 1 && to.components[0].isRetain())
+}
+
+// Apply returns the document with the operation applied.
+func (to *TextOperation) Apply(document string) (string, error) {
+	runes := []rune(document)
+	if len(runes) != to.BaseLength {
+		return "", ErrOperationMismatch
+	}
+	result := make([]rune, 0, to.TargetLength)
+	position := 0
+	for _, component := range to.components {
+		switch {
+		case component.isRetain():
+			result = append(result, runes[position:position+component.retain]...)
+			position += component.retain
+		case component.isInsert():
+			result = append(result, []rune(component.insert)...)
+		case component.isDelete():
+			position += component.delete
+		}
+	}
+	return string(result), nil
+}
+
+// TransformTextOperations transforms two operations that were made
+// concurrently on the same document. It returns a' and b' such that
+// applying a then b' results in the same document as applying b then a'.
+// Inserts of a are placed before inserts of b at the same position.
+func TransformTextOperations(a, b *TextOperation) (*TextOperation, *TextOperation, error) {
+	if a.BaseLength != b.BaseLength {
+		return nil, nil, ErrConcurrentNotAligned
+	}
+	aPrime, bPrime := &TextOperation{}, &TextOperation{}
+	as, bs := a.components, b.components
+	var ac, bc *textComponent
+	next := func(components *[]textComponent) *textComponent {
+		if len(*components) == 0 {
+			return nil
+		}
+		c := (*components)[0]
+		*components = (*components)[1:]
+		return &c
+	}
+	ac, bc = next(&as), next(&bs)
+	for ac != nil || bc != nil {
+		if ac != nil && ac.isInsert() {
+			aPrime.Insert(ac.insert)
+			bPrime.Retain(utf8.RuneCountInString(ac.insert))
+			ac = next(&as)
+			continue
+		}
+		if bc != nil && bc.isInsert() {
+			aPrime.Retain(utf8.RuneCountInString(bc.insert))
+			bPrime.Insert(bc.insert)
+			bc = next(&bs)
+			continue
+		}
+		if ac == nil || bc == nil {
+			return nil, nil, ErrConcurrentNotAligned
+		}
+
+		aLength, bLength := ac.retain+ac.delete, bc.retain+bc.delete
+		n := aLength
+		if bLength < n {
+			n = bLength
+		}
+		switch {
+		case ac.isRetain() && bc.isRetain():
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+		case ac.isDelete() && bc.isRetain():
+			aPrime.Delete(n)
+		case ac.isRetain() && bc.isDelete():
+			bPrime.Delete(n)
+		}
+		// Both deleting the same range needs nothing on either side.
+
+		if aLength == n {
+			ac = next(&as)
+		} else {
+			ac.retain, ac.delete = shrink(ac.retain, n), shrink(ac.delete, n)
+		}
+		if bLength == n {
+			bc = next(&bs)
+		} else {
+			bc.retain, bc.delete = shrink(bc.retain, n), shrink(bc.delete, n)
+		}
+	}
+	return aPrime, bPrime, nil
+}
+
+func shrink(length, n int) int {
+	if length == 0 {
+		return 0
+	}
+	return length - n
+}
+
+func (to TextOperation) MarshalJSON() ([]byte, error) {
+	values := make([]interface{}, 0, len(to.components))
+	for _, component := range to.components {
+		switch {
+		case component.isRetain():
+			values = append(values, component.retain)
+		case component.isInsert():
+			values = append(values, component.insert)
+		case component.isDelete():
+			values = append(values, -component.delete)
+		}
+	}
+	return json.Marshal(values)
+}
+
+func (to *TextOperation) UnmarshalJSON(data []byte) error {
+	var values []interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*to = TextOperation{}
+	for _, value := range values {
+		switch v := value.(type) {
+		case float64:
+			if v != float64(int(v)) || v == 0 {
+				return ErrInvalidOperation
+			}
+			if v > 0 {
+				to.Retain(int(v))
+			} else {
+				to.Delete(int(-v))
+			}
+		case string:
+			if v == "" || !utf8.ValidString(v) {
+				return ErrInvalidOperation
+			}
+			to.Insert(v)
+		default:
+			return ErrInvalidOperation
+		}
+	}
+	return nil
+}
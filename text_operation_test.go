@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextOperation_Apply(t *testing.T) {
+	operation := (&TextOperation{}).Retain(6).Delete(5).Insert("Gophers").Retain(1)
+
+	actual, err := operation.Apply("Hello world!")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello Gophers!", actual)
+	assert.Equal(t, 12, operation.BaseLength)
+	assert.Equal(t, 14, operation.TargetLength)
+
+	_, err = operation.Apply("Hello")
+	assert.Equal(t, ErrOperationMismatch, err)
+}
+
+func TestTextOperation_JSON(t *testing.T) {
+	var operation TextOperation
+	err := json.Unmarshal([]byte(`[2,"ネコ",-1,3]`), &operation)
+
+	assert.Nil(t, err)
+	actual, _ := operation.Apply("abcdef")
+	assert.Equal(t, "abネコdef", actual)
+	marshaled, _ := json.Marshal(operation)
+	assert.Equal(t, `[2,"ネコ",-1,3]`, string(marshaled))
+
+	for _, invalid := range []string{`[0]`, `[1.5]`, `[""]`, `[true]`, `{}`} {
+		assert.NotNil(t, json.Unmarshal([]byte(invalid), &operation), invalid)
+	}
+}
+
+func TestTransformTextOperations(t *testing.T) {
+	for _, td := range []struct {
+		title    string
+		document string
+		a        *TextOperation
+		b        *TextOperation
+		expected string
+	}{
+		{
+			title:    "Inserts at the same position keep a first",
+			document: "abc",
+			a:        (&TextOperation{}).Retain(1).Insert("X").Retain(2),
+			b:        (&TextOperation{}).Retain(1).Insert("Y").Retain(2),
+			expected: "aXYbc",
+		},
+		{
+			title:    "Insert inside a deleted range is kept",
+			document: "abcdef",
+			a:        (&TextOperation{}).Retain(1).Delete(4).Retain(1),
+			b:        (&TextOperation{}).Retain(3).Insert("X").Retain(3),
+			expected: "aXf",
+		},
+		{
+			title:    "Overlapping deletes are applied once",
+			document: "abcdef",
+			a:        (&TextOperation{}).Retain(1).Delete(3).Retain(2),
+			b:        (&TextOperation{}).Retain(2).Delete(3).Retain(1),
+			expected: "af",
+		},
+	} {
+		t.Run("Transform: "+td.title, func(t *testing.T) {
+			aPrime, bPrime, err := TransformTextOperations(td.a, td.b)
+			assert.Nil(t, err)
+
+			afterA, _ := td.a.Apply(td.document)
+			afterAB, err := bPrime.Apply(afterA)
+			assert.Nil(t, err)
+			afterB, _ := td.b.Apply(td.document)
+			afterBA, err := aPrime.Apply(afterB)
+			assert.Nil(t, err)
+
+			assert.Equal(t, td.expected, afterAB)
+			assert.Equal(t, td.expected, afterBA)
+		})
+	}
+
+	_, _, err := TransformTextOperations((&TextOperation{}).Retain(1), (&TextOperation{}).Retain(2))
+	assert.Equal(t, ErrConcurrentNotAligned, err)
+}
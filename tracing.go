@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	SERVICE_NAME = "todo-go-api"
+	TRACER_NAME  = "hi-watana/todo-go-api"
+
+	TRACES_EXPORTER_NONE = "none"
+	TRACES_EXPORTER_OTLP = "otlp"
+
+	OTLP_PROTOCOL_HTTP = "http/protobuf"
+	OTLP_PROTOCOL_GRPC = "grpc"
+
+	spanKey = "tracing:span"
+)
+
+// NewTracerProvider returns the tracer provider exporting spans as
+// configured: not at all, or with OTLP to OTEL_EXPORTER_OTLP_ENDPOINT.
+// Spans are recorded even if they are not exported, so that trace IDs are
+// logged and propagated.
+func NewTracerProvider(ctx context.Context, config TracingConfig) (*sdktrace.TracerProvider, error) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(SERVICE_NAME))),
+	}
+	switch config.Exporter {
+	case TRACES_EXPORTER_NONE:
+	case TRACES_EXPORTER_OTLP:
+		var exporter sdktrace.SpanExporter
+		var err error
+		switch config.Protocol {
+		case OTLP_PROTOCOL_HTTP:
+			exporter, err = otlptracehttp.New(ctx)
+		case OTLP_PROTOCOL_GRPC:
+			exporter, err = otlptracegrpc.New(ctx)
+		default:
+			return nil, errors.New("tracing protocol must be http/protobuf or grpc")
+		}
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, errors.New("tracing exporter must be none or otlp")
+	}
+	return sdktrace.NewTracerProvider(options...), nil
+}
+
+// SetTracerProvider makes the provider trace the application, propagating
+// traces in W3C traceparent and baggage headers.
+func SetTracerProvider(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// startSpan starts a span as a child of the span in the context, if any.
+// The tracer is looked up on every call so that it follows the provider set
+// last.
+func startSpan(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(TRACER_NAME).Start(ctx, name, options...)
+}
+
+// TracingMiddleware continues the trace of the traceparent header, or
+// starts one, in a server span named after the matched route.
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		name := route
+		if name == "" {
+			name = "HTTP " + c.Request.Method
+		}
+		ctx, span := startSpan(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(SERVICE_NAME, route, c.Request)...))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+	}
+}
+
+// TracingTransport sends requests in client spans, propagating the trace to
+// the server.
+type TracingTransport struct {
+	base http.RoundTripper
+}
+
+func NewTracingTransport(base http.RoundTripper) *TracingTransport {
+	return &TracingTransport{base}
+}
+
+func (tt *TracingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, span := startSpan(request.Context(), "HTTP "+request.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPClientAttributesFromHTTPRequest(request)...))
+	defer span.End()
+	request = request.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+
+	response, err := tt.base.RoundTrip(request)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(response.StatusCode)...)
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(response.StatusCode, trace.SpanKindClient))
+	return response, nil
+}
+
+// GormTracing is a gorm plugin running each statement in a client span,
+// a child of the span in the context of the statement.
+type GormTracing struct{}
+
+func (gt *GormTracing) Name() string {
+	return "tracing"
+}
+
+func (gt *GormTracing) Initialize(db *gorm.DB) error {
+	return registerGormCallbacks(db, gt.Name(), func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			_, span := startSpan(db.Statement.Context, "gorm."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBSQLTableKey.String(db.Statement.Table)))
+			db.InstanceSet(spanKey, span)
+		}
+	}, func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			value, ok := db.InstanceGet(spanKey)
+			if !ok {
+				return
+			}
+			span := value.(trace.Span)
+			defer span.End()
+			span.SetAttributes(semconv.DBStatementKey.String(db.Statement.SQL.String()),
+				attribute.Int64("db.rows_affected", db.RowsAffected))
+			if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+				span.RecordError(db.Error)
+				span.SetStatus(codes.Error, db.Error.Error())
+			}
+		}
+	})
+}
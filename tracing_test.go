@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hi-watana/todo-go-api/notespb"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// traceInMemory records the spans of the test in the returned exporter.
+func traceInMemory(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+	return exporter
+}
+
+func newTracedDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, _ := sqlmock.New()
+	t.Cleanup(func() { sqlDB.Close() })
+	db, _ := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	assert.NoError(t, db.Use(&GormTracing{}))
+	return db, mock
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing_request(t *testing.T) {
+	exporter := traceInMemory(t)
+	db, mock := newTracedDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "notes"`).WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(1, "Milk"))
+	noteService := &NoteService{noteRepository: &NoteRepository{db}}
+	router := NewRouter(&Controllers{noteController: NoteController{noteService, NewNoteRenderer(NOTE_RENDER_CACHE_SIZE)}})
+
+	req, _ := http.NewRequest("GET", "/v1/notes/1", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+	query, service, server := spans[0], spans[1], spans[2]
+	assert.Equal(t, "/v1/notes/:id", server.Name)
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", server.SpanContext.TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", server.Parent.SpanID().String())
+	assert.True(t, server.Parent.IsRemote())
+	assert.Equal(t, int64(http.StatusOK), spanAttribute(server, "http.status_code").AsInt64())
+
+	assert.Equal(t, "NoteService.GetById", service.Name)
+	assert.Equal(t, server.SpanContext.SpanID(), service.Parent.SpanID())
+	assert.Equal(t, int64(1), spanAttribute(service, "note.id").AsInt64())
+
+	assert.Equal(t, "gorm.query", query.Name)
+	assert.Equal(t, trace.SpanKindClient, query.SpanKind)
+	assert.Equal(t, service.SpanContext.SpanID(), query.Parent.SpanID())
+	assert.Contains(t, spanAttribute(query, "db.statement").AsString(), `SELECT * FROM "notes"`)
+	assert.Equal(t, "notes", spanAttribute(query, "db.sql.table").AsString())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTracing_failure(t *testing.T) {
+	exporter := traceInMemory(t)
+	db, mock := newTracedDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+	noteService := &NoteService{noteRepository: &NoteRepository{db}}
+
+	assert.False(t, noteService.Delete(1))
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	query, service := spans[0], spans[1]
+	assert.Equal(t, "gorm.raw", query.Name)
+	assert.Equal(t, codes.Error, query.Status.Code)
+	assert.Equal(t, "connection lost", query.Status.Description)
+	assert.Equal(t, "NoteService.Delete", service.Name)
+	assert.Equal(t, codes.Error, service.Status.Code)
+	assert.False(t, service.Parent.IsValid())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTracing_webhook(t *testing.T) {
+	exporter := traceInMemory(t)
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	mockService := &MockService{}
+	mockService.On("GetById", uint64(2)).Return(notifiedNote, true)
+	scheduler := &ReminderScheduler{noteService: mockService, notifier: NewWebhookNotifier(server.URL)}
+
+	assert.NoError(t, scheduler.fire(notifiedReminder))
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	client, fire := spans[0], spans[1]
+	assert.Equal(t, "ReminderScheduler.fire", fire.Name)
+	assert.False(t, fire.Parent.IsValid())
+	assert.Equal(t, "HTTP POST", client.Name)
+	assert.Equal(t, trace.SpanKindClient, client.SpanKind)
+	assert.Equal(t, fire.SpanContext.SpanID(), client.Parent.SpanID())
+	assert.Equal(t, fmt.Sprintf("00-%s-%s-01", client.SpanContext.TraceID(), client.SpanContext.SpanID()), traceparent)
+	assert.Equal(t, int64(http.StatusNoContent), spanAttribute(client, "http.status_code").AsInt64())
+}
+
+func TestTracing_logs(t *testing.T) {
+	traceInMemory(t)
+	var buffer bytes.Buffer
+	logger, _ := NewLogger(&buffer, "info")
+	router := gin.New()
+	router.Use(TracingMiddleware(), RequestLogMiddleware(logger))
+	router.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := logLines(&buffer)
+	assert.Len(t, lines, 1)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", lines[0]["trace_id"])
+	assert.Len(t, lines[0]["span_id"], 16)
+}
+
+func TestTracing_grpc(t *testing.T) {
+	exporter := traceInMemory(t)
+	var buffer bytes.Buffer
+	logger, _ := NewLogger(&buffer, "info")
+	mockService := &MockService{}
+	mockService.On("GetById", uint64(1)).Return(Note{ID: 1, Title: "Milk"}, true)
+	client, closeClient := dialGrpc(t, mockService, nil, logger)
+	defer closeClient()
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	_, err := client.GetById(ctx, &notespb.GetByIdRequest{Id: 1})
+
+	assert.NoError(t, err)
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 1) {
+		return
+	}
+	server := spans[0]
+	assert.Equal(t, "notes.v1.NotesService/GetById", server.Name)
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", server.SpanContext.TraceID().String())
+	assert.True(t, server.Parent.IsRemote())
+	lines := logLines(&buffer)
+	assert.Len(t, lines, 1)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", lines[0]["trace_id"])
+}
+
+func TestTracing_sync(t *testing.T) {
+	exporter := traceInMemory(t)
+	db, mock := newTracedDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "notes"`).WillReturnRows(sqlmock.NewRows([]string{"id", "title", "sequence"}).AddRow(1, "Milk", 3))
+	noteSyncService := &NoteSyncService{noteRepository: &NoteRepository{db}}
+	router := NewRouter(&Controllers{noteSyncController: NoteSyncController{noteSyncService}})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/v1/sync", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+	query, service, server := spans[0], spans[1], spans[2]
+	assert.Equal(t, "NoteSyncService.GetChanges", service.Name)
+	assert.Equal(t, server.SpanContext.SpanID(), service.Parent.SpanID())
+	assert.Equal(t, service.SpanContext.SpanID(), query.Parent.SpanID())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
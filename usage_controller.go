@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UsageController struct {
+	noteService INoteService
+}
+
+func (uc *UsageController) Get(c *gin.Context) {
+	usage, ok := uc.noteService.WithContext(c.Request.Context()).Usage()
+	if !ok {
+		RespondProblem(c, InternalErrorProblem())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, usage)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageController_Get(t *testing.T) {
+	for _, td := range []struct {
+		title          string
+		ok             bool
+		expectedStatus int
+	}{
+		{"Returns the usage", true, http.StatusOK},
+		{"Returns 500 if the usage is not found", false, http.StatusInternalServerError},
+	} {
+		t.Run(td.title, func(t *testing.T) {
+			mockService := &MockService{}
+			usageController := UsageController{mockService}
+			usage := UsageResponse{DEFAULT_WORKSPACE, QuotaAmount{3, 10}, QuotaAmount{120, 0}}
+			mockService.On("Usage").Return(usage, td.ok)
+			response := httptest.NewRecorder()
+			ginContext, _ := gin.CreateTestContext(response)
+			ginContext.Request, _ = http.NewRequest("GET", "/usage", nil)
+
+			usageController.Get(ginContext)
+
+			assert.Equal(t, td.expectedStatus, response.Code)
+			if td.ok {
+				expected, _ := json.MarshalIndent(usage, "", "    ")
+				assert.Equal(t, expected, response.Body.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Request DTOs declare their rules in `validate` tags, separated by ';'
+// since patterns may contain commas:
+//
+//	Title string `json:"title" validate:"required;maxLength=200;pattern=^[^\n]*$"`
+//
+// Rules are named after the OpenAPI keywords they are exported as:
+// required, minLength, maxLength (counting Unicode code points), pattern and
+// enum (values separated by '|'). A required string must not be blank.
+//
+// On a slice of strings, maxItems limits the number of items and the other
+// rules apply to each item:
+//
+//	Tags []string `json:"tags" validate:"maxItems=20;required;maxLength=50"`
+
+type validationRule struct {
+	name  string
+	value string
+}
+
+type fieldRules struct {
+	index int
+	name  string
+	rules []validationRule
+}
+
+var (
+	rulesCache   sync.Map
+	patternCache sync.Map
+)
+
+func rulesOf(t reflect.Type) []fieldRules {
+	if cached, found := rulesCache.Load(t); found {
+		return cached.([]fieldRules)
+	}
+	var fields []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, found := field.Tag.Lookup("validate")
+		if !found {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		var rules []validationRule
+		for _, rule := range strings.Split(tag, ";") {
+			nameAndValue := strings.SplitN(rule, "=", 2)
+			if len(nameAndValue) == 1 {
+				nameAndValue = append(nameAndValue, "")
+			}
+			rules = append(rules, validationRule{nameAndValue[0], nameAndValue[1]})
+		}
+		fields = append(fields, fieldRules{i, name, rules})
+	}
+	rulesCache.Store(t, fields)
+	return fields
+}
+
+func compiledPattern(pattern string) *regexp.Regexp {
+	if cached, found := patternCache.Load(pattern); found {
+		return cached.(*regexp.Regexp)
+	}
+	compiled := regexp.MustCompile(pattern)
+	patternCache.Store(pattern, compiled)
+	return compiled
+}
+
+func checkRule(rule validationRule, value string) (string, bool) {
+	switch rule.name {
+	case "required":
+		return "is required", strings.TrimSpace(value) != ""
+	case "minLength":
+		n, _ := strconv.Atoi(rule.value)
+		return "must be at least " + rule.value + " characters", value == "" || utf8.RuneCountInString(value) >= n
+	case "maxLength":
+		n, _ := strconv.Atoi(rule.value)
+		return "must be at most " + rule.value + " characters", utf8.RuneCountInString(value) <= n
+	case "pattern":
+		return "must match " + rule.value, value == "" || compiledPattern(rule.value).MatchString(value)
+	case "enum":
+		values := strings.Split(rule.value, "|")
+		for _, v := range values {
+			if v == value {
+				return "", true
+			}
+		}
+		return "must be one of " + strings.Join(values, ", "), value == ""
+	case "maxItems":
+		return "", true
+	}
+	panic("unknown validation rule: " + rule.name)
+}
+
+// Validate checks the string fields, and slices of strings, of the struct
+// against their rules and returns a ValidationError with all the failures,
+// or nil.
+func Validate(request interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(request))
+	var errors []FieldError
+	for _, field := range rulesOf(value.Type()) {
+		fieldValue := value.Field(field.index)
+		if fieldValue.Kind() != reflect.Slice {
+			errors = append(errors, checkRules(field.name, field.rules, fieldValue.String())...)
+			continue
+		}
+		for _, rule := range field.rules {
+			if n, _ := strconv.Atoi(rule.value); rule.name == "maxItems" && fieldValue.Len() > n {
+				errors = append(errors, FieldError{field.name, "must have at most " + rule.value + " items"})
+			}
+		}
+		for i := 0; i < fieldValue.Len(); i++ {
+			errors = append(errors, checkRules(field.name+"["+strconv.Itoa(i)+"]", field.rules, fieldValue.Index(i).String())...)
+		}
+	}
+	if len(errors) > 0 {
+		return &ValidationError{errors}
+	}
+	return nil
+}
+
+func checkRules(field string, rules []validationRule, value string) []FieldError {
+	var errors []FieldError
+	for _, rule := range rules {
+		if message, ok := checkRule(rule, value); !ok {
+			errors = append(errors, FieldError{field, message})
+		}
+	}
+	return errors
+}
+
+// exportRules adds the rules to the OpenAPI schema of a string property, or
+// of the items of an array property, and reports whether the property is
+// required. Items that are required are only required not to be blank.
+func exportRules(rules []validationRule, property map[string]interface{}) bool {
+	if items, isArray := property["items"].(map[string]interface{}); isArray {
+		for _, rule := range rules {
+			if rule.name == "maxItems" {
+				property[rule.name], _ = strconv.Atoi(rule.value)
+			}
+		}
+		if exportRules(rules, items) && items["minLength"] == nil {
+			items["minLength"] = 1
+		}
+		return false
+	}
+	required := false
+	for _, rule := range rules {
+		switch rule.name {
+		case "required":
+			required = true
+		case "minLength", "maxLength":
+			property[rule.name], _ = strconv.Atoi(rule.value)
+		case "pattern":
+			property[rule.name] = rule.value
+		case "enum":
+			var values []interface{}
+			for _, v := range strings.Split(rule.value, "|") {
+				values = append(values, v)
+			}
+			property[rule.name] = values
+		}
+	}
+	return required
+}
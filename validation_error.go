@@ -12,4 +12,21 @@ type InternalError struct {
 
 func (e *InternalError) Error() string {
 	return "Internal error"
-}
\ No newline at end of file
+}
+
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return "Validation failed"
+}
+
+// NoteConflictError is returned for a change based on a version of the note
+// that has since been changed or deleted.
+type NoteConflictError struct {
+}
+
+func (e *NoteConflictError) Error() string {
+	return "Note changed or deleted"
+}
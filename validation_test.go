@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validationTestRequest struct {
+	Name   string `json:"name" validate:"required;minLength=2;maxLength=5"`
+	Code   string `json:"code,omitempty" validate:"pattern=^[a-z]+$"`
+	Color  string `validate:"enum=red|green"`
+	Ignore string `json:"ignore"`
+}
+
+func TestValidate(t *testing.T) {
+	for _, td := range []struct {
+		title          string
+		request        validationTestRequest
+		expectedErrors []FieldError
+	}{
+		{
+			title:   "Returns nil if valid",
+			request: validationTestRequest{Name: "ネコ", Code: "abc", Color: "red"},
+		},
+		{
+			title:   "Skips rules other than required for empty strings",
+			request: validationTestRequest{Name: "ab"},
+		},
+		{
+			title:   "Returns all failures",
+			request: validationTestRequest{Name: " ", Code: "ABC", Color: "blue"},
+			expectedErrors: []FieldError{
+				{"name", "is required"},
+				{"name", "must be at least 2 characters"},
+				{"code", "must match ^[a-z]+$"},
+				{"Color", "must be one of red, green"},
+			},
+		},
+		{
+			title:   "Counts code points for lengths",
+			request: validationTestRequest{Name: strings.Repeat("ネ", 6)},
+			expectedErrors: []FieldError{
+				{"name", "must be at most 5 characters"},
+			},
+		},
+	} {
+		t.Run("Validate: "+td.title, func(t *testing.T) {
+			err := Validate(&td.request)
+			if td.expectedErrors == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.Equal(t, &ValidationError{td.expectedErrors}, err)
+		})
+	}
+}
+
+type validationTestListRequest struct {
+	Tags []string `json:"tags" validate:"maxItems=2;required;maxLength=3"`
+}
+
+func TestValidate_slice(t *testing.T) {
+	assert.Nil(t, Validate(&validationTestListRequest{}))
+	assert.Nil(t, Validate(&validationTestListRequest{Tags: []string{"a", "abc"}}))
+	assert.Equal(t, &ValidationError{[]FieldError{
+		{"tags", "must have at most 2 items"},
+		{"tags[0]", "must be at most 3 characters"},
+		{"tags[1]", "is required"},
+	}}, Validate(&validationTestListRequest{Tags: []string{"abcd", " ", "c"}}))
+}
+
+func TestExportRules_slice(t *testing.T) {
+	g := &openAPIGenerator{schemas: map[string]interface{}{}}
+	g.schemaOf(reflect.TypeOf(validationTestListRequest{}))
+
+	assert.Equal(t, map[string]interface{}{
+		"type": "array", "maxItems": 2,
+		"items": map[string]interface{}{"type": "string", "minLength": 1, "maxLength": 3},
+	}, g.schemas["validationTestListRequest"].(map[string]interface{})["properties"].(map[string]interface{})["tags"])
+}
+
+// Struct tags cannot refer to constants, so the limits in the tags of notes
+// are checked against them.
+func TestValidate_noteLimits(t *testing.T) {
+	expected := map[string]map[string]int{
+		"title":   {"maxLength": MAX_TITLE_LENGTH},
+		"content": {"maxLength": MAX_CONTENT_LENGTH},
+		"tags":    {"maxItems": MAX_TAGS, "maxLength": MAX_TAG_LENGTH},
+	}
+	for _, request := range []interface{}{NoteRequest{}, SyncChange{}} {
+		for _, field := range rulesOf(reflect.TypeOf(request)) {
+			limits, found := expected[field.name]
+			if !found {
+				continue
+			}
+			actual := map[string]int{}
+			for _, rule := range field.rules {
+				if _, found := limits[rule.name]; found {
+					actual[rule.name], _ = strconv.Atoi(rule.value)
+				}
+			}
+			assert.Equal(t, limits, actual, "%T.%s", request, field.name)
+		}
+	}
+}